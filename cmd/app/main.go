@@ -7,7 +7,6 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 
 	"github.com/dd-web/opforu-server/internal/handlers"
@@ -26,51 +25,38 @@ func main() {
 		log.Fatal(err)
 	}
 
-	// disable cache until fully implemented
-	// err = store.HydrateCache()
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
-
-	handler := types.NewRoutingHandler(store)
-
-	handler_account := handlers.InitAccountHandlers(handler)
-	handler_article := handlers.InitArticleHandler(handler)
-	handler_asset := handlers.InitAssetHandler(handler)
-	handler_board := handlers.InitBoardHandler(handler)
-	handler_thread := handlers.InitThreadHandler(handler)
-	handler_internal := handlers.InitInternalHandlers(handler)
-
-	fmt.Println("Registering handlers...")
+	err = store.Migrate()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// account
-	handler.Router.HandleFunc("/api/account/posts", handlers.WrapFn(handler_account.RegisterAccountPosts))
-	handler.Router.HandleFunc("/api/account/logout", handlers.WrapFn(handler_account.RegisterAccountLogout))
-	handler.Router.HandleFunc("/api/account/login", handlers.WrapFn(handler_account.RegisterAccountLogin))
-	handler.Router.HandleFunc("/api/account/register", handlers.WrapFn(handler_account.RegisterAccountRegister))
-	handler.Router.HandleFunc("/api/account", handlers.WrapFn(handler_account.RegisterAccountRoot))
+	err = store.HydrateCache()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// articles
-	handler.Router.HandleFunc("/api/articles", handlers.WrapFn(handler_article.RegisterArticleRoot))
-	handler.Router.HandleFunc("/api/articles/{slug}", handlers.WrapFn(handler_article.RegisterArticleSlug))
+	handler := types.NewRoutingHandler(store)
 
-	// assets
-	handler.Router.HandleFunc("/api/assets", handlers.WrapFn(handler_asset.RegisterAssetRoot))
+	mailer, err := types.NewMailerFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	handler.Mailer = mailer
 
-	// boards
-	handler.Router.HandleFunc("/api/boards/{short}", handlers.WrapFn(handler_board.RegisterBoardShort))
-	handler.Router.HandleFunc("/api/boards", handlers.WrapFn(handler_board.RegisterBoardRoot))
+	providers, err := types.NewOIDCProvidersFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	handler.OIDCProviders = providers
 
-	// threads
-	handler.Router.HandleFunc("/api/threads/{slug}", handlers.WrapFn(handler_thread.RegisterThreadRoot))
+	stopAccountSweeper := types.StartAccountSweeper(store)
+	defer stopAccountSweeper()
 
-	// internal server routes
-	handler.Router.HandleFunc("/api/internal/session/{session_id}", handlers.WrapFn(handler_internal.HandleGetSession))
-	handler.Router.HandleFunc("/api/internal/post/{thread_slug}/{post_number}", handlers.WrapFn(handler_internal.HandleGetPost))
-	handler.Router.HandleFunc("/api/internal/thread/{board_short}/{thread_slug}", handlers.WrapFn(handler_internal.HandleGetThread))
+	stopDataExportSweeper := types.StartDataExportSweeper(store)
+	defer stopDataExportSweeper()
 
-	// request config
-	handler.Router.Use(mux.CORSMethodMiddleware(handler.Router))
+	fmt.Println("Registering handlers...")
+	handlers.RegisterRoutes(handler)
 
 	srv := &http.Server{
 		Handler:      handler.Router,
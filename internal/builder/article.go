@@ -3,6 +3,7 @@ package builder
 import (
 	"github.com/dd-web/opforu-server/internal/types"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // list of paginated articles
@@ -29,7 +30,9 @@ func QrStrLookupArticleList(cfg *types.QueryCtx) bson.A {
 }
 
 // single article with populated comments
-func QrStrLookupArticle(slug string) bson.A {
+// deleted comments are shown as tombstones, staff see what was deleted
+// shadow banned comments are left out for everyone but their author and staff
+func QrStrLookupArticle(slug string, staff bool, viewer primitive.ObjectID) bson.A {
 	return bson.A{
 		BsonOperator("$match", "slug", slug),
 		QrStrLookupArticleAuthor("author"),
@@ -43,7 +46,7 @@ func QrStrLookupArticle(slug string) bson.A {
 		BsonD("$unset", "co_authors._id"),
 		BsonD("$unset", "co_authors.created_at"),
 		BsonD("$unset", "co_authors.updated_at"),
-		BsonLookup("article_comments", "comments", "_id", "comments", bson.D{}, getCommentPipe()),
+		BsonLookup("article_comments", "comments", "_id", "comments", bson.D{}, getCommentPipe(staff, viewer)),
 		QrStrLookupAssets("assets"),
 	}
 }
@@ -63,8 +66,14 @@ func getAuthorPipe() bson.A {
 }
 
 // comment lookup internal pipe
-func getCommentPipe() bson.A {
-	return bson.A{
+func getCommentPipe(staff bool, viewer primitive.ObjectID) bson.A {
+	pipe := QrStrShadowBanAccount("author", viewer, staff)
+	if !staff {
+		pipe = append(pipe, QrStrTombstone("comment"))
+	}
+
+	return append(
+		pipe,
 		QrStrLookupAccount("author"),
 		BsonOperator("$addFields", "author", BsonOperWithArray("$arrayElemAt", []interface{}{"$author", 0})),
 		BsonOperator("$addFields", "author.username", BsonOperWithArray("$cond", []interface{}{"$author_anonymous", "anonymous", "$author.username"})),
@@ -72,6 +81,7 @@ func getCommentPipe() bson.A {
 		BsonD("$unset", "author.created_at"),
 		BsonD("$unset", "author.updated_at"),
 		BsonD("$unset", "author_anonymous"),
+		BsonD("$unset", "raw"),
 		QrStrLookupAssets("assets"),
-	}
+	)
 }
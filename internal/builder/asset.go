@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 var (
@@ -42,3 +43,15 @@ func QrStrFindHashCollision(hash string, method string) bson.D {
 		},
 	)
 }
+
+// singular asset lookup - shaped like the assets populated on threads and posts
+func QrStrLookupAsset(id primitive.ObjectID) bson.A {
+	return bson.A{
+		BsonOperator("$match", "_id", id),
+		BsonD("$limit", 1),
+		BsonLookup("asset_sources", "source_id", "_id", "source", bson.D{}, bson.A{}),
+		BsonOperator("$addFields", "source", BsonOperWithArray("$arrayElemAt", []interface{}{"$source", 0})),
+		BsonProjectionMap(ASSET_PUBLIC_PROJECTION),
+		BsonOperWithArray("$unset", []interface{}{"_id"}),
+	}
+}
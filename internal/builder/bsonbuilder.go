@@ -100,18 +100,26 @@ func StartPaginatedPipe(mkey string, mval primitive.ObjectID, cfg *types.QueryCt
 		return nil, fmt.Errorf("invalid match key: %s", mkey)
 	}
 
-	match := BsonD("$match", BsonD(mkey, mval))
-	match = append(match, cfg.Search...)
+	return StartPaginatedPipeFilter(BsonD(mkey, mval), cfg), nil
+}
+
+// starts a paginated pipeline with a match on the filter
+// - stages run after the match but before the page is taken, for filtering that can't be done in the match
+func StartPaginatedPipeFilter(filter bson.D, cfg *types.QueryCtx, stages ...any) bson.A {
+	match := BsonD("$match", append(append(bson.D{}, filter...), cfg.Search...))
 
 	sort := cfg.Sort
 	if sort == "" {
 		sort = "updated_at"
 	}
 
-	return BsonA([]any{
-		match,
+	pipe := bson.A{match}
+	pipe = append(pipe, stages...)
+
+	return append(
+		pipe,
 		BsonOperator("$sort", sort, cfg.Order),
 		BsonD("$skip", cfg.Skip),
 		BsonD("$limit", cfg.Limit),
-	}), nil
+	)
 }
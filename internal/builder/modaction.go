@@ -0,0 +1,22 @@
+package builder
+
+import (
+	"github.com/dd-web/opforu-server/internal/types"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// paginated list of mod actions matching the filter with the actor and board populated
+func QrStrLookupModActions(filter bson.D, cfg *types.QueryCtx) bson.A {
+	return bson.A{
+		BsonD("$match", filter),
+		// actions recorded in the same millisecond keep the order they were recorded in
+		BsonD("$sort", bson.D{{Key: "created_at", Value: cfg.Order}, {Key: "_id", Value: cfg.Order}}),
+		BsonD("$skip", cfg.Skip),
+		BsonD("$limit", cfg.Limit),
+		BsonLookup("accounts", "actor_id", "_id", "actor", bson.D{}, bson.A{BsonProjection([]string{"username", "role"}, 1)}),
+		BsonOperator("$addFields", "actor", BsonOperWithArray("$arrayElemAt", []interface{}{"$actor", 0})),
+		BsonLookup("boards", "board_id", "_id", "board", bson.D{}, bson.A{BsonProjection([]string{"short", "title"}, 1)}),
+		BsonOperator("$addFields", "board", BsonOperWithArray("$arrayElemAt", []interface{}{"$board", 0})),
+		BsonOperWithArray("$unset", []interface{}{"actor._id", "board._id"}),
+	}
+}
@@ -1,6 +1,7 @@
 package builder
 
 import (
+	"github.com/dd-web/opforu-server/internal/types"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
@@ -12,40 +13,89 @@ func QrStrAddCreatorOnThread() bson.D {
 }
 
 // Thread posts aggregation lookup pipeline
-func QrStrLookupPosts(sortBy string, sortDir int, limit int) bson.D {
-	pipe := bson.A{
-		BsonOperator("$sort", sortBy, sortDir),
-	}
+// deleted posts are shown as tombstones, staff see what was deleted
+// shadow banned posts are left out for everyone but their author and staff, see QrStrShadowBanIdentity
+func QrStrLookupPosts(sortBy string, sortDir int, limit int, staff bool, viewer primitive.ObjectID) bson.D {
+	pipe := QrStrShadowBanIdentity("creator", viewer, staff)
+	pipe = append(pipe, BsonOperator("$sort", sortBy, sortDir))
 
 	if limit > 0 {
 		pipe = append(pipe, BsonD("$limit", limit))
 	}
 
+	if !staff {
+		pipe = append(pipe, QrStrTombstone("post"))
+	}
+
 	pipe = append(
 		pipe,
 		QrStrLookupAssets("assets"),
 		QrStrLookupIdentity("creator"),
 		BsonOperator("$addFields", "creator", BsonOperWithArray("$arrayElemAt", []interface{}{"$creator", 0})),
-		BsonOperWithArray("$unset", []interface{}{"thread", "board", "account", "creator._id"}),
+		BsonOperWithArray("$unset", []interface{}{"thread", "board", "account", "raw", "ip_hash", "creator._id"}),
 	)
 
 	return BsonLookup("posts", "posts", "_id", "posts", bson.D{}, pipe)
 }
 
+// number of posts in a thread as post_count
+// staff count every post, everyone else only counts the posts they'd see that aren't tombstones,
+// so deleted, held and shadow banned posts are left out
+func QrStrCountPosts(staff bool, viewer primitive.ObjectID) bson.A {
+	if staff {
+		return bson.A{BsonOperator("$addFields", "post_count", BsonD("$size", "$posts"))}
+	}
+
+	pipe := bson.A{BsonOperator("$match", "deleted_at", BsonD("$exists", false))}
+	pipe = append(pipe, QrStrShadowBanIdentity("creator", viewer, staff)...)
+	pipe = append(pipe, BsonProjection([]string{"_id"}, 1))
+
+	return bson.A{
+		BsonLookup("posts", "posts", "_id", "post_count", bson.D{}, pipe),
+		BsonOperator("$addFields", "post_count", BsonD("$size", "$post_count")),
+	}
+}
+
 // singular post lookup
-func QrStrLookupPost(threadID primitive.ObjectID, postNum int, threadSlug, boardShort string) bson.A {
+// a deleted post is shown as a tombstone, staff see what was deleted
+// a shadow banned post is only found by it's author and staff
+func QrStrLookupPost(threadID primitive.ObjectID, postNum int, threadSlug, boardShort string, staff bool, viewer primitive.ObjectID) bson.A {
 	pipe := bson.D{}
 	pipe = append(pipe, BsonE("thread", threadID))
 	pipe = append(pipe, BsonE("post_number", postNum))
 
-	return bson.A{
+	lookup := bson.A{
 		BsonD("$match", pipe),
 		BsonD("$limit", 1),
+	}
+	lookup = append(lookup, QrStrShadowBanIdentity("creator", viewer, staff)...)
+
+	if !staff {
+		lookup = append(lookup, QrStrTombstone("post"))
+	}
+
+	return append(
+		lookup,
 		QrStrLookupAssets("assets"),
 		QrStrLookupIdentity("creator"),
 		BsonOperator("$addFields", "creator", BsonOperWithArray("$arrayElemAt", []interface{}{"$creator", 0})),
 		BsonOperator("$addFields", "thread", threadSlug),
 		BsonOperator("$addFields", "board", boardShort),
-		BsonOperWithArray("$unset", []interface{}{"account", "_id", "creator._id"}),
-	}
+		BsonOperWithArray("$unset", []interface{}{"account", "_id", "raw", "ip_hash", "creator._id"}),
+	)
+}
+
+// replaces the body and assets of deleted content with a tombstone saying who deleted it
+// - accepts the name of the content shown in the tombstone, "post deleted by moderator"
+func QrStrTombstone(resource string) bson.D {
+	tombstone := BsonOperWithArray("$cond", []interface{}{
+		BsonOperWithArray("$eq", []interface{}{"$deleted_by", string(types.DeletedBySpamFilter)}),
+		resource + " held for review",
+		BsonOperWithArray("$concat", []interface{}{resource + " deleted by ", "$deleted_by"}),
+	})
+
+	return BsonD("$addFields", bson.D{
+		BsonE("body", BsonOperWithArray("$cond", []interface{}{"$deleted_at", tombstone, "$body"})),
+		BsonE("assets", BsonOperWithArray("$cond", []interface{}{"$deleted_at", bson.A{}, "$assets"})),
+	})
 }
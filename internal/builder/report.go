@@ -0,0 +1,45 @@
+package builder
+
+import (
+	"github.com/dd-web/opforu-server/internal/types"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// reports matching the filter grouped by the content they're against
+func qrStrGroupReports(filter bson.D) bson.A {
+	return bson.A{
+		BsonD("$match", filter),
+		BsonD("$group", bson.D{
+			BsonE("_id", "$target_id"),
+			BsonE("target_type", BsonD("$first", "$target_type")),
+			BsonE("board_id", BsonD("$first", "$board_id")),
+			BsonE("location", BsonD("$first", "$location")),
+			BsonE("reports", BsonD("$sum", 1)),
+			BsonE("categories", BsonD("$addToSet", "$category")),
+			BsonE("claimed_by", BsonD("$max", "$claimed_by")),
+			BsonE("claimed_at", BsonD("$max", "$claimed_at")),
+			BsonE("first_reported_at", BsonD("$min", "$created_at")),
+			BsonE("last_reported_at", BsonD("$max", "$created_at")),
+		}),
+	}
+}
+
+// page of the report queue, the most reported content first then the longest waiting
+func QrStrLookupReportQueue(filter bson.D, cfg *types.QueryCtx) bson.A {
+	return append(
+		qrStrGroupReports(filter),
+		BsonD("$sort", bson.D{BsonE("reports", -1), BsonE("first_reported_at", 1), BsonE("_id", 1)}),
+		BsonD("$skip", cfg.Skip),
+		BsonD("$limit", cfg.Limit),
+		BsonLookup("accounts", "claimed_by", "_id", "claimed_by", bson.D{}, bson.A{BsonProjection([]string{"username"}, 1)}),
+		BsonOperator("$addFields", "claimed_by", BsonOperWithArray("$arrayElemAt", []interface{}{"$claimed_by", 0})),
+		BsonLookup("boards", "board_id", "_id", "board", bson.D{}, bson.A{BsonProjection([]string{"short", "title"}, 1)}),
+		BsonOperator("$addFields", "board", BsonOperWithArray("$arrayElemAt", []interface{}{"$board", 0})),
+		BsonOperWithArray("$unset", []interface{}{"claimed_by._id", "board._id", "board_id", "location.thread_id"}),
+	)
+}
+
+// number of distinct pieces of content in the report queue
+func QrStrCountReportQueue(filter bson.D) bson.A {
+	return append(qrStrGroupReports(filter), BsonD("$count", "total"))
+}
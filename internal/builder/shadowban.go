@@ -0,0 +1,57 @@
+package builder
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// shadow banned content lookup for content written through an identity (threads and posts)
+// the content is hidden if either the identity or the account behind it is shadow banned
+// - pk is the field holding the identity _id
+func QrStrShadowBanIdentity(pk string, viewer primitive.ObjectID, staff bool) bson.A {
+	pipe := bson.A{
+		BsonLookup("identities", pk, "_id", "shadow_identity", bson.D{}, bson.A{BsonProjection([]string{"account", "shadow_banned"}, 1)}),
+		BsonOperator("$addFields", "shadow_identity", BsonOperWithArray("$arrayElemAt", []interface{}{"$shadow_identity", 0})),
+	}
+
+	return append(pipe, qrStrShadowBan("shadow_identity.account", bson.A{"$shadow_identity.shadow_banned"}, viewer, staff)...)
+}
+
+// shadow banned content lookup for content written by an account directly (article comments)
+// - pk is the field holding the account _id
+func QrStrShadowBanAccount(pk string, viewer primitive.ObjectID, staff bool) bson.A {
+	return qrStrShadowBan(pk, bson.A{}, viewer, staff)
+}
+
+// flags content from a shadow banned source as "shadowed", then hides it from everyone but it's author.
+// staff keep the flag so the content can be highlighted, the author sees it like any other content
+// - account is the field holding the author's account _id
+// - banned are other expressions that shadow the content when true
+// - viewer is the account looking, the nil object id when signed out
+func qrStrShadowBan(account string, banned bson.A, viewer primitive.ObjectID, staff bool) bson.A {
+	shadowed := bson.A{BsonOperWithArray("$eq", []interface{}{"$shadow_account.shadow_banned", true})}
+	for _, v := range banned {
+		shadowed = append(shadowed, BsonOperWithArray("$eq", []interface{}{v, true}))
+	}
+
+	pipe := bson.A{
+		BsonLookup("accounts", account, "_id", "shadow_account", bson.D{}, bson.A{BsonProjection([]string{"shadow_banned"}, 1)}),
+		BsonOperator("$addFields", "shadow_account", BsonOperWithArray("$arrayElemAt", []interface{}{"$shadow_account", 0})),
+		BsonOperator("$addFields", "shadowed", BsonD("$or", shadowed)),
+	}
+
+	if !staff {
+		visible := bson.A{BsonOperWithArray("$not", []interface{}{"$shadowed"})}
+		if !viewer.IsZero() {
+			visible = append(visible, BsonOperWithArray("$eq", []interface{}{"$" + account, viewer}))
+		}
+
+		pipe = append(
+			pipe,
+			BsonD("$match", BsonD("$expr", BsonD("$or", visible))),
+			BsonD("$unset", "shadowed"),
+		)
+	}
+
+	return append(pipe, BsonOperWithArray("$unset", []interface{}{"shadow_identity", "shadow_account"}))
+}
@@ -1,60 +1,91 @@
 package builder
 
 import (
+	"fmt"
+
 	"github.com/dd-web/opforu-server/internal/types"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // List of paginated thread previews for a board
-func QrStrLookupThreads(boardID primitive.ObjectID, cfg *types.QueryCtx) (bson.A, error) {
-	pipe, err := StartPaginatedPipe("board", boardID, cfg)
-	if err != nil {
-		return nil, err
+// deleted threads are only included for staff, who also see deleted posts instead of their tombstones
+// shadow banned threads and posts are only included for their author and staff
+func QrStrLookupThreads(boardID primitive.ObjectID, cfg *types.QueryCtx, staff bool, viewer primitive.ObjectID) (bson.A, error) {
+	if boardID == primitive.NilObjectID {
+		return nil, fmt.Errorf("invalid board id")
 	}
 
+	pipe := StartPaginatedPipeFilter(types.ThreadListingFilter(boardID, staff), cfg, QrStrShadowBanIdentity("creator", viewer, staff)...)
+
+	pipe = append(pipe, QrStrCountPosts(staff, viewer)...)
 	pipe = append(
 		pipe,
-		BsonOperator("$addFields", "post_count", BsonD("$size", "$posts")),
-		QrStrLookupPosts("post_number", -1, 5),
+		QrStrAddReadOnly(),
+		QrStrLookupPosts("post_number", -1, 5, staff, viewer),
 		QrStrLookupIdentity("creator"),
 		BsonOperator("$addFields", "creator", BsonOperWithArray("$arrayElemAt", []interface{}{"$creator", 0})),
 		QrStrLookupIdentity("mods"),
-		BsonOperWithArray("$unset", []interface{}{"board", "account", "creator._id", "mods._id"}),
+		BsonOperWithArray("$unset", []interface{}{"board", "account", "raw", "ip_hash", "creator._id", "mods._id"}),
 		QrStrLookupAssets("assets"),
 	)
 
 	return pipe, nil
 }
 
+// number of threads QrStrLookupThreads pages through
+func QrStrCountThreads(boardID primitive.ObjectID, cfg *types.QueryCtx, staff bool, viewer primitive.ObjectID) bson.A {
+	pipe := bson.A{BsonD("$match", append(types.ThreadListingFilter(boardID, staff), cfg.Search...))}
+	pipe = append(pipe, QrStrShadowBanIdentity("creator", viewer, staff)...)
+	return append(pipe, BsonD("$count", "total"))
+}
+
 // a single thread with all posts populated
-func QrStrEntireThread(slug string, cfg *types.QueryCtx) bson.A {
-	return bson.A{
-		BsonOperator("$match", "slug", slug),
-		QrStrLookupPosts("post_number", 1, 0),
+// deleted posts are shown as tombstones, staff see what was deleted
+// a shadow banned thread is only found by it's author and staff, the same goes for it's posts
+func QrStrEntireThread(slug string, cfg *types.QueryCtx, staff bool, viewer primitive.ObjectID) bson.A {
+	pipe := bson.A{BsonOperator("$match", "slug", slug)}
+	pipe = append(pipe, QrStrShadowBanIdentity("creator", viewer, staff)...)
+
+	return append(
+		pipe,
+		QrStrAddReadOnly(),
+		QrStrLookupPosts("post_number", 1, 0, staff, viewer),
 		QrStrLookupIdentity("creator"),
 		BsonOperator("$addFields", "creator", BsonOperWithArray("$arrayElemAt", []interface{}{"$creator", 0})),
 		QrStrLookupIdentity("mods"),
-		BsonOperWithArray("$unset", []interface{}{"board", "account", "creator._id", "mods._id"}),
+		BsonOperWithArray("$unset", []interface{}{"board", "account", "raw", "ip_hash", "creator._id", "mods._id"}),
 		QrStrLookupAssets("assets"),
-	}
+	)
 }
 
 // singular thread lookup - models in the shape of post
-func QrStrLookupThread(slug string, boardID primitive.ObjectID, boardShort string) bson.A {
+// a shadow banned thread is only found by it's author and staff
+func QrStrLookupThread(slug string, boardID primitive.ObjectID, boardShort string, staff bool, viewer primitive.ObjectID) bson.A {
 	pipe := bson.D{}
 	pipe = append(pipe, BsonE("slug", slug))
 	pipe = append(pipe, BsonE("board", boardID))
 
-	return bson.A{
+	lookup := bson.A{
 		BsonD("$match", pipe),
 		BsonD("$limit", 1),
+	}
+	lookup = append(lookup, QrStrShadowBanIdentity("creator", viewer, staff)...)
+	lookup = append(lookup, QrStrCountPosts(staff, viewer)...)
+
+	return append(
+		lookup,
 		QrStrLookupAssets("assets"),
 		QrStrLookupIdentity("creator"),
 		BsonOperator("$addFields", "creator", BsonOperWithArray("$arrayElemAt", []interface{}{"$creator", 0})),
 		BsonOperator("$addFields", "thread", slug),
 		BsonOperator("$addFields", "board", boardShort),
-		BsonOperator("$addFields", "post_count", BsonD("$size", "$posts")),
-		BsonOperWithArray("$unset", []interface{}{"account", "_id", "creator._id", "flags", "posts"}),
-	}
+		QrStrAddReadOnly(),
+		BsonOperWithArray("$unset", []interface{}{"account", "_id", "raw", "ip_hash", "creator._id", "flags", "posts"}),
+	)
+}
+
+// flags threads that can't be replied to so clients render them read only
+func QrStrAddReadOnly() bson.D {
+	return BsonOperator("$addFields", "read_only", BsonOperWithArray("$ne", []interface{}{"$status", types.ThreadStatusOpen}))
 }
@@ -5,11 +5,17 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/dd-web/opforu-server/internal/builder"
 	"github.com/dd-web/opforu-server/internal/types"
 	"github.com/dd-web/opforu-server/internal/utils"
+	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type AccountHandler struct {
@@ -31,6 +37,8 @@ func (ah *AccountHandler) RegisterAccountRoot(rc *types.RequestCtx) error {
 	switch rc.Request.Method {
 	case "GET":
 		return ah.handleGetAccount(rc)
+	case "DELETE":
+		return ah.handleDeleteAccount(rc)
 	default:
 		return HandleUnsupportedMethod(rc.Writer, rc.Request)
 	}
@@ -38,8 +46,139 @@ func (ah *AccountHandler) RegisterAccountRoot(rc *types.RequestCtx) error {
 
 // METHOD: GET
 // PATH: host.com/api/account
+// the account is added to every resolved response, there's nothing else to look up
 func (ah *AccountHandler) handleGetAccount(rc *types.RequestCtx) error {
-	return HandleSendJSON(rc.Writer, http.StatusOK, bson.M{"message": "account handler"}, rc)
+	return ResolveResponse(rc)
+}
+
+// METHOD: DELETE
+// PATH: host.com/api/account
+// signs the account out everywhere and schedules it to be purged once the grace period ends
+func (ah *AccountHandler) handleDeleteAccount(rc *types.RequestCtx) error {
+	if rc.UnresolvedAccount {
+		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+	}
+
+	if !rc.AccountCtx.IsSessionAuth() {
+		return ResolveResponseErr(rc, types.ErrorSessionRequired())
+	}
+
+	body, err := io.ReadAll(rc.Request.Body)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	var parsed struct {
+		CurrentPassword string `json:"current_password"`
+	}
+
+	err = json.Unmarshal(body, &parsed)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
+	}
+
+	if apiErr := ah.checkCurrentPassword(rc, parsed.CurrentPassword); apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	account := rc.AccountCtx.Account
+	account.MarkDeleted()
+
+	err = rc.Store.UpdateAccount(account)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	err = rc.Store.DeleteAccountSessions(account.ID)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	_, err = rc.Store.DeleteMulti(bson.D{{Key: "account_id", Value: account.ID}}, "api_tokens")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	types.ClearSessionCookie(rc.Writer)
+
+	// bypass the response resolver so it doesn't auto populate the deleted session
+	return HandleSendJSON(rc.Writer, http.StatusOK, bson.M{"message": "account deleted", "purge_at": account.PurgeAt()}, rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/restore
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountRestore(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return ah.handlePostAccountRestore(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/account/restore
+// cancels a deletion that's still within it's grace period. no session is started, the account
+// logs in as usual afterwards so two-factor still applies
+func (ah *AccountHandler) handlePostAccountRestore(rc *types.RequestCtx) error {
+	body, err := io.ReadAll(rc.Request.Body)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	var parsed struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+
+	err = json.Unmarshal(body, &parsed)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
+	}
+
+	keys := []string{types.LoginAttemptsIPKey(rc.RemoteIP())}
+
+	account, err := rc.Store.FindAccountByUsernameOrEmail(parsed.Username, "")
+	if err == nil {
+		keys = append(keys, types.LoginAttemptsAccountKey(account))
+	}
+
+	retryAfter, lookupErr := ah.findLoginAttempts(rc, keys)
+	if lookupErr != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	if retryAfter > 0 {
+		return ResolveResponseErr(rc, types.ErrorTooManyRequests(retryAfter))
+	}
+
+	// unknown accounts are still compared so they aren't told apart by how quickly they fail
+	hashed := ""
+	if err == nil {
+		hashed = account.Password
+	}
+
+	if !utils.CompareHash(hashed, parsed.Password) {
+		apiErr := types.ErrorUnauthorized()
+		apiErr.RetryAfter = ah.recordLoginFailures(rc, keys)
+		return ResolveResponseErr(rc, apiErr)
+	}
+
+	if !account.IsDeleted() {
+		return ResolveResponseErr(rc, types.ErrorConflict("account is not deleted"))
+	}
+
+	account.Restore()
+
+	err = rc.Store.UpdateAccount(account)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	return HandleSendJSON(rc.Writer, http.StatusOK, bson.M{"message": "account restored"}, rc)
 }
 
 /***********************************************************************************************/
@@ -72,32 +211,146 @@ func (ah *AccountHandler) handlePostAccountLogin(rc *types.RequestCtx) error {
 
 	err = json.Unmarshal(body, &parsed)
 	if err != nil {
-		return ResolveResponseErr(rc, types.ErrorUnexpected())
+		return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
 	}
 
+	// failures always count against the address, and against the account when there is one
+	keys := []string{types.LoginAttemptsIPKey(rc.RemoteIP())}
+
 	account, err := rc.Store.FindAccountByUsernameOrEmail(parsed.Username, "")
+	if err == nil {
+		keys = append(keys, types.LoginAttemptsAccountKey(account))
+	}
+
+	retryAfter, lookupErr := ah.findLoginAttempts(rc, keys)
+	if lookupErr != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	if retryAfter > 0 {
+		return ResolveResponseErr(rc, types.ErrorTooManyRequests(retryAfter))
+	}
+
+	// unknown accounts are still compared so they aren't told apart by how quickly they fail
+	hashed := ""
+	if err == nil {
+		hashed = account.Password
+	}
+
+	if !utils.CompareHash(hashed, parsed.Password) {
+		apiErr := types.ErrorUnauthorized()
+		apiErr.RetryAfter = ah.recordLoginFailures(rc, keys)
+		return ResolveResponseErr(rc, apiErr)
+	}
+
+	// only said once the password is known to be right, otherwise it would reveal deleted accounts
+	if account.IsDeleted() {
+		return ResolveResponseErr(rc, types.ErrorForbidden("account is pending deletion, restore it to log in"))
+	}
+
+	// the counters are only cleared once the second factor is verified too, otherwise knowing
+	// the password would be enough to reset the throttling on guessing codes
+	if account.HasTwoFactor() {
+		return ah.resolveLoginChallenge(rc, account, false)
+	}
+
+	err = rc.Store.ClearLoginAttempts(keys...)
 	if err != nil {
-		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+		fmt.Println("Error clearing login attempts", err)
 	}
 
-	passwordMatches := utils.CompareHash(account.Password, parsed.Password)
-	if !passwordMatches {
-		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+	return ah.resolveNewSession(rc, account)
+}
+
+// responds with a challenge for the account's second factor instead of a session
+// - accepts whether the deleted account is restored once the challenge is passed, it's left
+// deleted until then
+func (ah *AccountHandler) resolveLoginChallenge(rc *types.RequestCtx, account *types.Account, restore bool) error {
+	challenge, token, err := types.NewLoginChallenge(account)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+	challenge.Restore = restore
+
+	err = rc.Store.SaveNewSingle(challenge, "login_challenges")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
 	}
 
+	rc.AddToResponseList("two_factor_required", true)
+	rc.AddToResponseList("challenge", token)
+
+	return ResolveResponse(rc)
+}
+
+// starts a new session for the account and responds with it
+func (ah *AccountHandler) resolveNewSession(rc *types.RequestCtx, account *types.Account) error {
 	session := types.NewSession(account)
+	session.IP = rc.RemoteIP()
+	session.UserAgent = rc.Request.UserAgent()
 
-	err = rc.Store.SaveNewSingle(session, "sessions")
+	err := rc.Store.SaveNewSingle(session, "sessions")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	types.SetSessionCookie(rc.Writer, session)
+
+	// sanctioned accounts can still log in, the response tells them why they can't do anything else
+	sanction, err := types.ResolveAccountSanction(rc.Store, account)
 	if err != nil {
 		return ResolveResponseErr(rc, types.ErrorUnexpected())
 	}
 
 	rc.AccountCtx.Account = account
 	rc.AccountCtx.Session = session
+	rc.AccountCtx.Sanction = sanction
 
 	return ResolveResponse(rc)
 }
 
+// records a failed attempt against each of the keys
+// - returns the longest time any of them must now wait, sent along with the failure so clients know
+// when to try again without having to be rejected first
+func (ah *AccountHandler) recordLoginFailures(rc *types.RequestCtx, keys []string) time.Duration {
+	now := time.Now().UTC()
+
+	var wait time.Duration
+	for _, key := range keys {
+		attempts, err := rc.Store.RecordLoginFailure(key)
+		if err != nil {
+			fmt.Println("Error recording login failure", err)
+			continue
+		}
+
+		if d := attempts.RetryAfter(now); d > wait {
+			wait = d
+		}
+	}
+
+	return wait
+}
+
+// finds the failed login attempts for each key
+// - returns the longest time any of them must wait before another attempt
+func (ah *AccountHandler) findLoginAttempts(rc *types.RequestCtx, keys []string) (time.Duration, error) {
+	now := time.Now().UTC()
+
+	var wait time.Duration
+	for _, key := range keys {
+		attempts, err := rc.Store.FindLoginAttempts(key)
+		if err != nil {
+			return 0, err
+		}
+
+		if d := attempts.RetryAfter(now); d > wait {
+			wait = d
+		}
+	}
+
+	return wait, nil
+}
+
 /***********************************************************************************************/
 /* ROOT path: host.com/api/account/register
 /***********************************************************************************************/
@@ -121,17 +374,18 @@ func (ah *AccountHandler) handlePostAccountRegister(rc *types.RequestCtx) error
 		return ResolveResponseErr(rc, types.ErrorUnexpected())
 	}
 
-	var parsed struct {
-		Username        string `json:"username"`
-		Email           string `json:"email"`
-		Password        string `json:"password"`
-		ConfirmPassword string `json:"confirm_password"`
-	}
+	parsed := &types.RUMRegister{}
 
-	err = json.Unmarshal(body, &parsed)
+	err = json.Unmarshal(body, parsed)
 	if err != nil {
-		fmt.Println("Error parsing body", err)
-		return ResolveResponseErr(rc, types.ErrorUnexpected())
+		return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
+	}
+
+	parsed.Normalize()
+
+	fields := parsed.Validate()
+	if !fields.Empty() {
+		return ResolveResponseErr(rc, types.ErrorValidation(fields))
 	}
 
 	account, _ := rc.Store.FindAccountByUsernameOrEmail(parsed.Username, parsed.Email)
@@ -147,82 +401,477 @@ func (ah *AccountHandler) handlePostAccountRegister(rc *types.RequestCtx) error
 	}
 
 	newAccount := types.NewAccount()
-	newAccount.Username = parsed.Username
+	newAccount.SetUsername(parsed.Username)
 	newAccount.Email = parsed.Email
 	newAccount.Password = pwh
 
-	session := types.NewSession(newAccount)
-
 	err = rc.Store.SaveNewSingle(newAccount, "accounts")
+	if mongo.IsDuplicateKeyError(err) {
+		// someone else took the username since it was checked
+		return ResolveResponseErr(rc, types.ErrorConflict("email or username already exists"))
+	}
 	if err != nil {
 		fmt.Println("Error saving new account", err)
 		return ResolveResponseErr(rc, types.ErrorUnexpected())
 	}
 
-	err = rc.Store.SaveNewSingle(session, "sessions")
+	// the account is still usable if this fails, they can request another link
+	err = ah.sendVerificationMail(newAccount)
+	if err != nil {
+		fmt.Println("Error sending verification mail", err)
+	}
+
+	return ah.resolveNewSession(rc, newAccount)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/verify
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountVerify(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return ah.handlePostAccountVerify(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/account/verify
+// activates the account the signed token in the verification mail was issued for
+func (ah *AccountHandler) handlePostAccountVerify(rc *types.RequestCtx) error {
+	body, err := io.ReadAll(rc.Request.Body)
 	if err != nil {
-		fmt.Println("Error saving new session", err)
 		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
 
+	var parsed struct {
+		Token string `json:"token"`
 	}
 
-	rc.AccountCtx.Account = newAccount
-	rc.AccountCtx.Session = session
+	err = json.Unmarshal(body, &parsed)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
+	}
+
+	subject, err := utils.VerifyToken(types.TOKEN_PURPOSE_VERIFY_EMAIL, parsed.Token)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("verification token"))
+	}
+
+	id, _, _ := strings.Cut(subject, ":")
+	accountID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("verification token"))
+	}
+
+	account, err := rc.Store.FindAccountByID(accountID)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("verification token"))
+	}
+
+	// the email changed since the token was issued
+	if account.VerificationSubject() != subject {
+		return ResolveResponseErr(rc, types.ErrorInvalid("verification token"))
+	}
+
+	if !account.IsVerified() {
+		account.MarkVerified()
+
+		err = rc.Store.UpdateAccount(account)
+		if err != nil {
+			return ResolveResponseErr(rc, types.ErrorUnexpected())
+		}
+	}
+
+	rc.AccountCtx.Account = account
 
 	return ResolveResponse(rc)
 }
 
 /***********************************************************************************************/
-/* ROOT path: host.com/api/account/logout
+/* ROOT path: host.com/api/account/verify/resend
 /***********************************************************************************************/
-func (ah *AccountHandler) RegisterAccountLogout(rc *types.RequestCtx) error {
+func (ah *AccountHandler) RegisterAccountVerifyResend(rc *types.RequestCtx) error {
 	rc.UpdateStore(ah.rh.Store)
 
 	switch rc.Request.Method {
 	case "POST":
-		return ah.handlePostAccountLogout(rc)
+		return ah.handlePostAccountVerifyResend(rc)
 	default:
 		return ResolveResponseErr(rc, types.ErrorUnsupported())
 	}
 }
 
 // METHOD: POST
-// PATH: host.com/api/account/logout
-func (ah *AccountHandler) handlePostAccountLogout(rc *types.RequestCtx) error {
+// PATH: host.com/api/account/verify/resend
+func (ah *AccountHandler) handlePostAccountVerifyResend(rc *types.RequestCtx) error {
+	if rc.UnresolvedAccount {
+		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+	}
+
+	if !rc.AccountCtx.IsSessionAuth() {
+		return ResolveResponseErr(rc, types.ErrorSessionRequired())
+	}
+
+	if rc.AccountCtx.Account.IsVerified() {
+		return ResolveResponseErr(rc, types.ErrorConflict("account is already verified"))
+	}
+
+	err := ah.sendVerificationMail(rc.AccountCtx.Account)
+	if err != nil {
+		fmt.Println("Error sending verification mail", err)
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	return HandleSendJSON(rc.Writer, http.StatusOK, bson.M{"message": "verification mail sent"}, rc)
+}
+
+// signs a verification token for the account's current email and mails the link to it
+func (ah *AccountHandler) sendVerificationMail(account *types.Account) error {
+	exp := time.Now().Add(time.Duration(types.VERIFICATION_TOKEN_HOURS) * time.Hour)
+	token := utils.SignToken(types.TOKEN_PURPOSE_VERIFY_EMAIL, account.VerificationSubject(), exp)
+	link := utils.AppURL() + "/account/verify?token=" + url.QueryEscape(token)
+
+	return ah.rh.Mailer.Send(types.NewVerificationMail(account, link))
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/password/forgot
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountPasswordForgot(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return ah.handlePostPasswordForgot(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/account/password/forgot
+// always responds the same way so it can't be used to find out which emails have accounts
+func (ah *AccountHandler) handlePostPasswordForgot(rc *types.RequestCtx) error {
 	body, err := io.ReadAll(rc.Request.Body)
 	if err != nil {
 		return ResolveResponseErr(rc, types.ErrorUnexpected())
 	}
 
 	var parsed struct {
-		SessionID string `json:"session_id"`
+		Email string `json:"email"`
 	}
 
 	err = json.Unmarshal(body, &parsed)
+	if err != nil || parsed.Email == "" {
+		return ResolveResponseErr(rc, types.ErrorInvalid("email"))
+	}
+
+	response := bson.M{"message": "if an account with that email exists a reset link has been sent to it"}
+
+	// every request counts against the address and the email, the address is throttled like logins
+	ipKey := types.PasswordResetAttemptsIPKey(rc.RemoteIP())
+
+	retryAfter, err := ah.findLoginAttempts(rc, []string{ipKey})
 	if err != nil {
 		return ResolveResponseErr(rc, types.ErrorUnexpected())
 	}
 
-	session, err := rc.Store.FindSession(parsed.SessionID)
+	if retryAfter > 0 {
+		return ResolveResponseErr(rc, types.ErrorTooManyRequests(retryAfter))
+	}
+
+	ah.recordLoginFailures(rc, []string{ipKey})
+
+	// the email only gets a link for the first request in a window, later ones get the same
+	// response without sending anything so they can't be used to flood someone's inbox
+	attempts, err := rc.Store.RecordLoginFailure(types.PasswordResetAttemptsEmailKey(parsed.Email))
 	if err != nil {
-		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	if attempts.Failures > 1 {
+		return HandleSendJSON(rc.Writer, http.StatusOK, response, rc)
+	}
+
+	account := &types.Account{}
+	err = rc.Store.FindSingle(bson.D{{Key: "email", Value: types.NormalizeEmail(parsed.Email)}}, account, "accounts")
+	if err != nil {
+		return HandleSendJSON(rc.Writer, http.StatusOK, response, rc)
+	}
+
+	// only the most recently requested link works
+	_, err = rc.Store.DeleteMulti(bson.D{{Key: "account_id", Value: account.ID}, {Key: "used_at", Value: bson.D{{Key: "$exists", Value: false}}}}, "password_resets")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	reset, token, err := types.NewPasswordReset(account)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	err = rc.Store.SaveNewSingle(reset, "password_resets")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	link := utils.AppURL() + "/account/password/reset?token=" + url.QueryEscape(token)
+	err = ah.rh.Mailer.Send(types.NewPasswordResetMail(account, link))
+	if err != nil {
+		fmt.Println("Error sending password reset mail", err)
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
 	}
 
-	err = rc.Store.DeleteSingle(session.ID, "sessions")
+	return HandleSendJSON(rc.Writer, http.StatusOK, response, rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/password/reset
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountPasswordReset(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return ah.handlePostPasswordReset(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/account/password/reset
+// sets a new password using a reset token and signs the account out everywhere
+func (ah *AccountHandler) handlePostPasswordReset(rc *types.RequestCtx) error {
+	body, err := io.ReadAll(rc.Request.Body)
 	if err != nil {
 		return ResolveResponseErr(rc, types.ErrorUnexpected())
 	}
 
-	for k := range rc.Store.Cache.Sessions {
-		if k == parsed.SessionID {
-			delete(rc.Store.Cache.Sessions, k)
+	var parsed struct {
+		Token           string `json:"token"`
+		Password        string `json:"password"`
+		ConfirmPassword string `json:"confirm_password"`
+	}
+
+	err = json.Unmarshal(body, &parsed)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
+	}
+
+	reset := &types.PasswordReset{}
+	err = rc.Store.FindSingle(bson.D{{Key: "token_hash", Value: utils.HashToken(parsed.Token)}}, reset, "password_resets")
+	if err != nil || !reset.IsUsable() {
+		return ResolveResponseErr(rc, types.ErrorInvalid("reset token"))
+	}
+
+	account, err := rc.Store.FindAccountByID(reset.AccountID)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("reset token"))
+	}
+
+	fields := types.FieldErrors{}
+	if problem := types.ValidatePassword(parsed.Password, account.Username); problem != "" {
+		fields.Add("password", problem)
+	}
+	if parsed.ConfirmPassword != parsed.Password {
+		fields.Add("confirm_password", "does not match password")
+	}
+	if !fields.Empty() {
+		return ResolveResponseErr(rc, types.ErrorValidation(fields))
+	}
+
+	// claim the token before changing anything so it can't be used twice concurrently
+	ts := time.Now().UTC()
+	claimed, err := rc.Store.UpdateMulti(
+		bson.D{{Key: "_id", Value: reset.ID}, {Key: "used_at", Value: bson.D{{Key: "$exists", Value: false}}}},
+		builder.BsonOperator("$set", "used_at", ts),
+		"password_resets",
+	)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	if claimed == 0 {
+		return ResolveResponseErr(rc, types.ErrorInvalid("reset token"))
+	}
+
+	pwh, err := utils.HashPassword(parsed.Password)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	account.Password = pwh
+	account.UpdatedAt = &ts
+
+	err = rc.Store.UpdateAccount(account)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	err = rc.Store.DeleteAccountSessions(account.ID)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	return HandleSendJSON(rc.Writer, http.StatusOK, bson.M{"message": "password has been reset"}, rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/logout
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountLogout(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return ah.handlePostAccountLogout(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/account/logout
+// ends the session in the cookie and clears it
+func (ah *AccountHandler) handlePostAccountLogout(rc *types.RequestCtx) error {
+	if rc.AccountCtx.CSRFRejected {
+		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+	}
+
+	if rc.AccountCtx.Session != nil {
+		err := rc.Store.DeleteSession(rc.AccountCtx.Session)
+		if err != nil {
+			return ResolveResponseErr(rc, types.ErrorUnexpected())
 		}
 	}
 
+	types.ClearSessionCookie(rc.Writer)
+
 	// bypass the response resolver so it doesn't auto populate the deleted session
 	return HandleSendJSON(rc.Writer, http.StatusOK, bson.M{"message": "logged out"}, rc)
 }
 
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/sessions
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountSessions(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "GET":
+		return ah.handleGetSessions(rc)
+	case "DELETE":
+		return ah.handleDeleteOtherSessions(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: GET
+// PATH: host.com/api/account/sessions
+// lists the account's active sessions, flagging the one making the request
+func (ah *AccountHandler) handleGetSessions(rc *types.RequestCtx) error {
+	if rc.UnresolvedAccount {
+		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+	}
+
+	if !rc.AccountCtx.IsSessionAuth() {
+		return ResolveResponseErr(rc, types.ErrorSessionRequired())
+	}
+
+	sessions, err := rc.Store.FindAccountSessions(rc.AccountCtx.Account.ID)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	formatted := make([]bson.M, 0, len(sessions))
+	for _, v := range sessions {
+		formatted = append(formatted, v.ListFormat(v.ID == rc.AccountCtx.Session.ID))
+	}
+
+	rc.AddToResponseList("sessions", formatted)
+
+	return ResolveResponse(rc)
+}
+
+// METHOD: DELETE
+// PATH: host.com/api/account/sessions
+// logs out everywhere else, only the session making the request is kept
+func (ah *AccountHandler) handleDeleteOtherSessions(rc *types.RequestCtx) error {
+	if rc.UnresolvedAccount {
+		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+	}
+
+	if !rc.AccountCtx.IsSessionAuth() {
+		return ResolveResponseErr(rc, types.ErrorSessionRequired())
+	}
+
+	err := rc.Store.DeleteAccountSessions(rc.AccountCtx.Account.ID, rc.AccountCtx.Session.ID)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/sessions/{id}
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountSessionID(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "DELETE":
+		return ah.handleDeleteSession(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: DELETE
+// PATH: host.com/api/account/sessions/{id}
+// revokes one of the account's sessions by it's _id
+func (ah *AccountHandler) handleDeleteSession(rc *types.RequestCtx) error {
+	if rc.UnresolvedAccount {
+		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+	}
+
+	if !rc.AccountCtx.IsSessionAuth() {
+		return ResolveResponseErr(rc, types.ErrorSessionRequired())
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(rc.Request)["id"])
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("session id"))
+	}
+
+	// scoped to the account so other account's sessions are indistinguishable from missing ones
+	session := &types.Session{}
+	err = rc.Store.FindSingle(bson.D{{Key: "_id", Value: id}, {Key: "account_id", Value: rc.AccountCtx.Account.ID}}, session, "sessions")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorNotFound("session"))
+	}
+
+	err = rc.Store.DeleteSession(session)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	// revoking the current session is a logout, don't send it back
+	if session.ID == rc.AccountCtx.Session.ID {
+		rc.AccountCtx.Session = nil
+		rc.AccountCtx.Account = nil
+		types.ClearSessionCookie(rc.Writer)
+	}
+
+	return ResolveResponse(rc)
+}
+
 /***********************************************************************************************/
 /* ROOT path: host.com/api/account/posts
 /***********************************************************************************************/
@@ -240,10 +889,6 @@ func (ah *AccountHandler) RegisterAccountPosts(rc *types.RequestCtx) error {
 // METHOD: GET
 // PATH: host.com/api/account/posts
 func (ah *AccountHandler) handleGetRecentPosts(rc *types.RequestCtx) error {
-	if rc.UnresolvedAccount {
-		return ResolveResponseErr(rc, types.ErrorUnauthorized())
-	}
-
 	pipe := builder.QrStrLookupAccountRecentIdentities(rc.AccountCtx.Account.ID)
 	result, err := rc.Store.RunAggregation("identities", pipe)
 	if err != nil {
@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dd-web/opforu-server/internal/types"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type AdminHandler struct {
+	rh *types.RoutingHandler
+}
+
+func InitAdminHandler(rh *types.RoutingHandler) *AdminHandler {
+	return &AdminHandler{
+		rh: rh,
+	}
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/admin/settings
+/***********************************************************************************************/
+func (ah *AdminHandler) RegisterAdminSettings(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "GET":
+		return ah.handleGetSettings(rc)
+	case "POST":
+		return ah.handleUpdateSettings(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: GET
+// PATH: host.com/api/admin/settings
+func (ah *AdminHandler) handleGetSettings(rc *types.RequestCtx) error {
+	settings, err := rc.Store.FindSettings()
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	rc.AddToResponseList("settings", settings)
+
+	return ResolveResponse(rc)
+}
+
+// METHOD: POST
+// PATH: host.com/api/admin/settings
+// only the fields present in the body are changed
+func (ah *AdminHandler) handleUpdateSettings(rc *types.RequestCtx) error {
+	body, err := io.ReadAll(rc.Request.Body)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	var parsed struct {
+		RequireStaffTwoFactor *bool     `json:"require_staff_two_factor"`
+		SpamHoldScore         *float64  `json:"spam_hold_score"`
+		SpamRejectScore       *float64  `json:"spam_reject_score"`
+		SpamBannedPhrases     *[]string `json:"spam_banned_phrases"`
+		PostEditWindowMinutes *int      `json:"post_edit_window_minutes"`
+	}
+
+	err = json.Unmarshal(body, &parsed)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
+	}
+
+	settings, err := rc.Store.FindSettings()
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	if parsed.RequireStaffTwoFactor != nil {
+		// otherwise the admin would lose access to this page the moment it's saved
+		if *parsed.RequireStaffTwoFactor && !rc.AccountCtx.Account.HasTwoFactor() {
+			return ResolveResponseErr(rc, types.ErrorForbidden("enable two-factor authentication on your own account first"))
+		}
+		settings.RequireStaffTwoFactor = *parsed.RequireStaffTwoFactor
+	}
+
+	// settings saved before there were spam scores start from the defaults
+	settings.SpamHoldScore, settings.SpamRejectScore = settings.SpamThresholds()
+	if parsed.SpamHoldScore != nil {
+		settings.SpamHoldScore = *parsed.SpamHoldScore
+	}
+	if parsed.SpamRejectScore != nil {
+		settings.SpamRejectScore = *parsed.SpamRejectScore
+	}
+	if settings.SpamHoldScore <= 0 || settings.SpamRejectScore < settings.SpamHoldScore {
+		return ResolveResponseErr(rc, types.ErrorInvalid("spam scores"))
+	}
+
+	if parsed.SpamBannedPhrases != nil {
+		phrases := []string{}
+		for _, v := range *parsed.SpamBannedPhrases {
+			if v = strings.TrimSpace(v); v != "" {
+				phrases = append(phrases, v)
+			}
+		}
+		settings.SpamBannedPhrases = phrases
+	}
+
+	settings.PostEditWindowMinutes = int(settings.EditWindow() / time.Minute)
+	if parsed.PostEditWindowMinutes != nil {
+		if *parsed.PostEditWindowMinutes <= 0 {
+			return ResolveResponseErr(rc, types.ErrorInvalid("post edit window"))
+		}
+		settings.PostEditWindowMinutes = *parsed.PostEditWindowMinutes
+	}
+
+	before := *settings
+
+	ts := time.Now().UTC()
+	settings.UpdatedAt = &ts
+	settings.UpdatedBy = &rc.AccountCtx.Account.ID
+
+	err = rc.Store.UpdateSettings(settings)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	recordModAction(rc, types.NewModAction(rc.AccountCtx.Account.ID, types.ModActionSettingsUpdate, types.ModTargetSettings, settings.ID, "").Snapshot(before, settings))
+
+	rc.AddToResponseList("settings", settings)
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/admin/cache
+/***********************************************************************************************/
+func (ah *AdminHandler) RegisterAdminCache(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "GET":
+		return ah.handleGetCacheStats(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: GET
+// PATH: host.com/api/admin/cache
+func (ah *AdminHandler) handleGetCacheStats(rc *types.RequestCtx) error {
+	rc.AddToResponseList("cache", rc.Store.CacheStats())
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/admin/boards/{short}/roles
+/***********************************************************************************************/
+func (ah *AdminHandler) RegisterAdminBoardRoles(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "GET":
+		return ah.handleGetBoardRoles(rc)
+	case "POST":
+		return ah.handleGrantBoardRole(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: GET
+// PATH: host.com/api/admin/boards/{short}/roles
+func (ah *AdminHandler) handleGetBoardRoles(rc *types.RequestCtx) error {
+	board, err := rc.Store.FindBoardByShort(mux.Vars(rc.Request)["short"])
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorNotFound("board"))
+	}
+
+	roles := []*types.BoardRole{}
+	err = rc.Store.FindMulti(bson.D{{Key: "board_id", Value: board.ID}}, &roles, "board_roles")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	formatted := make([]bson.M, 0, len(roles))
+	for _, v := range roles {
+		formatted = append(formatted, v.CLFormat())
+	}
+
+	rc.AddToResponseList("roles", formatted)
+
+	return ResolveResponse(rc)
+}
+
+// METHOD: POST
+// PATH: host.com/api/admin/boards/{short}/roles
+// grants the account a role on the board, replacing any it already had there
+func (ah *AdminHandler) handleGrantBoardRole(rc *types.RequestCtx) error {
+	board, err := rc.Store.FindBoardByShort(mux.Vars(rc.Request)["short"])
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorNotFound("board"))
+	}
+
+	body, err := io.ReadAll(rc.Request.Body)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	var parsed struct {
+		Username string            `json:"username"`
+		Role     types.AccountRole `json:"role"`
+	}
+
+	err = json.Unmarshal(body, &parsed)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
+	}
+
+	if !types.IsGrantableBoardRole(parsed.Role) {
+		return ResolveResponseErr(rc, types.ErrorInvalid("role"))
+	}
+
+	account := &types.Account{}
+	err = rc.Store.FindSingle(bson.D{{Key: "username_key", Value: types.NormalizeUsername(parsed.Username)}}, account, "accounts")
+	if err == mongo.ErrNoDocuments {
+		return ResolveResponseErr(rc, types.ErrorNotFound("account"))
+	}
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	granted := bson.D{{Key: "account_id", Value: account.ID}, {Key: "board_id", Value: board.ID}}
+
+	var previous *types.BoardRole
+	existing := &types.BoardRole{}
+	err = rc.Store.FindSingle(granted, existing, "board_roles")
+	if err == nil {
+		previous = existing
+	} else if err != mongo.ErrNoDocuments {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	_, err = rc.Store.DeleteMulti(granted, "board_roles")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	role := types.NewBoardRole(account.ID, board.ID, parsed.Role, rc.AccountCtx.Account.ID)
+
+	err = rc.Store.SaveNewSingle(role, "board_roles")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	recordModAction(rc, types.NewModAction(rc.AccountCtx.Account.ID, types.ModActionBoardRoleGrant, types.ModTargetAccount, account.ID, "").OnBoard(board.ID).Snapshot(previous, role))
+
+	rc.AddToResponseListCLF("role", role)
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/admin/boards/{short}/roles/{account_id}
+/***********************************************************************************************/
+func (ah *AdminHandler) RegisterAdminBoardRoleID(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "DELETE":
+		return ah.handleRevokeBoardRole(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: DELETE
+// PATH: host.com/api/admin/boards/{short}/roles/{account_id}
+func (ah *AdminHandler) handleRevokeBoardRole(rc *types.RequestCtx) error {
+	vars := mux.Vars(rc.Request)
+
+	board, err := rc.Store.FindBoardByShort(vars["short"])
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorNotFound("board"))
+	}
+
+	account_id, err := primitive.ObjectIDFromHex(vars["account_id"])
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("account id"))
+	}
+
+	granted := bson.D{{Key: "account_id", Value: account_id}, {Key: "board_id", Value: board.ID}}
+
+	role := &types.BoardRole{}
+	err = rc.Store.FindSingle(granted, role, "board_roles")
+	if err == mongo.ErrNoDocuments {
+		return ResolveResponseErr(rc, types.ErrorNotFound("role"))
+	}
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	deleted, err := rc.Store.DeleteMulti(granted, "board_roles")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	if deleted == 0 {
+		return ResolveResponseErr(rc, types.ErrorNotFound("role"))
+	}
+
+	recordModAction(rc, types.NewModAction(rc.AccountCtx.Account.ID, types.ModActionBoardRoleRevoke, types.ModTargetAccount, account_id, "").OnBoard(board.ID).Snapshot(role, nil))
+
+	return ResolveResponse(rc)
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dd-web/opforu-server/internal/types"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/tokens
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountTokens(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "GET":
+		return ah.handleGetTokens(rc)
+	case "POST":
+		return ah.handleNewToken(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: GET
+// PATH: host.com/api/account/tokens
+func (ah *AccountHandler) handleGetTokens(rc *types.RequestCtx) error {
+	if rc.UnresolvedAccount {
+		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+	}
+
+	if !rc.AccountCtx.IsSessionAuth() {
+		return ResolveResponseErr(rc, types.ErrorSessionRequired())
+	}
+
+	tokens := []*types.APIToken{}
+	err := rc.Store.FindMulti(bson.D{{Key: "account_id", Value: rc.AccountCtx.Account.ID}}, &tokens, "api_tokens")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	formatted := make([]bson.M, 0, len(tokens))
+	for _, v := range tokens {
+		formatted = append(formatted, v.CLFormat())
+	}
+
+	rc.AddToResponseList("tokens", formatted)
+
+	return ResolveResponse(rc)
+}
+
+// METHOD: POST
+// PATH: host.com/api/account/tokens
+// mints a new token, the token is only ever in this response
+func (ah *AccountHandler) handleNewToken(rc *types.RequestCtx) error {
+	if rc.UnresolvedAccount {
+		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+	}
+
+	if !rc.AccountCtx.IsSessionAuth() {
+		return ResolveResponseErr(rc, types.ErrorSessionRequired())
+	}
+
+	body, err := io.ReadAll(rc.Request.Body)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	var parsed struct {
+		Name          string                `json:"name"`
+		Scopes        []types.APITokenScope `json:"scopes"`
+		ExpiresInDays int                   `json:"expires_in_days"` // 0 never expires
+	}
+
+	err = json.Unmarshal(body, &parsed)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
+	}
+
+	parsed.Name = strings.TrimSpace(parsed.Name)
+
+	fields := types.FieldErrors{}
+	if parsed.Name == "" {
+		fields.Add("name", "required")
+	} else if len(parsed.Name) > types.API_TOKEN_NAME_MAX_LENGTH {
+		fields.Add("name", "too long")
+	}
+
+	if len(parsed.Scopes) == 0 {
+		fields.Add("scopes", "at least one scope is required")
+	}
+	for _, scope := range parsed.Scopes {
+		if !scope.IsValid() {
+			fields.Add("scopes", "unknown scope "+string(scope))
+		}
+		if scope == types.APITokenScopeModerate && !canModerate(rc) {
+			fields.Add("scopes", "only staff accounts can grant the moderate scope")
+		}
+	}
+
+	if parsed.ExpiresInDays < 0 {
+		fields.Add("expires_in_days", "must not be negative")
+	}
+
+	if !fields.Empty() {
+		return ResolveResponseErr(rc, types.ErrorValidation(fields))
+	}
+
+	count := rc.Store.CountResults("api_tokens", bson.D{{Key: "account_id", Value: rc.AccountCtx.Account.ID}})
+	if count >= int64(types.API_TOKEN_MAX_PER_ACCOUNT) {
+		return ResolveResponseErr(rc, types.ErrorConflict("too many api tokens, revoke one first"))
+	}
+
+	var expires *time.Time
+	if parsed.ExpiresInDays > 0 {
+		exp := time.Now().UTC().AddDate(0, 0, parsed.ExpiresInDays)
+		expires = &exp
+	}
+
+	token, raw, err := types.NewAPIToken(rc.AccountCtx.Account, parsed.Name, parsed.Scopes, expires)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	err = rc.Store.SaveNewSingle(token, "api_tokens")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	rc.AddToResponseListCLF("api_token", token)
+	rc.AddToResponseList("token", raw)
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/tokens/{id}
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountTokenID(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "DELETE":
+		return ah.handleDeleteToken(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: DELETE
+// PATH: host.com/api/account/tokens/{id}
+// revokes a token, it stops working immediately
+func (ah *AccountHandler) handleDeleteToken(rc *types.RequestCtx) error {
+	if rc.UnresolvedAccount {
+		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+	}
+
+	if !rc.AccountCtx.IsSessionAuth() {
+		return ResolveResponseErr(rc, types.ErrorSessionRequired())
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(rc.Request)["id"])
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("token id"))
+	}
+
+	deleted, err := rc.Store.DeleteMulti(bson.D{{Key: "_id", Value: id}, {Key: "account_id", Value: rc.AccountCtx.Account.ID}}, "api_tokens")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	if deleted == 0 {
+		return ResolveResponseErr(rc, types.ErrorNotFound("api token"))
+	}
+
+	return ResolveResponse(rc)
+}
+
+// is the account staff anywhere, either through it's role or a role granted on a board
+func canModerate(rc *types.RequestCtx) bool {
+	return types.RoleUsesScope(rc.AccountCtx.Role, types.APITokenScopeModerate) || len(rc.ResolveBoardRoles()) > 0
+}
@@ -76,7 +76,7 @@ func (ah *ArticleHandler) RegisterArticleSlug(rc *types.RequestCtx) error {
 // PATH: host.com/api/articles/{slug}
 func (ah *ArticleHandler) handleGetSingleArticle(rc *types.RequestCtx) error {
 	vars := mux.Vars(rc.Request)
-	pipeline := builder.QrStrLookupArticle(vars["slug"])
+	pipeline := builder.QrStrLookupArticle(vars["slug"], rc.AccountCtx.Can(types.PermContentDeleted), rc.ViewerID())
 
 	article, err := rc.Store.RunAggregation("articles", pipeline)
 	if err != nil {
@@ -94,10 +94,6 @@ func (ah *ArticleHandler) handleGetSingleArticle(rc *types.RequestCtx) error {
 // METHOD: POST
 // PATH: host.com/api/articles/{slug}
 func (ah *ArticleHandler) handleNewArticleComment(rc *types.RequestCtx) error {
-	if rc.UnresolvedAccount {
-		return ResolveResponseErr(rc, types.ErrorUnauthorized())
-	}
-
 	// setup necessary dependencies
 	vars := mux.Vars(rc.Request)
 	ts := time.Now().UTC()
@@ -118,6 +114,11 @@ func (ah *ArticleHandler) handleNewArticleComment(rc *types.RequestCtx) error {
 		return ResolveResponseErr(rc, types.ErrorUnexpected())
 	}
 
+	verdict, apiErr := checkSpam(rc, ah.rh.Spam, types.ModTargetComment, "", details.Content)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
 	// dependency injection
 	newCommentAssets := []*types.Asset{}
 	newCommentAssetInterfaces := []interface{}{}
@@ -142,12 +143,13 @@ func (ah *ArticleHandler) handleNewArticleComment(rc *types.RequestCtx) error {
 	article.CommentRef++
 	comment.CommentNumber = article.CommentRef
 	comment.Body = str
+	comment.Raw = details.Content
 	comment.AuthorID = rc.AccountCtx.Account.ID
 
 	comment.UpdatedAt = &ts
 	article.UpdatedAt = &ts
 
-	if details.MakeAnonymous && rc.AccountCtx.Account.IsStaff() {
+	if details.MakeAnonymous && rc.AccountCtx.Can(types.PermCommentAnonymous) {
 		comment.AuthorAnon = true
 	}
 
@@ -164,6 +166,10 @@ func (ah *ArticleHandler) handleNewArticleComment(rc *types.RequestCtx) error {
 
 	article.Comments = append(article.Comments, comment.ID)
 
+	if verdict.Action == types.SpamActionHold {
+		comment.MarkDeleted(types.DeletedBySpamFilter)
+	}
+
 	// save & update associative docs
 	err = rc.Store.SaveNewSingle(comment, "article_comments")
 	if err != nil {
@@ -175,6 +181,13 @@ func (ah *ArticleHandler) handleNewArticleComment(rc *types.RequestCtx) error {
 		return ResolveResponseErr(rc, types.ErrorUnexpected())
 	}
 
+	if verdict.Action == types.SpamActionHold {
+		err = holdForReview(rc, verdict, types.ModTargetComment, comment.ID, nil, types.ReportLocation{Article: article.Slug, CommentNumber: comment.CommentNumber})
+		if err != nil {
+			return ResolveResponseErr(rc, types.ErrorUnexpected())
+		}
+	}
+
 	rc.AddToResponseList("comment_number", comment.CommentNumber)
 	return ResolveResponse(rc)
 }
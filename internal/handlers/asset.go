@@ -1,17 +1,14 @@
 package handlers
 
 import (
-	"context"
 	"fmt"
 	"net/http"
 	"os"
-	"time"
 
 	"github.com/dd-web/opforu-server/internal/builder"
 	"github.com/dd-web/opforu-server/internal/types"
 	"github.com/dd-web/opforu-server/internal/utils"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type AssetHandler struct {
@@ -49,10 +46,6 @@ func (ah *AssetHandler) handleAssetList(rc *types.RequestCtx) error {
 // METHOD: POST
 // PATH: host.com/api/assets
 func (ah *AssetHandler) handleNewAsset(rc *types.RequestCtx) error {
-	if rc.UnresolvedAccount {
-		return ResolveResponseErr(rc, types.ErrorUnauthorized())
-	}
-
 	/*
 	 * Setup variable necessary for all steps, check that the file is valid
 	 * and setup temp file for upload
@@ -134,15 +127,8 @@ func (ah *AssetHandler) handleNewAsset(rc *types.RequestCtx) error {
 
 		if !uploaderExistsInList {
 			collided.Uploaders = append(collided.Uploaders, rc.AccountCtx.Account.ID)
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-
-			collection := ah.rh.Store.DB.Collection("asset_sources")
-			opts := options.Update().SetUpsert(false)
-
-			updateQry := builder.BsonOperator("$set", "uploaders", collided.Uploaders)
 
-			_, err := collection.UpdateByID(ctx, collided.ID, updateQry, opts)
+			err := ah.rh.Store.UpdateAssetSource(collided)
 			if err != nil {
 				fmt.Println("error updating asset source", err)
 			}
@@ -1,16 +1,13 @@
 package handlers
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"time"
 
 	"github.com/dd-web/opforu-server/internal/builder"
 	"github.com/dd-web/opforu-server/internal/types"
 	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -41,30 +38,11 @@ func (bh *BoardHandler) RegisterBoardRoot(rc *types.RequestCtx) error {
 // METHOD: GET
 // PATH: host.com/api/boards
 func (bh *BoardHandler) handleBoardList(rc *types.RequestCtx) error {
-	col := rc.Store.DB.Collection("boards")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	cursor, err := col.Find(ctx, bson.M{})
+	boards, err := rc.Store.FindAllBoards()
 	if err != nil {
 		return ResolveResponseErr(rc, types.ErrorUnexpected())
 	}
 
-	defer func() {
-		cursor.Close(ctx)
-	}()
-
-	var boards []types.Board = []types.Board{}
-
-	for cursor.Next(ctx) {
-		var board types.Board
-		err := cursor.Decode(&board)
-		if err != nil {
-			return ResolveResponseErr(rc, types.ErrorUnexpected())
-		}
-		boards = append(boards, board)
-	}
-
 	rc.AddToResponseList("boards", boards)
 	return ResolveResponse(rc)
 }
@@ -96,19 +74,32 @@ func (bh *BoardHandler) handleBoardShort(rc *types.RequestCtx) error {
 		return ResolveResponseErr(rc, types.ErrorUnexpected())
 	}
 
-	pipeline, err := builder.QrStrLookupThreads(board.ID, rc.Query)
+	// staff see deleted threads so they can be looked over or restored
+	staff := rc.CanOnBoard(types.PermContentDeleted, board.ID)
+
+	pipeline, err := builder.QrStrLookupThreads(board.ID, rc.Query, staff, rc.ViewerID())
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	counted, err := rc.Store.RunAggregation("threads", builder.QrStrCountThreads(board.ID, rc.Query, staff, rc.ViewerID()))
 	if err != nil {
 		return ResolveResponseErr(rc, types.ErrorUnexpected())
 	}
 
-	count := rc.Store.CountResults("threads", append(bson.D{{Key: "board", Value: board.ID}}, rc.Query.Search...))
+	count := 0
+	if len(counted) > 0 {
+		if total, ok := counted[0]["total"].(int32); ok {
+			count = int(total)
+		}
+	}
 
 	threads, err := rc.Store.RunAggregation("threads", pipeline)
 	if err != nil {
 		return ResolveResponseErr(rc, types.ErrorUnexpected())
 	}
 
-	rc.Pagination.Update(int(count))
+	rc.Pagination.Update(count)
 	rc.Records = threads
 	rc.AddToResponseList("board", board)
 
@@ -118,10 +109,6 @@ func (bh *BoardHandler) handleBoardShort(rc *types.RequestCtx) error {
 // METHOD: POST
 // PATH: host.com/api/boards/{short}
 func (bh *BoardHandler) handleNewThread(rc *types.RequestCtx) error {
-	if rc.UnresolvedAccount {
-		return ResolveResponseErr(rc, types.ErrorUnauthorized())
-	}
-
 	// invoke necessary data and dependencies
 	vars := mux.Vars(rc.Request)
 	board, err := rc.Store.FindBoardByShort(vars["short"])
@@ -141,6 +128,11 @@ func (bh *BoardHandler) handleNewThread(rc *types.RequestCtx) error {
 		return ResolveResponseErr(rc, types.ErrorUnexpected())
 	}
 
+	verdict, apiErr := checkSpam(rc, bh.rh.Spam, types.ModTargetThread, details.Title, details.Content)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
 	// dependency injection
 	newThreadAssets := []*types.Asset{}
 	newThreadAssetInterfaces := []interface{}{}
@@ -171,6 +163,8 @@ func (bh *BoardHandler) handleNewThread(rc *types.RequestCtx) error {
 	newIdentity.Thread = thread.ID
 	thread.Title = details.Title
 	thread.Body = str
+	thread.Raw = details.Content
+	thread.IPHash = rc.IPHash()
 	thread.Board = board.ID
 	thread.Creator = newIdentity.ID
 	thread.Mods = []primitive.ObjectID{newIdentity.ID}
@@ -194,6 +188,10 @@ func (bh *BoardHandler) handleNewThread(rc *types.RequestCtx) error {
 		return ResolveResponseErr(rc, types.ErrorInvalid(err.Error()))
 	}
 
+	if verdict.Action == types.SpamActionHold {
+		thread.MarkDeleted(types.DeletedBySpamFilter)
+	}
+
 	err = rc.Store.SaveNewSingle(newIdentity, "identities")
 	if err != nil {
 		return ResolveResponseErr(rc, types.ErrorUnexpected())
@@ -204,6 +202,13 @@ func (bh *BoardHandler) handleNewThread(rc *types.RequestCtx) error {
 		return ResolveResponseErr(rc, types.ErrorUnexpected())
 	}
 
+	if verdict.Action == types.SpamActionHold {
+		err = holdForReview(rc, verdict, types.ModTargetThread, thread.ID, &board.ID, types.ReportLocation{ThreadID: &thread.ID, Thread: thread.Slug})
+		if err != nil {
+			return ResolveResponseErr(rc, types.ErrorUnexpected())
+		}
+	}
+
 	rc.AddToResponseList("thread_id", thread.Slug)
 	return ResolveResponse(rc)
 }
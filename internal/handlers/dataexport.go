@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/dd-web/opforu-server/internal/types"
+	"github.com/dd-web/opforu-server/internal/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/export
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountExport(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "GET":
+		return ah.handleGetExport(rc)
+	case "POST":
+		return ah.handleNewExport(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// finds the account's most recent export, nil if it never requested one
+func (ah *AccountHandler) findLatestExport(rc *types.RequestCtx) (*types.DataExport, error) {
+	exports := []*types.DataExport{}
+	err := rc.Store.FindMulti(bson.D{{Key: "account_id", Value: rc.AccountCtx.Account.ID}}, &exports, "data_exports")
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *types.DataExport
+	for _, v := range exports {
+		if latest == nil || v.CreatedAt.After(*latest.CreatedAt) {
+			latest = v
+		}
+	}
+
+	return latest, nil
+}
+
+// METHOD: GET
+// PATH: host.com/api/account/export
+// the status of the latest export, with a download link once it's ready
+func (ah *AccountHandler) handleGetExport(rc *types.RequestCtx) error {
+	if rc.UnresolvedAccount {
+		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+	}
+
+	if !rc.AccountCtx.IsSessionAuth() {
+		return ResolveResponseErr(rc, types.ErrorSessionRequired())
+	}
+
+	export, err := ah.findLatestExport(rc)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	if export == nil {
+		return ResolveResponseErr(rc, types.ErrorNotFound("export"))
+	}
+
+	// the process building it stopped before it finished
+	if export.IsAbandoned() {
+		export.Fail()
+
+		err = rc.Store.ReplaceSingle(export.ID, export, "data_exports")
+		if err != nil {
+			return ResolveResponseErr(rc, types.ErrorUnexpected())
+		}
+	}
+
+	rc.AddToResponseListCLF("export", export)
+
+	if export.IsDownloadable() {
+		token := utils.SignToken(types.TOKEN_PURPOSE_DATA_EXPORT, export.ID.Hex(), *export.ExpiresAt)
+		rc.AddToResponseList("download_url", "/api/account/export/download?token="+url.QueryEscape(token))
+	}
+
+	return ResolveResponse(rc)
+}
+
+// METHOD: POST
+// PATH: host.com/api/account/export
+// starts building a new export, replacing any earlier one
+func (ah *AccountHandler) handleNewExport(rc *types.RequestCtx) error {
+	if rc.UnresolvedAccount {
+		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+	}
+
+	if !rc.AccountCtx.IsSessionAuth() {
+		return ResolveResponseErr(rc, types.ErrorSessionRequired())
+	}
+
+	account := rc.AccountCtx.Account
+
+	// exports pending for too long were abandoned and don't hold up a new one
+	cutoff := time.Now().UTC().Add(-time.Duration(types.DATA_EXPORT_BUILD_TIMEOUT_MINUTES) * time.Minute)
+	pending := bson.D{
+		{Key: "account_id", Value: account.ID},
+		{Key: "status", Value: types.DataExportStatusPending},
+		{Key: "created_at", Value: bson.D{{Key: "$gt", Value: cutoff}}},
+	}
+	if rc.Store.CountResults("data_exports", pending) > 0 {
+		return ResolveResponseErr(rc, types.ErrorConflict("an export is already being built"))
+	}
+
+	err := types.DeleteDataExports(rc.Store, bson.D{{Key: "account_id", Value: account.ID}})
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	export := types.NewDataExport(account)
+
+	err = rc.Store.SaveNewSingle(export, "data_exports")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	// formatted before the build starts changing it
+	rc.AddToResponseListCLF("export", export)
+
+	go buildDataExport(rc.Store, account, export)
+
+	return ResolveResponse(rc)
+}
+
+// builds the archive and stores it for the export, runs outside of the request
+func buildDataExport(store types.Repository, account *types.Account, export *types.DataExport) {
+	archive, err := types.BuildDataExport(store, account)
+	if err == nil {
+		err = store.SaveDataExportArchive(export.ID, archive)
+	}
+
+	if err != nil {
+		fmt.Println("Error building data export", err)
+		export.Fail()
+	} else {
+		export.Complete(int64(len(archive)))
+	}
+
+	err = store.ReplaceSingle(export.ID, export, "data_exports")
+	if err != nil {
+		fmt.Println("Error saving data export", err)
+	}
+
+	// a new export replaced this one while it was being built
+	if store.CountResults("data_exports", bson.D{{Key: "_id", Value: export.ID}}) == 0 {
+		if err := store.DeleteDataExportArchive(export.ID); err != nil {
+			fmt.Println("Error deleting data export archive", err)
+		}
+	}
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/export/download
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountExportDownload(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "GET":
+		return ah.handleGetExportDownload(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: GET
+// PATH: host.com/api/account/export/download?token=
+// the signed link is the only thing that's needed, it expires with the export
+func (ah *AccountHandler) handleGetExportDownload(rc *types.RequestCtx) error {
+	subject, err := utils.VerifyToken(types.TOKEN_PURPOSE_DATA_EXPORT, rc.Request.URL.Query().Get("token"))
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("download token"))
+	}
+
+	id, err := primitive.ObjectIDFromHex(subject)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("download token"))
+	}
+
+	export := &types.DataExport{}
+	err = rc.Store.FindSingle(bson.D{{Key: "_id", Value: id}}, export, "data_exports")
+	if err != nil || !export.IsDownloadable() {
+		return ResolveResponseErr(rc, types.ErrorNotFound("export"))
+	}
+
+	rc.Writer.Header().Set("Content-Type", "application/zip")
+	rc.Writer.Header().Set("Content-Disposition", "attachment; filename=\"opforu-export-"+export.CompletedAt.Format("2006-01-02")+".zip\"")
+	rc.Writer.Header().Set("Content-Length", strconv.FormatInt(export.Size, 10))
+	rc.Writer.WriteHeader(http.StatusOK)
+
+	return rc.Store.WriteDataExportArchive(export.ID, rc.Writer)
+}
@@ -0,0 +1,416 @@
+package handlers
+
+import (
+	"strconv"
+
+	"github.com/dd-web/opforu-server/internal/types"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// content is only ever soft deleted, it keeps it's place and number and is shown as a tombstone
+// saying who took it down. staff see what was deleted and can put it back.
+
+// the post named by the {post_number} route variable in the thread
+func findThreadPost(rc *types.RequestCtx, thread *types.Thread) (*types.Post, *types.APIError) {
+	postNumber, err := strconv.Atoi(mux.Vars(rc.Request)["post_number"])
+	if err != nil {
+		apiErr := types.ErrorInvalid("post number")
+		return nil, &apiErr
+	}
+
+	post := &types.Post{}
+	err = rc.Store.FindSingle(bson.D{{Key: "thread", Value: thread.ID}, {Key: "post_number", Value: postNumber}}, post, "posts")
+	if err != nil {
+		apiErr := types.ErrorNotFound("post")
+		return nil, &apiErr
+	}
+	return post, nil
+}
+
+// the caller's identity in the thread, nil when they've never posted there
+func findCallerIdentity(rc *types.RequestCtx, thread *types.Thread) (*types.Identity, *types.APIError) {
+	identity := &types.Identity{}
+	err := rc.Store.FindSingle(types.FindFilterIdentityInThread(thread.ID, rc.AccountCtx.Account.ID), identity, "identities")
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		apiErr := types.ErrorUnexpected()
+		return nil, &apiErr
+	}
+	return identity, nil
+}
+
+// who the request deletes the thread as
+// - the creator deletes it while it's open
+// - staff delete it anywhere, appointed thread mods only look after the posts in it
+func resolveThreadDeleter(rc *types.RequestCtx, thread *types.Thread) (types.DeletedBy, *types.APIError) {
+	return resolveDeleter(rc, thread, thread.Creator, false)
+}
+
+// who the request deletes a post in the thread as
+// - accepts the identity that made the post
+// - authors delete their own posts while the thread is open
+// - thread mods delete any post in the thread until it's archived
+// - staff delete anything anywhere
+func resolvePostDeleter(rc *types.RequestCtx, thread *types.Thread, author primitive.ObjectID) (types.DeletedBy, *types.APIError) {
+	return resolveDeleter(rc, thread, author, true)
+}
+
+func resolveDeleter(rc *types.RequestCtx, thread *types.Thread, author primitive.ObjectID, threadMods bool) (types.DeletedBy, *types.APIError) {
+	identity, apiErr := findCallerIdentity(rc, thread)
+	if apiErr != nil {
+		return "", apiErr
+	}
+
+	found := identity != nil
+	moddable := thread.Status == types.ThreadStatusOpen || thread.Status == types.ThreadStatusClosed
+
+	switch {
+	case found && identity.ID == author && thread.WriteError() == nil:
+		return types.DeletedByAuthor, nil
+	case threadMods && found && thread.IsMod(identity.ID) && moddable && rc.CanOnBoard(types.PermThreadModerate, thread.Board):
+		return types.DeletedByModerator, nil
+	case rc.CanOnBoard(types.PermPostDeleteAny, thread.Board):
+		return types.DeletedByModerator, nil
+	}
+
+	if apiErr := thread.WriteError(); apiErr != nil {
+		return "", apiErr
+	}
+
+	forbidden := types.ErrorForbidden("not allowed to delete this")
+	return "", &forbidden
+}
+
+// METHOD: DELETE
+// PATH: host.com/api/threads/{slug}
+func (th *ThreadHandler) handleDeleteThread(rc *types.RequestCtx) error {
+	thread, err := rc.Store.FindThreadBySlug(mux.Vars(rc.Request)["slug"])
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorNotFound("thread"))
+	}
+
+	if thread.IsDeleted() {
+		return ResolveResponseErr(rc, types.ErrorGone("thread"))
+	}
+
+	by, apiErr := resolveThreadDeleter(rc, thread)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	before := types.ModSnapshot(thread)
+	thread.MarkDeleted(by)
+
+	err = rc.Store.UpdateThread(thread)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	if by == types.DeletedByModerator {
+		recordModAction(rc, types.NewModAction(rc.AccountCtx.Account.ID, types.ModActionThreadDelete, types.ModTargetThread, thread.ID, "").
+			OnBoard(thread.Board).
+			Snapshot(before, thread))
+	}
+
+	rc.AddToResponseList("deleted_by", by)
+
+	return ResolveResponse(rc)
+}
+
+// held content is only put up by approving the hold, so the hold is resolved and the classifier learns from it
+func errorHeldForReview() types.APIError {
+	return types.ErrorConflict("held by the spam filter, approve it through /api/mod/spam instead")
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/threads/{slug}/restore
+/***********************************************************************************************/
+func (th *ThreadHandler) RegisterThreadRestore(rc *types.RequestCtx) error {
+	rc.UpdateStore(th.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return th.handleRestoreThread(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/threads/{slug}/restore
+// puts a deleted thread back with the status it had
+func (th *ThreadHandler) handleRestoreThread(rc *types.RequestCtx) error {
+	thread, err := rc.Store.FindThreadBySlug(mux.Vars(rc.Request)["slug"])
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorNotFound("thread"))
+	}
+
+	if !thread.IsDeleted() {
+		return ResolveResponseErr(rc, types.ErrorConflict("thread isn't deleted"))
+	}
+
+	if thread.DeletedBy == types.DeletedBySpamFilter {
+		return ResolveResponseErr(rc, errorHeldForReview())
+	}
+
+	before := types.ModSnapshot(thread)
+	thread.Restore()
+
+	err = rc.Store.UpdateThread(thread)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	recordModAction(rc, types.NewModAction(rc.AccountCtx.Account.ID, types.ModActionThreadRestore, types.ModTargetThread, thread.ID, "").
+		OnBoard(thread.Board).
+		Snapshot(before, thread))
+
+	rc.AddToResponseList("status", thread.Status)
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/threads/{slug}/posts/{post_number}
+/***********************************************************************************************/
+func (th *ThreadHandler) RegisterThreadPost(rc *types.RequestCtx) error {
+	rc.UpdateStore(th.rh.Store)
+
+	switch rc.Request.Method {
+	case "PATCH":
+		return th.handleEditThreadPost(rc)
+	case "DELETE":
+		return th.handleDeleteThreadPost(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: DELETE
+// PATH: host.com/api/threads/{slug}/posts/{post_number}
+func (th *ThreadHandler) handleDeleteThreadPost(rc *types.RequestCtx) error {
+	thread, err := rc.Store.FindThreadBySlug(mux.Vars(rc.Request)["slug"])
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorNotFound("thread"))
+	}
+
+	parsed, apiErr := readThreadModRequest(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	post, apiErr := findThreadPost(rc, thread)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	if post.IsDeleted() {
+		return ResolveResponseErr(rc, types.ErrorGone("post"))
+	}
+
+	by, apiErr := resolvePostDeleter(rc, thread, post.Creator)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	before := types.ModSnapshot(post)
+	post.MarkDeleted(by)
+
+	err = rc.Store.ReplaceSingle(post.ID, post, "posts")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	if by == types.DeletedByModerator {
+		recordModAction(rc, types.NewModAction(rc.AccountCtx.Account.ID, types.ModActionPostRemove, types.ModTargetPost, post.ID, parsed.Reason).
+			OnBoard(thread.Board).
+			Snapshot(before, post))
+	}
+
+	rc.AddToResponseList("deleted_by", by)
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/threads/{slug}/posts/{post_number}/restore
+/***********************************************************************************************/
+func (th *ThreadHandler) RegisterThreadPostRestore(rc *types.RequestCtx) error {
+	rc.UpdateStore(th.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return th.handleRestoreThreadPost(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/threads/{slug}/posts/{post_number}/restore
+func (th *ThreadHandler) handleRestoreThreadPost(rc *types.RequestCtx) error {
+	thread, err := rc.Store.FindThreadBySlug(mux.Vars(rc.Request)["slug"])
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorNotFound("thread"))
+	}
+
+	post, apiErr := findThreadPost(rc, thread)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	if !post.IsDeleted() {
+		return ResolveResponseErr(rc, types.ErrorConflict("post isn't deleted"))
+	}
+
+	if post.DeletedBy == types.DeletedBySpamFilter {
+		return ResolveResponseErr(rc, errorHeldForReview())
+	}
+
+	before := types.ModSnapshot(post)
+	post.Restore()
+
+	err = rc.Store.ReplaceSingle(post.ID, post, "posts")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	recordModAction(rc, types.NewModAction(rc.AccountCtx.Account.ID, types.ModActionPostRestore, types.ModTargetPost, post.ID, "").
+		OnBoard(thread.Board).
+		Snapshot(before, post))
+
+	return ResolveResponse(rc)
+}
+
+// the comment named by the {comment_number} route variable on the {slug} article
+func findArticleComment(rc *types.RequestCtx) (*types.ArticleComment, *types.APIError) {
+	vars := mux.Vars(rc.Request)
+
+	article, err := rc.Store.FindArticleBySlug(vars["slug"])
+	if err != nil {
+		apiErr := types.ErrorNotFound("article")
+		return nil, &apiErr
+	}
+
+	commentNumber, err := strconv.Atoi(vars["comment_number"])
+	if err != nil {
+		apiErr := types.ErrorInvalid("comment number")
+		return nil, &apiErr
+	}
+
+	comment := &types.ArticleComment{}
+	err = rc.Store.FindSingle(bson.D{
+		{Key: "_id", Value: bson.D{{Key: "$in", Value: article.Comments}}},
+		{Key: "comment_number", Value: commentNumber},
+	}, comment, "article_comments")
+	if err != nil {
+		apiErr := types.ErrorNotFound("comment")
+		return nil, &apiErr
+	}
+
+	return comment, nil
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/articles/{slug}/comments/{comment_number}
+/***********************************************************************************************/
+func (ah *ArticleHandler) RegisterArticleComment(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "DELETE":
+		return ah.handleDeleteArticleComment(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: DELETE
+// PATH: host.com/api/articles/{slug}/comments/{comment_number}
+// authors delete their own comments, staff delete any
+func (ah *ArticleHandler) handleDeleteArticleComment(rc *types.RequestCtx) error {
+	comment, apiErr := findArticleComment(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	if comment.IsDeleted() {
+		return ResolveResponseErr(rc, types.ErrorGone("comment"))
+	}
+
+	var by types.DeletedBy
+	switch {
+	case comment.AuthorID == rc.AccountCtx.Account.ID:
+		by = types.DeletedByAuthor
+	case rc.AccountCtx.Can(types.PermPostDeleteAny):
+		by = types.DeletedByModerator
+	default:
+		return ResolveResponseErr(rc, types.ErrorForbidden("not allowed to delete this"))
+	}
+
+	before := types.ModSnapshot(comment)
+	comment.MarkDeleted(by)
+
+	err := rc.Store.ReplaceSingle(comment.ID, comment, "article_comments")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	if by == types.DeletedByModerator {
+		recordModAction(rc, types.NewModAction(rc.AccountCtx.Account.ID, types.ModActionCommentDelete, types.ModTargetComment, comment.ID, "").
+			Snapshot(before, comment))
+	}
+
+	rc.AddToResponseList("deleted_by", by)
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/articles/{slug}/comments/{comment_number}/restore
+/***********************************************************************************************/
+func (ah *ArticleHandler) RegisterArticleCommentRestore(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return ah.handleRestoreArticleComment(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/articles/{slug}/comments/{comment_number}/restore
+func (ah *ArticleHandler) handleRestoreArticleComment(rc *types.RequestCtx) error {
+	comment, apiErr := findArticleComment(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	if !comment.IsDeleted() {
+		return ResolveResponseErr(rc, types.ErrorConflict("comment isn't deleted"))
+	}
+
+	if comment.DeletedBy == types.DeletedBySpamFilter {
+		return ResolveResponseErr(rc, errorHeldForReview())
+	}
+
+	before := types.ModSnapshot(comment)
+	comment.Restore()
+
+	err := rc.Store.ReplaceSingle(comment.ID, comment, "article_comments")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	recordModAction(rc, types.NewModAction(rc.AccountCtx.Account.ID, types.ModActionCommentRestore, types.ModTargetComment, comment.ID, "").
+		Snapshot(before, comment))
+
+	return ResolveResponse(rc)
+}
@@ -2,21 +2,32 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/dd-web/opforu-server/internal/types"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type HandlerWrapperFunc func(rc *types.RequestCtx) error
 
 // wraps a handle func with a request context and error handling
 // populates request context with request details such as the account making the request (if any)
-func WrapFn(f HandlerWrapperFunc) http.HandlerFunc {
+// writes from addresses banned in the routing handler's store are turned away before the handler is called
+func WrapFn(rh *types.RoutingHandler, f HandlerWrapperFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		rc := types.NewRequestCtx(w, r)
 		types.RequestLogger(rc)
 
+		if ban := checkIPBan(rh, rc); ban != nil {
+			ResolveResponseErr(rc, types.ErrorIPBanned(ban))
+			return
+		}
+
 		if err := f(rc); err != nil {
 			fmt.Println("Error in handler:", err)
 			HandleSendJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()}, rc)
@@ -24,6 +35,145 @@ func WrapFn(f HandlerWrapperFunc) http.HandlerFunc {
 	}
 }
 
+// the ip ban stopping the request, nil for reads and for staff who manage bans so they can't
+// lock themselves out
+func checkIPBan(rh *types.RoutingHandler, rc *types.RequestCtx) *types.IPBan {
+	if rh.Store == nil || types.IsSafeMethod(rc.Request.Method) {
+		return nil
+	}
+
+	ban, err := types.FindIPBan(rh.Store, rc.RemoteIP())
+	if err != nil {
+		// an outage shouldn't take writes down with it
+		fmt.Println("Error checking ip bans", err)
+		return nil
+	}
+	if ban == nil {
+		return nil
+	}
+
+	rc.UpdateStore(rh.Store)
+	if !rc.UnresolvedAccount && rc.AccountCtx.Can(types.PermIPBan) {
+		return nil
+	}
+	return ban
+}
+
+// finds the board a request acts on so permissions granted on that board apply
+type BoardResolver func(rc *types.RequestCtx) (primitive.ObjectID, error)
+
+// the permissions a route needs for a method, declared when the route is registered
+type Requirement struct {
+	Method      string // "*" for every method
+	Permissions []types.Permission
+	Board       BoardResolver // nil when only permissions held everywhere count
+}
+
+// requires the permissions on requests with the method
+func Require(method string, perms ...types.Permission) Requirement {
+	return Requirement{Method: method, Permissions: perms}
+}
+
+// lets roles granted on the board the resolver finds satisfy the requirement
+func (req Requirement) OnBoard(resolver BoardResolver) Requirement {
+	req.Board = resolver
+	return req
+}
+
+// wraps a handle func like WrapFn, rejecting requests that don't meet the route's requirements
+// before the handler is called
+func Protect(rh *types.RoutingHandler, f HandlerWrapperFunc, reqs ...Requirement) http.HandlerFunc {
+	return WrapFn(rh, func(rc *types.RequestCtx) error {
+		for _, req := range reqs {
+			if req.Method != "*" && req.Method != rc.Request.Method {
+				continue
+			}
+
+			rc.UpdateStore(rh.Store)
+
+			if err := req.check(rc); err != nil {
+				return ResolveResponseErr(rc, *err)
+			}
+		}
+
+		return f(rc)
+	})
+}
+
+// checks each permission in turn so the client is told the first thing it's missing
+func (req Requirement) check(rc *types.RequestCtx) *types.APIError {
+	if rc.UnresolvedAccount {
+		err := types.ErrorUnauthorized()
+		return &err
+	}
+
+	board := primitive.NilObjectID
+	if req.Board != nil {
+		id, err := req.Board(rc)
+		if err != nil {
+			apiErr := types.ErrorUnexpected()
+			errors.As(err, &apiErr)
+			return &apiErr
+		}
+		board = id
+	}
+
+	for _, perm := range req.Permissions {
+		var err types.APIError
+
+		switch {
+		case rc.AccountCtx.Token != nil && perm.Scope() == "":
+			err = types.ErrorSessionRequired()
+		case !rc.AccountCtx.HasScope(perm.Scope()):
+			err = types.ErrorMissingScope(perm.Scope())
+		case rc.AccountCtx.Sanction != nil && !perm.AllowsSanctioned():
+			err = types.ErrorSanctioned(rc.AccountCtx.Sanction)
+		case perm.RequiresVerified() && !rc.AccountCtx.Account.IsVerified():
+			err = types.ErrorForbidden("account email is not verified")
+		case !rc.CanOnBoard(perm, board):
+			err = types.ErrorForbidden("missing permission " + string(perm))
+		default:
+			continue
+		}
+
+		return &err
+	}
+
+	return nil
+}
+
+// the board named by the {short} route variable
+func BoardFromShort(rc *types.RequestCtx) (primitive.ObjectID, error) {
+	board, err := rc.Store.FindBoardByShort(mux.Vars(rc.Request)["short"])
+	if err != nil {
+		return primitive.NilObjectID, types.ErrorNotFound("board")
+	}
+	return board.ID, nil
+}
+
+// the board of the thread named by the {slug} route variable
+func BoardFromThreadSlug(rc *types.RequestCtx) (primitive.ObjectID, error) {
+	thread, err := rc.Store.FindThreadBySlug(mux.Vars(rc.Request)["slug"])
+	if err != nil {
+		return primitive.NilObjectID, types.ErrorNotFound("thread")
+	}
+	return thread.Board, nil
+}
+
+// the board named by the ?board= query param, none when it's left out
+func BoardFromQuery(rc *types.RequestCtx) (primitive.ObjectID, error) {
+	short := rc.Request.URL.Query().Get("board")
+	if short == "" {
+		return primitive.NilObjectID, nil
+	}
+
+	board, err := rc.Store.FindBoardByShort(short)
+	if err != nil {
+		return primitive.NilObjectID, types.ErrorNotFound("board")
+	}
+	return board.ID, nil
+}
+
 // Handles the sending of JSON responses to the client.
 // it's responsible for setting the response headers and status code as well as any data
 // that needs to be sent. data should be serialized before being passed to this function.
@@ -51,6 +201,20 @@ func ResolveResponse(rc *types.RequestCtx) error {
 }
 
 // resolves an error response and sends it to the client
+// validation errors are sent as an object so the client can show each problem next to it's field
 func ResolveResponseErr(rc *types.RequestCtx, err types.APIError) error {
+	// handlers only know the account didn't resolve, tell the client why when it was the csrf check
+	if err.Status == http.StatusUnauthorized && rc.AccountCtx.CSRFRejected {
+		err = types.ErrorForbidden("missing or invalid csrf token")
+	}
+
+	if err.RetryAfter > 0 {
+		// rounded up, telling a client to retry in 0 seconds would just get it rejected again
+		rc.Writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(err.RetryAfter.Seconds()))))
+	}
+
+	if !err.Fields.Empty() || len(err.Details) > 0 {
+		return HandleSendJSON(rc.Writer, err.Status, err.Bson(), rc)
+	}
 	return HandleSendJSON(rc.Writer, err.Status, err.Error(), rc)
 }
@@ -64,6 +64,10 @@ func (ih *InternalHandler) HandleGetPost(rc *types.RequestCtx) error {
 		return ResolveResponseErr(rc, types.ErrorNotFound("thread"))
 	}
 
+	if thread.Status == types.ThreadStatusDeleted {
+		return ResolveResponseErr(rc, types.ErrorGone("thread"))
+	}
+
 	board, err := rc.Store.FindBoardByObjectID(thread.Board)
 	if err != nil {
 		return ResolveResponseErr(rc, types.ErrorNotFound("post's board"))
@@ -78,7 +82,7 @@ func (ih *InternalHandler) HandleGetPost(rc *types.RequestCtx) error {
 		return ResolveResponseErr(rc, types.ErrorNotFound("post"))
 	}
 
-	p, err := rc.Store.RunAggregation("posts", builder.QrStrLookupPost(thread.ID, postNum, thread.Slug, board.Short))
+	p, err := rc.Store.RunAggregation("posts", builder.QrStrLookupPost(thread.ID, postNum, thread.Slug, board.Short, false, rc.ViewerID()))
 	if err != nil {
 		return ResolveResponseErr(rc, types.ErrorNotFound("post"))
 	}
@@ -107,12 +111,16 @@ func (ih *InternalHandler) HandleGetThread(rc *types.RequestCtx) error {
 		return ResolveResponseErr(rc, types.ErrorNotFound("thread"))
 	}
 
+	if thread.Status == types.ThreadStatusDeleted {
+		return ResolveResponseErr(rc, types.ErrorGone("thread"))
+	}
+
 	board, err := rc.Store.FindBoardByObjectID(thread.Board)
 	if err != nil {
 		return ResolveResponseErr(rc, types.ErrorNotFound("thread's board"))
 	}
 
-	t, err := rc.Store.RunAggregation("threads", builder.QrStrLookupThread(thread.Slug, board.ID, board.Short))
+	t, err := rc.Store.RunAggregation("threads", builder.QrStrLookupThread(thread.Slug, board.ID, board.Short, false, rc.ViewerID()))
 	if err != nil {
 		return ResolveResponseErr(rc, types.ErrorNotFound("thread"))
 	}
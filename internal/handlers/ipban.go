@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/dd-web/opforu-server/internal/types"
+	"github.com/dd-web/opforu-server/internal/utils"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// how staff see a ban, hash bans only say which content they were made from
+func formatIPBan(ban *types.IPBan) bson.M {
+	f := ban.CLFormat()
+	f["_id"] = ban.ID
+	f["cidr"] = ban.CIDR
+	f["location"] = ban.Location
+	f["issued_by"] = ban.IssuedBy
+	f["lifted_by"] = ban.LiftedBy
+	f["lifted_at"] = ban.LiftedAt
+	f["active"] = ban.IsActive()
+	return f
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/admin/ip-bans
+/***********************************************************************************************/
+func (ah *AdminHandler) RegisterAdminIPBans(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "GET":
+		return ah.handleGetIPBans(rc)
+	case "POST":
+		return ah.handleNewIPBan(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: GET
+// PATH: host.com/api/admin/ip-bans
+// bans in force, ?all=true includes expired and lifted ones
+func (ah *AdminHandler) handleGetIPBans(rc *types.RequestCtx) error {
+	bans := []*types.IPBan{}
+	err := rc.Store.FindMulti(bson.D{}, &bans, "ip_bans")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	all := rc.Request.URL.Query().Get("all") == "true"
+
+	formatted := make([]bson.M, 0, len(bans))
+	for _, v := range bans {
+		if all || v.IsActive() {
+			formatted = append(formatted, formatIPBan(v))
+		}
+	}
+
+	rc.AddToResponseList("bans", formatted)
+
+	return ResolveResponse(rc)
+}
+
+// METHOD: POST
+// PATH: host.com/api/admin/ip-bans
+// bans a `cidr` (an address or range), or the address behind a post with `thread` and
+// `post_number` (zero or left out for the thread itself). bans without `duration_hours` are permanent.
+func (ah *AdminHandler) handleNewIPBan(rc *types.RequestCtx) error {
+	body, err := io.ReadAll(rc.Request.Body)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	var parsed struct {
+		CIDR          string `json:"cidr"`
+		Thread        string `json:"thread"`
+		PostNumber    int    `json:"post_number"`
+		Reason        string `json:"reason"`
+		DurationHours int    `json:"duration_hours"`
+	}
+
+	err = json.Unmarshal(body, &parsed)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
+	}
+
+	parsed.Reason = strings.TrimSpace(parsed.Reason)
+	fields := types.FieldErrors{}
+
+	var ipnet *net.IPNet
+	switch {
+	case parsed.CIDR != "" && parsed.Thread != "":
+		fields.Add("cidr", "give either a cidr or a thread, not both")
+	case parsed.CIDR != "":
+		ipnet, err = utils.ParseIPNet(parsed.CIDR)
+		if err != nil {
+			fields.Add("cidr", "must be an address or CIDR range")
+		} else if !types.IPBanRangeAllowed(ipnet) {
+			fields.Add("cidr", "range is too wide")
+		}
+	case parsed.Thread == "":
+		fields.Add("cidr", "required without a thread")
+	}
+
+	if parsed.Reason == "" {
+		fields.Add("reason", "required")
+	} else if len(parsed.Reason) > types.IP_BAN_REASON_MAX_LENGTH {
+		fields.Add("reason", "too long")
+	}
+
+	if parsed.DurationHours < 0 {
+		fields.Add("duration_hours", "must not be negative")
+	}
+
+	if !fields.Empty() {
+		return ResolveResponseErr(rc, types.ErrorValidation(fields))
+	}
+
+	var expires *time.Time
+	if parsed.DurationHours > 0 {
+		exp := time.Now().UTC().Add(time.Duration(parsed.DurationHours) * time.Hour)
+		expires = &exp
+	}
+
+	ban := types.NewIPBan(parsed.Reason, rc.AccountCtx.Account.ID, expires)
+	if ipnet != nil {
+		ban.SetRange(ipnet)
+	}
+
+	if parsed.Thread != "" {
+		hash, location, apiErr := findContentIPHash(rc, parsed.Thread, parsed.PostNumber)
+		if apiErr != nil {
+			return ResolveResponseErr(rc, *apiErr)
+		}
+		ban.IPHash = hash
+		ban.Location = location
+	}
+
+	err = rc.Store.SaveNewSingle(ban, "ip_bans")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	recordModAction(rc, types.NewModAction(rc.AccountCtx.Account.ID, types.ModActionIPBan, types.ModTargetIPBan, ban.ID, ban.Reason).Snapshot(nil, ban))
+
+	rc.AddToResponseList("issued", formatIPBan(ban))
+
+	return ResolveResponse(rc)
+}
+
+// the address hash stored with a thread or one of it's posts
+func findContentIPHash(rc *types.RequestCtx, slug string, post_number int) (string, *types.ReportLocation, *types.APIError) {
+	thread, err := rc.Store.FindThreadBySlug(slug)
+	if err != nil {
+		apiErr := types.ErrorNotFound("thread")
+		return "", nil, &apiErr
+	}
+
+	location := &types.ReportLocation{ThreadID: &thread.ID, Thread: thread.Slug, PostNumber: post_number}
+	hash := thread.IPHash
+
+	if post_number > 0 {
+		post := &types.Post{}
+		err = rc.Store.FindSingle(bson.D{{Key: "thread", Value: thread.ID}, {Key: "post_number", Value: post_number}}, post, "posts")
+		if err != nil {
+			apiErr := types.ErrorNotFound("post")
+			return "", nil, &apiErr
+		}
+		hash = post.IPHash
+	}
+
+	if hash == "" {
+		apiErr := types.ErrorConflict("no address was recorded for this content")
+		return "", nil, &apiErr
+	}
+
+	return hash, location, nil
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/admin/ip-bans/{id}
+/***********************************************************************************************/
+func (ah *AdminHandler) RegisterAdminIPBanID(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "DELETE":
+		return ah.handleLiftIPBan(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: DELETE
+// PATH: host.com/api/admin/ip-bans/{id}
+// ends the ban early, the record is kept
+func (ah *AdminHandler) handleLiftIPBan(rc *types.RequestCtx) error {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(rc.Request)["id"])
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("ban id"))
+	}
+
+	ban := &types.IPBan{}
+	err = rc.Store.FindSingle(bson.D{{Key: "_id", Value: id}}, ban, "ip_bans")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorNotFound("ban"))
+	}
+
+	if !ban.IsActive() {
+		return ResolveResponseErr(rc, types.ErrorConflict("ban is no longer in force"))
+	}
+
+	before := *ban
+	ban.Lift(rc.AccountCtx.Account.ID)
+
+	err = rc.Store.ReplaceSingle(ban.ID, ban, "ip_bans")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	recordModAction(rc, types.NewModAction(rc.AccountCtx.Account.ID, types.ModActionIPUnban, types.ModTargetIPBan, ban.ID, "").Snapshot(before, ban))
+
+	rc.AddToResponseList("lifted", formatIPBan(ban))
+
+	return ResolveResponse(rc)
+}
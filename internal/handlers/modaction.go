@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/dd-web/opforu-server/internal/builder"
+	"github.com/dd-web/opforu-server/internal/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// adds the action to the moderation audit log once it's been carried out
+// every moderation endpoint records what it did through this. the action has already happened by
+// the time it's recorded so a failure is logged rather than reported to the client.
+func recordModAction(rc *types.RequestCtx, action *types.ModAction) {
+	err := types.RecordModAction(rc.Store, action)
+	if err != nil {
+		fmt.Println("Error recording mod action", action.Action, err)
+	}
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/admin/mod-actions
+/***********************************************************************************************/
+func (ah *AdminHandler) RegisterAdminModActions(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "GET":
+		return ah.handleGetModActions(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: GET
+// PATH: host.com/api/admin/mod-actions
+// newest first, filtered by any of ?action= &target_type= &target_id= &actor={username} &board={short}
+func (ah *AdminHandler) handleGetModActions(rc *types.RequestCtx) error {
+	params := rc.Query.UnhandledQueryParams
+	filter := bson.D{}
+
+	if v, ok := params["action"].(string); ok {
+		filter = append(filter, bson.E{Key: "action", Value: v})
+	}
+
+	if v, ok := params["target_type"].(string); ok {
+		if !types.ModTarget(v).IsValid() {
+			return ResolveResponseErr(rc, types.ErrorInvalid("target_type"))
+		}
+		filter = append(filter, bson.E{Key: "target_type", Value: v})
+	}
+
+	if v, ok := params["target_id"].(string); ok {
+		id, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			return ResolveResponseErr(rc, types.ErrorInvalid("target_id"))
+		}
+		filter = append(filter, bson.E{Key: "target_id", Value: id})
+	}
+
+	if v, ok := params["actor"].(string); ok {
+		actor := &types.Account{}
+		err := rc.Store.FindSingle(bson.D{{Key: "username_key", Value: types.NormalizeUsername(v)}}, actor, "accounts")
+		if err != nil {
+			return ResolveResponseErr(rc, types.ErrorNotFound("actor"))
+		}
+		filter = append(filter, bson.E{Key: "actor_id", Value: actor.ID})
+	}
+
+	if v, ok := params["board"].(string); ok {
+		board, err := rc.Store.FindBoardByShort(v)
+		if err != nil {
+			return ResolveResponseErr(rc, types.ErrorNotFound("board"))
+		}
+		filter = append(filter, bson.E{Key: "board_id", Value: board.ID})
+	}
+
+	count := rc.Store.CountResults("mod_actions", filter)
+
+	actions, err := rc.Store.RunAggregation("mod_actions", builder.QrStrLookupModActions(filter, rc.Query))
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	rc.Pagination.Update(int(count))
+	rc.Records = actions
+
+	return ResolveResponse(rc)
+}
@@ -0,0 +1,514 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dd-web/opforu-server/internal/types"
+	"github.com/dd-web/opforu-server/internal/utils"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// how many suffixes are tried when the username an account would get on first sign in is taken
+var oidcUsernameAttempts = 10
+
+// the provider named by the {provider} route variable, nil if it isn't configured
+func (ah *AccountHandler) findProvider(rc *types.RequestCtx) *types.OIDCProvider {
+	return ah.rh.OIDCProviders[mux.Vars(rc.Request)["provider"]]
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/oidc/providers
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountOIDCProviders(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "GET":
+		return ah.handleGetOIDCProviders(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: GET
+// PATH: host.com/api/account/oidc/providers
+// the providers the login page can offer
+func (ah *AccountHandler) handleGetOIDCProviders(rc *types.RequestCtx) error {
+	names := make([]string, 0, len(ah.rh.OIDCProviders))
+	for name := range ah.rh.OIDCProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rc.AddToResponseList("providers", names)
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/oidc/{provider}/authorize
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountOIDCAuthorize(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return ah.handleOIDCAuthorize(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/account/oidc/{provider}/authorize
+// starts signing in with the provider, linking it to the signed in account when link is set, or
+// confirming the signed in user when reauth is set so an account without a password can manage itself
+// the client sends the user to the authorization_url, the provider sends them back to the front end
+// with the code and state for the callback
+func (ah *AccountHandler) handleOIDCAuthorize(rc *types.RequestCtx) error {
+	provider := ah.findProvider(rc)
+	if provider == nil {
+		return ResolveResponseErr(rc, types.ErrorNotFound("provider"))
+	}
+
+	var parsed struct {
+		Link   bool `json:"link"`
+		Reauth bool `json:"reauth"`
+	}
+
+	body, err := io.ReadAll(rc.Request.Body)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &parsed); err != nil {
+			return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
+		}
+	}
+
+	if parsed.Link && parsed.Reauth {
+		return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
+	}
+
+	var link *types.Account
+	if parsed.Link || parsed.Reauth {
+		if rc.UnresolvedAccount {
+			return ResolveResponseErr(rc, types.ErrorUnauthorized())
+		}
+
+		if !rc.AccountCtx.IsSessionAuth() {
+			return ResolveResponseErr(rc, types.ErrorSessionRequired())
+		}
+
+		if parsed.Link {
+			link = rc.AccountCtx.Account
+		}
+	}
+
+	ls, state, err := types.NewOIDCLoginState(provider.Name, link)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	if parsed.Reauth {
+		ls.ReauthSessionID = &rc.AccountCtx.Session.ID
+	}
+
+	authURL, err := provider.AuthorizationURL(state, ls.Nonce, ls.Verifier)
+	if err != nil {
+		fmt.Println("Error discovering oidc provider", provider.Name, err)
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	err = rc.Store.SaveNewSingle(ls, "oidc_states")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	types.SetOIDCStateCookie(rc.Writer, state, *ls.ExpiresAt)
+	rc.AddToResponseList("authorization_url", authURL)
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/oidc/{provider}/callback
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountOIDCCallback(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return ah.handleOIDCCallback(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/account/oidc/{provider}/callback
+// finishes the sign in with the code the provider sent back, the account linked to the provider's
+// user is signed in and one is created for them the first time
+func (ah *AccountHandler) handleOIDCCallback(rc *types.RequestCtx) error {
+	provider := ah.findProvider(rc)
+	if provider == nil {
+		return ResolveResponseErr(rc, types.ErrorNotFound("provider"))
+	}
+
+	body, err := io.ReadAll(rc.Request.Body)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	var parsed struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+
+	err = json.Unmarshal(body, &parsed)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
+	}
+
+	// the state must come back to the browser that started the sign in
+	cookie, err := rc.Request.Cookie(types.OIDC_STATE_COOKIE_NAME)
+	if err != nil || parsed.State == "" || cookie.Value != parsed.State {
+		return ResolveResponseErr(rc, types.ErrorInvalid("sign in state"))
+	}
+	types.ClearOIDCStateCookie(rc.Writer)
+
+	ls := &types.OIDCLoginState{}
+	filter := bson.D{{Key: "state_hash", Value: utils.HashToken(parsed.State)}, {Key: "provider", Value: provider.Name}}
+
+	err = rc.Store.FindSingle(filter, ls, "oidc_states")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("sign in state"))
+	}
+
+	// states are single use
+	err = rc.Store.DeleteSingle(ls.ID, "oidc_states")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	if ls.IsExpired() {
+		return ResolveResponseErr(rc, types.ErrorInvalid("sign in state"))
+	}
+
+	claims, err := provider.Exchange(parsed.Code, ls.Verifier, ls.Nonce)
+	if err != nil {
+		fmt.Println("Error exchanging oidc code", provider.Name, err)
+		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+	}
+
+	if ls.LinkAccountID != nil {
+		return ah.linkExternalLogin(rc, provider, claims, ls)
+	}
+
+	if ls.ReauthSessionID != nil {
+		return ah.reauthenticate(rc, provider, claims, ls)
+	}
+
+	var account *types.Account
+
+	login, err := rc.Store.FindExternalLogin(provider.Name, claims.Subject)
+	switch err {
+	case nil:
+		account, err = rc.Store.FindAccountByID(login.AccountID)
+		if err != nil {
+			return ResolveResponseErr(rc, types.ErrorUnexpected())
+		}
+
+		ts := time.Now().UTC()
+		_, err = rc.Store.UpdateMulti(bson.D{{Key: "_id", Value: login.ID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "last_used_at", Value: ts}}}}, "external_logins")
+		if err != nil {
+			fmt.Println("Error updating external login", err)
+		}
+	case mongo.ErrNoDocuments:
+		var apiErr *types.APIError
+		account, apiErr = ah.createOIDCAccount(rc, provider, claims)
+		if apiErr != nil {
+			return ResolveResponseErr(rc, *apiErr)
+		}
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	// the provider only stands in for the password, the second factor is still ours to check
+	// before the account is restored or signed in to
+	if account.HasTwoFactor() {
+		return ah.resolveLoginChallenge(rc, account, account.IsDeleted())
+	}
+
+	// the provider stands in for the password when restoring too
+	if account.IsDeleted() {
+		account.Restore()
+
+		err = rc.Store.UpdateAccount(account)
+		if err != nil {
+			return ResolveResponseErr(rc, types.ErrorUnexpected())
+		}
+
+		rc.AddToResponseList("restored", true)
+	}
+
+	return ah.resolveNewSession(rc, account)
+}
+
+// creates the account for a provider's user signing in for the first time
+// accounts are never matched up by email, whoever controls the provider account could otherwise
+// take over an existing account that uses the same address
+func (ah *AccountHandler) createOIDCAccount(rc *types.RequestCtx, provider *types.OIDCProvider, claims *types.OIDCClaims) (*types.Account, *types.APIError) {
+	email := types.NormalizeEmail(claims.Email)
+	if email == "" {
+		apiErr := types.ErrorForbidden("the provider didn't share an email address")
+		return nil, &apiErr
+	}
+
+	if problem := types.ValidateEmail(email); problem != "" {
+		apiErr := types.ErrorInvalid("provider email")
+		return nil, &apiErr
+	}
+
+	existing := &types.Account{}
+	err := rc.Store.FindSingle(bson.D{{Key: "email", Value: email}}, existing, "accounts")
+	if err == nil {
+		apiErr := types.ErrorConflict("an account already uses this email, log in to it and link the provider instead")
+		return nil, &apiErr
+	}
+	if err != mongo.ErrNoDocuments {
+		apiErr := types.ErrorUnexpected()
+		return nil, &apiErr
+	}
+
+	username, err := ah.uniqueUsername(rc, types.OIDCUsername(claims))
+	if err != nil {
+		apiErr := types.ErrorUnexpected()
+		return nil, &apiErr
+	}
+
+	account := types.NewAccount()
+	account.SetUsername(username)
+	account.Email = email
+
+	// providers vouch for the addresses they've verified, others get our verification mail
+	if claims.EmailVerified {
+		account.MarkVerified()
+	}
+
+	err = rc.Store.SaveNewSingle(account, "accounts")
+	if err != nil {
+		apiErr := types.ErrorUnexpected()
+		return nil, &apiErr
+	}
+
+	err = rc.Store.SaveNewSingle(types.NewExternalLogin(account, provider.Name, claims), "external_logins")
+	if err != nil {
+		// the same user signing in twice at once, the other sign in made the account
+		if mongo.IsDuplicateKeyError(err) {
+			if err := rc.Store.DeleteSingle(account.ID, "accounts"); err != nil {
+				fmt.Println("Error removing duplicate oidc account", err)
+			}
+
+			apiErr := types.ErrorConflict("this " + provider.Name + " account was just signed in with, try again")
+			return nil, &apiErr
+		}
+
+		apiErr := types.ErrorUnexpected()
+		return nil, &apiErr
+	}
+
+	if !account.IsVerified() {
+		if err := ah.sendVerificationMail(account); err != nil {
+			fmt.Println("Error sending verification mail", err)
+		}
+	}
+
+	return account, nil
+}
+
+// the username if it's free, otherwise the first free one with a number added
+func (ah *AccountHandler) uniqueUsername(rc *types.RequestCtx, username string) (string, error) {
+	candidate := username
+
+	for i := 0; i < oidcUsernameAttempts; i++ {
+		err := rc.Store.FindSingle(bson.D{{Key: "username_key", Value: types.NormalizeUsername(candidate)}}, &types.Account{}, "accounts")
+		if err == mongo.ErrNoDocuments {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		candidate = username + strconv.Itoa(1000+rand.Intn(9000))
+	}
+
+	return "", fmt.Errorf("no free username for %s", username)
+}
+
+// links the provider's user to the account that started the sign in
+func (ah *AccountHandler) linkExternalLogin(rc *types.RequestCtx, provider *types.OIDCProvider, claims *types.OIDCClaims, ls *types.OIDCLoginState) error {
+	if rc.UnresolvedAccount || rc.AccountCtx.Account.ID != *ls.LinkAccountID {
+		return ResolveResponseErr(rc, types.ErrorForbidden("the link was started by another account"))
+	}
+
+	account := rc.AccountCtx.Account
+
+	existing, err := rc.Store.FindExternalLogin(provider.Name, claims.Subject)
+	if err == nil {
+		if existing.AccountID != account.ID {
+			return ResolveResponseErr(rc, types.ErrorConflict("this "+provider.Name+" account is linked to another account"))
+		}
+
+		rc.AddToResponseListCLF("external_login", existing)
+		return ResolveResponse(rc)
+	}
+	if err != mongo.ErrNoDocuments {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	linked := bson.D{{Key: "account_id", Value: account.ID}, {Key: "provider", Value: provider.Name}}
+	if rc.Store.CountResults("external_logins", linked) > 0 {
+		return ResolveResponseErr(rc, types.ErrorConflict("another "+provider.Name+" account is already linked, unlink it first"))
+	}
+
+	login := types.NewExternalLogin(account, provider.Name, claims)
+
+	err = rc.Store.SaveNewSingle(login, "external_logins")
+	if mongo.IsDuplicateKeyError(err) {
+		return ResolveResponseErr(rc, types.ErrorConflict("this "+provider.Name+" account is linked to another account"))
+	}
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	rc.AddToResponseListCLF("external_login", login)
+
+	return ResolveResponse(rc)
+}
+
+// confirms the user of the session that started the sign in is the provider's user linked to it's account
+func (ah *AccountHandler) reauthenticate(rc *types.RequestCtx, provider *types.OIDCProvider, claims *types.OIDCClaims, ls *types.OIDCLoginState) error {
+	if !rc.AccountCtx.IsSessionAuth() || rc.AccountCtx.Session.ID != *ls.ReauthSessionID {
+		return ResolveResponseErr(rc, types.ErrorForbidden("the sign in was started by another session"))
+	}
+
+	login, err := rc.Store.FindExternalLogin(provider.Name, claims.Subject)
+	if err == mongo.ErrNoDocuments || (err == nil && login.AccountID != rc.AccountCtx.Account.ID) {
+		return ResolveResponseErr(rc, types.ErrorForbidden("this "+provider.Name+" account isn't linked to your account"))
+	}
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	session := rc.AccountCtx.Session
+	ts := time.Now().UTC()
+	session.ReauthenticatedAt = &ts
+
+	err = rc.Store.UpdateSession(session)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	rc.AddToResponseList("reauthenticated_until", ts.Add(time.Duration(types.OIDC_REAUTH_MINUTES)*time.Minute))
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/oidc
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountOIDC(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "GET":
+		return ah.handleGetExternalLogins(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: GET
+// PATH: host.com/api/account/oidc
+// the providers linked to the account
+func (ah *AccountHandler) handleGetExternalLogins(rc *types.RequestCtx) error {
+	if rc.UnresolvedAccount {
+		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+	}
+
+	if !rc.AccountCtx.IsSessionAuth() {
+		return ResolveResponseErr(rc, types.ErrorSessionRequired())
+	}
+
+	logins := []*types.ExternalLogin{}
+	err := rc.Store.FindMulti(bson.D{{Key: "account_id", Value: rc.AccountCtx.Account.ID}}, &logins, "external_logins")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	formatted := make([]bson.M, 0, len(logins))
+	for _, v := range logins {
+		formatted = append(formatted, v.CLFormat())
+	}
+
+	rc.AddToResponseList("external_logins", formatted)
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/oidc/{provider}
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountOIDCProvider(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "DELETE":
+		return ah.handleDeleteExternalLogin(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: DELETE
+// PATH: host.com/api/account/oidc/{provider}
+// unlinks the provider, an account without a password must keep at least one way to sign in
+func (ah *AccountHandler) handleDeleteExternalLogin(rc *types.RequestCtx) error {
+	if rc.UnresolvedAccount {
+		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+	}
+
+	if !rc.AccountCtx.IsSessionAuth() {
+		return ResolveResponseErr(rc, types.ErrorSessionRequired())
+	}
+
+	account := rc.AccountCtx.Account
+	owned := bson.D{{Key: "account_id", Value: account.ID}}
+	filter := bson.D{{Key: "account_id", Value: account.ID}, {Key: "provider", Value: mux.Vars(rc.Request)["provider"]}}
+
+	linked := rc.Store.CountResults("external_logins", filter)
+	if linked == 0 {
+		return ResolveResponseErr(rc, types.ErrorNotFound("external login"))
+	}
+
+	if account.Password == "" && rc.Store.CountResults("external_logins", owned) <= linked {
+		return ResolveResponseErr(rc, types.ErrorConflict("set a password before unlinking your last sign in provider"))
+	}
+
+	_, err := rc.Store.DeleteMulti(filter, "external_logins")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	return ResolveResponse(rc)
+}
@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dd-web/opforu-server/internal/types"
+	"github.com/dd-web/opforu-server/internal/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// checks the password of the account making the request
+// wrong guesses count against the login limits so a stolen session can't be used to guess it
+// accounts without a password sign in with their provider again instead (see handleOIDCAuthorize)
+// - returns the error to respond with, nil if the password matched
+func (ah *AccountHandler) checkCurrentPassword(rc *types.RequestCtx, password string) *types.APIError {
+	if rc.AccountCtx.Account.Password == "" {
+		if rc.AccountCtx.Session.IsRecentlyReauthenticated() {
+			return nil
+		}
+
+		apiErr := types.ErrorForbidden("sign in with your provider again to confirm it's you")
+		return &apiErr
+	}
+
+	keys := []string{types.LoginAttemptsIPKey(rc.RemoteIP()), types.LoginAttemptsAccountKey(rc.AccountCtx.Account)}
+
+	retryAfter, err := ah.findLoginAttempts(rc, keys)
+	if err != nil {
+		apiErr := types.ErrorUnexpected()
+		return &apiErr
+	}
+
+	if retryAfter > 0 {
+		apiErr := types.ErrorTooManyRequests(retryAfter)
+		return &apiErr
+	}
+
+	if !utils.CompareHash(rc.AccountCtx.Account.Password, password) {
+		apiErr := types.ErrorValidation(types.FieldErrors{"current_password": "incorrect password"})
+		apiErr.RetryAfter = ah.recordLoginFailures(rc, keys)
+		return &apiErr
+	}
+
+	return nil
+}
+
+// records a change to the account making the request
+// the change has already been made so a failure here is only logged
+func (ah *AccountHandler) recordAccountChange(rc *types.RequestCtx, change types.AccountChange, oldValue, newValue string) {
+	entry := types.NewAccountHistory(rc, change, oldValue, newValue)
+
+	err := rc.Store.SaveNewSingle(entry, "account_history")
+	if err != nil {
+		fmt.Println("Error saving account history", err)
+	}
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/username
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountUsername(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return ah.handlePostUsername(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/account/username
+// the new username may differ from the current one only in casing
+func (ah *AccountHandler) handlePostUsername(rc *types.RequestCtx) error {
+	if rc.UnresolvedAccount {
+		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+	}
+
+	if !rc.AccountCtx.IsSessionAuth() {
+		return ResolveResponseErr(rc, types.ErrorSessionRequired())
+	}
+
+	body, err := io.ReadAll(rc.Request.Body)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	var parsed struct {
+		Username string `json:"username"`
+	}
+
+	err = json.Unmarshal(body, &parsed)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
+	}
+
+	account := rc.AccountCtx.Account
+	username := strings.TrimSpace(parsed.Username)
+
+	if problem := types.ValidateUsername(username); problem != "" {
+		return ResolveResponseErr(rc, types.ErrorValidation(types.FieldErrors{"username": problem}))
+	}
+
+	if username == account.Username {
+		return ResolveResponseErr(rc, types.ErrorConflict("username is unchanged"))
+	}
+
+	existing, _ := rc.Store.FindAccountByUsernameOrEmail(username, "")
+	if existing != nil && existing.ID != account.ID {
+		return ResolveResponseErr(rc, types.ErrorConflict("username already exists"))
+	}
+
+	old := account.Username
+	ts := time.Now().UTC()
+
+	account.SetUsername(username)
+	account.UpdatedAt = &ts
+
+	err = rc.Store.UpdateAccount(account)
+	if mongo.IsDuplicateKeyError(err) {
+		return ResolveResponseErr(rc, types.ErrorConflict("username already exists"))
+	}
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	ah.recordAccountChange(rc, types.AccountChangeUsername, old, username)
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/email
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountEmail(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return ah.handlePostEmail(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/account/email
+// the new address has to be verified again, the old one is told about the change
+func (ah *AccountHandler) handlePostEmail(rc *types.RequestCtx) error {
+	if rc.UnresolvedAccount {
+		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+	}
+
+	if !rc.AccountCtx.IsSessionAuth() {
+		return ResolveResponseErr(rc, types.ErrorSessionRequired())
+	}
+
+	body, err := io.ReadAll(rc.Request.Body)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	var parsed struct {
+		Email           string `json:"email"`
+		CurrentPassword string `json:"current_password"`
+	}
+
+	err = json.Unmarshal(body, &parsed)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
+	}
+
+	account := rc.AccountCtx.Account
+	email := types.NormalizeEmail(parsed.Email)
+
+	if problem := types.ValidateEmail(email); problem != "" {
+		return ResolveResponseErr(rc, types.ErrorValidation(types.FieldErrors{"email": problem}))
+	}
+
+	if email == account.Email {
+		return ResolveResponseErr(rc, types.ErrorConflict("email is unchanged"))
+	}
+
+	if apiErr := ah.checkCurrentPassword(rc, parsed.CurrentPassword); apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	existing := &types.Account{}
+	err = rc.Store.FindSingle(bson.D{{Key: "email", Value: email}}, existing, "accounts")
+	if err == nil {
+		return ResolveResponseErr(rc, types.ErrorConflict("email already exists"))
+	}
+
+	old := account.Email
+	ts := time.Now().UTC()
+
+	account.Email = email
+	account.EmailVerifiedAt = nil
+	account.UpdatedAt = &ts
+
+	err = rc.Store.UpdateAccount(account)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	ah.recordAccountChange(rc, types.AccountChangeEmail, old, email)
+
+	err = ah.sendVerificationMail(account)
+	if err != nil {
+		fmt.Println("Error sending verification mail", err)
+	}
+
+	err = ah.rh.Mailer.Send(types.NewEmailChangedMail(account, old))
+	if err != nil {
+		fmt.Println("Error sending email changed mail", err)
+	}
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/password
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountPassword(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return ah.handlePostPassword(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/account/password
+// signs the account out everywhere and responds with a new session for the client that changed it
+func (ah *AccountHandler) handlePostPassword(rc *types.RequestCtx) error {
+	if rc.UnresolvedAccount {
+		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+	}
+
+	if !rc.AccountCtx.IsSessionAuth() {
+		return ResolveResponseErr(rc, types.ErrorSessionRequired())
+	}
+
+	body, err := io.ReadAll(rc.Request.Body)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	var parsed struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+		ConfirmPassword string `json:"confirm_password"`
+	}
+
+	err = json.Unmarshal(body, &parsed)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
+	}
+
+	account := rc.AccountCtx.Account
+
+	fields := types.FieldErrors{}
+	if problem := types.ValidatePassword(parsed.Password, account.Username); problem != "" {
+		fields.Add("password", problem)
+	}
+	if parsed.ConfirmPassword != parsed.Password {
+		fields.Add("confirm_password", "does not match password")
+	}
+	if !fields.Empty() {
+		return ResolveResponseErr(rc, types.ErrorValidation(fields))
+	}
+
+	if apiErr := ah.checkCurrentPassword(rc, parsed.CurrentPassword); apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	pwh, err := utils.HashPassword(parsed.Password)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	ts := time.Now().UTC()
+	account.Password = pwh
+	account.UpdatedAt = &ts
+
+	err = rc.Store.UpdateAccount(account)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	ah.recordAccountChange(rc, types.AccountChangePassword, "", "")
+
+	err = rc.Store.DeleteAccountSessions(account.ID)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	return ah.resolveNewSession(rc, account)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/history
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountHistory(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "GET":
+		return ah.handleGetHistory(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: GET
+// PATH: host.com/api/account/history
+// lists the most recent changes made to the account
+func (ah *AccountHandler) handleGetHistory(rc *types.RequestCtx) error {
+	if rc.UnresolvedAccount {
+		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+	}
+
+	if !rc.AccountCtx.IsSessionAuth() {
+		return ResolveResponseErr(rc, types.ErrorSessionRequired())
+	}
+
+	history, err := rc.Store.FindAccountHistory(rc.AccountCtx.Account.ID)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	formatted := make([]bson.M, 0, len(history))
+	for _, v := range history {
+		formatted = append(formatted, v.CLFormat())
+	}
+
+	rc.AddToResponseList("history", formatted)
+
+	return ResolveResponse(rc)
+}
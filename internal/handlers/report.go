@@ -0,0 +1,663 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dd-web/opforu-server/internal/builder"
+	"github.com/dd-web/opforu-server/internal/types"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type ReportHandler struct {
+	rh *types.RoutingHandler
+}
+
+func InitReportHandler(rh *types.RoutingHandler) *ReportHandler {
+	return &ReportHandler{
+		rh: rh,
+	}
+}
+
+// the body of a new report, content is located the same way clients address it
+type reportRequest struct {
+	TargetType    types.ModTarget      `json:"target_type"`
+	Thread        string               `json:"thread"`         // thread slug, for threads and posts
+	PostNumber    int                  `json:"post_number"`    // for posts
+	Article       string               `json:"article"`        // article slug, for comments
+	CommentNumber int                  `json:"comment_number"` // for comments
+	Asset         string               `json:"asset"`          // sha256 of the file, for assets within any of the above
+	Category      types.ReportCategory `json:"category"`
+	Reason        string               `json:"reason"`
+}
+
+// the board of the content reported under the {target_id} route variable
+func BoardFromReportTarget(rc *types.RequestCtx) (primitive.ObjectID, error) {
+	target_id, err := primitive.ObjectIDFromHex(mux.Vars(rc.Request)["target_id"])
+	if err != nil {
+		return primitive.NilObjectID, types.ErrorInvalid("target id")
+	}
+
+	report := &types.Report{}
+	err = rc.Store.FindSingle(bson.D{{Key: "target_id", Value: target_id}}, report, "reports")
+	if err != nil {
+		return primitive.NilObjectID, types.ErrorNotFound("report")
+	}
+
+	if report.BoardID == nil {
+		return primitive.NilObjectID, nil
+	}
+	return *report.BoardID, nil
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/reports
+/***********************************************************************************************/
+func (rh *ReportHandler) RegisterReportRoot(rc *types.RequestCtx) error {
+	rc.UpdateStore(rh.rh.Store)
+
+	switch rc.Request.Method {
+	case "GET":
+		return rh.handleGetOwnReports(rc)
+	case "POST":
+		return rh.handleNewReport(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: GET
+// PATH: host.com/api/reports
+// the reports the account has filed, newest first
+func (rh *ReportHandler) handleGetOwnReports(rc *types.RequestCtx) error {
+	reports, err := rc.Store.FindAccountReports(rc.AccountCtx.Account.ID)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	formatted := make([]bson.M, 0, len(reports))
+	for _, v := range reports {
+		formatted = append(formatted, v.CLFormat())
+	}
+
+	rc.AddToResponseList("reports", formatted)
+
+	return ResolveResponse(rc)
+}
+
+// METHOD: POST
+// PATH: host.com/api/reports
+func (rh *ReportHandler) handleNewReport(rc *types.RequestCtx) error {
+	body, err := io.ReadAll(rc.Request.Body)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	var parsed reportRequest
+	err = json.Unmarshal(body, &parsed)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
+	}
+
+	parsed.Reason = strings.TrimSpace(parsed.Reason)
+	fields := types.FieldErrors{}
+
+	if !types.IsReportableTarget(parsed.TargetType) {
+		fields.Add("target_type", "must be thread, post, comment or asset")
+	}
+
+	if !parsed.Category.IsValid() {
+		fields.Add("category", "must be spam, harassment, illegal, off_topic or other")
+	}
+
+	if len(parsed.Reason) > types.REPORT_REASON_MAX_LENGTH {
+		fields.Add("reason", "too long")
+	} else if parsed.Category == types.ReportCategoryOther && parsed.Reason == "" {
+		fields.Add("reason", "required for other")
+	}
+
+	if !fields.Empty() {
+		return ResolveResponseErr(rc, types.ErrorValidation(fields))
+	}
+
+	retryAfter, err := types.ReportRetryAfter(rc.Store, rc.AccountCtx.Account.ID)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	if retryAfter > 0 {
+		return ResolveResponseErr(rc, types.ErrorTooManyRequests(retryAfter))
+	}
+
+	report, apiErr := locateReportTarget(rc, parsed)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	// one open report per reporter and content, more of the same don't help staff
+	filter := append(types.OpenReportsFilter(report.TargetID), bson.E{Key: "reporter_id", Value: rc.AccountCtx.Account.ID})
+	if rc.Store.CountResults("reports", filter) > 0 {
+		return ResolveResponseErr(rc, types.ErrorConflict("you've already reported this"))
+	}
+
+	err = rc.Store.SaveNewSingle(report, "reports")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	rc.AddToResponseListCLF("report", report)
+
+	return ResolveResponse(rc)
+}
+
+// finds the reported content, the returned report is ready to be saved
+func locateReportTarget(rc *types.RequestCtx, parsed reportRequest) (*types.Report, *types.APIError) {
+	notFound := func(resource string) (*types.Report, *types.APIError) {
+		apiErr := types.ErrorNotFound(resource)
+		return nil, &apiErr
+	}
+	unexpected := func() (*types.Report, *types.APIError) {
+		apiErr := types.ErrorUnexpected()
+		return nil, &apiErr
+	}
+
+	location := types.ReportLocation{}
+	var board *primitive.ObjectID
+	var target_id primitive.ObjectID
+	var assets []primitive.ObjectID
+
+	switch {
+	case parsed.Thread != "" && parsed.TargetType != types.ModTargetComment:
+		thread, err := rc.Store.FindThreadBySlug(parsed.Thread)
+		if err != nil {
+			return notFound("thread")
+		}
+
+		if thread.IsDeleted() {
+			apiErr := types.ErrorGone("thread")
+			return nil, &apiErr
+		}
+
+		location.ThreadID = &thread.ID
+		location.Thread = thread.Slug
+		board = &thread.Board
+		target_id, assets = thread.ID, thread.Assets
+
+		if parsed.TargetType == types.ModTargetPost || (parsed.TargetType == types.ModTargetAsset && parsed.PostNumber > 0) {
+			post := &types.Post{}
+			err = rc.Store.FindSingle(bson.D{{Key: "thread", Value: thread.ID}, {Key: "post_number", Value: parsed.PostNumber}}, post, "posts")
+			if err == mongo.ErrNoDocuments {
+				return notFound("post")
+			}
+			if err != nil {
+				return unexpected()
+			}
+
+			if post.IsDeleted() {
+				apiErr := types.ErrorGone("post")
+				return nil, &apiErr
+			}
+
+			location.PostNumber = parsed.PostNumber
+			target_id, assets = post.ID, post.Assets
+		}
+
+	case parsed.Article != "" && (parsed.TargetType == types.ModTargetComment || parsed.TargetType == types.ModTargetAsset):
+		article, err := rc.Store.FindArticleBySlug(parsed.Article)
+		if err != nil {
+			return notFound("article")
+		}
+
+		comment := &types.ArticleComment{}
+		err = rc.Store.FindSingle(bson.D{
+			{Key: "_id", Value: bson.D{{Key: "$in", Value: article.Comments}}},
+			{Key: "comment_number", Value: parsed.CommentNumber},
+		}, comment, "article_comments")
+		if err == mongo.ErrNoDocuments {
+			return notFound("comment")
+		}
+		if err != nil {
+			return unexpected()
+		}
+
+		if comment.IsDeleted() {
+			apiErr := types.ErrorGone("comment")
+			return nil, &apiErr
+		}
+
+		location.Article = article.Slug
+		location.CommentNumber = comment.CommentNumber
+		target_id, assets = comment.ID, comment.Assets
+
+	default:
+		return notFound(string(parsed.TargetType))
+	}
+
+	// assets are found among the ones attached to the content located above
+	if parsed.TargetType == types.ModTargetAsset {
+		source := &types.AssetSource{}
+		err := rc.Store.FindSingle(bson.D{{Key: "details.source.hash_sha256", Value: parsed.Asset}}, source, "asset_sources")
+		if err != nil || parsed.Asset == "" {
+			return notFound("asset")
+		}
+
+		asset := &types.Asset{}
+		err = rc.Store.FindSingle(bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: assets}}}, {Key: "source_id", Value: source.ID}}, asset, "assets")
+		if err != nil {
+			return notFound("asset")
+		}
+
+		location.Asset = parsed.Asset
+		target_id = asset.ID
+	}
+
+	report := types.NewReport(rc.AccountCtx.Account.ID, parsed.TargetType, target_id, location, parsed.Category, parsed.Reason)
+	report.BoardID = board
+	return report, nil
+}
+
+// the reported content as it is now through the same pipelines that serve it publicly
+// - returns nil if it's no longer there
+func reportContent(rc *types.RequestCtx, target types.ModTarget, target_id primitive.ObjectID, location types.ReportLocation, board_id *primitive.ObjectID) any {
+	var pipeline bson.A
+	var col string
+
+	switch {
+	case target == types.ModTargetComment:
+		comment := &types.ArticleComment{}
+		if err := rc.Store.FindSingle(bson.D{{Key: "_id", Value: target_id}}, comment, "article_comments"); err != nil {
+			return nil
+		}
+		return comment
+
+	case target == types.ModTargetAsset:
+		col, pipeline = "assets", builder.QrStrLookupAsset(target_id)
+
+	case board_id != nil && location.ThreadID != nil:
+		board, err := rc.Store.FindBoardByObjectID(*board_id)
+		if err != nil {
+			return nil
+		}
+
+		if target == types.ModTargetPost {
+			col, pipeline = "posts", builder.QrStrLookupPost(*location.ThreadID, location.PostNumber, location.Thread, board.Short, true, rc.ViewerID())
+		} else {
+			col, pipeline = "threads", builder.QrStrLookupThread(location.Thread, board.ID, board.Short, true, rc.ViewerID())
+		}
+
+	default:
+		return nil
+	}
+
+	found, err := rc.Store.RunAggregation(col, pipeline)
+	if err != nil || len(found) == 0 {
+		return nil
+	}
+	return found[0]
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/mod/reports
+/***********************************************************************************************/
+func (rh *ReportHandler) RegisterModReports(rc *types.RequestCtx) error {
+	rc.UpdateStore(rh.rh.Store)
+
+	switch rc.Request.Method {
+	case "GET":
+		return rh.handleGetReportQueue(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: GET
+// PATH: host.com/api/mod/reports
+// reported content with open reports, filtered by any of ?board={short} &target_type= &claimed=mine|none
+// ?status=resolved or dismissed lists content whose reports were closed that way instead
+func (rh *ReportHandler) handleGetReportQueue(rc *types.RequestCtx) error {
+	params := rc.Query.UnhandledQueryParams
+	filter := bson.D{{Key: "status", Value: types.ReportStatusOpen}}
+
+	if v, ok := params["status"].(string); ok {
+		switch types.ReportStatus(v) {
+		case types.ReportStatusOpen, types.ReportStatusResolved, types.ReportStatusDismissed:
+			filter[0].Value = v
+		default:
+			return ResolveResponseErr(rc, types.ErrorInvalid("status"))
+		}
+	}
+
+	if v, ok := params["target_type"].(string); ok {
+		if !types.IsReportableTarget(types.ModTarget(v)) {
+			return ResolveResponseErr(rc, types.ErrorInvalid("target_type"))
+		}
+		filter = append(filter, bson.E{Key: "target_type", Value: v})
+	}
+
+	if v, ok := params["board"].(string); ok {
+		board, err := rc.Store.FindBoardByShort(v)
+		if err != nil {
+			return ResolveResponseErr(rc, types.ErrorNotFound("board"))
+		}
+		filter = append(filter, bson.E{Key: "board_id", Value: board.ID})
+	}
+
+	switch params["claimed"] {
+	case nil:
+	case "mine":
+		filter = append(filter, bson.E{Key: "claimed_by", Value: rc.AccountCtx.Account.ID})
+	case "none":
+		filter = append(filter, bson.E{Key: "claimed_by", Value: bson.D{{Key: "$exists", Value: false}}})
+	default:
+		return ResolveResponseErr(rc, types.ErrorInvalid("claimed"))
+	}
+
+	counted, err := rc.Store.RunAggregation("reports", builder.QrStrCountReportQueue(filter))
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	count := 0
+	if len(counted) > 0 {
+		if total, ok := counted[0]["total"].(int32); ok {
+			count = int(total)
+		}
+	}
+
+	groups, err := rc.Store.RunAggregation("reports", builder.QrStrLookupReportQueue(filter, rc.Query))
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	for _, group := range groups {
+		rh.attachReportContent(rc, group)
+	}
+
+	rc.Pagination.Update(count)
+	rc.Records = groups
+
+	return ResolveResponse(rc)
+}
+
+// adds the live content to a group from the report queue
+func (rh *ReportHandler) attachReportContent(rc *types.RequestCtx, group bson.M) {
+	target_id, _ := group["_id"].(primitive.ObjectID)
+	target, _ := group["target_type"].(string)
+
+	location := types.ReportLocation{}
+	if raw, err := bson.Marshal(group["location"]); err == nil {
+		_ = bson.Unmarshal(raw, &location)
+	}
+
+	first := &types.Report{}
+	if err := rc.Store.FindSingle(bson.D{{Key: "target_id", Value: target_id}}, first, "reports"); err != nil {
+		return
+	}
+
+	// the thread id is only kept on the reports themselves
+	location.ThreadID = first.Location.ThreadID
+	group["content"] = reportContent(rc, types.ModTarget(target), target_id, location, first.BoardID)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/mod/reports/{target_id}
+/***********************************************************************************************/
+func (rh *ReportHandler) RegisterModReportTarget(rc *types.RequestCtx) error {
+	rc.UpdateStore(rh.rh.Store)
+
+	switch rc.Request.Method {
+	case "GET":
+		return rh.handleGetReportTarget(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: GET
+// PATH: host.com/api/mod/reports/{target_id}
+// every report ever filed against the content with who filed it, and the content itself
+func (rh *ReportHandler) handleGetReportTarget(rc *types.RequestCtx) error {
+	target_id, err := primitive.ObjectIDFromHex(mux.Vars(rc.Request)["target_id"])
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("target id"))
+	}
+
+	reports := []*types.Report{}
+	err = rc.Store.FindMulti(bson.D{{Key: "target_id", Value: target_id}}, &reports, "reports")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	if len(reports) == 0 {
+		return ResolveResponseErr(rc, types.ErrorNotFound("report"))
+	}
+
+	formatted := make([]bson.M, 0, len(reports))
+	for _, v := range reports {
+		f := v.CLFormat()
+		f["reporter_id"] = v.ReporterID
+		f["claimed_by"] = v.ClaimedBy
+		f["closed_by"] = v.ClosedBy
+		f["closed_at"] = v.ClosedAt
+		f["note"] = v.Note
+		formatted = append(formatted, f)
+	}
+
+	first := reports[0]
+	rc.AddToResponseList("reports", formatted)
+	rc.AddToResponseList("content", reportContent(rc, first.TargetType, target_id, first.Location, first.BoardID))
+
+	return ResolveResponse(rc)
+}
+
+// the open reports against the {target_id} route variable
+func findOpenReports(rc *types.RequestCtx) ([]*types.Report, *types.APIError) {
+	target_id, err := primitive.ObjectIDFromHex(mux.Vars(rc.Request)["target_id"])
+	if err != nil {
+		apiErr := types.ErrorInvalid("target id")
+		return nil, &apiErr
+	}
+
+	reports := []*types.Report{}
+	err = rc.Store.FindMulti(types.OpenReportsFilter(target_id), &reports, "reports")
+	if err != nil {
+		apiErr := types.ErrorUnexpected()
+		return nil, &apiErr
+	}
+
+	if len(reports) == 0 {
+		apiErr := types.ErrorNotFound("open report")
+		return nil, &apiErr
+	}
+
+	return reports, nil
+}
+
+// who the open reports are claimed by, nil if nobody
+func reportsClaimedBy(reports []*types.Report) *primitive.ObjectID {
+	for _, v := range reports {
+		if v.ClaimedBy != nil {
+			return v.ClaimedBy
+		}
+	}
+	return nil
+}
+
+// a mod action against the content the reports are about
+func newReportModAction(rc *types.RequestCtx, action types.ModActionKind, reports []*types.Report, note string) *types.ModAction {
+	first := reports[0]
+	entry := types.NewModAction(rc.AccountCtx.Account.ID, action, first.TargetType, first.TargetID, note)
+	if first.BoardID != nil {
+		entry.OnBoard(*first.BoardID)
+	}
+	return entry
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/mod/reports/{target_id}/claim
+/***********************************************************************************************/
+func (rh *ReportHandler) RegisterModReportClaim(rc *types.RequestCtx) error {
+	rc.UpdateStore(rh.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return rh.handleClaimReports(rc)
+	case "DELETE":
+		return rh.handleUnclaimReports(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/mod/reports/{target_id}/claim
+// lets other staff know the content is being looked at
+func (rh *ReportHandler) handleClaimReports(rc *types.RequestCtx) error {
+	reports, apiErr := findOpenReports(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	claimed := reportsClaimedBy(reports)
+	if claimed != nil && *claimed != rc.AccountCtx.Account.ID {
+		return ResolveResponseErr(rc, types.ErrorConflict("reports are claimed by someone else"))
+	}
+
+	ts := time.Now().UTC()
+	_, err := rc.Store.UpdateMulti(types.OpenReportsFilter(reports[0].TargetID), bson.D{{Key: "$set", Value: bson.D{
+		{Key: "claimed_by", Value: rc.AccountCtx.Account.ID},
+		{Key: "claimed_at", Value: ts},
+	}}}, "reports")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	if claimed == nil {
+		recordModAction(rc, newReportModAction(rc, types.ModActionReportClaim, reports, ""))
+	}
+
+	rc.AddToResponseList("claimed", len(reports))
+
+	return ResolveResponse(rc)
+}
+
+// METHOD: DELETE
+// PATH: host.com/api/mod/reports/{target_id}/claim
+// hands the content back to the queue, only the one who claimed it can
+func (rh *ReportHandler) handleUnclaimReports(rc *types.RequestCtx) error {
+	reports, apiErr := findOpenReports(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	claimed := reportsClaimedBy(reports)
+	if claimed == nil || *claimed != rc.AccountCtx.Account.ID {
+		return ResolveResponseErr(rc, types.ErrorConflict("reports aren't claimed by you"))
+	}
+
+	_, err := rc.Store.UpdateMulti(types.OpenReportsFilter(reports[0].TargetID), bson.D{{Key: "$unset", Value: bson.D{
+		{Key: "claimed_by", Value: ""},
+		{Key: "claimed_at", Value: ""},
+	}}}, "reports")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	recordModAction(rc, newReportModAction(rc, types.ModActionReportUnclaim, reports, ""))
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/mod/reports/{target_id}/resolve
+/***********************************************************************************************/
+func (rh *ReportHandler) RegisterModReportResolve(rc *types.RequestCtx) error {
+	rc.UpdateStore(rh.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return rh.handleCloseReports(rc, types.ReportStatusResolved)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/mod/reports/{target_id}/dismiss
+/***********************************************************************************************/
+func (rh *ReportHandler) RegisterModReportDismiss(rc *types.RequestCtx) error {
+	rc.UpdateStore(rh.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return rh.handleCloseReports(rc, types.ReportStatusDismissed)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/mod/reports/{target_id}/resolve
+// PATH: host.com/api/mod/reports/{target_id}/dismiss
+// closes every open report against the content with an optional note
+func (rh *ReportHandler) handleCloseReports(rc *types.RequestCtx, status types.ReportStatus) error {
+	reports, apiErr := findOpenReports(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	body, err := io.ReadAll(rc.Request.Body)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	var parsed struct {
+		Note string `json:"note"`
+	}
+
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &parsed)
+		if err != nil {
+			return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
+		}
+	}
+
+	parsed.Note = strings.TrimSpace(parsed.Note)
+	if len(parsed.Note) > types.REPORT_NOTE_MAX_LENGTH {
+		return ResolveResponseErr(rc, types.ErrorValidation(types.FieldErrors{"note": "too long"}))
+	}
+
+	claimed := reportsClaimedBy(reports)
+	if claimed != nil && *claimed != rc.AccountCtx.Account.ID {
+		return ResolveResponseErr(rc, types.ErrorConflict("reports are claimed by someone else"))
+	}
+
+	ts := time.Now().UTC()
+	_, err = rc.Store.UpdateMulti(types.OpenReportsFilter(reports[0].TargetID), bson.D{{Key: "$set", Value: bson.D{
+		{Key: "status", Value: status},
+		{Key: "closed_by", Value: rc.AccountCtx.Account.ID},
+		{Key: "closed_at", Value: ts},
+		{Key: "note", Value: parsed.Note},
+	}}}, "reports")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	action := types.ModActionReportResolve
+	if status == types.ReportStatusDismissed {
+		action = types.ModActionReportDismiss
+	}
+
+	before := bson.M{"status": types.ReportStatusOpen, "reports": len(reports)}
+	after := bson.M{"status": status, "reports": len(reports)}
+	recordModAction(rc, newReportModAction(rc, action, reports, parsed.Note).Snapshot(before, after))
+
+	rc.AddToResponseList(string(status), len(reports))
+
+	return ResolveResponse(rc)
+}
@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dd-web/opforu-server/internal/types"
+	"github.com/dd-web/opforu-server/internal/utils"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// edits replace a thread or post in place, the version they replace is kept in post_revisions
+// so staff can see what was said before.
+
+type postEditRequest struct {
+	Title   *string `json:"title"`
+	Content string  `json:"content"`
+}
+
+func readPostEditRequest(rc *types.RequestCtx) (*postEditRequest, *types.APIError) {
+	parsed := &postEditRequest{}
+
+	body, err := io.ReadAll(rc.Request.Body)
+	if err != nil {
+		apiErr := types.ErrorUnexpected()
+		return nil, &apiErr
+	}
+
+	err = json.Unmarshal(body, parsed)
+	if err != nil {
+		apiErr := types.ErrorInvalid("request body")
+		return nil, &apiErr
+	}
+
+	if strings.TrimSpace(parsed.Content) == "" {
+		apiErr := types.ErrorInvalid("content")
+		return nil, &apiErr
+	}
+
+	if parsed.Title != nil && strings.TrimSpace(*parsed.Title) == "" {
+		apiErr := types.ErrorInvalid("title")
+		return nil, &apiErr
+	}
+
+	return parsed, nil
+}
+
+// whether the request edits content in the thread as staff
+// - accepts the identity that made the content and when it was made
+// - authors edit their own content for a while after posting, while the thread is open
+// - staff edit anything anywhere, whenever
+func resolveThreadEditor(rc *types.RequestCtx, thread *types.Thread, author primitive.ObjectID, created_at *time.Time) (bool, *types.APIError) {
+	identity, apiErr := findCallerIdentity(rc, thread)
+	if apiErr != nil {
+		return false, apiErr
+	}
+
+	settings, err := rc.Store.FindSettings()
+	if err != nil {
+		apiErr := types.ErrorUnexpected()
+		return false, &apiErr
+	}
+
+	own := identity != nil && identity.ID == author && thread.WriteError() == nil
+	if own && types.WithinEditWindow(settings, created_at) {
+		return false, nil
+	}
+
+	if rc.CanOnBoard(types.PermPostEditAny, thread.Board) {
+		return true, nil
+	}
+
+	if own {
+		apiErr := types.ErrorForbidden("edit window has passed")
+		return false, &apiErr
+	}
+
+	if apiErr := thread.WriteError(); apiErr != nil {
+		return false, apiErr
+	}
+
+	forbidden := types.ErrorForbidden("not allowed to edit this")
+	return false, &forbidden
+}
+
+// when the current version of something was written
+func lastWritten(created_at, edited_at *time.Time) *time.Time {
+	if edited_at != nil {
+		return edited_at
+	}
+	return created_at
+}
+
+// keeps the version an edit replaces under the next revision number
+// revision numbers are unique per thread or post, an edit that loses the number to another edit
+// made at the same time takes the one after it
+func saveRevision(rc *types.RequestCtx, revision *types.PostRevision) error {
+	for attempt := 1; ; attempt++ {
+		revision.Revision = int(rc.Store.CountResults("post_revisions", bson.D{{Key: "target_id", Value: revision.TargetID}})) + 1
+
+		err := rc.Store.SaveNewSingle(revision, "post_revisions")
+		if !mongo.IsDuplicateKeyError(err) || attempt == types.REVISION_SAVE_ATTEMPTS {
+			return err
+		}
+	}
+}
+
+// METHOD: PATCH
+// PATH: host.com/api/threads/{slug}
+func (th *ThreadHandler) handleEditThread(rc *types.RequestCtx) error {
+	thread, err := rc.Store.FindThreadBySlug(mux.Vars(rc.Request)["slug"])
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorNotFound("thread"))
+	}
+
+	if thread.IsDeleted() {
+		return ResolveResponseErr(rc, types.ErrorGone("thread"))
+	}
+
+	parsed, apiErr := readPostEditRequest(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	staff, apiErr := resolveThreadEditor(rc, thread, thread.Creator, thread.CreatedAt)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	title := thread.Title
+	if parsed.Title != nil {
+		title = strings.TrimSpace(*parsed.Title)
+	}
+
+	// an edit could otherwise put up anything the filter kept out of a new thread
+	verdict, apiErr := checkSpam(rc, th.rh.Spam, types.ModTargetThread, title, parsed.Content)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	str, err := rc.TemplateStore.Parse(parsed.Content)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	revision := types.NewPostRevision(types.ModTargetThread, thread.ID,
+		thread.Title, thread.Raw, thread.Body, lastWritten(thread.CreatedAt, thread.EditedAt), rc.AccountCtx.Account.ID)
+
+	err = saveRevision(rc, revision)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	before := types.ModSnapshot(thread)
+
+	thread.Title = title
+	thread.Raw = parsed.Content
+	thread.Body = str
+	thread.EditedAt = revision.ReplacedAt
+	thread.UpdatedAt = revision.ReplacedAt
+
+	if verdict.Action == types.SpamActionHold {
+		thread.MarkDeleted(types.DeletedBySpamFilter)
+	}
+
+	err = rc.Store.UpdateThread(thread)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	if verdict.Action == types.SpamActionHold {
+		err = holdForReview(rc, verdict, types.ModTargetThread, thread.ID, &thread.Board, types.ReportLocation{ThreadID: &thread.ID, Thread: thread.Slug})
+		if err != nil {
+			return ResolveResponseErr(rc, types.ErrorUnexpected())
+		}
+	}
+
+	if staff {
+		recordModAction(rc, types.NewModAction(rc.AccountCtx.Account.ID, types.ModActionThreadEdit, types.ModTargetThread, thread.ID, "").
+			OnBoard(thread.Board).
+			Snapshot(before, thread))
+	}
+
+	rc.AddToResponseList("edited_at", thread.EditedAt)
+
+	return ResolveResponse(rc)
+}
+
+// METHOD: PATCH
+// PATH: host.com/api/threads/{slug}/posts/{post_number}
+func (th *ThreadHandler) handleEditThreadPost(rc *types.RequestCtx) error {
+	thread, err := rc.Store.FindThreadBySlug(mux.Vars(rc.Request)["slug"])
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorNotFound("thread"))
+	}
+
+	if thread.IsDeleted() {
+		return ResolveResponseErr(rc, types.ErrorGone("thread"))
+	}
+
+	parsed, apiErr := readPostEditRequest(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	post, apiErr := findThreadPost(rc, thread)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	if post.IsDeleted() {
+		return ResolveResponseErr(rc, types.ErrorGone("post"))
+	}
+
+	staff, apiErr := resolveThreadEditor(rc, thread, post.Creator, post.CreatedAt)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	verdict, apiErr := checkSpam(rc, th.rh.Spam, types.ModTargetPost, "", parsed.Content)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	str, err := rc.TemplateStore.Parse(parsed.Content)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	revision := types.NewPostRevision(types.ModTargetPost, post.ID,
+		"", post.Raw, post.Body, lastWritten(post.CreatedAt, post.EditedAt), rc.AccountCtx.Account.ID)
+
+	err = saveRevision(rc, revision)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	before := types.ModSnapshot(post)
+
+	post.Raw = parsed.Content
+	post.Body = str
+	post.EditedAt = revision.ReplacedAt
+	post.UpdatedAt = revision.ReplacedAt
+
+	if verdict.Action == types.SpamActionHold {
+		post.MarkDeleted(types.DeletedBySpamFilter)
+	}
+
+	err = rc.Store.ReplaceSingle(post.ID, post, "posts")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	if verdict.Action == types.SpamActionHold {
+		err = holdForReview(rc, verdict, types.ModTargetPost, post.ID, &thread.Board, types.ReportLocation{ThreadID: &thread.ID, Thread: thread.Slug, PostNumber: int(post.PostNumber)})
+		if err != nil {
+			return ResolveResponseErr(rc, types.ErrorUnexpected())
+		}
+	}
+
+	if staff {
+		recordModAction(rc, types.NewModAction(rc.AccountCtx.Account.ID, types.ModActionPostEdit, types.ModTargetPost, post.ID, "").
+			OnBoard(thread.Board).
+			Snapshot(before, post))
+	}
+
+	rc.AddToResponseList("edited_at", post.EditedAt)
+
+	return ResolveResponse(rc)
+}
+
+// the kept versions of something in client format, each with a diff against the version that replaced it
+// - accepts the current version the last kept one is compared to
+func revisionHistory(rc *types.RequestCtx, revisions []*types.PostRevision, current string) []bson.M {
+	editors := map[primitive.ObjectID]string{}
+	history := make([]bson.M, 0, len(revisions))
+
+	for i, revision := range revisions {
+		next := current
+		if i+1 < len(revisions) {
+			next = revisions[i+1].DiffText()
+		}
+
+		if _, ok := editors[revision.EditedBy]; !ok {
+			editors[revision.EditedBy] = ""
+			if account, err := rc.Store.FindAccountByID(revision.EditedBy); err == nil {
+				editors[revision.EditedBy] = account.Username
+			}
+		}
+
+		formatted := revision.CLFormat()
+		formatted["edited_by"] = editors[revision.EditedBy]
+		// diffs too large to work out are left for the client to make from the versions, if it wants
+		diff, ok := utils.DiffLines(revision.DiffText(), next)
+		formatted["diff"] = diff
+		formatted["diff_too_large"] = !ok
+		history = append(history, formatted)
+	}
+
+	return history
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/threads/{slug}/revisions
+/***********************************************************************************************/
+func (th *ThreadHandler) RegisterThreadRevisions(rc *types.RequestCtx) error {
+	rc.UpdateStore(th.rh.Store)
+
+	switch rc.Request.Method {
+	case "GET":
+		return th.handleThreadRevisions(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: GET
+// PATH: host.com/api/threads/{slug}/revisions
+func (th *ThreadHandler) handleThreadRevisions(rc *types.RequestCtx) error {
+	thread, err := rc.Store.FindThreadBySlug(mux.Vars(rc.Request)["slug"])
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorNotFound("thread"))
+	}
+
+	revisions, err := types.FindPostRevisions(rc.Store, thread.ID)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	current := thread.Raw
+	if current == "" {
+		current = thread.Body
+	}
+
+	rc.AddToResponseList("current", bson.M{
+		"title":     thread.Title,
+		"raw":       thread.Raw,
+		"body":      thread.Body,
+		"edited_at": thread.EditedAt,
+	})
+	rc.AddToResponseList("revisions", revisionHistory(rc, revisions, current))
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/threads/{slug}/posts/{post_number}/revisions
+/***********************************************************************************************/
+func (th *ThreadHandler) RegisterThreadPostRevisions(rc *types.RequestCtx) error {
+	rc.UpdateStore(th.rh.Store)
+
+	switch rc.Request.Method {
+	case "GET":
+		return th.handleThreadPostRevisions(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: GET
+// PATH: host.com/api/threads/{slug}/posts/{post_number}/revisions
+func (th *ThreadHandler) handleThreadPostRevisions(rc *types.RequestCtx) error {
+	thread, err := rc.Store.FindThreadBySlug(mux.Vars(rc.Request)["slug"])
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorNotFound("thread"))
+	}
+
+	post, apiErr := findThreadPost(rc, thread)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	revisions, err := types.FindPostRevisions(rc.Store, post.ID)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	current := post.Raw
+	if current == "" {
+		current = post.Body
+	}
+
+	rc.AddToResponseList("current", bson.M{
+		"raw":       post.Raw,
+		"body":      post.Body,
+		"edited_at": post.EditedAt,
+	})
+	rc.AddToResponseList("revisions", revisionHistory(rc, revisions, current))
+
+	return ResolveResponse(rc)
+}
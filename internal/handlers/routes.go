@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"github.com/gorilla/mux"
+
+	"github.com/dd-web/opforu-server/internal/types"
+)
+
+// RegisterRoutes builds every handler and registers all of the api routes on the handler's router
+// the server and the handler tests both go through here so they can't drift apart
+func RegisterRoutes(handler *types.RoutingHandler) {
+	handler_account := InitAccountHandlers(handler)
+	handler_article := InitArticleHandler(handler)
+	handler_asset := InitAssetHandler(handler)
+	handler_board := InitBoardHandler(handler)
+	handler_thread := InitThreadHandler(handler)
+	handler_internal := InitInternalHandlers(handler)
+	handler_admin := InitAdminHandler(handler)
+	handler_report := InitReportHandler(handler)
+	handler_spam := InitSpamHandler(handler)
+
+	// account
+	handler.Router.HandleFunc("/api/account/posts", Protect(handler, handler_account.RegisterAccountPosts, Require("GET", types.PermAccountRead)))
+	handler.Router.HandleFunc("/api/account/sessions/{id}", WrapFn(handler, handler_account.RegisterAccountSessionID))
+	handler.Router.HandleFunc("/api/account/sessions", WrapFn(handler, handler_account.RegisterAccountSessions))
+	handler.Router.HandleFunc("/api/account/tokens/{id}", WrapFn(handler, handler_account.RegisterAccountTokenID))
+	handler.Router.HandleFunc("/api/account/tokens", WrapFn(handler, handler_account.RegisterAccountTokens))
+	handler.Router.HandleFunc("/api/account/history", WrapFn(handler, handler_account.RegisterAccountHistory))
+	handler.Router.HandleFunc("/api/account/username", WrapFn(handler, handler_account.RegisterAccountUsername))
+	handler.Router.HandleFunc("/api/account/email", WrapFn(handler, handler_account.RegisterAccountEmail))
+	handler.Router.HandleFunc("/api/account/password", WrapFn(handler, handler_account.RegisterAccountPassword))
+	handler.Router.HandleFunc("/api/account/restore", WrapFn(handler, handler_account.RegisterAccountRestore))
+	handler.Router.HandleFunc("/api/account/export/download", WrapFn(handler, handler_account.RegisterAccountExportDownload))
+	handler.Router.HandleFunc("/api/account/export", WrapFn(handler, handler_account.RegisterAccountExport))
+	handler.Router.HandleFunc("/api/account/oidc/providers", WrapFn(handler, handler_account.RegisterAccountOIDCProviders))
+	handler.Router.HandleFunc("/api/account/oidc/{provider}/authorize", WrapFn(handler, handler_account.RegisterAccountOIDCAuthorize))
+	handler.Router.HandleFunc("/api/account/oidc/{provider}/callback", WrapFn(handler, handler_account.RegisterAccountOIDCCallback))
+	handler.Router.HandleFunc("/api/account/oidc/{provider}", WrapFn(handler, handler_account.RegisterAccountOIDCProvider))
+	handler.Router.HandleFunc("/api/account/oidc", WrapFn(handler, handler_account.RegisterAccountOIDC))
+	handler.Router.HandleFunc("/api/account/logout", WrapFn(handler, handler_account.RegisterAccountLogout))
+	handler.Router.HandleFunc("/api/account/login/2fa", WrapFn(handler, handler_account.RegisterAccountLoginTwoFactor))
+	handler.Router.HandleFunc("/api/account/login", WrapFn(handler, handler_account.RegisterAccountLogin))
+	handler.Router.HandleFunc("/api/account/2fa/enroll", WrapFn(handler, handler_account.RegisterAccountTwoFactorEnroll))
+	handler.Router.HandleFunc("/api/account/2fa/confirm", WrapFn(handler, handler_account.RegisterAccountTwoFactorConfirm))
+	handler.Router.HandleFunc("/api/account/2fa/disable", WrapFn(handler, handler_account.RegisterAccountTwoFactorDisable))
+	handler.Router.HandleFunc("/api/account/register", WrapFn(handler, handler_account.RegisterAccountRegister))
+	handler.Router.HandleFunc("/api/account/password/forgot", WrapFn(handler, handler_account.RegisterAccountPasswordForgot))
+	handler.Router.HandleFunc("/api/account/password/reset", WrapFn(handler, handler_account.RegisterAccountPasswordReset))
+	handler.Router.HandleFunc("/api/account/verify/resend", WrapFn(handler, handler_account.RegisterAccountVerifyResend))
+	handler.Router.HandleFunc("/api/account/verify", WrapFn(handler, handler_account.RegisterAccountVerify))
+	handler.Router.HandleFunc("/api/account", Protect(handler, handler_account.RegisterAccountRoot, Require("GET", types.PermAccountRead)))
+
+	// articles
+	handler.Router.HandleFunc("/api/articles", WrapFn(handler, handler_article.RegisterArticleRoot))
+	handler.Router.HandleFunc("/api/articles/{slug}/comments/{comment_number}/restore", Protect(handler, handler_article.RegisterArticleCommentRestore, Require("*", types.PermContentDeleted)))
+	handler.Router.HandleFunc("/api/articles/{slug}/comments/{comment_number}", Protect(handler, handler_article.RegisterArticleComment, Require("DELETE", types.PermCommentCreate)))
+	handler.Router.HandleFunc("/api/articles/{slug}", Protect(handler, handler_article.RegisterArticleSlug, Require("POST", types.PermCommentCreate)))
+
+	// assets
+	handler.Router.HandleFunc("/api/assets", Protect(handler, handler_asset.RegisterAssetRoot, Require("POST", types.PermAssetUpload)))
+
+	// boards
+	handler.Router.HandleFunc("/api/boards/{short}", Protect(handler, handler_board.RegisterBoardShort, Require("POST", types.PermThreadCreate).OnBoard(BoardFromShort)))
+	handler.Router.HandleFunc("/api/boards", WrapFn(handler, handler_board.RegisterBoardRoot))
+
+	// threads
+	handler.Router.HandleFunc("/api/threads/{slug}/status", Protect(handler, handler_thread.RegisterThreadStatus, Require("*", types.PermThreadModerate).OnBoard(BoardFromThreadSlug)))
+	handler.Router.HandleFunc("/api/threads/{slug}/posts/{post_number}/restore", Protect(handler, handler_thread.RegisterThreadPostRestore, Require("*", types.PermContentDeleted).OnBoard(BoardFromThreadSlug)))
+	handler.Router.HandleFunc("/api/threads/{slug}/posts/{post_number}/revisions", Protect(handler, handler_thread.RegisterThreadPostRevisions, Require("*", types.PermRevisionRead).OnBoard(BoardFromThreadSlug)))
+	handler.Router.HandleFunc("/api/threads/{slug}/posts/{post_number}", Protect(handler, handler_thread.RegisterThreadPost, Require("PATCH", types.PermPostCreate).OnBoard(BoardFromThreadSlug), Require("DELETE", types.PermPostCreate).OnBoard(BoardFromThreadSlug)))
+	handler.Router.HandleFunc("/api/threads/{slug}/restore", Protect(handler, handler_thread.RegisterThreadRestore, Require("*", types.PermContentDeleted).OnBoard(BoardFromThreadSlug)))
+	handler.Router.HandleFunc("/api/threads/{slug}/revisions", Protect(handler, handler_thread.RegisterThreadRevisions, Require("*", types.PermRevisionRead).OnBoard(BoardFromThreadSlug)))
+	handler.Router.HandleFunc("/api/threads/{slug}/mods/{name}", Protect(handler, handler_thread.RegisterThreadModName, Require("*", types.PermThreadModerate).OnBoard(BoardFromThreadSlug)))
+	handler.Router.HandleFunc("/api/threads/{slug}/mods", Protect(handler, handler_thread.RegisterThreadMods, Require("*", types.PermThreadModerate).OnBoard(BoardFromThreadSlug)))
+	handler.Router.HandleFunc("/api/threads/{slug}/bans/{name}", Protect(handler, handler_thread.RegisterThreadBanName, Require("*", types.PermThreadModerate).OnBoard(BoardFromThreadSlug)))
+	handler.Router.HandleFunc("/api/threads/{slug}/bans", Protect(handler, handler_thread.RegisterThreadBans, Require("*", types.PermThreadModerate).OnBoard(BoardFromThreadSlug)))
+	handler.Router.HandleFunc("/api/threads/{slug}/shadow-bans/{name}", Protect(handler, handler_thread.RegisterThreadShadowBanName, Require("*", types.PermShadowBan).OnBoard(BoardFromThreadSlug)))
+	handler.Router.HandleFunc("/api/threads/{slug}", Protect(handler, handler_thread.RegisterThreadRoot, Require("POST", types.PermPostCreate).OnBoard(BoardFromThreadSlug), Require("PATCH", types.PermPostCreate).OnBoard(BoardFromThreadSlug), Require("DELETE", types.PermPostCreate).OnBoard(BoardFromThreadSlug)))
+
+	// reports
+	handler.Router.HandleFunc("/api/reports", Protect(handler, handler_report.RegisterReportRoot, Require("POST", types.PermReportCreate), Require("GET", types.PermAccountRead)))
+	handler.Router.HandleFunc("/api/mod/reports/{target_id}/claim", Protect(handler, handler_report.RegisterModReportClaim, Require("*", types.PermReportReview).OnBoard(BoardFromReportTarget)))
+	handler.Router.HandleFunc("/api/mod/reports/{target_id}/resolve", Protect(handler, handler_report.RegisterModReportResolve, Require("*", types.PermReportReview).OnBoard(BoardFromReportTarget)))
+	handler.Router.HandleFunc("/api/mod/reports/{target_id}/dismiss", Protect(handler, handler_report.RegisterModReportDismiss, Require("*", types.PermReportReview).OnBoard(BoardFromReportTarget)))
+	handler.Router.HandleFunc("/api/mod/reports/{target_id}", Protect(handler, handler_report.RegisterModReportTarget, Require("*", types.PermReportReview).OnBoard(BoardFromReportTarget)))
+	handler.Router.HandleFunc("/api/mod/reports", Protect(handler, handler_report.RegisterModReports, Require("*", types.PermReportReview).OnBoard(BoardFromQuery)))
+
+	// spam
+	handler.Router.HandleFunc("/api/mod/spam/train", Protect(handler, handler_spam.RegisterModSpamTrain, Require("*", types.PermSpamReview)))
+	handler.Router.HandleFunc("/api/mod/spam/{hold_id}/approve", Protect(handler, handler_spam.RegisterModSpamApprove, Require("*", types.PermSpamReview).OnBoard(BoardFromSpamHold)))
+	handler.Router.HandleFunc("/api/mod/spam/{hold_id}/reject", Protect(handler, handler_spam.RegisterModSpamReject, Require("*", types.PermSpamReview).OnBoard(BoardFromSpamHold)))
+	handler.Router.HandleFunc("/api/mod/spam", Protect(handler, handler_spam.RegisterModSpam, Require("*", types.PermSpamReview).OnBoard(BoardFromQuery)))
+
+	// admin
+	handler.Router.HandleFunc("/api/admin/settings", Protect(handler, handler_admin.RegisterAdminSettings, Require("*", types.PermSettingsManage)))
+	handler.Router.HandleFunc("/api/admin/cache", Protect(handler, handler_admin.RegisterAdminCache, Require("*", types.PermSettingsManage)))
+	handler.Router.HandleFunc("/api/admin/mod-actions", Protect(handler, handler_admin.RegisterAdminModActions, Require("*", types.PermModLogRead)))
+	handler.Router.HandleFunc("/api/admin/boards/{short}/roles/{account_id}", Protect(handler, handler_admin.RegisterAdminBoardRoleID, Require("*", types.PermRoleManage)))
+	handler.Router.HandleFunc("/api/admin/boards/{short}/roles", Protect(handler, handler_admin.RegisterAdminBoardRoles, Require("*", types.PermRoleManage)))
+	handler.Router.HandleFunc("/api/admin/accounts/{username}/sanctions/{id}", Protect(handler, handler_admin.RegisterAdminAccountSanctionID, Require("*", types.PermAccountSanction)))
+	handler.Router.HandleFunc("/api/admin/accounts/{username}/sanctions", Protect(handler, handler_admin.RegisterAdminAccountSanctions, Require("*", types.PermAccountSanction)))
+	handler.Router.HandleFunc("/api/admin/accounts/{username}/shadow-ban", Protect(handler, handler_admin.RegisterAdminAccountShadowBan, Require("*", types.PermShadowBan)))
+	handler.Router.HandleFunc("/api/admin/ip-bans/{id}", Protect(handler, handler_admin.RegisterAdminIPBanID, Require("*", types.PermIPBan)))
+	handler.Router.HandleFunc("/api/admin/ip-bans", Protect(handler, handler_admin.RegisterAdminIPBans, Require("*", types.PermIPBan)))
+
+	// internal server routes
+	handler.Router.HandleFunc("/api/internal/session/{session_id}", WrapFn(handler, handler_internal.HandleGetSession))
+	handler.Router.HandleFunc("/api/internal/post/{thread_slug}/{post_number}", WrapFn(handler, handler_internal.HandleGetPost))
+	handler.Router.HandleFunc("/api/internal/thread/{board_short}/{thread_slug}", WrapFn(handler, handler_internal.HandleGetThread))
+
+	// request config
+	handler.Router.Use(mux.CORSMethodMiddleware(handler.Router))
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dd-web/opforu-server/internal/types"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// finds the account named by the {username} route variable
+func findRouteAccount(rc *types.RequestCtx) (*types.Account, *types.APIError) {
+	account := &types.Account{}
+
+	err := rc.Store.FindSingle(bson.D{{Key: "username_key", Value: types.NormalizeUsername(mux.Vars(rc.Request)["username"])}}, account, "accounts")
+	if err == mongo.ErrNoDocuments {
+		apiErr := types.ErrorNotFound("account")
+		return nil, &apiErr
+	}
+	if err != nil {
+		apiErr := types.ErrorUnexpected()
+		return nil, &apiErr
+	}
+
+	return account, nil
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/admin/accounts/{username}/sanctions
+/***********************************************************************************************/
+func (ah *AdminHandler) RegisterAdminAccountSanctions(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "GET":
+		return ah.handleGetSanctions(rc)
+	case "POST":
+		return ah.handleNewSanction(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: GET
+// PATH: host.com/api/admin/accounts/{username}/sanctions
+// every sanction issued against the account, including expired and lifted ones
+func (ah *AdminHandler) handleGetSanctions(rc *types.RequestCtx) error {
+	account, apiErr := findRouteAccount(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	sanctions, err := rc.Store.FindAccountSanctions(account.ID)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	formatted := make([]bson.M, 0, len(sanctions))
+	for _, v := range sanctions {
+		f := v.CLFormat()
+		f["issued_by"] = v.IssuedBy
+		f["lifted_by"] = v.LiftedBy
+		f["active"] = v.IsActive()
+		formatted = append(formatted, f)
+	}
+
+	rc.AddToResponseList("sanctions", formatted)
+
+	return ResolveResponse(rc)
+}
+
+// METHOD: POST
+// PATH: host.com/api/admin/accounts/{username}/sanctions
+// suspends or bans the account, suspensions need a duration and bans without one are permanent
+func (ah *AdminHandler) handleNewSanction(rc *types.RequestCtx) error {
+	account, apiErr := findRouteAccount(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	body, err := io.ReadAll(rc.Request.Body)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	var parsed struct {
+		Kind          types.SanctionKind `json:"kind"`
+		Reason        string             `json:"reason"`
+		DurationHours int                `json:"duration_hours"`
+	}
+
+	err = json.Unmarshal(body, &parsed)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
+	}
+
+	parsed.Reason = strings.TrimSpace(parsed.Reason)
+	fields := types.FieldErrors{}
+
+	if !parsed.Kind.IsValid() {
+		fields.Add("kind", "must be suspension or ban")
+	}
+
+	if parsed.Reason == "" {
+		fields.Add("reason", "required")
+	} else if len(parsed.Reason) > types.SANCTION_REASON_MAX_LENGTH {
+		fields.Add("reason", "too long")
+	}
+
+	if parsed.DurationHours < 0 {
+		fields.Add("duration_hours", "must not be negative")
+	} else if parsed.Kind == types.SanctionKindSuspension && parsed.DurationHours == 0 {
+		fields.Add("duration_hours", "required for a suspension")
+	} else if parsed.Kind == types.SanctionKindSuspension && parsed.DurationHours > types.SANCTION_MAX_SUSPENSION_DAYS*24 {
+		fields.Add("duration_hours", "too long for a suspension, issue a ban instead")
+	}
+
+	if !fields.Empty() {
+		return ResolveResponseErr(rc, types.ErrorValidation(fields))
+	}
+
+	if account.ID == rc.AccountCtx.Account.ID {
+		return ResolveResponseErr(rc, types.ErrorForbidden("you can't sanction your own account"))
+	}
+
+	if account.IsDeleted() {
+		return ResolveResponseErr(rc, types.ErrorConflict("account is deleted"))
+	}
+
+	// staff can only be sanctioned by those able to take their role away
+	if types.RoleHasPermission(account.Role, types.PermAccountSanction) && !types.RoleHasPermission(rc.AccountCtx.Role, types.PermRoleManage) {
+		return ResolveResponseErr(rc, types.ErrorForbidden("only admins can sanction staff accounts"))
+	}
+
+	current, err := types.ResolveAccountSanction(rc.Store, account)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	var expires *time.Time
+	if parsed.DurationHours > 0 {
+		exp := time.Now().UTC().Add(time.Duration(parsed.DurationHours) * time.Hour)
+		expires = &exp
+	}
+
+	sanction := types.NewSanction(account, current, parsed.Kind, parsed.Reason, rc.AccountCtx.Account.ID, expires)
+
+	err = rc.Store.SaveNewSingle(sanction, "account_sanctions")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	// brings the account's status in line with whichever sanction now outranks the rest
+	_, err = types.ResolveAccountSanction(rc.Store, account)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	recordModAction(rc, types.NewModAction(rc.AccountCtx.Account.ID, types.ModActionSanctionIssue, types.ModTargetAccount, account.ID, sanction.Reason).Snapshot(nil, sanction))
+
+	rc.AddToResponseListCLF("issued", sanction)
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/admin/accounts/{username}/sanctions/{id}
+/***********************************************************************************************/
+func (ah *AdminHandler) RegisterAdminAccountSanctionID(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "DELETE":
+		return ah.handleLiftSanction(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: DELETE
+// PATH: host.com/api/admin/accounts/{username}/sanctions/{id}
+// ends the sanction early, the record is kept
+func (ah *AdminHandler) handleLiftSanction(rc *types.RequestCtx) error {
+	account, apiErr := findRouteAccount(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	id, err := primitive.ObjectIDFromHex(mux.Vars(rc.Request)["id"])
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("sanction id"))
+	}
+
+	sanction := &types.Sanction{}
+	err = rc.Store.FindSingle(bson.D{{Key: "_id", Value: id}, {Key: "account_id", Value: account.ID}}, sanction, "account_sanctions")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorNotFound("sanction"))
+	}
+
+	if !sanction.IsActive() {
+		return ResolveResponseErr(rc, types.ErrorConflict("sanction is no longer in force"))
+	}
+
+	before := *sanction
+	sanction.Lift(rc.AccountCtx.Account.ID)
+
+	err = rc.Store.ReplaceSingle(sanction.ID, sanction, "account_sanctions")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	_, err = types.ResolveAccountSanction(rc.Store, account)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	recordModAction(rc, types.NewModAction(rc.AccountCtx.Account.ID, types.ModActionSanctionLift, types.ModTargetAccount, account.ID, "").Snapshot(before, sanction))
+
+	rc.AddToResponseListCLF("lifted", sanction)
+
+	return ResolveResponse(rc)
+}
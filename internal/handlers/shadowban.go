@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dd-web/opforu-server/internal/types"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// shadow banned accounts and identities keep posting like nothing happened, what they write is
+// saved but left out for everyone except them and staff (see builder.QrStrShadowBanIdentity)
+
+// the body of a shadow ban request, it's optional
+type shadowBanRequest struct {
+	Reason string `json:"reason"`
+}
+
+func readShadowBanRequest(rc *types.RequestCtx) (shadowBanRequest, *types.APIError) {
+	var parsed shadowBanRequest
+
+	body, err := io.ReadAll(rc.Request.Body)
+	if err != nil {
+		apiErr := types.ErrorUnexpected()
+		return parsed, &apiErr
+	}
+
+	if len(body) > 0 {
+		if err = json.Unmarshal(body, &parsed); err != nil {
+			apiErr := types.ErrorInvalid("request body")
+			return parsed, &apiErr
+		}
+	}
+
+	parsed.Reason = strings.TrimSpace(parsed.Reason)
+	if len(parsed.Reason) > types.SANCTION_REASON_MAX_LENGTH {
+		apiErr := types.ErrorValidation(types.FieldErrors{"reason": "too long"})
+		return parsed, &apiErr
+	}
+
+	return parsed, nil
+}
+
+// can the caller shadow ban the account, staff can only be shadow banned by those able to take their role away
+func canShadowBanAccount(rc *types.RequestCtx, account *types.Account) *types.APIError {
+	if account.ID == rc.AccountCtx.Account.ID {
+		apiErr := types.ErrorForbidden("you can't shadow ban your own account")
+		return &apiErr
+	}
+
+	if types.RoleHasPermission(account.Role, types.PermShadowBan) && !types.RoleHasPermission(rc.AccountCtx.Role, types.PermRoleManage) {
+		apiErr := types.ErrorForbidden("only admins can shadow ban staff accounts")
+		return &apiErr
+	}
+
+	return nil
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/admin/accounts/{username}/shadow-ban
+/***********************************************************************************************/
+func (ah *AdminHandler) RegisterAdminAccountShadowBan(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "PUT":
+		return ah.handleSetAccountShadowBan(rc, true)
+	case "DELETE":
+		return ah.handleSetAccountShadowBan(rc, false)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: PUT, DELETE
+// PATH: host.com/api/admin/accounts/{username}/shadow-ban
+// PUT hides everything the account writes from everyone else, DELETE lifts it
+func (ah *AdminHandler) handleSetAccountShadowBan(rc *types.RequestCtx, banned bool) error {
+	account, apiErr := findRouteAccount(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	parsed, apiErr := readShadowBanRequest(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	if apiErr := canShadowBanAccount(rc, account); apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	if account.ShadowBanned == banned {
+		return ResolveResponseErr(rc, types.ErrorConflict(shadowBanConflict("account", banned)))
+	}
+
+	before := *account
+	ts := time.Now().UTC()
+	account.ShadowBanned = banned
+	account.UpdatedAt = &ts
+
+	err := rc.Store.UpdateAccount(account)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	recordModAction(rc, types.NewModAction(rc.AccountCtx.Account.ID, shadowBanAction(banned), types.ModTargetAccount, account.ID, parsed.Reason).Snapshot(before, account))
+
+	rc.AddToResponseList("shadow_ban", bson.M{"username": account.Username, "shadow_banned": account.ShadowBanned})
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/threads/{slug}/shadow-bans/{name}
+/***********************************************************************************************/
+func (th *ThreadHandler) RegisterThreadShadowBanName(rc *types.RequestCtx) error {
+	rc.UpdateStore(th.rh.Store)
+
+	switch rc.Request.Method {
+	case "PUT":
+		return th.handleSetIdentityShadowBan(rc, true)
+	case "DELETE":
+		return th.handleSetIdentityShadowBan(rc, false)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: PUT, DELETE
+// PATH: host.com/api/threads/{slug}/shadow-bans/{name}
+// PUT hides everything the identity writes in the thread from everyone else, DELETE lifts it
+func (th *ThreadHandler) handleSetIdentityShadowBan(rc *types.RequestCtx, banned bool) error {
+	thread, err := rc.Store.FindThreadBySlug(mux.Vars(rc.Request)["slug"])
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorNotFound("thread"))
+	}
+
+	parsed, apiErr := readShadowBanRequest(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	identity, apiErr := findThreadIdentity(rc, thread, mux.Vars(rc.Request)["name"])
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	// identities of purged accounts have nobody behind them left to check
+	if !identity.Account.IsZero() {
+		account, err := rc.Store.FindAccountByID(identity.Account)
+		if err != nil {
+			return ResolveResponseErr(rc, types.ErrorUnexpected())
+		}
+
+		if apiErr := canShadowBanAccount(rc, account); apiErr != nil {
+			return ResolveResponseErr(rc, *apiErr)
+		}
+	}
+
+	if identity.ShadowBanned == banned {
+		return ResolveResponseErr(rc, types.ErrorConflict(shadowBanConflict("identity", banned)))
+	}
+
+	before := types.ModSnapshot(identity)
+	ts := time.Now().UTC()
+	identity.ShadowBanned = banned
+	identity.UpdatedAt = &ts
+
+	err = rc.Store.ReplaceSingle(identity.ID, identity, "identities")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	recordModAction(rc, types.NewModAction(rc.AccountCtx.Account.ID, shadowBanAction(banned), types.ModTargetIdentity, identity.ID, parsed.Reason).
+		OnBoard(thread.Board).
+		Snapshot(before, identity))
+
+	formatted := identity.CLFormat()
+	formatted["shadow_banned"] = identity.ShadowBanned
+	rc.AddToResponseList("identity", formatted)
+
+	return ResolveResponse(rc)
+}
+
+func shadowBanAction(banned bool) types.ModActionKind {
+	if banned {
+		return types.ModActionShadowBan
+	}
+	return types.ModActionShadowUnban
+}
+
+func shadowBanConflict(target string, banned bool) string {
+	if banned {
+		return target + " is already shadow banned"
+	}
+	return target + " isn't shadow banned"
+}
@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dd-web/opforu-server/internal/types"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// new threads, posts and comments go through the spam pipeline before they're saved. held
+// submissions are saved deleted by the spam filter so they keep their number, and wait in
+// spam_holds until staff approve or reject them. every decision trains the classifier.
+
+type SpamHandler struct {
+	rh *types.RoutingHandler
+}
+
+func InitSpamHandler(rh *types.RoutingHandler) *SpamHandler {
+	return &SpamHandler{
+		rh: rh,
+	}
+}
+
+// scores a submission before it's saved, staff who review spam skip the check
+// - returns the verdict, or the error to respond with when it's rejected
+func checkSpam(rc *types.RequestCtx, pipeline *types.SpamPipeline, target types.ModTarget, title, content string) (*types.SpamVerdict, *types.APIError) {
+	if pipeline == nil || rc.AccountCtx.Can(types.PermSpamReview) {
+		return &types.SpamVerdict{Action: types.SpamActionAccept}, nil
+	}
+
+	verdict, err := pipeline.Check(rc.Store, &types.SpamSubmission{
+		Target:  target,
+		Title:   title,
+		Content: content,
+		Account: rc.AccountCtx.Account,
+	})
+	if err != nil {
+		apiErr := types.ErrorUnexpected()
+		return nil, &apiErr
+	}
+
+	if verdict.Action == types.SpamActionReject {
+		apiErr := types.ErrorSpam()
+		return nil, &apiErr
+	}
+
+	return verdict, nil
+}
+
+// puts held content in front of staff, it's already been saved deleted by the spam filter
+func holdForReview(rc *types.RequestCtx, verdict *types.SpamVerdict, target types.ModTarget, target_id primitive.ObjectID, board_id *primitive.ObjectID, location types.ReportLocation) error {
+	hold := types.NewSpamHold(rc.AccountCtx.Account.ID, target, target_id, location, verdict)
+	hold.BoardID = board_id
+
+	err := rc.Store.SaveNewSingle(hold, "spam_holds")
+	if err != nil {
+		return err
+	}
+
+	rc.AddToResponseList("held", true)
+	return nil
+}
+
+// Resolves the board of the hold named by the {hold_id} route variable for board scoped permissions
+// holds on article comments belong to no board
+func BoardFromSpamHold(rc *types.RequestCtx) (primitive.ObjectID, error) {
+	hold, apiErr := findSpamHold(rc)
+	if apiErr != nil {
+		return primitive.NilObjectID, *apiErr
+	}
+
+	if hold.BoardID == nil {
+		return primitive.NilObjectID, nil
+	}
+	return *hold.BoardID, nil
+}
+
+func findSpamHold(rc *types.RequestCtx) (*types.SpamHold, *types.APIError) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(rc.Request)["hold_id"])
+	if err != nil {
+		apiErr := types.ErrorInvalid("hold id")
+		return nil, &apiErr
+	}
+
+	hold := &types.SpamHold{}
+	err = rc.Store.FindSingle(bson.D{{Key: "_id", Value: id}}, hold, "spam_holds")
+	if err != nil {
+		apiErr := types.ErrorNotFound("hold")
+		return nil, &apiErr
+	}
+	return hold, nil
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/mod/spam
+/***********************************************************************************************/
+func (sh *SpamHandler) RegisterModSpam(rc *types.RequestCtx) error {
+	rc.UpdateStore(sh.rh.Store)
+
+	switch rc.Request.Method {
+	case "GET":
+		return sh.handleGetSpamHolds(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: GET
+// PATH: host.com/api/mod/spam
+// pending holds oldest first with the content held, filtered by any of ?board={short} &status=
+func (sh *SpamHandler) handleGetSpamHolds(rc *types.RequestCtx) error {
+	params := rc.Query.UnhandledQueryParams
+	status := types.SpamHoldStatusPending
+
+	if v, ok := params["status"].(string); ok {
+		switch types.SpamHoldStatus(v) {
+		case types.SpamHoldStatusPending, types.SpamHoldStatusApproved, types.SpamHoldStatusRejected:
+			status = types.SpamHoldStatus(v)
+		default:
+			return ResolveResponseErr(rc, types.ErrorInvalid("status"))
+		}
+	}
+
+	filter := types.SpamHoldFilter(status)
+
+	if v, ok := params["board"].(string); ok {
+		board, err := rc.Store.FindBoardByShort(v)
+		if err != nil {
+			return ResolveResponseErr(rc, types.ErrorNotFound("board"))
+		}
+		filter = append(filter, bson.E{Key: "board_id", Value: board.ID})
+	}
+
+	holds := []*types.SpamHold{}
+	err := rc.Store.FindMulti(filter, &holds, "spam_holds")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	formatted := make([]bson.M, 0, len(holds))
+	for _, hold := range holds {
+		formatted = append(formatted, bson.M{
+			"_id":         hold.ID,
+			"target_type": hold.TargetType,
+			"location":    hold.Location,
+			"verdict":     hold.Verdict,
+			"status":      hold.Status,
+			"reviewed_at": hold.ReviewedAt,
+			"created_at":  hold.CreatedAt,
+			"content":     reportContent(rc, hold.TargetType, hold.TargetID, hold.Location, hold.BoardID),
+		})
+	}
+
+	rc.AddToResponseList("holds", formatted)
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/mod/spam/train
+/***********************************************************************************************/
+func (sh *SpamHandler) RegisterModSpamTrain(rc *types.RequestCtx) error {
+	rc.UpdateStore(sh.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return sh.handleTrainSpam(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/mod/spam/train
+// teaches the classifier about content it hasn't seen through holds, {"content": ..., "spam": true}
+func (sh *SpamHandler) handleTrainSpam(rc *types.RequestCtx) error {
+	body, err := io.ReadAll(rc.Request.Body)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	var parsed struct {
+		Content string `json:"content"`
+		Spam    *bool  `json:"spam"`
+	}
+
+	err = json.Unmarshal(body, &parsed)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
+	}
+
+	if strings.TrimSpace(parsed.Content) == "" {
+		return ResolveResponseErr(rc, types.ErrorInvalid("content"))
+	}
+	if parsed.Spam == nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("spam"))
+	}
+
+	err = types.TrainSpamClassifier(rc.Store, parsed.Content, *parsed.Spam)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	corpus, err := types.FindSpamCorpus(rc.Store)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	rc.AddToResponseList("corpus", corpus)
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/mod/spam/{hold_id}/approve
+/***********************************************************************************************/
+func (sh *SpamHandler) RegisterModSpamApprove(rc *types.RequestCtx) error {
+	rc.UpdateStore(sh.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return sh.handleDecideSpamHold(rc, true)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/mod/spam/{hold_id}/reject
+/***********************************************************************************************/
+func (sh *SpamHandler) RegisterModSpamReject(rc *types.RequestCtx) error {
+	rc.UpdateStore(sh.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return sh.handleDecideSpamHold(rc, false)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/mod/spam/{hold_id}/approve or /reject
+// approving puts the content up, rejecting leaves it down as deleted by a moderator
+func (sh *SpamHandler) handleDecideSpamHold(rc *types.RequestCtx, approve bool) error {
+	hold, apiErr := findSpamHold(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	if hold.Status != types.SpamHoldStatusPending {
+		return ResolveResponseErr(rc, types.ErrorConflict("hold was already "+string(hold.Status)))
+	}
+
+	submission, err := decideHeldContent(rc, hold, approve)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	before := *hold
+
+	ts := time.Now().UTC()
+	hold.Status = types.SpamHoldStatusRejected
+	if approve {
+		hold.Status = types.SpamHoldStatusApproved
+	}
+	hold.ReviewedBy = &rc.AccountCtx.Account.ID
+	hold.ReviewedAt = &ts
+
+	err = rc.Store.ReplaceSingle(hold.ID, hold, "spam_holds")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	// the classifier learns from every decision, it's a nicety so a failure doesn't undo one
+	if submission != nil {
+		if err := types.TrainSpamClassifier(rc.Store, submission.Text(), !approve); err != nil {
+			fmt.Println("Error training the spam classifier", err)
+		}
+	}
+
+	kind := types.ModActionSpamReject
+	if approve {
+		kind = types.ModActionSpamApprove
+	}
+
+	action := types.NewModAction(rc.AccountCtx.Account.ID, kind, hold.TargetType, hold.TargetID, "").Snapshot(before, hold)
+	if hold.BoardID != nil {
+		action.OnBoard(*hold.BoardID)
+	}
+	recordModAction(rc, action)
+
+	rc.AddToResponseList("status", hold.Status)
+
+	return ResolveResponse(rc)
+}
+
+// puts held content up or turns it into a moderator deletion
+// content someone has dealt with some other way since it was held is left alone
+// - returns the content as it was scored, nil if it's gone
+func decideHeldContent(rc *types.RequestCtx, hold *types.SpamHold, approve bool) (*types.SpamSubmission, error) {
+	filter := bson.D{{Key: "_id", Value: hold.TargetID}}
+
+	switch hold.TargetType {
+	case types.ModTargetThread:
+		thread := &types.Thread{}
+		if err := rc.Store.FindSingle(filter, thread, "threads"); err != nil {
+			return nil, nil
+		}
+
+		if thread.DeletedBy == types.DeletedBySpamFilter {
+			if approve {
+				thread.Restore()
+			} else {
+				thread.DeletedBy = types.DeletedByModerator
+			}
+			if err := rc.Store.UpdateThread(thread); err != nil {
+				return nil, err
+			}
+		}
+		return &types.SpamSubmission{Target: hold.TargetType, Title: thread.Title, Content: thread.Raw}, nil
+
+	case types.ModTargetPost:
+		post := &types.Post{}
+		if err := rc.Store.FindSingle(filter, post, "posts"); err != nil {
+			return nil, nil
+		}
+
+		if post.DeletedBy == types.DeletedBySpamFilter {
+			if approve {
+				post.Restore()
+			} else {
+				post.DeletedBy = types.DeletedByModerator
+			}
+			if err := rc.Store.ReplaceSingle(post.ID, post, "posts"); err != nil {
+				return nil, err
+			}
+		}
+		return &types.SpamSubmission{Target: hold.TargetType, Content: post.Raw}, nil
+
+	case types.ModTargetComment:
+		comment := &types.ArticleComment{}
+		if err := rc.Store.FindSingle(filter, comment, "article_comments"); err != nil {
+			return nil, nil
+		}
+
+		if comment.DeletedBy == types.DeletedBySpamFilter {
+			if approve {
+				comment.Restore()
+			} else {
+				comment.DeletedBy = types.DeletedByModerator
+			}
+			if err := rc.Store.ReplaceSingle(comment.ID, comment, "article_comments"); err != nil {
+				return nil, err
+			}
+		}
+		return &types.SpamSubmission{Target: hold.TargetType, Content: comment.Raw}, nil
+	}
+
+	return nil, nil
+}
@@ -2,12 +2,14 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"time"
 
 	"github.com/dd-web/opforu-server/internal/builder"
 	"github.com/dd-web/opforu-server/internal/types"
 	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 type ThreadHandler struct {
@@ -31,6 +33,10 @@ func (th *ThreadHandler) RegisterThreadRoot(rc *types.RequestCtx) error {
 		return th.handleThreadRoot(rc)
 	case "POST":
 		return th.handleThreadReply(rc)
+	case "PATCH":
+		return th.handleEditThread(rc)
+	case "DELETE":
+		return th.handleDeleteThread(rc)
 	default:
 		return HandleUnsupportedMethod(rc.Writer, rc.Request)
 	}
@@ -41,13 +47,29 @@ func (th *ThreadHandler) RegisterThreadRoot(rc *types.RequestCtx) error {
 func (th *ThreadHandler) handleThreadRoot(rc *types.RequestCtx) error {
 	vars := mux.Vars(rc.Request)
 
-	pipeline := builder.QrStrEntireThread(vars["slug"], rc.Query)
+	thread, err := rc.Store.FindThreadBySlug(vars["slug"])
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorNotFound("thread"))
+	}
+
+	// staff see deleted threads and posts so they can be looked over or restored
+	staff := rc.CanOnBoard(types.PermContentDeleted, thread.Board)
+
+	if thread.IsDeleted() && !staff {
+		return ResolveResponseErr(rc, types.ErrorGone("thread"))
+	}
+
+	pipeline := builder.QrStrEntireThread(vars["slug"], rc.Query, staff, rc.ViewerID())
 
 	result, err := th.rh.Store.RunAggregation("threads", pipeline)
 	if err != nil {
 		return err
 	}
 
+	if len(result) == 0 {
+		return ResolveResponseErr(rc, types.ErrorNotFound("thread"))
+	}
+
 	rc.AddToResponseList("thread", result[0])
 	return ResolveResponse(rc)
 }
@@ -55,10 +77,6 @@ func (th *ThreadHandler) handleThreadRoot(rc *types.RequestCtx) error {
 // METHOD: POST
 // PATH: host.com/api/threads/{slug}
 func (th *ThreadHandler) handleThreadReply(rc *types.RequestCtx) error {
-	if rc.UnresolvedAccount {
-		return ResolveResponseErr(rc, types.ErrorUnauthorized())
-	}
-
 	// invoke necessary data and dependencies
 	vars := mux.Vars(rc.Request)
 
@@ -67,7 +85,11 @@ func (th *ThreadHandler) handleThreadReply(rc *types.RequestCtx) error {
 
 	thread, err := th.rh.Store.FindThreadBySlug(vars["slug"])
 	if err != nil {
-		return ResolveResponseErr(rc, types.ErrorUnexpected())
+		return ResolveResponseErr(rc, types.ErrorNotFound("thread"))
+	}
+
+	if apiErr := thread.WriteError(); apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
 	}
 
 	board, err := th.rh.Store.FindBoardByObjectID(thread.Board)
@@ -90,6 +112,15 @@ func (th *ThreadHandler) handleThreadReply(rc *types.RequestCtx) error {
 		return ResolveResponseErr(rc, types.ErrorUnexpected())
 	}
 
+	if identity.Status == types.IdentityStatusBanned {
+		return ResolveResponseErr(rc, types.ErrorForbidden("banned from this thread"))
+	}
+
+	verdict, apiErr := checkSpam(rc, th.rh.Spam, types.ModTargetPost, "", details.Content)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
 	// dependency injection
 	newPostAssets := []*types.Asset{}
 	newPostAssetInterfaces := []interface{}{}
@@ -115,16 +146,17 @@ func (th *ThreadHandler) handleThreadReply(rc *types.RequestCtx) error {
 
 	post := types.NewPost()
 
-	post.PostNumber = board.PostRef + 1
+	post.PostNumber, err = rc.Store.NextPostNumber(board.ID)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
 	post.Creator = identity.ID
 	post.Body = str
+	post.Raw = details.Content
+	post.IPHash = rc.IPHash()
 	post.Board = board.ID
 	post.Thread = thread.ID
-	thread.Posts = append(thread.Posts, post.ID)
-	board.PostRef++
-
-	thread.UpdatedAt = &ts
-	board.UpdatedAt = &ts
 
 	if len(details.Assets) > 0 {
 		for _, v := range newPostAssets {
@@ -137,21 +169,57 @@ func (th *ThreadHandler) handleThreadReply(rc *types.RequestCtx) error {
 		}
 	}
 
+	if verdict.Action == types.SpamActionHold {
+		post.MarkDeleted(types.DeletedBySpamFilter)
+	}
+
 	err = rc.Store.SaveNewSingle(post, "posts")
 	if err != nil {
 		return ResolveResponseErr(rc, types.ErrorUnexpected())
 	}
 
-	err = rc.Store.UpdateThread(thread)
-	if err != nil {
-		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	// appended in place rather than saving the thread loaded above, which would undo anything done
+	// to it since. it only matches while the thread is open so replies can't land after it's closed
+	appended, err := rc.Store.UpdateMulti(types.ThreadWritableFilter(thread.ID), bson.D{
+		{Key: "$push", Value: bson.D{{Key: "posts", Value: post.ID}}},
+		{Key: "$set", Value: bson.D{{Key: "updated_at", Value: ts}}},
+	}, "threads")
+	if err != nil || appended == 0 {
+		return th.rejectReply(rc, post, vars["slug"], err)
 	}
 
-	err = rc.Store.UpdateBoard(board)
-	if err != nil {
-		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	if verdict.Action == types.SpamActionHold {
+		err = holdForReview(rc, verdict, types.ModTargetPost, post.ID, &board.ID, types.ReportLocation{ThreadID: &thread.ID, Thread: thread.Slug, PostNumber: int(post.PostNumber)})
+		if err != nil {
+			return ResolveResponseErr(rc, types.ErrorUnexpected())
+		}
 	}
 
 	rc.AddToResponseList("post_number", post.PostNumber)
 	return ResolveResponse(rc)
 }
+
+// undoes a reply that couldn't be added to its thread
+// - returns the thread's write error when it stopped accepting replies after it was loaded
+func (th *ThreadHandler) rejectReply(rc *types.RequestCtx, post *types.Post, slug string, cause error) error {
+	if len(post.Assets) > 0 {
+		if _, err := rc.Store.DeleteMulti(bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: post.Assets}}}}, "assets"); err != nil {
+			fmt.Println("Error removing assets of rejected reply", err)
+		}
+	}
+	if err := rc.Store.DeleteSingle(post.ID, "posts"); err != nil {
+		fmt.Println("Error removing rejected reply", err)
+	}
+
+	if cause == nil {
+		thread, err := rc.Store.FindThreadBySlug(slug)
+		if err != nil {
+			return ResolveResponseErr(rc, types.ErrorNotFound("thread"))
+		}
+		if apiErr := thread.WriteError(); apiErr != nil {
+			return ResolveResponseErr(rc, *apiErr)
+		}
+	}
+
+	return ResolveResponseErr(rc, types.ErrorUnexpected())
+}
@@ -0,0 +1,422 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/dd-web/opforu-server/internal/types"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// thread mods only ever deal in identities, the accounts behind them are never looked up or sent
+
+// the body of a thread mod request, every field is optional to some route
+type threadModRequest struct {
+	Status   types.ThreadStatus `json:"status"`
+	Identity string             `json:"identity"` // identity name
+	Reason   string             `json:"reason"`
+}
+
+func readThreadModRequest(rc *types.RequestCtx) (threadModRequest, *types.APIError) {
+	var parsed threadModRequest
+
+	body, err := io.ReadAll(rc.Request.Body)
+	if err != nil {
+		apiErr := types.ErrorUnexpected()
+		return parsed, &apiErr
+	}
+
+	if len(body) > 0 {
+		if err = json.Unmarshal(body, &parsed); err != nil {
+			apiErr := types.ErrorInvalid("request body")
+			return parsed, &apiErr
+		}
+	}
+
+	return parsed, nil
+}
+
+// the thread named by the {slug} route variable and the requesting account's identity in it
+// - the identity must be one of the thread's mods
+// - archived and deleted threads are out of a thread mod's hands
+func resolveThreadMod(rc *types.RequestCtx) (*types.Thread, *types.Identity, *types.APIError) {
+	thread, err := rc.Store.FindThreadBySlug(mux.Vars(rc.Request)["slug"])
+	if err != nil {
+		apiErr := types.ErrorNotFound("thread")
+		return nil, nil, &apiErr
+	}
+
+	// mods keep their powers over closed threads so they can reopen them
+	if thread.Status != types.ThreadStatusClosed {
+		if apiErr := thread.WriteError(); apiErr != nil {
+			return nil, nil, apiErr
+		}
+	}
+
+	identity := &types.Identity{}
+	err = rc.Store.FindSingle(types.FindFilterIdentityInThread(thread.ID, rc.AccountCtx.Account.ID), identity, "identities")
+	if err != nil && err != mongo.ErrNoDocuments {
+		apiErr := types.ErrorUnexpected()
+		return nil, nil, &apiErr
+	}
+
+	if err == mongo.ErrNoDocuments || !thread.IsMod(identity.ID) {
+		apiErr := types.ErrorForbidden("not a mod of this thread")
+		return nil, nil, &apiErr
+	}
+
+	return thread, identity, nil
+}
+
+// an identity in the thread by it's name
+func findThreadIdentity(rc *types.RequestCtx, thread *types.Thread, name string) (*types.Identity, *types.APIError) {
+	identity := &types.Identity{}
+	err := rc.Store.FindSingle(types.FindFilterIdentityByName(thread.ID, name), identity, "identities")
+	if err == mongo.ErrNoDocuments || name == "" {
+		apiErr := types.ErrorNotFound("identity")
+		return nil, &apiErr
+	}
+	if err != nil {
+		apiErr := types.ErrorUnexpected()
+		return nil, &apiErr
+	}
+	return identity, nil
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/threads/{slug}/status
+/***********************************************************************************************/
+func (th *ThreadHandler) RegisterThreadStatus(rc *types.RequestCtx) error {
+	rc.UpdateStore(th.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return th.handleSetThreadStatus(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/threads/{slug}/status
+// closes or reopens the thread
+func (th *ThreadHandler) handleSetThreadStatus(rc *types.RequestCtx) error {
+	thread, _, apiErr := resolveThreadMod(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	parsed, apiErr := readThreadModRequest(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	action := types.ModActionThreadClose
+	switch parsed.Status {
+	case types.ThreadStatusClosed:
+	case types.ThreadStatusOpen:
+		action = types.ModActionThreadReopen
+	default:
+		return ResolveResponseErr(rc, types.ErrorInvalid("status"))
+	}
+
+	if thread.Status == parsed.Status {
+		return ResolveResponseErr(rc, types.ErrorConflict("thread is already "+string(thread.Status)))
+	}
+
+	before := thread.Status
+	ts := time.Now().UTC()
+	thread.Status = parsed.Status
+	thread.UpdatedAt = &ts
+
+	err := rc.Store.UpdateThread(thread)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	recordModAction(rc, types.NewModAction(rc.AccountCtx.Account.ID, action, types.ModTargetThread, thread.ID, parsed.Reason).
+		OnBoard(thread.Board).
+		Snapshot(bson.M{"status": before}, bson.M{"status": thread.Status}))
+
+	rc.AddToResponseList("status", thread.Status)
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/threads/{slug}/mods
+/***********************************************************************************************/
+func (th *ThreadHandler) RegisterThreadMods(rc *types.RequestCtx) error {
+	rc.UpdateStore(th.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return th.handleAppointThreadMod(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/threads/{slug}/mods
+// makes another identity in the thread a mod of it
+func (th *ThreadHandler) handleAppointThreadMod(rc *types.RequestCtx) error {
+	thread, _, apiErr := resolveThreadMod(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	parsed, apiErr := readThreadModRequest(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	identity, apiErr := findThreadIdentity(rc, thread, parsed.Identity)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	if thread.IsMod(identity.ID) {
+		return ResolveResponseErr(rc, types.ErrorConflict("identity is already a mod"))
+	}
+
+	if identity.Status == types.IdentityStatusBanned {
+		return ResolveResponseErr(rc, types.ErrorConflict("identity is banned from the thread"))
+	}
+
+	before := types.ModSnapshot(identity)
+	ts := time.Now().UTC()
+	identity.Role = types.ThreadRoleMod
+	identity.UpdatedAt = &ts
+
+	err := rc.Store.ReplaceSingle(identity.ID, identity, "identities")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	thread.Mods = append(thread.Mods, identity.ID)
+	thread.UpdatedAt = &ts
+
+	err = rc.Store.UpdateThread(thread)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	recordModAction(rc, types.NewModAction(rc.AccountCtx.Account.ID, types.ModActionThreadModAdd, types.ModTargetIdentity, identity.ID, parsed.Reason).
+		OnBoard(thread.Board).
+		Snapshot(before, identity))
+
+	rc.AddToResponseListCLF("mod", identity)
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/threads/{slug}/mods/{name}
+/***********************************************************************************************/
+func (th *ThreadHandler) RegisterThreadModName(rc *types.RequestCtx) error {
+	rc.UpdateStore(th.rh.Store)
+
+	switch rc.Request.Method {
+	case "DELETE":
+		return th.handleDismissThreadMod(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: DELETE
+// PATH: host.com/api/threads/{slug}/mods/{name}
+// only the thread's creator dismisses mods, and can't be dismissed
+func (th *ThreadHandler) handleDismissThreadMod(rc *types.RequestCtx) error {
+	thread, caller, apiErr := resolveThreadMod(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	if caller.ID != thread.Creator {
+		return ResolveResponseErr(rc, types.ErrorForbidden("only the thread creator can dismiss mods"))
+	}
+
+	parsed, apiErr := readThreadModRequest(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	identity, apiErr := findThreadIdentity(rc, thread, mux.Vars(rc.Request)["name"])
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	if identity.ID == thread.Creator {
+		return ResolveResponseErr(rc, types.ErrorConflict("the thread creator can't be dismissed"))
+	}
+
+	if !thread.IsMod(identity.ID) {
+		return ResolveResponseErr(rc, types.ErrorNotFound("mod"))
+	}
+
+	before := types.ModSnapshot(identity)
+	ts := time.Now().UTC()
+	identity.Role = types.ThreadRoleUser
+	identity.UpdatedAt = &ts
+
+	err := rc.Store.ReplaceSingle(identity.ID, identity, "identities")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	mods := make([]primitive.ObjectID, 0, len(thread.Mods))
+	for _, v := range thread.Mods {
+		if v != identity.ID {
+			mods = append(mods, v)
+		}
+	}
+	thread.Mods = mods
+	thread.UpdatedAt = &ts
+
+	err = rc.Store.UpdateThread(thread)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	recordModAction(rc, types.NewModAction(rc.AccountCtx.Account.ID, types.ModActionThreadModRemove, types.ModTargetIdentity, identity.ID, parsed.Reason).
+		OnBoard(thread.Board).
+		Snapshot(before, identity))
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/threads/{slug}/bans
+/***********************************************************************************************/
+func (th *ThreadHandler) RegisterThreadBans(rc *types.RequestCtx) error {
+	rc.UpdateStore(th.rh.Store)
+
+	switch rc.Request.Method {
+	case "GET":
+		return th.handleGetThreadBans(rc)
+	case "POST":
+		return th.handleBanThreadIdentity(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: GET
+// PATH: host.com/api/threads/{slug}/bans
+func (th *ThreadHandler) handleGetThreadBans(rc *types.RequestCtx) error {
+	thread, _, apiErr := resolveThreadMod(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	banned := []*types.Identity{}
+	err := rc.Store.FindMulti(bson.D{{Key: "thread", Value: thread.ID}, {Key: "status", Value: types.IdentityStatusBanned}}, &banned, "identities")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	formatted := make([]bson.M, 0, len(banned))
+	for _, v := range banned {
+		formatted = append(formatted, v.CLFormat())
+	}
+
+	rc.AddToResponseList("banned", formatted)
+
+	return ResolveResponse(rc)
+}
+
+// METHOD: POST
+// PATH: host.com/api/threads/{slug}/bans
+// stops the identity replying to the thread, mods can't be banned
+func (th *ThreadHandler) handleBanThreadIdentity(rc *types.RequestCtx) error {
+	thread, _, apiErr := resolveThreadMod(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	parsed, apiErr := readThreadModRequest(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	identity, apiErr := findThreadIdentity(rc, thread, parsed.Identity)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	if thread.IsMod(identity.ID) {
+		return ResolveResponseErr(rc, types.ErrorConflict("mods can't be banned"))
+	}
+
+	if identity.Status == types.IdentityStatusBanned {
+		return ResolveResponseErr(rc, types.ErrorConflict("identity is already banned"))
+	}
+
+	return th.setThreadIdentityStatus(rc, thread, identity, types.IdentityStatusBanned, types.ModActionIdentityBan, parsed.Reason)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/threads/{slug}/bans/{name}
+/***********************************************************************************************/
+func (th *ThreadHandler) RegisterThreadBanName(rc *types.RequestCtx) error {
+	rc.UpdateStore(th.rh.Store)
+
+	switch rc.Request.Method {
+	case "DELETE":
+		return th.handleUnbanThreadIdentity(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: DELETE
+// PATH: host.com/api/threads/{slug}/bans/{name}
+func (th *ThreadHandler) handleUnbanThreadIdentity(rc *types.RequestCtx) error {
+	thread, _, apiErr := resolveThreadMod(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	parsed, apiErr := readThreadModRequest(rc)
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	identity, apiErr := findThreadIdentity(rc, thread, mux.Vars(rc.Request)["name"])
+	if apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	if identity.Status != types.IdentityStatusBanned {
+		return ResolveResponseErr(rc, types.ErrorNotFound("ban"))
+	}
+
+	return th.setThreadIdentityStatus(rc, thread, identity, types.IdentityStatusActive, types.ModActionIdentityUnban, parsed.Reason)
+}
+
+// saves the identity's new status and records it in the moderation log
+func (th *ThreadHandler) setThreadIdentityStatus(rc *types.RequestCtx, thread *types.Thread, identity *types.Identity, status types.IdentityStatus, action types.ModActionKind, reason string) error {
+	before := types.ModSnapshot(identity)
+	ts := time.Now().UTC()
+	identity.Status = status
+	identity.UpdatedAt = &ts
+
+	err := rc.Store.ReplaceSingle(identity.ID, identity, "identities")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	recordModAction(rc, types.NewModAction(rc.AccountCtx.Account.ID, action, types.ModTargetIdentity, identity.ID, reason).
+		OnBoard(thread.Board).
+		Snapshot(before, identity))
+
+	rc.AddToResponseListCLF("identity", identity)
+
+	return ResolveResponse(rc)
+}
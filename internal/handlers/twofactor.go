@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/dd-web/opforu-server/internal/types"
+	"github.com/dd-web/opforu-server/internal/utils"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// request body shared by the two-factor endpoints
+type rumTwoFactorCode struct {
+	Challenge string `json:"challenge,omitempty"`
+	Code      string `json:"code"`
+}
+
+func parseTwoFactorCode(rc *types.RequestCtx) (*rumTwoFactorCode, error) {
+	body, err := io.ReadAll(rc.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := &rumTwoFactorCode{}
+	err = json.Unmarshal(body, parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsed, nil
+}
+
+// checks a code from a signed in account with verify, wrong codes count against the same limits
+// as wrong passwords so a stolen session can't keep guessing until it gets one right
+func (ah *AccountHandler) verifySessionTwoFactorCode(rc *types.RequestCtx, account *types.Account, verify func(string) bool, code string) *types.APIError {
+	keys := []string{types.LoginAttemptsIPKey(rc.RemoteIP()), types.LoginAttemptsAccountKey(account)}
+
+	retryAfter, err := ah.findLoginAttempts(rc, keys)
+	if err != nil {
+		apiErr := types.ErrorUnexpected()
+		return &apiErr
+	}
+
+	if retryAfter > 0 {
+		apiErr := types.ErrorTooManyRequests(retryAfter)
+		return &apiErr
+	}
+
+	if !verify(code) {
+		apiErr := types.ErrorInvalid("code")
+		apiErr.RetryAfter = ah.recordLoginFailures(rc, keys)
+		return &apiErr
+	}
+
+	return nil
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/login/2fa
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountLoginTwoFactor(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return ah.handlePostLoginTwoFactor(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/account/login/2fa
+// completes a login that passed the password check with a TOTP or recovery code
+func (ah *AccountHandler) handlePostLoginTwoFactor(rc *types.RequestCtx) error {
+	parsed, err := parseTwoFactorCode(rc)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
+	}
+
+	challenge := &types.LoginChallenge{}
+	err = rc.Store.FindSingle(bson.D{{Key: "token_hash", Value: utils.HashToken(parsed.Challenge)}}, challenge, "login_challenges")
+	if err != nil || challenge.IsExpired() {
+		return ResolveResponseErr(rc, types.ErrorInvalid("login challenge"))
+	}
+
+	account, err := rc.Store.FindAccountByID(challenge.AccountID)
+	if err != nil || !account.HasTwoFactor() {
+		return ResolveResponseErr(rc, types.ErrorInvalid("login challenge"))
+	}
+
+	// only sign ins that restore the account get a challenge for a deleted one, anything else was
+	// deleted since the challenge was made
+	if account.IsDeleted() && !challenge.Restore {
+		return ResolveResponseErr(rc, types.ErrorForbidden("account is pending deletion, restore it to log in"))
+	}
+
+	// wrong codes count against the same limits as wrong passwords
+	keys := []string{types.LoginAttemptsIPKey(rc.RemoteIP()), types.LoginAttemptsAccountKey(account)}
+
+	retryAfter, err := ah.findLoginAttempts(rc, keys)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	if retryAfter > 0 {
+		return ResolveResponseErr(rc, types.ErrorTooManyRequests(retryAfter))
+	}
+
+	if !account.TwoFactor.Verify(parsed.Code) {
+		apiErr := types.ErrorUnauthorized()
+		apiErr.RetryAfter = ah.recordLoginFailures(rc, keys)
+		return ResolveResponseErr(rc, apiErr)
+	}
+
+	if account.IsDeleted() {
+		account.Restore()
+		rc.AddToResponseList("restored", true)
+	}
+
+	// saves the used time step or recovery code, and the restore
+	err = rc.Store.UpdateAccount(account)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	err = rc.Store.DeleteSingle(challenge.ID, "login_challenges")
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	err = rc.Store.ClearLoginAttempts(keys...)
+	if err != nil {
+		fmt.Println("Error clearing login attempts", err)
+	}
+
+	return ah.resolveNewSession(rc, account)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/2fa/enroll
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountTwoFactorEnroll(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return ah.handlePostTwoFactorEnroll(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/account/2fa/enroll
+// generates a new secret, two-factor isn't enabled until it's confirmed with a code
+func (ah *AccountHandler) handlePostTwoFactorEnroll(rc *types.RequestCtx) error {
+	if rc.UnresolvedAccount {
+		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+	}
+
+	if !rc.AccountCtx.IsSessionAuth() {
+		return ResolveResponseErr(rc, types.ErrorSessionRequired())
+	}
+
+	account := rc.AccountCtx.Account
+
+	if account.HasTwoFactor() {
+		return ResolveResponseErr(rc, types.ErrorConflict("two-factor authentication is already enabled"))
+	}
+
+	tf, err := types.NewTwoFactor()
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	account.TwoFactor = tf
+
+	err = rc.Store.UpdateAccount(account)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	rc.AddToResponseList("secret", tf.Secret)
+	rc.AddToResponseList("otpauth_uri", tf.URI(account))
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/2fa/confirm
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountTwoFactorConfirm(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return ah.handlePostTwoFactorConfirm(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/account/2fa/confirm
+// enables two-factor once a code from the new secret is given, responds with the recovery codes
+func (ah *AccountHandler) handlePostTwoFactorConfirm(rc *types.RequestCtx) error {
+	if rc.UnresolvedAccount {
+		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+	}
+
+	if !rc.AccountCtx.IsSessionAuth() {
+		return ResolveResponseErr(rc, types.ErrorSessionRequired())
+	}
+
+	account := rc.AccountCtx.Account
+
+	if account.TwoFactor == nil || account.HasTwoFactor() {
+		return ResolveResponseErr(rc, types.ErrorConflict("no two-factor enrollment is pending"))
+	}
+
+	parsed, err := parseTwoFactorCode(rc)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
+	}
+
+	if apiErr := ah.verifySessionTwoFactorCode(rc, account, account.TwoFactor.VerifyCode, parsed.Code); apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	codes, err := account.TwoFactor.Enable()
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	err = rc.Store.UpdateAccount(account)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	rc.AddToResponseList("recovery_codes", codes)
+
+	return ResolveResponse(rc)
+}
+
+/***********************************************************************************************/
+/* ROOT path: host.com/api/account/2fa/disable
+/***********************************************************************************************/
+func (ah *AccountHandler) RegisterAccountTwoFactorDisable(rc *types.RequestCtx) error {
+	rc.UpdateStore(ah.rh.Store)
+
+	switch rc.Request.Method {
+	case "POST":
+		return ah.handlePostTwoFactorDisable(rc)
+	default:
+		return ResolveResponseErr(rc, types.ErrorUnsupported())
+	}
+}
+
+// METHOD: POST
+// PATH: host.com/api/account/2fa/disable
+// turns two-factor off, requires a current code so a stolen session alone can't do it
+func (ah *AccountHandler) handlePostTwoFactorDisable(rc *types.RequestCtx) error {
+	if rc.UnresolvedAccount {
+		return ResolveResponseErr(rc, types.ErrorUnauthorized())
+	}
+
+	if !rc.AccountCtx.IsSessionAuth() {
+		return ResolveResponseErr(rc, types.ErrorSessionRequired())
+	}
+
+	account := rc.AccountCtx.Account
+
+	if !account.HasTwoFactor() {
+		return ResolveResponseErr(rc, types.ErrorConflict("two-factor authentication is not enabled"))
+	}
+
+	parsed, err := parseTwoFactorCode(rc)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorInvalid("request body"))
+	}
+
+	if apiErr := ah.verifySessionTwoFactorCode(rc, account, account.TwoFactor.Verify, parsed.Code); apiErr != nil {
+		return ResolveResponseErr(rc, *apiErr)
+	}
+
+	account.TwoFactor = nil
+
+	err = rc.Store.UpdateAccount(account)
+	if err != nil {
+		return ResolveResponseErr(rc, types.ErrorUnexpected())
+	}
+
+	return ResolveResponse(rc)
+}
@@ -7,15 +7,34 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+var (
+	// how long an email verification link stays valid
+	VERIFICATION_TOKEN_HOURS = 48
+
+	// signed token purposes
+	TOKEN_PURPOSE_VERIFY_EMAIL = "verify-email"
+)
+
 type Account struct {
-	ID       primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
-	Username string             `bson:"username,omitempty" json:"username"`
-	Email    string             `bson:"email,omitempty" json:"email"`
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	Username    string             `bson:"username,omitempty" json:"username"`
+	UsernameKey string             `bson:"username_key,omitempty" json:"-"` // lowercased username, keeps usernames unique regardless of casing
+	Email       string             `bson:"email,omitempty" json:"email"`
 
 	Role   AccountRole   `bson:"role" json:"role"`
 	Status AccountStatus `bson:"status" json:"status"`
 
-	Password string `json:"password_hash" bson:"password_hash"`
+	// when the current email address was verified, nil until it is and cleared when it changes
+	EmailVerifiedAt *time.Time `bson:"email_verified_at,omitempty" json:"email_verified_at,omitempty"`
+
+	// the status to go back to if a deleted account is restored during it's grace period
+	RestoreStatus AccountStatus `bson:"restore_status,omitempty" json:"-"`
+
+	// content from the account is only shown to it and staff, it's never told
+	ShadowBanned bool `bson:"shadow_banned,omitempty" json:"-"`
+
+	Password  string     `json:"password_hash" bson:"password_hash"`
+	TwoFactor *TwoFactor `json:"-" bson:"two_factor,omitempty"`
 
 	CreatedAt *time.Time `bson:"created_at" json:"created_at"`
 	UpdatedAt *time.Time `bson:"updated_at" json:"updated_at"`
@@ -43,6 +62,7 @@ const (
 )
 
 // Creates a new Account object with some values set by default
+// accounts start unverified until their email address is confirmed
 func NewAccount() *Account {
 	ts := time.Now().UTC()
 	return &Account{
@@ -61,6 +81,8 @@ func (a *Account) CLFormat() bson.M {
 		"email":      a.Email,
 		"role":       a.Role,
 		"status":     a.Status,
+		"verified":   a.IsVerified(),
+		"two_factor": a.HasTwoFactor(),
 		"created_at": a.CreatedAt,
 		"updated_at": a.UpdatedAt,
 	}
@@ -77,3 +99,26 @@ func (a *Account) IsMod() bool {
 func (a *Account) IsStaff() bool {
 	return a.IsAdmin() || a.IsMod()
 }
+
+// sets the username and it's lookup key together so they can't drift apart
+func (a *Account) SetUsername(username string) {
+	a.Username = username
+	a.UsernameKey = NormalizeUsername(username)
+}
+
+// has the account verified it's email address
+func (a *Account) IsVerified() bool {
+	return a.EmailVerifiedAt != nil
+}
+
+// marks the current email address verified
+func (a *Account) MarkVerified() {
+	ts := time.Now().UTC()
+	a.EmailVerifiedAt = &ts
+	a.UpdatedAt = &ts
+}
+
+// the subject of an email verification token, includes the email so changing it invalidates old links
+func (a *Account) VerificationSubject() string {
+	return a.ID.Hex() + ":" + a.Email
+}
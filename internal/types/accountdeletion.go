@@ -0,0 +1,214 @@
+package types
+
+import (
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	// how long a deleted account can still be restored before it's purged
+	ACCOUNT_DELETION_GRACE_DAYS = 30
+	// how often accounts past their grace period are looked for
+	ACCOUNT_SWEEP_INTERVAL_MINUTES = 60
+
+	// the account content of purged accounts is handed to, not a valid username so it can't be registered
+	TOMBSTONE_USERNAME = "[deleted]"
+)
+
+// has the account been deleted, it may still be within it's grace period
+func (a *Account) IsDeleted() bool {
+	return a.Status == AccountStatusDeleted
+}
+
+// when a deleted account is purged, nil if it isn't deleted
+func (a *Account) PurgeAt() *time.Time {
+	if !a.IsDeleted() || a.DeletedAt == nil {
+		return nil
+	}
+
+	ts := a.DeletedAt.Add(time.Duration(ACCOUNT_DELETION_GRACE_DAYS) * 24 * time.Hour)
+	return &ts
+}
+
+// marks the account deleted, the status it had is kept so a restore can put it back
+func (a *Account) MarkDeleted() {
+	ts := time.Now().UTC()
+	a.RestoreStatus = a.Status
+	a.Status = AccountStatusDeleted
+	a.DeletedAt = &ts
+	a.UpdatedAt = &ts
+}
+
+// undoes MarkDeleted
+func (a *Account) Restore() {
+	ts := time.Now().UTC()
+	a.Status = a.RestoreStatus
+	if a.Status == "" {
+		a.Status = AccountStatusActive
+	}
+	a.RestoreStatus = ""
+	a.DeletedAt = nil
+	a.UpdatedAt = &ts
+}
+
+// finds the tombstone account, creating it the first time it's needed
+func ResolveTombstoneAccount(repo Repository) (*Account, error) {
+	tombstone := &Account{}
+
+	err := repo.FindSingle(bson.D{{Key: "username_key", Value: TOMBSTONE_USERNAME}}, tombstone, "accounts")
+	if err == nil {
+		return tombstone, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	tombstone = NewAccount()
+	tombstone.SetUsername(TOMBSTONE_USERNAME)
+	tombstone.Status = AccountStatusDeleted
+
+	err = repo.SaveNewSingle(tombstone, "accounts")
+	if err != nil {
+		return nil, err
+	}
+
+	return tombstone, nil
+}
+
+// Purge Account
+// detaches the account from everything it made then removes it and everything only it used
+// - identities lose their account so posts stay up anonymously
+// - article comments, assets, reports, spam holds and edits are handed to the tombstone account
+// - the account is dropped from the uploaders of asset sources
+func PurgeAccount(repo Repository, account *Account) error {
+	tombstone, err := ResolveTombstoneAccount(repo)
+	if err != nil {
+		return err
+	}
+
+	owned := bson.D{{Key: "account", Value: account.ID}}
+	_, err = repo.UpdateMulti(owned, bson.D{{Key: "$unset", Value: bson.D{{Key: "account", Value: ""}}}}, "identities")
+	if err != nil {
+		return err
+	}
+
+	authored := bson.D{{Key: "author", Value: account.ID}}
+	_, err = repo.UpdateMulti(authored, bson.D{{Key: "$set", Value: bson.D{{Key: "author", Value: tombstone.ID}}}}, "article_comments")
+	if err != nil {
+		return err
+	}
+
+	uploaded := bson.D{{Key: "account_id", Value: account.ID}}
+	_, err = repo.UpdateMulti(uploaded, bson.D{{Key: "$set", Value: bson.D{{Key: "account_id", Value: tombstone.ID}}}}, "assets")
+	if err != nil {
+		return err
+	}
+
+	reported := bson.D{{Key: "reporter_id", Value: account.ID}}
+	_, err = repo.UpdateMulti(reported, bson.D{{Key: "$set", Value: bson.D{{Key: "reporter_id", Value: tombstone.ID}}}}, "reports")
+	if err != nil {
+		return err
+	}
+
+	held := bson.D{{Key: "account_id", Value: account.ID}}
+	_, err = repo.UpdateMulti(held, bson.D{{Key: "$set", Value: bson.D{{Key: "account_id", Value: tombstone.ID}}}}, "spam_holds")
+	if err != nil {
+		return err
+	}
+
+	edited := bson.D{{Key: "edited_by", Value: account.ID}}
+	_, err = repo.UpdateMulti(edited, bson.D{{Key: "$set", Value: bson.D{{Key: "edited_by", Value: tombstone.ID}}}}, "post_revisions")
+	if err != nil {
+		return err
+	}
+
+	uploaders := bson.D{{Key: "uploaders", Value: account.ID}}
+	_, err = repo.UpdateMulti(uploaders, bson.D{{Key: "$pull", Value: bson.D{{Key: "uploaders", Value: account.ID}}}}, "asset_sources")
+	if err != nil {
+		return err
+	}
+
+	err = repo.DeleteAccountSessions(account.ID)
+	if err != nil {
+		return err
+	}
+
+	err = DeleteDataExports(repo, bson.D{{Key: "account_id", Value: account.ID}})
+	if err != nil {
+		return err
+	}
+
+	for _, col := range []string{"api_tokens", "account_history", "password_resets", "login_challenges", "board_roles", "account_sanctions", "external_logins"} {
+		_, err = repo.DeleteMulti(bson.D{{Key: "account_id", Value: account.ID}}, col)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = repo.DeleteMulti(bson.D{{Key: "key", Value: LoginAttemptsAccountKey(account)}}, "login_attempts")
+	if err != nil {
+		return err
+	}
+
+	return repo.DeleteSingle(account.ID, "accounts")
+}
+
+// Sweep Deleted Accounts
+// purges every account whose grace period has ended
+// - returns the number of accounts purged
+func SweepDeletedAccounts(repo Repository) (int, error) {
+	cutoff := time.Now().UTC().Add(-time.Duration(ACCOUNT_DELETION_GRACE_DAYS) * 24 * time.Hour)
+
+	accounts := []*Account{}
+	err := repo.FindMulti(bson.D{
+		{Key: "status", Value: AccountStatusDeleted},
+		{Key: "deleted_at", Value: bson.D{{Key: "$lte", Value: cutoff}}},
+	}, &accounts, "accounts")
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, account := range accounts {
+		// the tombstone is never purged, content has been handed to it
+		if account.UsernameKey == TOMBSTONE_USERNAME {
+			continue
+		}
+
+		if err := PurgeAccount(repo, account); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// runs SweepDeletedAccounts every ACCOUNT_SWEEP_INTERVAL_MINUTES until stop is called
+func StartAccountSweeper(repo Repository) (stop func()) {
+	ticker := time.NewTicker(time.Duration(ACCOUNT_SWEEP_INTERVAL_MINUTES) * time.Minute)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				purged, err := SweepDeletedAccounts(repo)
+				if err != nil {
+					fmt.Println("Error sweeping deleted accounts", err)
+				}
+				if purged > 0 {
+					fmt.Printf("Purged %d deleted accounts\n", purged)
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -0,0 +1,67 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// how many history entries are returned to the account
+	ACCOUNT_HISTORY_LIMIT = 50
+)
+
+// the part of an account that was changed
+type AccountChange string
+
+const (
+	AccountChangeUsername AccountChange = "username"
+	AccountChangeEmail    AccountChange = "email"
+	AccountChangePassword AccountChange = "password"
+)
+
+// a record of a change made to an account's profile or credentials
+// password changes never record the values
+type AccountHistory struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	AccountID primitive.ObjectID `bson:"account_id" json:"account_id"`
+	Change    AccountChange      `bson:"change" json:"change"`
+
+	OldValue string `bson:"old_value,omitempty" json:"old_value,omitempty"`
+	NewValue string `bson:"new_value,omitempty" json:"new_value,omitempty"`
+
+	// the client that made the change
+	IP        string `bson:"ip,omitempty" json:"ip,omitempty"`
+	UserAgent string `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+
+	CreatedAt *time.Time `bson:"created_at" json:"created_at"`
+}
+
+// creates a history entry for a change made by the request
+func NewAccountHistory(rc *RequestCtx, change AccountChange, oldValue, newValue string) *AccountHistory {
+	ts := time.Now().UTC()
+	return &AccountHistory{
+		ID:        primitive.NewObjectID(),
+		AccountID: rc.AccountCtx.Account.ID,
+		Change:    change,
+		OldValue:  oldValue,
+		NewValue:  newValue,
+		IP:        rc.RemoteIP(),
+		UserAgent: rc.Request.UserAgent(),
+		CreatedAt: &ts,
+	}
+}
+
+// ClientFormatter implementation
+func (ah *AccountHistory) CLFormat() bson.M {
+	return bson.M{
+		"_id":        ah.ID,
+		"change":     ah.Change,
+		"old_value":  ah.OldValue,
+		"new_value":  ah.NewValue,
+		"ip":         ah.IP,
+		"user_agent": ah.UserAgent,
+		"created_at": ah.CreatedAt,
+	}
+}
@@ -0,0 +1,116 @@
+package types
+
+import (
+	"strings"
+	"time"
+
+	"github.com/dd-web/opforu-server/internal/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// every token starts with this so leaked tokens are easy to spot and scan for
+	API_TOKEN_PREFIX = "opf_"
+	// how many tokens one account can have at once
+	API_TOKEN_MAX_PER_ACCOUNT = 20
+	API_TOKEN_NAME_MAX_LENGTH = 64
+)
+
+// what an api token is allowed to do on behalf of it's account
+type APITokenScope string
+
+const (
+	APITokenScopeRead     APITokenScope = "read"     // read the account's own data
+	APITokenScopePost     APITokenScope = "post"     // create threads, replies, comments and assets
+	APITokenScopeModerate APITokenScope = "moderate" // use the account's staff privileges
+)
+
+var APITokenScopes = []APITokenScope{APITokenScopeRead, APITokenScopePost, APITokenScopeModerate}
+
+func (s APITokenScope) IsValid() bool {
+	for _, v := range APITokenScopes {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// a personal access token for bots and scripts that can't do a cookie login
+// only the hash of the token is stored, the token itself is shown once when it's created
+type APIToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	AccountID primitive.ObjectID `bson:"account_id" json:"account_id"`
+	Name      string             `bson:"name" json:"name"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+	Hint      string             `bson:"hint" json:"hint"` // the start of the token so it can be recognized in a list
+
+	Scopes []APITokenScope `bson:"scopes" json:"scopes"`
+
+	LastUsedAt *time.Time `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	Expires    *time.Time `bson:"expires,omitempty" json:"expires,omitempty"` // nil never expires
+	CreatedAt  *time.Time `bson:"created_at" json:"created_at"`
+}
+
+// creates a new api token for the account
+// - returns the token to be saved and the plaintext token to give the client
+func NewAPIToken(account *Account, name string, scopes []APITokenScope, expires *time.Time) (*APIToken, string, error) {
+	raw, err := utils.RandomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token := API_TOKEN_PREFIX + raw
+	ts := time.Now().UTC()
+
+	return &APIToken{
+		ID:        primitive.NewObjectID(),
+		AccountID: account.ID,
+		Name:      name,
+		TokenHash: utils.HashToken(token),
+		Hint:      token[:len(API_TOKEN_PREFIX)+6],
+		Scopes:    scopes,
+		Expires:   expires,
+		CreatedAt: &ts,
+	}, token, nil
+}
+
+// is the string shaped like one of our tokens
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, API_TOKEN_PREFIX)
+}
+
+func (t *APIToken) IsExpired() bool {
+	return t.Expires != nil && !t.Expires.After(time.Now().UTC())
+}
+
+func (t *APIToken) HasScope(scope APITokenScope) bool {
+	for _, v := range t.Scopes {
+		if v == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// should the last used time be refreshed, written at the same rate as a session's last seen time
+func (t *APIToken) IsLastUsedStale() bool {
+	if t.LastUsedAt == nil {
+		return true
+	}
+	return time.Since(*t.LastUsedAt) > time.Duration(SESSION_LAST_SEEN_INTERVAL_MINUTES)*time.Minute
+}
+
+// ClientFormatter implementation
+func (t *APIToken) CLFormat() bson.M {
+	return bson.M{
+		"_id":          t.ID,
+		"name":         t.Name,
+		"hint":         t.Hint,
+		"scopes":       t.Scopes,
+		"last_used_at": t.LastUsedAt,
+		"expires":      t.Expires,
+		"created_at":   t.CreatedAt,
+	}
+}
@@ -67,11 +67,13 @@ type ArticleComment struct {
 
 	CommentNumber int                  `json:"comment_number" bson:"comment_number"`
 	Body          string               `json:"body" bson:"body"`
+	Raw           string               `json:"-" bson:"raw,omitempty"` // as written, before it's rendered into Body
 	Assets        []primitive.ObjectID `json:"assets" bson:"assets"`
 
 	CreatedAt *time.Time `bson:"created_at" json:"created_at"`
 	UpdatedAt *time.Time `bson:"updated_at" json:"updated_at"`
 	DeletedAt *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy DeletedBy  `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
 }
 
 func NewArticleComment() *ArticleComment {
@@ -0,0 +1,253 @@
+package types
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// how long cached documents are trusted, other server instances changing them are only seen after this
+	CACHE_SESSION_TTL_SECONDS = 300
+	CACHE_ACCOUNT_TTL_SECONDS = 300
+	CACHE_BOARD_TTL_SECONDS   = 600
+
+	// the most documents each table holds, the least recently used are evicted past it
+	CACHE_SESSION_MAX_ENTRIES = 10000
+	CACHE_ACCOUNT_MAX_ENTRIES = 10000
+	CACHE_BOARD_MAX_ENTRIES   = 1000
+)
+
+// High level server cache for frequently used data to avoid network calls
+//
+// Boards are cached on server start, sessions and accounts are cached as they're accessed.
+// Every table is safe for concurrent use.
+type ServerCache struct {
+	Boards    *CacheTable // "short:" + short & "id:" + _id -> Board
+	Sessions  *CacheTable // session_id -> Session
+	Accounts  *CacheTable // _id -> Account
+	StartedAt *time.Time
+	EndedAt   *time.Time
+}
+
+func NewServerCache() *ServerCache {
+	ts := time.Now().UTC()
+	return &ServerCache{
+		Boards:    NewCacheTable(time.Duration(CACHE_BOARD_TTL_SECONDS)*time.Second, CACHE_BOARD_MAX_ENTRIES),
+		Sessions:  NewCacheTable(time.Duration(CACHE_SESSION_TTL_SECONDS)*time.Second, CACHE_SESSION_MAX_ENTRIES),
+		Accounts:  NewCacheTable(time.Duration(CACHE_ACCOUNT_TTL_SECONDS)*time.Second, CACHE_ACCOUNT_MAX_ENTRIES),
+		StartedAt: &ts,
+	}
+}
+
+// the table caching documents of the collection, nil if it isn't cached
+func (sc *ServerCache) table(col string) *CacheTable {
+	switch col {
+	case "boards":
+		return sc.Boards
+	case "sessions":
+		return sc.Sessions
+	case "accounts":
+		return sc.Accounts
+	}
+	return nil
+}
+
+// drops the cached copy of a document that was replaced or deleted by _id
+func (sc *ServerCache) Invalidate(col string, id primitive.ObjectID) {
+	if table := sc.table(col); table != nil {
+		table.DeleteID(id)
+	}
+}
+
+// drops the whole table of a collection after a change that could have touched any document in it
+func (sc *ServerCache) InvalidateAll(col string) {
+	if table := sc.table(col); table != nil {
+		table.Clear()
+	}
+}
+
+// hit and miss counts of every table
+func (sc *ServerCache) Stats() map[string]CacheStats {
+	return map[string]CacheStats{
+		"boards":   sc.Boards.Stats(),
+		"sessions": sc.Sessions.Stats(),
+		"accounts": sc.Accounts.Stats(),
+	}
+}
+
+// how well a cache table is doing since the server started
+type CacheStats struct {
+	Entries   int     `json:"entries"`
+	Hits      uint64  `json:"hits"`
+	Misses    uint64  `json:"misses"`
+	Evictions uint64  `json:"evictions"` // dropped to stay under the size bound, not counting expiry
+	HitRate   float64 `json:"hit_rate"`
+}
+
+// a size bounded, least recently used cache of documents that expire after a fixed time
+//
+// documents are stored as bson and decoded into a new value on every hit, so callers can change
+// what they get back without affecting the cache or other requests
+type CacheTable struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List                             // most recently used at the front
+	ids     map[primitive.ObjectID]map[string]bool // document _id -> the keys it's cached under
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+type cacheEntry struct {
+	key     string
+	id      primitive.ObjectID // the nil object id for documents without one
+	doc     bson.Raw
+	expires time.Time
+}
+
+func NewCacheTable(ttl time.Duration, maxEntries int) *CacheTable {
+	return &CacheTable{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+		ids:        map[primitive.ObjectID]map[string]bool{},
+	}
+}
+
+// decodes the cached document into v
+// - returns false if it isn't cached or has expired
+func (ct *CacheTable) Get(key string, v any) bool {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	el, ok := ct.entries[key]
+	if !ok {
+		ct.misses++
+		return false
+	}
+
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		ct.remove(el)
+		ct.misses++
+		return false
+	}
+
+	if err := bson.Unmarshal(entry.doc, v); err != nil {
+		ct.remove(el)
+		ct.misses++
+		return false
+	}
+
+	ct.order.MoveToFront(el)
+	ct.hits++
+	return true
+}
+
+// caches the document under the key, replacing anything already there
+func (ct *CacheTable) Set(key string, v any) {
+	doc, err := bson.Marshal(v)
+	if err != nil {
+		return
+	}
+
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	if el, ok := ct.entries[key]; ok {
+		ct.remove(el)
+	}
+
+	id, _ := bson.Raw(doc).Lookup("_id").ObjectIDOK()
+	ct.entries[key] = ct.order.PushFront(&cacheEntry{key: key, id: id, doc: doc, expires: time.Now().Add(ct.ttl)})
+
+	if !id.IsZero() {
+		if ct.ids[id] == nil {
+			ct.ids[id] = map[string]bool{}
+		}
+		ct.ids[id][key] = true
+	}
+
+	for ct.order.Len() > ct.maxEntries {
+		ct.remove(ct.order.Back())
+		ct.evictions++
+	}
+}
+
+func (ct *CacheTable) Delete(keys ...string) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	for _, key := range keys {
+		if el, ok := ct.entries[key]; ok {
+			ct.remove(el)
+		}
+	}
+}
+
+// removes every key the document with the _id is cached under
+func (ct *CacheTable) DeleteID(id primitive.ObjectID) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	for key := range ct.ids[id] {
+		ct.remove(ct.entries[key])
+	}
+}
+
+// removes every cached document the func matches
+func (ct *CacheTable) DeleteWhere(match func(doc bson.Raw) bool) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	for el := ct.order.Front(); el != nil; {
+		next := el.Next()
+		if match(el.Value.(*cacheEntry).doc) {
+			ct.remove(el)
+		}
+		el = next
+	}
+}
+
+func (ct *CacheTable) Clear() {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	ct.entries = map[string]*list.Element{}
+	ct.ids = map[primitive.ObjectID]map[string]bool{}
+	ct.order.Init()
+}
+
+func (ct *CacheTable) Stats() CacheStats {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	stats := CacheStats{Entries: ct.order.Len(), Hits: ct.hits, Misses: ct.misses, Evictions: ct.evictions}
+	if total := ct.hits + ct.misses; total > 0 {
+		stats.HitRate = float64(ct.hits) / float64(total)
+	}
+	return stats
+}
+
+// must be called with the lock held
+func (ct *CacheTable) remove(el *list.Element) {
+	entry := el.Value.(*cacheEntry)
+	delete(ct.entries, entry.key)
+	ct.order.Remove(el)
+
+	if keys := ct.ids[entry.id]; keys != nil {
+		delete(keys, entry.key)
+		if len(keys) == 0 {
+			delete(ct.ids, entry.id)
+		}
+	}
+}
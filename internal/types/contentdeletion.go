@@ -0,0 +1,82 @@
+package types
+
+import "time"
+
+// who took content down, told to readers in place of the content
+type DeletedBy string
+
+const (
+	DeletedByAuthor     DeletedBy = "author"
+	DeletedByModerator  DeletedBy = "moderator"
+	DeletedBySpamFilter DeletedBy = "spam filter" // held for review, see SpamHold
+)
+
+// has the post been deleted
+func (p *Post) IsDeleted() bool {
+	return p.DeletedAt != nil
+}
+
+// soft deletes the post, it keeps it's number and is shown as a tombstone
+func (p *Post) MarkDeleted(by DeletedBy) {
+	ts := time.Now().UTC()
+	p.DeletedAt = &ts
+	p.DeletedBy = by
+	p.UpdatedAt = &ts
+}
+
+// undoes MarkDeleted
+func (p *Post) Restore() {
+	ts := time.Now().UTC()
+	p.DeletedAt = nil
+	p.DeletedBy = ""
+	p.UpdatedAt = &ts
+}
+
+// has the thread been deleted
+func (t *Thread) IsDeleted() bool {
+	return t.Status == ThreadStatusDeleted
+}
+
+// marks the thread deleted, the status it had is kept so a restore can put it back
+func (t *Thread) MarkDeleted(by DeletedBy) {
+	ts := time.Now().UTC()
+	t.RestoreStatus = t.Status
+	t.Status = ThreadStatusDeleted
+	t.DeletedAt = &ts
+	t.DeletedBy = by
+	t.UpdatedAt = &ts
+}
+
+// undoes MarkDeleted
+func (t *Thread) Restore() {
+	ts := time.Now().UTC()
+	t.Status = t.RestoreStatus
+	if t.Status == "" {
+		t.Status = ThreadStatusOpen
+	}
+	t.RestoreStatus = ""
+	t.DeletedAt = nil
+	t.DeletedBy = ""
+	t.UpdatedAt = &ts
+}
+
+// has the comment been deleted
+func (c *ArticleComment) IsDeleted() bool {
+	return c.DeletedAt != nil
+}
+
+// soft deletes the comment, it keeps it's number and is shown as a tombstone
+func (c *ArticleComment) MarkDeleted(by DeletedBy) {
+	ts := time.Now().UTC()
+	c.DeletedAt = &ts
+	c.DeletedBy = by
+	c.UpdatedAt = &ts
+}
+
+// undoes MarkDeleted
+func (c *ArticleComment) Restore() {
+	ts := time.Now().UTC()
+	c.DeletedAt = nil
+	c.DeletedBy = ""
+	c.UpdatedAt = &ts
+}
@@ -0,0 +1,365 @@
+package types
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// how long a finished export can be downloaded
+	DATA_EXPORT_LINK_HOURS = 24
+	// how long an export can be pending before it's given up on, the process building it may have stopped
+	DATA_EXPORT_BUILD_TIMEOUT_MINUTES = 30
+	// how often expired and abandoned exports are looked for
+	DATA_EXPORT_SWEEP_INTERVAL_MINUTES = 60
+
+	// signed token purposes
+	TOKEN_PURPOSE_DATA_EXPORT = "data-export"
+)
+
+type DataExportStatus string
+
+const (
+	DataExportStatusPending DataExportStatus = "pending"
+	DataExportStatusReady   DataExportStatus = "ready"
+	DataExportStatusFailed  DataExportStatus = "failed"
+)
+
+// an archive of everything held about an account, built in the background after it's requested
+type DataExport struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	AccountID primitive.ObjectID `bson:"account_id" json:"account_id"`
+	Status    DataExportStatus   `bson:"status" json:"status"`
+
+	// the size of the zip file, which is stored apart from the export (see SaveDataExportArchive)
+	Size int64 `bson:"size,omitempty" json:"-"`
+
+	CreatedAt   *time.Time `bson:"created_at" json:"created_at"`
+	CompletedAt *time.Time `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+}
+
+// creates a pending export for the account
+func NewDataExport(account *Account) *DataExport {
+	ts := time.Now().UTC()
+	return &DataExport{
+		ID:        primitive.NewObjectID(),
+		AccountID: account.ID,
+		Status:    DataExportStatusPending,
+		CreatedAt: &ts,
+	}
+}
+
+// marks the export ready once it's archive is stored, the download link is valid from now on
+func (de *DataExport) Complete(size int64) {
+	ts := time.Now().UTC()
+	exp := ts.Add(time.Duration(DATA_EXPORT_LINK_HOURS) * time.Hour)
+
+	de.Size = size
+	de.Status = DataExportStatusReady
+	de.CompletedAt = &ts
+	de.ExpiresAt = &exp
+}
+
+func (de *DataExport) Fail() {
+	ts := time.Now().UTC()
+	de.Status = DataExportStatusFailed
+	de.CompletedAt = &ts
+}
+
+// has the export been pending for longer than it takes to build
+func (de *DataExport) IsAbandoned() bool {
+	timeout := time.Duration(DATA_EXPORT_BUILD_TIMEOUT_MINUTES) * time.Minute
+	return de.Status == DataExportStatusPending && de.CreatedAt.Add(timeout).Before(time.Now().UTC())
+}
+
+// can the archive still be downloaded
+func (de *DataExport) IsDownloadable() bool {
+	return de.Status == DataExportStatusReady && de.ExpiresAt != nil && de.ExpiresAt.After(time.Now().UTC())
+}
+
+// ClientFormatter implementation
+func (de *DataExport) CLFormat() bson.M {
+	return bson.M{
+		"_id":          de.ID,
+		"status":       de.Status,
+		"created_at":   de.CreatedAt,
+		"completed_at": de.CompletedAt,
+		"expires_at":   de.ExpiresAt,
+	}
+}
+
+// Delete Data Exports
+// removes the exports matching the filter along with their archives
+func DeleteDataExports(repo Repository, filter bson.D) error {
+	exports := []*DataExport{}
+	err := repo.FindMulti(filter, &exports, "data_exports")
+	if err != nil {
+		return err
+	}
+
+	for _, v := range exports {
+		if err := repo.DeleteDataExportArchive(v.ID); err != nil {
+			return err
+		}
+		if err := repo.DeleteSingle(v.ID, "data_exports"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Sweep Data Exports
+// gives up on abandoned exports so they can be requested again and removes expired ones
+// - returns the number of exports removed
+func SweepDataExports(repo Repository) (int, error) {
+	now := time.Now().UTC()
+	cutoff := now.Add(-time.Duration(DATA_EXPORT_BUILD_TIMEOUT_MINUTES) * time.Minute)
+
+	_, err := repo.UpdateMulti(bson.D{
+		{Key: "status", Value: DataExportStatusPending},
+		{Key: "created_at", Value: bson.D{{Key: "$lte", Value: cutoff}}},
+	}, bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: DataExportStatusFailed}, {Key: "completed_at", Value: now}}}}, "data_exports")
+	if err != nil {
+		return 0, err
+	}
+
+	expired := bson.D{{Key: "expires_at", Value: bson.D{{Key: "$lte", Value: now}}}}
+	removed := int(repo.CountResults("data_exports", expired))
+
+	return removed, DeleteDataExports(repo, expired)
+}
+
+// runs SweepDataExports on start and every DATA_EXPORT_SWEEP_INTERVAL_MINUTES until stop is called
+// exports left pending by an earlier process are given up on by the first sweep past their timeout
+func StartDataExportSweeper(repo Repository) (stop func()) {
+	ticker := time.NewTicker(time.Duration(DATA_EXPORT_SWEEP_INTERVAL_MINUTES) * time.Minute)
+	done := make(chan struct{})
+
+	sweep := func() {
+		removed, err := SweepDataExports(repo)
+		if err != nil {
+			fmt.Println("Error sweeping data exports", err)
+		}
+		if removed > 0 {
+			fmt.Printf("Removed %d expired data exports\n", removed)
+		}
+	}
+
+	go func() {
+		sweep()
+		for {
+			select {
+			case <-ticker.C:
+				sweep()
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// an identity along with everything made through it
+type dataExportIdentity struct {
+	Identity bson.M   `json:"identity"`
+	Threads  []bson.M `json:"threads"`
+	Posts    []bson.M `json:"posts"`
+}
+
+// an uploaded asset along with the details of the file it points to
+type dataExportAsset struct {
+	Asset     *Asset              `json:"asset"`
+	AssetType AssetType           `json:"asset_type,omitempty"`
+	Source    *AssetSourceDetails `json:"source,omitempty"`
+}
+
+// Build Data Export
+// collects everything held about the account into a zip of json files
+// secrets are left out: the password hash, two-factor secret, session ids and token hashes
+// - returns the zip file
+func BuildDataExport(repo Repository, account *Account) ([]byte, error) {
+	files := map[string]any{}
+
+	profile := account.CLFormat()
+	profile["_id"] = account.ID
+	files["account.json"] = profile
+
+	sessions, err := repo.FindAccountSessions(account.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	formattedSessions := make([]bson.M, 0, len(sessions))
+	for _, v := range sessions {
+		formattedSessions = append(formattedSessions, v.ListFormat(false))
+	}
+	files["sessions.json"] = formattedSessions
+
+	tokens := []*APIToken{}
+	err = repo.FindMulti(bson.D{{Key: "account_id", Value: account.ID}}, &tokens, "api_tokens")
+	if err != nil {
+		return nil, err
+	}
+
+	formattedTokens := make([]bson.M, 0, len(tokens))
+	for _, v := range tokens {
+		formattedTokens = append(formattedTokens, v.CLFormat())
+	}
+	files["api_tokens.json"] = formattedTokens
+
+	history := []*AccountHistory{}
+	err = repo.FindMulti(bson.D{{Key: "account_id", Value: account.ID}}, &history, "account_history")
+	if err != nil {
+		return nil, err
+	}
+
+	formattedHistory := make([]bson.M, 0, len(history))
+	for _, v := range history {
+		formattedHistory = append(formattedHistory, v.CLFormat())
+	}
+	files["account_history.json"] = formattedHistory
+
+	sanctions, err := repo.FindAccountSanctions(account.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	formattedSanctions := make([]bson.M, 0, len(sanctions))
+	for _, v := range sanctions {
+		formattedSanctions = append(formattedSanctions, v.CLFormat())
+	}
+	files["sanctions.json"] = formattedSanctions
+
+	reports := []*Report{}
+	err = repo.FindMulti(bson.D{{Key: "reporter_id", Value: account.ID}}, &reports, "reports")
+	if err != nil {
+		return nil, err
+	}
+
+	formattedReports := make([]bson.M, 0, len(reports))
+	for _, v := range reports {
+		formattedReports = append(formattedReports, v.CLFormat())
+	}
+	files["reports.json"] = formattedReports
+
+	identities, err := collectExportIdentities(repo, account)
+	if err != nil {
+		return nil, err
+	}
+	files["identities.json"] = identities
+
+	comments := []*ArticleComment{}
+	err = repo.FindMulti(bson.D{{Key: "author", Value: account.ID}}, &comments, "article_comments")
+	if err != nil {
+		return nil, err
+	}
+	files["article_comments.json"] = comments
+
+	assets, err := collectExportAssets(repo, account)
+	if err != nil {
+		return nil, err
+	}
+	files["assets.json"] = assets
+
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+
+	for name, contents := range files {
+		bs, err := json.MarshalIndent(contents, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		w, err := zw.Create(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := w.Write(bs); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// every identity of the account with the threads and posts made through it
+func collectExportIdentities(repo Repository, account *Account) ([]*dataExportIdentity, error) {
+	identities := []*Identity{}
+	err := repo.FindMulti(bson.D{{Key: "account", Value: account.ID}}, &identities, "identities")
+	if err != nil {
+		return nil, err
+	}
+
+	collected := make([]*dataExportIdentity, 0, len(identities))
+	for _, identity := range identities {
+		threads := []*Thread{}
+		err := repo.FindMulti(bson.D{{Key: "creator", Value: identity.ID}}, &threads, "threads")
+		if err != nil {
+			return nil, err
+		}
+
+		posts := []*Post{}
+		err = repo.FindMulti(bson.D{{Key: "creator", Value: identity.ID}}, &posts, "posts")
+		if err != nil {
+			return nil, err
+		}
+
+		entry := &dataExportIdentity{
+			Identity: identity.CLFormat(),
+			Threads:  make([]bson.M, 0, len(threads)),
+			Posts:    make([]bson.M, 0, len(posts)),
+		}
+		entry.Identity["_id"] = identity.ID
+
+		for _, v := range threads {
+			entry.Threads = append(entry.Threads, v.CLFormat())
+		}
+		for _, v := range posts {
+			entry.Posts = append(entry.Posts, v.CLFormat())
+		}
+
+		collected = append(collected, entry)
+	}
+
+	return collected, nil
+}
+
+// every asset the account uploaded with the metadata of it's source file
+// the source's uploaders are left out, they're other accounts
+func collectExportAssets(repo Repository, account *Account) ([]*dataExportAsset, error) {
+	assets := []*Asset{}
+	err := repo.FindMulti(bson.D{{Key: "account_id", Value: account.ID}}, &assets, "assets")
+	if err != nil {
+		return nil, err
+	}
+
+	collected := make([]*dataExportAsset, 0, len(assets))
+	for _, asset := range assets {
+		entry := &dataExportAsset{Asset: asset}
+
+		source := &AssetSource{}
+		if err := repo.FindSingle(bson.D{{Key: "_id", Value: asset.SourceID}}, source, "asset_sources"); err == nil {
+			entry.AssetType = source.AssetType
+			entry.Source = source.Details
+		}
+
+		collected = append(collected, entry)
+	}
+
+	return collected, nil
+}
@@ -2,6 +2,7 @@ package types
 
 import (
 	"net/http"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 )
@@ -14,7 +15,10 @@ const (
 	Error_NotFound     ServerError = "not found"
 	Error_Invalid      ServerError = "invalid"
 	Error_Unauthorized ServerError = "unauthorized"
+	Error_Forbidden    ServerError = "forbidden"
 	Error_Unsupported  ServerError = "unsupported method"
+	Error_TooMany      ServerError = "too many requests"
+	Error_Gone         ServerError = "gone"
 )
 
 // Status codes mapped to their respective ServerError
@@ -23,6 +27,9 @@ var ServerErrorStatusMap map[int]ServerError = map[int]ServerError{
 	http.StatusNotFound:            Error_NotFound,
 	http.StatusBadRequest:          Error_Invalid,
 	http.StatusUnauthorized:        Error_Unauthorized,
+	http.StatusForbidden:           Error_Forbidden,
+	http.StatusTooManyRequests:     Error_TooMany,
+	http.StatusGone:                Error_Gone,
 }
 
 func (se ServerError) String() string {
@@ -33,6 +40,11 @@ func (se ServerError) String() string {
 type APIError struct {
 	Status  int
 	Message string
+	Fields  FieldErrors // per field problems with the request, only set on validation errors
+
+	RetryAfter time.Duration // how long the client should wait before trying again, sent as the Retry-After header
+
+	Details bson.M // anything else the client needs to act on the error, sent alongside the message
 }
 
 // implements the error interface
@@ -42,7 +54,14 @@ func (e APIError) Error() string {
 
 // formats into bson (json likeish) for response
 func (e APIError) Bson() bson.M {
-	return bson.M{"error": e.Message}
+	formatted := bson.M{"error": e.Message}
+	if !e.Fields.Empty() {
+		formatted["fields"] = e.Fields
+	}
+	for k, v := range e.Details {
+		formatted[k] = v
+	}
+	return formatted
 }
 
 // Creates a new APIError with the given status and message
@@ -70,16 +89,90 @@ func ErrorInvalid(what string) APIError {
 	return *NewAPIError(http.StatusBadRequest, what+" is "+Error_Invalid.String())
 }
 
+// New Validation Error
+// the request was well formed but some of it's fields were not acceptable
+// - accepts the problems found keyed by field name
+func ErrorValidation(fields FieldErrors) APIError {
+	err := NewAPIError(http.StatusBadRequest, "validation failed")
+	err.Fields = fields
+	return *err
+}
+
 // New Conflict Error
 func ErrorConflict(msg string) APIError {
 	return *NewAPIError(http.StatusConflict, msg)
 }
 
+// New Gone Error
+// the resource existed but has been deleted, unlike not found there's no point asking again
+// - accepts a string of the resource that's gone
+func ErrorGone(resource string) APIError {
+	return *NewAPIError(http.StatusGone, resource+" has been deleted")
+}
+
+// New Spam Error
+// the spam pipeline turned the submission away, how it scored is kept from the client
+func ErrorSpam() APIError {
+	return *NewAPIError(http.StatusUnprocessableEntity, "submission was rejected as spam")
+}
+
 // New Unauthorized Error
 func ErrorUnauthorized() APIError {
 	return *NewAPIError(http.StatusUnauthorized, Error_Unauthorized.String())
 }
 
+// New Forbidden Error
+// the requester is known but not allowed to do this
+// - accepts a string explaining why
+func ErrorForbidden(reason string) APIError {
+	return *NewAPIError(http.StatusForbidden, reason)
+}
+
+// New Too Many Requests Error
+// the client has made too many attempts and must wait before trying again
+// - accepts a time.Duration of how long to wait
+func ErrorTooManyRequests(retryAfter time.Duration) APIError {
+	err := NewAPIError(http.StatusTooManyRequests, Error_TooMany.String())
+	err.RetryAfter = retryAfter
+	return *err
+}
+
+// New Missing Scope Error
+// the request was made with an api token that wasn't given the scope
+// - accepts the scope that's required
+func ErrorMissingScope(scope APITokenScope) APIError {
+	return *NewAPIError(http.StatusForbidden, "api token is missing the "+string(scope)+" scope")
+}
+
+// New Session Required Error
+// account management can't be done with an api token, only with a login session
+func ErrorSessionRequired() APIError {
+	return *NewAPIError(http.StatusForbidden, "requires a login session, api tokens can't be used")
+}
+
+// New Sanctioned Error
+// the account is suspended or banned, the sanction is sent so the client can show why and until when
+// - accepts the sanction in force
+func ErrorSanctioned(sanction *Sanction) APIError {
+	msg := "account is suspended"
+	if sanction.Kind == SanctionKindBan {
+		msg = "account is banned"
+	}
+
+	err := NewAPIError(http.StatusForbidden, msg)
+	err.Details = bson.M{"sanction": sanction.CLFormat()}
+	return *err
+}
+
+// New IP Banned Error
+// writes from the client's address are banned, the ban is sent so the client can show why and until when
+// - accepts the ban in force
+func ErrorIPBanned(ban *IPBan) APIError {
+	err := NewAPIError(http.StatusForbidden, "address is banned")
+	err.Details = bson.M{"ban": ban.CLFormat()}
+	return *err
+}
+
 // New Unexpected Error
 func ErrorUnexpected() APIError {
 	return *NewAPIError(http.StatusInternalServerError, Error_Unexpected.String())
@@ -32,6 +32,9 @@ type Identity struct {
 	Role   ThreadRole     `bson:"role" json:"role"`
 	Status IdentityStatus `bson:"status" json:"status"`
 
+	// content from the identity is only shown to it's account and staff, see Account.ShadowBanned
+	ShadowBanned bool `bson:"shadow_banned,omitempty" json:"-"`
+
 	Thread primitive.ObjectID `bson:"thread,omitempty" json:"thread"`
 
 	CreatedAt *time.Time `bson:"created_at" json:"created_at"`
@@ -98,3 +101,11 @@ func FindFilterIdentityInThread(thread_id, account_id primitive.ObjectID) bson.D
 		{Key: "account", Value: account_id},
 	}
 }
+
+// returns a filter object that resolves an identity from a particular thread by it's public name
+func FindFilterIdentityByName(thread_id primitive.ObjectID, name string) bson.D {
+	return bson.D{
+		{Key: "thread", Value: thread_id},
+		{Key: "name", Value: name},
+	}
+}
@@ -2,11 +2,13 @@ package types
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/dd-web/opforu-server/internal/utils"
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -41,7 +43,7 @@ type RequestCtx struct {
 	Resource          APIResource         // this is the main subroute of the API, the first major path after root.
 	Pagination        *PageCtx            `json:"pages"`   // pagination information
 	Records           []bson.M            `json:"records"` // resource(s) we intend to return to the client
-	Store             *Store
+	Store             Repository
 	AccountCtx        *AccountCtx
 	TemplateStore     *TemplateStore
 	UnresolvedAccount bool
@@ -67,8 +69,36 @@ func NewRequestCtx(w http.ResponseWriter, r *http.Request) *RequestCtx {
 	}).Resolve()
 }
 
+// the address of the client making the request, without the port
+// requests through a trusted proxy are from whoever it says it's forwarding for
+func (rc *RequestCtx) RemoteIP() string {
+	host, _, err := net.SplitHostPort(rc.Request.RemoteAddr)
+	if err != nil {
+		host = rc.Request.RemoteAddr
+	}
+	return utils.ClientIP(host, rc.Request.Header.Values("X-Forwarded-For"))
+}
+
+// the client's address as it's stored with content, see utils.HashIP
+func (rc *RequestCtx) IPHash() string {
+	return utils.HashIP(rc.RemoteIP())
+}
+
+// the account making the request, the nil object id when signed out
+func (rc *RequestCtx) ViewerID() primitive.ObjectID {
+	if rc.AccountCtx.Account == nil {
+		return primitive.NilObjectID
+	}
+	return rc.AccountCtx.Account.ID
+}
+
 // updates the request context with the store
-func (rc *RequestCtx) UpdateStore(s *Store) {
+// the account is only resolved once, route guards resolve it before the handler is called
+func (rc *RequestCtx) UpdateStore(s Repository) {
+	if rc.Store == s {
+		return
+	}
+
 	rc.Store = s
 	rc.ResolveAccountCtx() // we call this here for access to the store
 }
@@ -104,6 +134,14 @@ func (rc *RequestCtx) Finalize() {
 		rc.AddToResponseListCLF("session", rc.AccountCtx.Session)
 	}
 
+	if rc.AccountCtx.TwoFactorSetupRequired {
+		rc.AddToResponseList("two_factor_setup_required", true)
+	}
+
+	if rc.AccountCtx.Sanction != nil {
+		rc.AddToResponseListCLF("sanction", rc.AccountCtx.Sanction)
+	}
+
 	// these are explicity added, shouldn't need to check for nil
 	for _, v := range rc.ResponseList {
 		for key, value := range v {
@@ -113,11 +151,17 @@ func (rc *RequestCtx) Finalize() {
 }
 
 // resolves an account context for the request
+// bots authenticate with an api token in the Authorization header, everything else with the session cookie
 func (rc *RequestCtx) ResolveAccountCtx() {
+	if token, ok := strings.CutPrefix(rc.Request.Header.Get("Authorization"), "Bearer "); ok {
+		rc.resolveTokenAccountCtx(strings.TrimSpace(token))
+		return
+	}
+
 	var sessionid string = ""
 
 	for _, v := range rc.Request.Cookies() {
-		if v.Name == "session" {
+		if v.Name == SESSION_COOKIE_NAME {
 			sessionid = v.Value
 		}
 	}
@@ -132,6 +176,7 @@ func (rc *RequestCtx) ResolveAccountCtx() {
 	if err != nil {
 		fmt.Println("Error finding session", err)
 		rc.UnresolvedAccount = true
+		ClearSessionCookie(rc.Writer)
 		return
 	}
 
@@ -139,14 +184,29 @@ func (rc *RequestCtx) ResolveAccountCtx() {
 		fmt.Println("Session Expired!")
 		rc.UnresolvedAccount = true
 		rc.AccountCtx.ExpiredSession = true
+		ClearSessionCookie(rc.Writer)
 		return
 	}
 
-	if session.IsExpiringSoon() {
+	// browsers attach the cookie to cross site requests, so anything that changes state must also
+	// prove it came from our front end by echoing the session's csrf token
+	if !IsSafeMethod(rc.Request.Method) && !utils.VerifyCSRFToken(session.SessionID, rc.Request.Header.Get(CSRF_HEADER_NAME)) {
+		fmt.Println("CSRF token missing or invalid")
+		rc.UnresolvedAccount = true
+		rc.AccountCtx.CSRFRejected = true
+		return
+	}
+
+	if session.IsExpiringSoon() || session.IsLastSeenStale() {
 		ts := time.Now().UTC()
-		exp := time.Now().Add(time.Duration(time.Hour * 24 * 7)).UTC()
-		session.Expires = &exp
-		session.UpdatedAt = &ts
+		session.LastSeenAt = &ts
+
+		extended := session.IsExpiringSoon()
+		if extended {
+			exp := time.Now().Add(time.Duration(time.Hour * 24 * 7)).UTC()
+			session.Expires = &exp
+			session.UpdatedAt = &ts
+		}
 
 		err := rc.Store.UpdateSession(session)
 		if err != nil {
@@ -155,6 +215,11 @@ func (rc *RequestCtx) ResolveAccountCtx() {
 			rc.UnresolvedAccount = true
 			return
 		}
+
+		// the cookie has it's own expiry which has to follow the session's
+		if extended {
+			SetSessionCookie(rc.Writer, session)
+		}
 	}
 
 	rc.AccountCtx.Session = session
@@ -172,9 +237,69 @@ func (rc *RequestCtx) ResolveAccountCtx() {
 		rc.UnresolvedAccount = true
 	}
 
+	rc.setAccount(account)
+}
+
+// resolves the account from an api token
+// tokens aren't sent automatically by browsers so they don't need a csrf token
+func (rc *RequestCtx) resolveTokenAccountCtx(raw string) {
+	if !IsAPIToken(raw) {
+		rc.UnresolvedAccount = true
+		return
+	}
+
+	token, err := rc.Store.FindAPIToken(utils.HashToken(raw))
+	if err != nil || token.IsExpired() {
+		fmt.Println("Invalid api token")
+		rc.UnresolvedAccount = true
+		return
+	}
+
+	account, err := rc.Store.FindAccountByID(token.AccountID)
+	if err != nil {
+		fmt.Println("Error finding account", err)
+		rc.UnresolvedAccount = true
+		return
+	}
+
+	if token.IsLastUsedStale() {
+		ts := time.Now().UTC()
+		token.LastUsedAt = &ts
+
+		_, err := rc.Store.UpdateMulti(bson.D{{Key: "_id", Value: token.ID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "last_used_at", Value: ts}}}}, "api_tokens")
+		if err != nil {
+			fmt.Println("Error updating api token", err)
+		}
+	}
+
+	rc.AccountCtx.Token = token
+	rc.setAccount(account)
+}
+
+// sets the resolved account and the role the request acts with
+func (rc *RequestCtx) setAccount(account *Account) {
 	rc.AccountCtx.Account = account
 	rc.AccountCtx.Role = account.Role
 
+	// issuing a sanction puts it's status on the account, the records only need checking while it's there
+	if account.IsSanctioned() {
+		sanction, err := ResolveAccountSanction(rc.Store, account)
+		if err != nil {
+			fmt.Println("Error resolving account sanction", err)
+			rc.UnresolvedAccount = true
+			return
+		}
+		rc.AccountCtx.Sanction = sanction
+	}
+
+	// staff privileges are withheld until two-factor is enabled when the site requires it
+	if account.IsStaff() && !account.HasTwoFactor() {
+		settings, err := rc.Store.FindSettings()
+		if err != nil || settings.RequireStaffTwoFactor {
+			rc.AccountCtx.Role = AccountRoleUser
+			rc.AccountCtx.TwoFactorSetupRequired = true
+		}
+	}
 }
 
 // parse the request and populate each of the contexts with relevant information
@@ -310,9 +435,22 @@ func (p *PageCtx) Update(results int) {
 // context of the requesting user. if unable to resolve a user pointers will be nil
 type AccountCtx struct {
 	Session        *Session    `json:"session,omitempty"`
+	Token          *APIToken   `json:"-"` // set instead of Session when an api token was used
 	Account        *Account    `json:"account,omitempty"`
 	ExpiredSession bool        `json:"expired_session,omitempty"`
 	Role           AccountRole `json:"-"`
+
+	// the session was found but the request didn't carry a valid csrf token
+	CSRFRejected bool `json:"-"`
+
+	// the account is staff but must enable two-factor before it's role applies
+	TwoFactorSetupRequired bool `json:"two_factor_setup_required,omitempty"`
+
+	// the suspension or ban in force on the account, nil if there isn't one
+	Sanction *Sanction `json:"-"`
+
+	// roles granted on single boards, nil until they're first needed
+	BoardRoles map[primitive.ObjectID]AccountRole `json:"-"`
 }
 
 // creates a new user context with default values and a public role
@@ -323,21 +461,121 @@ func NewAccountCtx() *AccountCtx {
 	}
 }
 
+// can the request use the permission everywhere
+// the role the request acts with may be less than the account's role, and api tokens also need the
+// permission's scope
+func (ac *AccountCtx) Can(perm Permission) bool {
+	return ac.canWithRole(ac.Role, perm)
+}
+
+func (ac *AccountCtx) canWithRole(role AccountRole, perm Permission) bool {
+	if ac.Token != nil && (perm.Scope() == "" || !ac.Token.HasScope(perm.Scope())) {
+		return false
+	}
+	if ac.Sanction != nil && !perm.AllowsSanctioned() {
+		return false
+	}
+	return RoleHasPermission(role, perm)
+}
+
+// can the request use the permission on the board, either everywhere or through a role granted
+// on that board
+func (rc *RequestCtx) CanOnBoard(perm Permission, board_id primitive.ObjectID) bool {
+	if rc.AccountCtx.Can(perm) {
+		return true
+	}
+
+	if rc.AccountCtx.Account == nil || board_id.IsZero() {
+		return false
+	}
+
+	role, ok := rc.ResolveBoardRoles()[board_id]
+	return ok && rc.AccountCtx.canWithRole(role, perm)
+}
+
+// the roles the account was granted per board, looked up the first time they're needed
+func (rc *RequestCtx) ResolveBoardRoles() map[primitive.ObjectID]AccountRole {
+	if rc.AccountCtx.BoardRoles != nil {
+		return rc.AccountCtx.BoardRoles
+	}
+
+	rc.AccountCtx.BoardRoles = map[primitive.ObjectID]AccountRole{}
+
+	// board roles are staff privileges too, withheld the same way when two-factor is required
+	if rc.AccountCtx.TwoFactorSetupRequired {
+		return rc.AccountCtx.BoardRoles
+	}
+
+	account := rc.AccountCtx.Account
+	if !account.HasTwoFactor() {
+		settings, err := rc.Store.FindSettings()
+		if err != nil || settings.RequireStaffTwoFactor {
+			return rc.AccountCtx.BoardRoles
+		}
+	}
+
+	grants, err := rc.Store.FindAccountBoardRoles(account.ID)
+	if err != nil {
+		fmt.Println("Error finding board roles", err)
+		return rc.AccountCtx.BoardRoles
+	}
+
+	for _, v := range grants {
+		rc.AccountCtx.BoardRoles[v.BoardID] = v.Role
+	}
+
+	return rc.AccountCtx.BoardRoles
+}
+
+// can the request do what the scope allows
+// sessions can do everything their account can, api tokens only what they were given
+func (ac *AccountCtx) HasScope(scope APITokenScope) bool {
+	return ac.Token == nil || ac.Token.HasScope(scope)
+}
+
+// was the request made with a login session rather than an api token
+func (ac *AccountCtx) IsSessionAuth() bool {
+	return ac.Session != nil
+}
+
+// methods that must not change state, these don't need a csrf token
+func IsSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
 // logs the request to the console
 func RequestLogger(rc *RequestCtx) {
 	fmt.Printf("[%s]: %s - %s \n", rc.Request.Method, rc.Request.URL.Path, rc.Request.RemoteAddr)
 }
 
 // top level router with access to the store for database operations
+// and the services handlers depend on
 type RoutingHandler struct {
 	Router *mux.Router
-	Store  *Store
+	Store  Repository
+	Mailer Mailer
+
+	// external sign in providers by name, none until configured
+	OIDCProviders map[string]*OIDCProvider
+
+	// what new threads, posts and comments are scored by before they're saved
+	Spam *SpamPipeline
 }
 
-func NewRoutingHandler(s *Store) *RoutingHandler {
+// mail is printed to stdout until a mailer is configured
+func NewRoutingHandler(s Repository) *RoutingHandler {
 	r := mux.NewRouter()
 	return &RoutingHandler{
 		Router: r,
 		Store:  s,
+		Mailer: NewStdoutMailer(),
+
+		OIDCProviders: map[string]*OIDCProvider{},
+
+		Spam: DefaultSpamPipeline(),
 	}
 }
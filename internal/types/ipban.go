@@ -0,0 +1,141 @@
+package types
+
+import (
+	"encoding/hex"
+	"net"
+	"time"
+
+	"github.com/dd-web/opforu-server/internal/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	IP_BAN_REASON_MAX_LENGTH = 500
+
+	// the widest ranges that can be banned, anything wider takes out too many bystanders
+	IP_BAN_MIN_PREFIX_V4 = 16
+	IP_BAN_MIN_PREFIX_V6 = 32
+)
+
+// stops writes from an address range, or from the address behind a piece of content
+// content only stores a hash of the address it came from, so bans made from content match that
+// hash and the address itself is never known to staff
+type IPBan struct {
+	ID       primitive.ObjectID  `bson:"_id,omitempty" json:"_id"`
+	CIDR     string              `bson:"cidr,omitempty" json:"cidr,omitempty"`
+	IPHash   string              `bson:"ip_hash,omitempty" json:"-"`
+	Location *ReportLocation     `bson:"location,omitempty" json:"location,omitempty"` // the content a hash ban was made from
+	Reason   string              `bson:"reason" json:"reason"`
+	IssuedBy primitive.ObjectID  `bson:"issued_by" json:"issued_by"`
+	LiftedBy *primitive.ObjectID `bson:"lifted_by,omitempty" json:"lifted_by,omitempty"`
+
+	// the first and last address of a range ban (see IPBanKey), bans covering an address are found by them
+	RangeStart string `bson:"range_start,omitempty" json:"-"`
+	RangeEnd   string `bson:"range_end,omitempty" json:"-"`
+
+	ExpiresAt *time.Time `bson:"expires_at,omitempty" json:"expires_at,omitempty"` // nil never expires
+	LiftedAt  *time.Time `bson:"lifted_at,omitempty" json:"lifted_at,omitempty"`
+	CreatedAt *time.Time `bson:"created_at" json:"created_at"`
+}
+
+func NewIPBan(reason string, issued_by primitive.ObjectID, expires *time.Time) *IPBan {
+	ts := time.Now().UTC()
+	return &IPBan{
+		ID:        primitive.NewObjectID(),
+		Reason:    reason,
+		IssuedBy:  issued_by,
+		ExpiresAt: expires,
+		CreatedAt: &ts,
+	}
+}
+
+// is the range narrow enough to ban
+func IPBanRangeAllowed(ipnet *net.IPNet) bool {
+	ones, bits := ipnet.Mask.Size()
+	if bits == 32 {
+		return ones >= IP_BAN_MIN_PREFIX_V4
+	}
+	return ones >= IP_BAN_MIN_PREFIX_V6
+}
+
+// does the ban apply right now
+func (b *IPBan) IsActive() bool {
+	return b.LiftedAt == nil && (b.ExpiresAt == nil || b.ExpiresAt.After(time.Now().UTC()))
+}
+
+// bans the range, it must already be narrow enough (see IPBanRangeAllowed)
+func (b *IPBan) SetRange(ipnet *net.IPNet) {
+	last := make(net.IP, len(ipnet.IP))
+	for i := range ipnet.IP {
+		last[i] = ipnet.IP[i] | ^ipnet.Mask[i]
+	}
+
+	b.CIDR = ipnet.String()
+	b.RangeStart = IPBanKey(ipnet.IP)
+	b.RangeEnd = IPBanKey(last)
+}
+
+// the form addresses are compared in to find the ranges covering them, v4 addresses in their v6 form
+// so both sort by the same fixed width hex
+func IPBanKey(ip net.IP) string {
+	return hex.EncodeToString(ip.To16())
+}
+
+// ends the ban early
+func (b *IPBan) Lift(lifted_by primitive.ObjectID) {
+	ts := time.Now().UTC()
+	b.LiftedAt = &ts
+	b.LiftedBy = &lifted_by
+}
+
+// ClientFormatter implementation
+// sent to the banned client, which range or content caught them is left out
+func (b *IPBan) CLFormat() bson.M {
+	return bson.M{
+		"reason":     b.Reason,
+		"expires_at": b.ExpiresAt,
+		"created_at": b.CreatedAt,
+	}
+}
+
+// bans that haven't been lifted or run out
+func ActiveIPBanFilter() bson.D {
+	return bson.D{
+		{Key: "lifted_at", Value: bson.D{{Key: "$exists", Value: false}}},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "expires_at", Value: bson.D{{Key: "$exists", Value: false}}}},
+			bson.D{{Key: "expires_at", Value: bson.D{{Key: "$gt", Value: time.Now().UTC()}}}},
+		}},
+	}
+}
+
+// Find IP Ban
+// checked on every write so it's a single indexed query, by the address's hash for bans made from
+// content and by the range bounds for the rest
+// - accepts the client's address
+// - returns the ban in force on the address, nil if there isn't one
+func FindIPBan(repo Repository, ip string) (*IPBan, error) {
+	covering := bson.A{bson.D{{Key: "ip_hash", Value: utils.HashIP(ip)}}}
+	if parsed := net.ParseIP(ip); parsed != nil {
+		key := IPBanKey(parsed)
+		covering = append(covering, bson.D{
+			{Key: "range_start", Value: bson.D{{Key: "$lte", Value: key}}},
+			{Key: "range_end", Value: bson.D{{Key: "$gte", Value: key}}},
+		})
+	}
+
+	filter := bson.D{{Key: "$and", Value: bson.A{ActiveIPBanFilter(), bson.D{{Key: "$or", Value: covering}}}}}
+
+	ban := &IPBan{}
+	err := repo.FindSingle(filter, ban, "ip_bans")
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ban, nil
+}
@@ -0,0 +1,111 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// failures allowed before any delay is enforced, covers the odd typo
+	LOGIN_FREE_FAILURES = 3
+	// failures after which the key is locked out entirely
+	LOGIN_MAX_FAILURES = 10
+	// the first delay once free failures are used up, doubles with every failure after
+	LOGIN_BACKOFF_BASE_SECONDS = 2
+	// how long a lockout lasts
+	LOGIN_LOCKOUT_MINUTES = 15
+	// failures older than this are forgotten
+	LOGIN_FAILURE_WINDOW_MINUTES = 60
+)
+
+// failed login attempts tracked against a single key. keys are either an account or a remote address
+// so guessing is limited per account no matter where it comes from, and per address no matter
+// which accounts it targets. password reset requests are counted the same way under their own keys.
+type LoginAttempts struct {
+	ID  primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	Key string             `bson:"key" json:"key"`
+
+	Failures      int        `bson:"failures" json:"failures"`
+	LastFailureAt *time.Time `bson:"last_failure_at,omitempty" json:"last_failure_at,omitempty"`
+	LockedUntil   *time.Time `bson:"locked_until,omitempty" json:"locked_until,omitempty"`
+}
+
+func NewLoginAttempts(key string) *LoginAttempts {
+	return &LoginAttempts{
+		ID:  primitive.NewObjectID(),
+		Key: key,
+	}
+}
+
+// the key attempts against an account are tracked by
+func LoginAttemptsAccountKey(account *Account) string {
+	return "account:" + account.ID.Hex()
+}
+
+// the key attempts from a remote address are tracked by
+func LoginAttemptsIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// the key password reset requests from a remote address are tracked by, kept apart from logins
+// so asking for reset links doesn't hold up signing in
+func PasswordResetAttemptsIPKey(ip string) string {
+	return "forgot:ip:" + ip
+}
+
+// the key password reset requests for an email are tracked by, whether or not an account has it
+func PasswordResetAttemptsEmailKey(email string) string {
+	return "forgot:email:" + NormalizeEmail(email)
+}
+
+// records a failed attempt, locking the key once too many have failed
+func (la *LoginAttempts) RecordFailure(now time.Time) {
+	if la.expired(now) {
+		la.Failures = 0
+		la.LockedUntil = nil
+	}
+
+	la.Failures++
+	la.LastFailureAt = &now
+
+	if la.Failures >= LOGIN_MAX_FAILURES {
+		until := now.Add(time.Duration(LOGIN_LOCKOUT_MINUTES) * time.Minute)
+		la.LockedUntil = &until
+	}
+}
+
+// how long until another attempt is allowed, zero if one is allowed now
+func (la *LoginAttempts) RetryAfter(now time.Time) time.Duration {
+	if la.LockedUntil != nil && now.Before(*la.LockedUntil) {
+		return la.LockedUntil.Sub(now)
+	}
+
+	if la.expired(now) || la.Failures <= LOGIN_FREE_FAILURES {
+		return 0
+	}
+
+	delay := time.Duration(LOGIN_BACKOFF_BASE_SECONDS) * time.Second << (la.Failures - LOGIN_FREE_FAILURES - 1)
+	if lockout := time.Duration(LOGIN_LOCKOUT_MINUTES) * time.Minute; delay > lockout {
+		delay = lockout
+	}
+
+	if wait := la.LastFailureAt.Add(delay).Sub(now); wait > 0 {
+		return wait
+	}
+
+	return 0
+}
+
+// a lockout that has run out or failures that are too old to count start the key over
+func (la *LoginAttempts) expired(now time.Time) bool {
+	if la.LockedUntil != nil {
+		return !now.Before(*la.LockedUntil)
+	}
+
+	if la.LastFailureAt == nil {
+		return true
+	}
+
+	return now.Sub(*la.LastFailureAt) > time.Duration(LOGIN_FAILURE_WINDOW_MINUTES)*time.Minute
+}
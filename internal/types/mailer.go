@@ -0,0 +1,185 @@
+package types
+
+import (
+	"fmt"
+	"io"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// an outgoing email
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends mail to users. Handlers only depend on this interface, the backend is picked
+// on server start (see NewMailerFromEnv).
+type Mailer interface {
+	Send(mail *Mail) error
+}
+
+// renders a mail as an RFC 5322 message
+func (m *Mail) Format(from string) []byte {
+	var sb strings.Builder
+	sb.WriteString("From: " + from + "\r\n")
+	sb.WriteString("To: " + m.To + "\r\n")
+	sb.WriteString("Subject: " + m.Subject + "\r\n")
+	sb.WriteString("Date: " + time.Now().UTC().Format(time.RFC1123Z) + "\r\n")
+	sb.WriteString("MIME-Version: 1.0\r\n")
+	sb.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	sb.WriteString("\r\n")
+	sb.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	sb.WriteString("\r\n")
+	return []byte(sb.String())
+}
+
+/*******************************************************************************************
+ * Writer Mailer - stdout & file backends for local development
+ *******************************************************************************************/
+
+// writes every mail to the provided writer instead of delivering it
+type WriterMailer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	From string
+}
+
+// mailer that prints mail to stdout
+func NewStdoutMailer() *WriterMailer {
+	return &WriterMailer{w: os.Stdout, From: "noreply@localhost"}
+}
+
+// mailer that appends mail to the file at path, creating it if necessary
+func NewFileMailer(path string) (*WriterMailer, error) {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &WriterMailer{w: f, From: "noreply@localhost"}, nil
+}
+
+func (wm *WriterMailer) Send(mail *Mail) error {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	_, err := fmt.Fprintf(wm.w, "%s\r\n", mail.Format(wm.From))
+	return err
+}
+
+/*******************************************************************************************
+ * SMTP Mailer
+ *******************************************************************************************/
+
+// delivers mail through an SMTP server
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (sm *SMTPMailer) Send(mail *Mail) error {
+	var auth smtp.Auth
+	if sm.Username != "" {
+		auth = smtp.PlainAuth("", sm.Username, sm.Password, sm.Host)
+	}
+
+	return smtp.SendMail(sm.Host+":"+sm.Port, auth, sm.From, []string{mail.To}, mail.Format(sm.From))
+}
+
+// picks a mailer backend from env vars
+//
+//	MAIL_BACKEND=smtp   uses SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD & MAIL_FROM
+//	MAIL_BACKEND=file   appends mail to MAIL_FILE
+//	anything else       prints mail to stdout
+func NewMailerFromEnv() (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "noreply@localhost"
+	}
+
+	switch os.Getenv("MAIL_BACKEND") {
+	case "smtp":
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		if os.Getenv("SMTP_HOST") == "" {
+			return nil, fmt.Errorf("SMTP_HOST must be set for the smtp mail backend")
+		}
+		return &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}, nil
+	case "file":
+		path := os.Getenv("MAIL_FILE")
+		if path == "" {
+			path = "./tmp/mail.log"
+		}
+		fm, err := NewFileMailer(path)
+		if err != nil {
+			return nil, err
+		}
+		fm.From = from
+		return fm, nil
+	default:
+		sm := NewStdoutMailer()
+		sm.From = from
+		return sm, nil
+	}
+}
+
+/*******************************************************************************************
+ * Mail Templates
+ *******************************************************************************************/
+
+// the mail sent after registering (or changing email) with a link to verify the address
+func NewVerificationMail(account *Account, link string) *Mail {
+	return &Mail{
+		To:      account.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nPlease verify your email address by following the link below:\n\n%s\n\nThe link expires in %d hours. If you didn't create an account you can ignore this email.\n",
+			account.Username, link, VERIFICATION_TOKEN_HOURS,
+		),
+	}
+}
+
+// the mail sent when a password reset is requested
+func NewPasswordResetMail(account *Account, link string) *Mail {
+	return &Mail{
+		To:      account.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nSomeone requested a password reset for your account. Follow the link below to choose a new password:\n\n%s\n\nThe link can be used once and expires in %d minutes. If you didn't request this you can ignore this email, your password won't change.\n",
+			account.Username, link, PASSWORD_RESET_MINUTES,
+		),
+	}
+}
+
+// the notice sent to the old address when an account's email is changed
+func NewEmailChangedMail(account *Account, oldEmail string) *Mail {
+	return &Mail{
+		To:      oldEmail,
+		Subject: "Your email address was changed",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nThe email address on your account was just changed to %s. If you didn't do this, reset your password and contact us right away.\n",
+			account.Username, account.Email,
+		),
+	}
+}
@@ -0,0 +1,1351 @@
+// memquery.go
+//
+// A small evaluator for the subset of the MongoDB query, update and aggregation languages
+// the builder package produces. It backs the MemoryStore so handlers and pipelines behave the
+// same with or without a database. Documents are held as bson.M, specs (filters, pipelines,
+// updates) are normalized to primitive.D so key order is preserved where mongo cares about it.
+
+package types
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// marks a field that does not exist, as opposed to one explicitly set to null
+type missingValue struct{}
+
+var missing = missingValue{}
+
+// converts any go value (structs, bson.D, time.Time...) into a document in bson.M form
+func toBsonDoc(v any) (bson.M, error) {
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := bson.M{}
+	err = bson.Unmarshal(raw, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// converts any go value into its ordered bson representation (documents become primitive.D)
+func toBsonSpec(v any) (any, error) {
+	raw, err := bson.Marshal(bson.M{"v": v})
+	if err != nil {
+		return nil, err
+	}
+
+	wrapper := bson.D{}
+	err = bson.Unmarshal(raw, &wrapper)
+	if err != nil {
+		return nil, err
+	}
+
+	return wrapper[0].Value, nil
+}
+
+// same as toBsonSpec but for values that must be documents (filters, updates)
+func toBsonSpecDoc(v any) (bson.D, error) {
+	if v == nil {
+		return bson.D{}, nil
+	}
+
+	spec, err := toBsonSpec(v)
+	if err != nil {
+		return nil, err
+	}
+
+	d, ok := spec.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("expected a document, got %T", spec)
+	}
+
+	return d, nil
+}
+
+// decodes a stored document into the given result pointer
+func decodeDoc(doc bson.M, result any) error {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, result)
+}
+
+// deep copies a document value so stored documents are never aliased by callers
+func cloneValue(v any) any {
+	switch t := v.(type) {
+	case bson.M:
+		c := make(bson.M, len(t))
+		for k, val := range t {
+			c[k] = cloneValue(val)
+		}
+		return c
+	case bson.D:
+		return dToM(t)
+	case bson.A:
+		c := make(bson.A, len(t))
+		for i, val := range t {
+			c[i] = cloneValue(val)
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+// converts an ordered spec document into a document value
+func dToM(d bson.D) bson.M {
+	m := make(bson.M, len(d))
+	for _, e := range d {
+		m[e.Key] = cloneValue(e.Value)
+	}
+	return m
+}
+
+func isOperatorDoc(v any) (bson.D, bool) {
+	d, ok := v.(bson.D)
+	if !ok || len(d) == 0 {
+		return nil, false
+	}
+	return d, strings.HasPrefix(d[0].Key, "$")
+}
+
+/*******************************************************************************************
+ * Paths
+ *******************************************************************************************/
+
+// resolves a dotted path on a value, traversing arrays of documents the same way mongo does.
+// returns missing if the path does not exist
+func getPath(v any, path string) any {
+	if path == "" {
+		return v
+	}
+
+	head, rest, _ := strings.Cut(path, ".")
+
+	switch t := v.(type) {
+	case bson.M:
+		next, ok := t[head]
+		if !ok {
+			return missing
+		}
+		if rest == "" {
+			return next
+		}
+		return getPath(next, rest)
+	case bson.A:
+		out := bson.A{}
+		for _, elem := range t {
+			if _, isDoc := elem.(bson.M); !isDoc {
+				continue
+			}
+			r := getPath(elem, path)
+			if r != missing {
+				out = append(out, r)
+			}
+		}
+		return out
+	default:
+		return missing
+	}
+}
+
+// sets a dotted path on a document, applying to every element when an array of documents is crossed
+func setPath(doc bson.M, path string, value any) {
+	head, rest, _ := strings.Cut(path, ".")
+
+	if rest == "" {
+		if value == missing {
+			delete(doc, head)
+			return
+		}
+		doc[head] = value
+		return
+	}
+
+	switch next := doc[head].(type) {
+	case bson.M:
+		setPath(next, rest, value)
+	case bson.A:
+		for _, elem := range next {
+			if sub, ok := elem.(bson.M); ok {
+				setPath(sub, rest, value)
+			}
+		}
+	default:
+		if value == missing {
+			return
+		}
+		sub := bson.M{}
+		setPath(sub, rest, value)
+		doc[head] = sub
+	}
+}
+
+// removes a dotted path from a document, descending into arrays of documents
+func unsetPath(doc bson.M, path string) {
+	setPath(doc, path, missing)
+}
+
+/*******************************************************************************************
+ * Comparison
+ *******************************************************************************************/
+
+// canonical bson type ordering used when comparing values of different types
+func typeRank(v any) int {
+	switch v.(type) {
+	case missingValue, nil, primitive.Null, primitive.Undefined:
+		return 1
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return 2
+	case string, primitive.Symbol:
+		return 3
+	case bson.M, bson.D:
+		return 4
+	case bson.A:
+		return 5
+	case primitive.Binary:
+		return 6
+	case primitive.ObjectID:
+		return 7
+	case bool:
+		return 8
+	case primitive.DateTime, time.Time:
+		return 9
+	case primitive.Timestamp:
+		return 10
+	case primitive.Regex:
+		return 11
+	default:
+		return 12
+	}
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
+
+func toInt(v any) int {
+	f, ok := toFloat(v)
+	if !ok {
+		return 0
+	}
+	return int(f)
+}
+
+func toDateTime(v any) primitive.DateTime {
+	switch t := v.(type) {
+	case primitive.DateTime:
+		return t
+	case time.Time:
+		return primitive.NewDateTimeFromTime(t)
+	}
+	return 0
+}
+
+func cmpInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compares two values using mongo's ordering rules, returns -1, 0 or 1
+func compareValues(a, b any) int {
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		return cmpInt(ra, rb)
+	}
+
+	switch ra {
+	case 1:
+		return 0
+	case 2:
+		fa, _ := toFloat(a)
+		fb, _ := toFloat(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	case 3:
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	case 4:
+		return compareDocs(a, b)
+	case 5:
+		aa, ba := a.(bson.A), b.(bson.A)
+		for i := 0; i < len(aa) && i < len(ba); i++ {
+			if c := compareValues(aa[i], ba[i]); c != 0 {
+				return c
+			}
+		}
+		return cmpInt(len(aa), len(ba))
+	case 6:
+		return bytes.Compare(a.(primitive.Binary).Data, b.(primitive.Binary).Data)
+	case 7:
+		oa, ob := a.(primitive.ObjectID), b.(primitive.ObjectID)
+		return bytes.Compare(oa[:], ob[:])
+	case 8:
+		ba, bb := a.(bool), b.(bool)
+		if ba == bb {
+			return 0
+		}
+		if !ba {
+			return -1
+		}
+		return 1
+	case 9:
+		da, db := toDateTime(a), toDateTime(b)
+		switch {
+		case da < db:
+			return -1
+		case da > db:
+			return 1
+		}
+		return 0
+	}
+
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func compareDocs(a, b any) int {
+	ma, mb := a, b
+	if d, ok := a.(bson.D); ok {
+		ma = dToM(d)
+	}
+	if d, ok := b.(bson.D); ok {
+		mb = dToM(d)
+	}
+
+	am, bm := ma.(bson.M), mb.(bson.M)
+	keys := map[string]struct{}{}
+	for k := range am {
+		keys[k] = struct{}{}
+	}
+	for k := range bm {
+		keys[k] = struct{}{}
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	for _, k := range sorted {
+		av, ok := am[k]
+		if !ok {
+			av = missing
+		}
+		bv, ok := bm[k]
+		if !ok {
+			bv = missing
+		}
+		if c := compareValues(av, bv); c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+func valuesEqual(a, b any) bool {
+	return compareValues(a, b) == 0
+}
+
+/*******************************************************************************************
+ * Query Matching
+ *******************************************************************************************/
+
+// does the document satisfy the filter
+func matchDoc(doc bson.M, filter bson.D) bool {
+	for _, e := range filter {
+		switch e.Key {
+		case "$or":
+			if !matchAny(doc, e.Value) {
+				return false
+			}
+		case "$and":
+			for _, sub := range asArray(e.Value) {
+				if d, ok := sub.(bson.D); ok && !matchDoc(doc, d) {
+					return false
+				}
+			}
+		case "$nor":
+			if matchAny(doc, e.Value) {
+				return false
+			}
+		case "$expr":
+			if !truthy(evalExpr(doc, e.Value, nil)) {
+				return false
+			}
+		default:
+			if !matchField(getPath(doc, e.Key), e.Value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func matchAny(doc bson.M, clauses any) bool {
+	for _, sub := range asArray(clauses) {
+		if d, ok := sub.(bson.D); ok && matchDoc(doc, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// the values a field condition is tested against - arrays match on any element or as a whole
+func candidates(v any) []any {
+	if arr, ok := v.(bson.A); ok {
+		out := make([]any, 0, len(arr)+1)
+		out = append(out, arr...)
+		return append(out, arr)
+	}
+	return []any{v}
+}
+
+func matchField(value any, cond any) bool {
+	ops, isOps := isOperatorDoc(cond)
+	if !isOps {
+		return matchEquals(value, cond)
+	}
+
+	for _, op := range ops {
+		if !matchOperator(value, op.Key, op.Value, ops) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchEquals(value any, want any) bool {
+	if re, ok := want.(primitive.Regex); ok {
+		return matchRegex(value, re.Pattern, re.Options)
+	}
+
+	if want == nil {
+		if value == missing || value == nil {
+			return true
+		}
+	}
+
+	for _, c := range candidates(value) {
+		if valuesEqual(c, want) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchRegex(value any, pattern, options string) bool {
+	flags := ""
+	if strings.Contains(options, "i") {
+		flags += "i"
+	}
+	if strings.Contains(options, "m") {
+		flags += "m"
+	}
+	if strings.Contains(options, "s") {
+		flags += "s"
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+
+	rxp, err := regexp.Compile(pattern)
+	if err != nil {
+		return false
+	}
+
+	for _, c := range candidates(value) {
+		if s, ok := c.(string); ok && rxp.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchOperator(value any, op string, arg any, siblings bson.D) bool {
+	switch op {
+	case "$eq":
+		return matchEquals(value, arg)
+	case "$ne":
+		return !matchEquals(value, arg)
+	case "$gt", "$gte", "$lt", "$lte":
+		for _, c := range candidates(value) {
+			if c == missing || typeRank(c) != typeRank(arg) {
+				continue
+			}
+			cmp := compareValues(c, arg)
+			if (op == "$gt" && cmp > 0) || (op == "$gte" && cmp >= 0) || (op == "$lt" && cmp < 0) || (op == "$lte" && cmp <= 0) {
+				return true
+			}
+		}
+		return false
+	case "$in":
+		for _, want := range asArray(arg) {
+			if matchEquals(value, want) {
+				return true
+			}
+		}
+		return false
+	case "$nin":
+		for _, want := range asArray(arg) {
+			if matchEquals(value, want) {
+				return false
+			}
+		}
+		return true
+	case "$exists":
+		return (value != missing) == truthy(arg)
+	case "$regex":
+		options := ""
+		for _, s := range siblings {
+			if s.Key == "$options" {
+				options, _ = s.Value.(string)
+			}
+		}
+		if re, ok := arg.(primitive.Regex); ok {
+			return matchRegex(value, re.Pattern, re.Options+options)
+		}
+		pattern, _ := arg.(string)
+		return matchRegex(value, pattern, options)
+	case "$options":
+		return true
+	case "$not":
+		return !matchField(value, arg)
+	case "$size":
+		arr, ok := value.(bson.A)
+		return ok && len(arr) == toInt(arg)
+	case "$elemMatch":
+		arr, ok := value.(bson.A)
+		if !ok {
+			return false
+		}
+		sub, _ := arg.(bson.D)
+		for _, elem := range arr {
+			if d, isDoc := elem.(bson.M); isDoc && matchDoc(d, sub) {
+				return true
+			}
+			if _, isOps := isOperatorDoc(sub); isOps && matchField(elem, sub) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return false
+}
+
+func asArray(v any) bson.A {
+	switch t := v.(type) {
+	case bson.A:
+		return t
+	case []any:
+		return bson.A(t)
+	}
+	return bson.A{v}
+}
+
+/*******************************************************************************************
+ * Expressions
+ *******************************************************************************************/
+
+// mongo truthiness - false, null, missing and zero are falsy, everything else is truthy
+func truthy(v any) bool {
+	switch t := v.(type) {
+	case missingValue, nil, primitive.Null, primitive.Undefined:
+		return false
+	case bool:
+		return t
+	}
+	if f, ok := toFloat(v); ok {
+		return f != 0
+	}
+	return true
+}
+
+// evaluates an aggregation expression against a document. vars holds $$ variables
+func evalExpr(doc bson.M, expr any, vars map[string]any) any {
+	switch t := expr.(type) {
+	case string:
+		if strings.HasPrefix(t, "$$") {
+			name, rest, _ := strings.Cut(t[2:], ".")
+			var base any
+			switch name {
+			case "ROOT", "CURRENT":
+				base = doc
+			case "REMOVE":
+				return missing
+			default:
+				v, ok := vars[name]
+				if !ok {
+					return missing
+				}
+				base = v
+			}
+			return getPath(base, rest)
+		}
+		if strings.HasPrefix(t, "$") {
+			return getPath(doc, t[1:])
+		}
+		return t
+	case bson.A:
+		out := make(bson.A, 0, len(t))
+		for _, e := range t {
+			out = append(out, evalExpr(doc, e, vars))
+		}
+		return out
+	case bson.D:
+		if ops, isOps := isOperatorDoc(t); isOps && len(ops) == 1 {
+			return evalOperator(doc, ops[0].Key, ops[0].Value, vars)
+		}
+		out := bson.M{}
+		for _, e := range t {
+			v := evalExpr(doc, e.Value, vars)
+			if v != missing {
+				out[e.Key] = v
+			}
+		}
+		return out
+	}
+	return expr
+}
+
+// evaluates the arguments of an operator that takes an array of expressions
+func evalArgs(doc bson.M, arg any, vars map[string]any) bson.A {
+	args := asArray(arg)
+	out := make(bson.A, len(args))
+	for i, a := range args {
+		out[i] = evalExpr(doc, a, vars)
+	}
+	return out
+}
+
+// fetches a named argument of an operator that takes a document of arguments
+func namedArg(arg any, name string) any {
+	if d, ok := arg.(bson.D); ok {
+		for _, e := range d {
+			if e.Key == name {
+				return e.Value
+			}
+		}
+	}
+	return missing
+}
+
+func evalOperator(doc bson.M, op string, arg any, vars map[string]any) any {
+	switch op {
+	case "$literal":
+		return arg
+	case "$arrayElemAt":
+		args := evalArgs(doc, arg, vars)
+		arr, ok := args[0].(bson.A)
+		if !ok || len(args) < 2 {
+			return missing
+		}
+		idx := toInt(args[1])
+		if idx < 0 {
+			idx += len(arr)
+		}
+		if idx < 0 || idx >= len(arr) {
+			return missing
+		}
+		return arr[idx]
+	case "$size":
+		v := evalExpr(doc, unwrapSingle(arg), vars)
+		if arr, ok := v.(bson.A); ok {
+			return int32(len(arr))
+		}
+		return int32(0)
+	case "$cond":
+		var cond, then, otherwise any
+		if d, ok := arg.(bson.D); ok {
+			cond, then, otherwise = namedArg(d, "if"), namedArg(d, "then"), namedArg(d, "else")
+		} else {
+			args := asArray(arg)
+			if len(args) < 3 {
+				return missing
+			}
+			cond, then, otherwise = args[0], args[1], args[2]
+		}
+		if truthy(evalExpr(doc, cond, vars)) {
+			return evalExpr(doc, then, vars)
+		}
+		return evalExpr(doc, otherwise, vars)
+	case "$ifNull":
+		args := evalArgs(doc, arg, vars)
+		for _, a := range args[:len(args)-1] {
+			if a != missing && a != nil {
+				return a
+			}
+		}
+		return args[len(args)-1]
+	case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte":
+		args := evalArgs(doc, arg, vars)
+		if len(args) < 2 {
+			return false
+		}
+		cmp := compareValues(args[0], args[1])
+		switch op {
+		case "$eq":
+			return cmp == 0
+		case "$ne":
+			return cmp != 0
+		case "$gt":
+			return cmp > 0
+		case "$gte":
+			return cmp >= 0
+		case "$lt":
+			return cmp < 0
+		}
+		return cmp <= 0
+	case "$and":
+		for _, a := range evalArgs(doc, arg, vars) {
+			if !truthy(a) {
+				return false
+			}
+		}
+		return true
+	case "$or":
+		for _, a := range evalArgs(doc, arg, vars) {
+			if truthy(a) {
+				return true
+			}
+		}
+		return false
+	case "$not":
+		return !truthy(evalExpr(doc, unwrapSingle(arg), vars))
+	case "$in":
+		args := evalArgs(doc, arg, vars)
+		if len(args) < 2 {
+			return false
+		}
+		arr, _ := args[1].(bson.A)
+		for _, v := range arr {
+			if valuesEqual(v, args[0]) {
+				return true
+			}
+		}
+		return false
+	case "$isArray":
+		_, ok := evalExpr(doc, unwrapSingle(arg), vars).(bson.A)
+		return ok
+	case "$concat":
+		var sb strings.Builder
+		for _, a := range evalArgs(doc, arg, vars) {
+			s, ok := a.(string)
+			if !ok {
+				return nil
+			}
+			sb.WriteString(s)
+		}
+		return sb.String()
+	case "$concatArrays":
+		out := bson.A{}
+		for _, a := range evalArgs(doc, arg, vars) {
+			if arr, ok := a.(bson.A); ok {
+				out = append(out, arr...)
+			}
+		}
+		return out
+	case "$toString":
+		v := evalExpr(doc, unwrapSingle(arg), vars)
+		if oid, ok := v.(primitive.ObjectID); ok {
+			return oid.Hex()
+		}
+		return fmt.Sprint(v)
+	case "$filter", "$map":
+		input, _ := evalExpr(doc, namedArg(arg, "input"), vars).(bson.A)
+		as, _ := namedArg(arg, "as").(string)
+		if as == "" {
+			as = "this"
+		}
+		scoped := map[string]any{}
+		for k, v := range vars {
+			scoped[k] = v
+		}
+		out := bson.A{}
+		for _, elem := range input {
+			scoped[as] = elem
+			if op == "$filter" {
+				if truthy(evalExpr(doc, namedArg(arg, "cond"), scoped)) {
+					out = append(out, elem)
+				}
+				continue
+			}
+			out = append(out, evalExpr(doc, namedArg(arg, "in"), scoped))
+		}
+		return out
+	case "$sum", "$max", "$min":
+		values := evalArgs(doc, arg, vars)
+		if len(values) == 1 {
+			if arr, ok := values[0].(bson.A); ok {
+				values = arr
+			}
+		}
+		return accumulate(op, values)
+	}
+
+	return missing
+}
+
+// operators like $size accept either an expression or a single element array of one
+func unwrapSingle(arg any) any {
+	if arr, ok := arg.(bson.A); ok && len(arr) == 1 {
+		return arr[0]
+	}
+	return arg
+}
+
+func accumulate(op string, values bson.A) any {
+	switch op {
+	case "$sum":
+		total, isFloat := 0.0, false
+		for _, v := range values {
+			if f, ok := toFloat(v); ok {
+				total += f
+				if _, ok := v.(float64); ok {
+					isFloat = true
+				}
+			}
+		}
+		if isFloat || total != math.Trunc(total) {
+			return total
+		}
+		return int64(total)
+	case "$max", "$min":
+		var best any = missing
+		for _, v := range values {
+			if v == missing || v == nil {
+				continue
+			}
+			if best == missing {
+				best = v
+				continue
+			}
+			c := compareValues(v, best)
+			if (op == "$max" && c > 0) || (op == "$min" && c < 0) {
+				best = v
+			}
+		}
+		if best == missing {
+			return nil
+		}
+		return best
+	}
+	return missing
+}
+
+/*******************************************************************************************
+ * Aggregation
+ *******************************************************************************************/
+
+// resolves foreign collections for $lookup stages
+type collectionSource func(name string) []bson.M
+
+// runs a pipeline over the given documents
+func runPipeline(docs []bson.M, pipeline bson.A, source collectionSource) ([]bson.M, error) {
+	for _, raw := range pipeline {
+		stage, ok := raw.(bson.D)
+		if !ok || len(stage) != 1 {
+			return nil, fmt.Errorf("invalid pipeline stage %+v", raw)
+		}
+
+		var err error
+		docs, err = runStage(docs, stage[0].Key, stage[0].Value, source)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+func runStage(docs []bson.M, name string, spec any, source collectionSource) ([]bson.M, error) {
+	switch name {
+	case "$match":
+		filter, _ := spec.(bson.D)
+		out := []bson.M{}
+		for _, d := range docs {
+			if matchDoc(d, filter) {
+				out = append(out, d)
+			}
+		}
+		return out, nil
+
+	case "$sort":
+		keys, _ := spec.(bson.D)
+		sort.SliceStable(docs, func(i, j int) bool {
+			for _, k := range keys {
+				c := compareValues(getPath(docs[i], k.Key), getPath(docs[j], k.Key))
+				if c == 0 {
+					continue
+				}
+				if toInt(k.Value) < 0 {
+					return c > 0
+				}
+				return c < 0
+			}
+			return false
+		})
+		return docs, nil
+
+	case "$skip":
+		n := toInt(spec)
+		if n >= len(docs) {
+			return []bson.M{}, nil
+		}
+		return docs[n:], nil
+
+	case "$limit":
+		n := toInt(spec)
+		if n < len(docs) {
+			return docs[:n], nil
+		}
+		return docs, nil
+
+	case "$addFields", "$set":
+		fields, _ := spec.(bson.D)
+		for _, d := range docs {
+			for _, f := range fields {
+				setPath(d, f.Key, evalExpr(d, f.Value, nil))
+			}
+		}
+		return docs, nil
+
+	case "$unset":
+		for _, d := range docs {
+			for _, p := range asArray(spec) {
+				if s, ok := p.(string); ok {
+					unsetPath(d, s)
+				}
+			}
+		}
+		return docs, nil
+
+	case "$project":
+		fields, _ := spec.(bson.D)
+		out := make([]bson.M, 0, len(docs))
+		for _, d := range docs {
+			out = append(out, project(d, fields))
+		}
+		return out, nil
+
+	case "$lookup":
+		return lookup(docs, spec, source)
+
+	case "$unwind":
+		path, preserve := "", false
+		if s, ok := spec.(string); ok {
+			path = s
+		} else {
+			path, _ = namedArg(spec, "path").(string)
+			preserve = truthy(namedArg(spec, "preserveNullAndEmptyArrays"))
+		}
+		path = strings.TrimPrefix(path, "$")
+		out := []bson.M{}
+		for _, d := range docs {
+			arr, ok := getPath(d, path).(bson.A)
+			if !ok || len(arr) == 0 {
+				if preserve || (!ok && getPath(d, path) != missing && getPath(d, path) != nil) {
+					out = append(out, d)
+				}
+				continue
+			}
+			for _, elem := range arr {
+				c := cloneValue(d).(bson.M)
+				setPath(c, path, elem)
+				out = append(out, c)
+			}
+		}
+		return out, nil
+
+	case "$group":
+		return group(docs, spec)
+
+	case "$count":
+		field, _ := spec.(string)
+		if len(docs) == 0 {
+			return []bson.M{}, nil
+		}
+		return []bson.M{{field: int32(len(docs))}}, nil
+
+	case "$replaceRoot":
+		out := make([]bson.M, 0, len(docs))
+		for _, d := range docs {
+			if root, ok := evalExpr(d, namedArg(spec, "newRoot"), nil).(bson.M); ok {
+				out = append(out, root)
+			}
+		}
+		return out, nil
+	}
+
+	return nil, fmt.Errorf("unsupported pipeline stage %s", name)
+}
+
+// $project in both inclusion and exclusion modes, with computed fields
+func project(doc bson.M, fields bson.D) bson.M {
+	exclusion := true
+	for _, f := range fields {
+		if f.Key == "_id" {
+			continue
+		}
+		if isInclusionFlag(f.Value) || !isFlag(f.Value) {
+			exclusion = false
+		}
+	}
+
+	if exclusion {
+		out := cloneValue(doc).(bson.M)
+		for _, f := range fields {
+			unsetPath(out, f.Key)
+		}
+		return out
+	}
+
+	out := bson.M{}
+	if id, ok := doc["_id"]; ok {
+		out["_id"] = id
+	}
+
+	for _, f := range fields {
+		switch {
+		case isFlag(f.Value) && !isInclusionFlag(f.Value):
+			unsetPath(out, f.Key)
+		case isFlag(f.Value):
+			if v := getPath(doc, f.Key); v != missing {
+				setPath(out, f.Key, cloneValue(v))
+			}
+		default:
+			if v := evalExpr(doc, f.Value, nil); v != missing {
+				setPath(out, f.Key, v)
+			}
+		}
+	}
+
+	return out
+}
+
+func isFlag(v any) bool {
+	if _, ok := v.(bool); ok {
+		return true
+	}
+	_, ok := toFloat(v)
+	return ok
+}
+
+func isInclusionFlag(v any) bool {
+	return isFlag(v) && truthy(v)
+}
+
+// $lookup supporting localField/foreignField joins with an optional sub pipeline
+func lookup(docs []bson.M, spec any, source collectionSource) ([]bson.M, error) {
+	from, _ := namedArg(spec, "from").(string)
+	localField, _ := namedArg(spec, "localField").(string)
+	foreignField, _ := namedArg(spec, "foreignField").(string)
+	as, _ := namedArg(spec, "as").(string)
+	pipeline, _ := namedArg(spec, "pipeline").(bson.A)
+
+	foreign := source(from)
+
+	for _, d := range docs {
+		matched := []bson.M{}
+
+		if localField != "" && foreignField != "" {
+			local := getPath(d, localField)
+			for _, f := range foreign {
+				if lookupMatches(local, getPath(f, foreignField)) {
+					matched = append(matched, cloneValue(f).(bson.M))
+				}
+			}
+		} else {
+			for _, f := range foreign {
+				matched = append(matched, cloneValue(f).(bson.M))
+			}
+		}
+
+		if len(pipeline) > 0 {
+			var err error
+			matched, err = runPipeline(matched, pipeline, source)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		joined := make(bson.A, len(matched))
+		for i, m := range matched {
+			joined[i] = m
+		}
+		setPath(d, as, joined)
+	}
+
+	return docs, nil
+}
+
+func lookupMatches(local, foreign any) bool {
+	for _, l := range candidates(local) {
+		if _, isArr := l.(bson.A); isArr {
+			continue
+		}
+		for _, f := range candidates(foreign) {
+			if l == missing {
+				l = nil
+			}
+			if f == missing {
+				f = nil
+			}
+			if valuesEqual(l, f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// $group with the common accumulators
+func group(docs []bson.M, spec any) ([]bson.M, error) {
+	fields, _ := spec.(bson.D)
+	var idExpr any
+	for _, f := range fields {
+		if f.Key == "_id" {
+			idExpr = f.Value
+		}
+	}
+
+	type bucket struct {
+		id   any
+		docs []bson.M
+	}
+	buckets := []*bucket{}
+
+	for _, d := range docs {
+		id := evalExpr(d, idExpr, nil)
+		if id == missing {
+			id = nil
+		}
+		var found *bucket
+		for _, b := range buckets {
+			if valuesEqual(b.id, id) {
+				found = b
+				break
+			}
+		}
+		if found == nil {
+			found = &bucket{id: id}
+			buckets = append(buckets, found)
+		}
+		found.docs = append(found.docs, d)
+	}
+
+	out := make([]bson.M, 0, len(buckets))
+	for _, b := range buckets {
+		result := bson.M{"_id": b.id}
+
+		for _, f := range fields {
+			if f.Key == "_id" {
+				continue
+			}
+			acc, ok := f.Value.(bson.D)
+			if !ok || len(acc) != 1 {
+				return nil, fmt.Errorf("invalid accumulator for %s", f.Key)
+			}
+
+			values := bson.A{}
+			for _, d := range b.docs {
+				values = append(values, evalExpr(d, acc[0].Value, nil))
+			}
+
+			switch acc[0].Key {
+			case "$sum", "$max", "$min":
+				result[f.Key] = accumulate(acc[0].Key, values)
+			case "$avg":
+				total, n := 0.0, 0
+				for _, v := range values {
+					if fv, ok := toFloat(v); ok {
+						total += fv
+						n++
+					}
+				}
+				if n == 0 {
+					result[f.Key] = nil
+				} else {
+					result[f.Key] = total / float64(n)
+				}
+			case "$first", "$last":
+				v := values[0]
+				if acc[0].Key == "$last" {
+					v = values[len(values)-1]
+				}
+				// like mongo a missing field comes out as null
+				if v == missing {
+					v = nil
+				}
+				result[f.Key] = v
+			case "$push":
+				pushed := bson.A{}
+				for _, v := range values {
+					if v != missing {
+						pushed = append(pushed, v)
+					}
+				}
+				result[f.Key] = pushed
+			case "$addToSet":
+				set := bson.A{}
+				for _, v := range values {
+					if v != missing && !arrayContains(set, v) {
+						set = append(set, v)
+					}
+				}
+				result[f.Key] = set
+			default:
+				return nil, fmt.Errorf("unsupported accumulator %s", acc[0].Key)
+			}
+		}
+
+		out = append(out, result)
+	}
+
+	return out, nil
+}
+
+func arrayContains(arr bson.A, v any) bool {
+	for _, e := range arr {
+		if valuesEqual(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+/*******************************************************************************************
+ * Updates
+ *******************************************************************************************/
+
+// applies update operators to a document in place
+func applyUpdate(doc bson.M, update bson.D) error {
+	for _, op := range update {
+		fields, ok := op.Value.(bson.D)
+		if !ok {
+			return fmt.Errorf("invalid update operator %s", op.Key)
+		}
+
+		for _, f := range fields {
+			value := cloneValue(f.Value)
+
+			switch op.Key {
+			case "$set":
+				setPath(doc, f.Key, value)
+			case "$unset":
+				unsetPath(doc, f.Key)
+			case "$inc":
+				current := getPath(doc, f.Key)
+				cf, _ := toFloat(current)
+				inc, _ := toFloat(value)
+				_, curInt := current.(int64)
+				_, incInt := value.(int64)
+				_, cur32 := current.(int32)
+				_, inc32 := value.(int32)
+				switch {
+				case (curInt || cur32 || current == missing) && (incInt || inc32):
+					if cur32 && inc32 {
+						setPath(doc, f.Key, int32(cf+inc))
+					} else {
+						setPath(doc, f.Key, int64(cf+inc))
+					}
+				default:
+					setPath(doc, f.Key, cf+inc)
+				}
+			case "$push", "$addToSet":
+				arr, _ := getPath(doc, f.Key).(bson.A)
+				items := bson.A{value}
+				if each := namedArg(f.Value, "$each"); each != missing {
+					items = asArray(cloneValue(each))
+				}
+				for _, item := range items {
+					if op.Key == "$addToSet" && arrayContains(arr, item) {
+						continue
+					}
+					arr = append(arr, item)
+				}
+				setPath(doc, f.Key, arr)
+			case "$pull":
+				arr, ok := getPath(doc, f.Key).(bson.A)
+				if !ok {
+					continue
+				}
+				kept := bson.A{}
+				for _, item := range arr {
+					if pullMatches(item, f.Value) {
+						continue
+					}
+					kept = append(kept, item)
+				}
+				setPath(doc, f.Key, kept)
+			default:
+				return fmt.Errorf("unsupported update operator %s", op.Key)
+			}
+		}
+	}
+	return nil
+}
+
+func pullMatches(item any, cond any) bool {
+	if _, isOps := isOperatorDoc(cond); isOps {
+		return matchField(item, cond)
+	}
+	if d, ok := cond.(bson.D); ok {
+		if m, isDoc := item.(bson.M); isDoc {
+			return matchDoc(m, d)
+		}
+	}
+	return valuesEqual(item, cond)
+}
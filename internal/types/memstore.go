@@ -0,0 +1,831 @@
+package types
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// In-memory data store
+//
+// MemoryStore implements the Repository entirely in process memory. Documents are kept in their
+// bson form per collection so filters, updates and aggregation pipelines built for mongo run
+// unchanged against it (see memquery.go). It's intended for tests and local development where
+// a database isn't available, nothing is persisted.
+type MemoryStore struct {
+	mu          sync.RWMutex
+	collections map[string][]bson.M
+	archives    map[primitive.ObjectID][]byte // data export archives by the _id of their export
+	StartedAt   *time.Time
+}
+
+// creates a new empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	ts := time.Now().UTC()
+	return &MemoryStore{
+		collections: map[string][]bson.M{},
+		archives:    map[primitive.ObjectID][]byte{},
+		StartedAt:   &ts,
+	}
+}
+
+// returns the documents of a collection - must be called while holding the lock
+func (m *MemoryStore) collection(col string) []bson.M {
+	return m.collections[col]
+}
+
+// finds the documents matching the filter and returns copies of them
+func (m *MemoryStore) findDocs(filter any, col string) ([]bson.M, error) {
+	spec, err := toBsonSpecDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	found := []bson.M{}
+	for _, doc := range m.collection(col) {
+		if matchDoc(doc, spec) {
+			found = append(found, cloneValue(doc).(bson.M))
+		}
+	}
+
+	return found, nil
+}
+
+/*******************************************************************************************
+ * Generic Operations
+ *******************************************************************************************/
+
+// Run Aggregation
+// - accepts a string of the collection name
+// - accepts a pipeline, anything that marshals into a bson array of stages
+// - returns a slice of bson.M containing the results
+func (m *MemoryStore) RunAggregation(col string, pipe any) ([]bson.M, error) {
+	spec, err := toBsonSpec(pipe)
+	if err != nil {
+		return nil, err
+	}
+
+	stages, ok := spec.(bson.A)
+	if !ok {
+		return nil, fmt.Errorf("pipeline must be an array, got %T", spec)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	docs := make([]bson.M, 0, len(m.collection(col)))
+	for _, doc := range m.collection(col) {
+		docs = append(docs, cloneValue(doc).(bson.M))
+	}
+
+	return runPipeline(docs, stages, m.collection)
+}
+
+// Count Results
+// - accepts a string of the collection name
+// - accepts a bson.D of the filter
+// - returns an int64 of the count
+func (m *MemoryStore) CountResults(col string, filter bson.D) int64 {
+	found, err := m.findDocs(filter, col)
+	if err != nil {
+		return 0
+	}
+	return int64(len(found))
+}
+
+// Find a Single Document
+// - returns mongo.ErrNoDocuments if nothing matched
+func (m *MemoryStore) FindSingle(filter bson.D, result any, col string) error {
+	found, err := m.findDocs(filter, col)
+	if err != nil {
+		return err
+	}
+
+	if len(found) == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return decodeDoc(found[0], result)
+}
+
+// Find Multiple Documents
+// - accepts a pointer to a slice the documents will be decoded into
+func (m *MemoryStore) FindMulti(filter bson.D, results any, col string) error {
+	found, err := m.findDocs(filter, col)
+	if err != nil {
+		return err
+	}
+
+	rv := reflect.ValueOf(results)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("results must be a pointer to a slice, got %T", results)
+	}
+
+	slice := reflect.MakeSlice(rv.Elem().Type(), 0, len(found))
+	for _, doc := range found {
+		elem := reflect.New(rv.Elem().Type().Elem())
+		if err := decodeDoc(doc, elem.Interface()); err != nil {
+			return err
+		}
+		slice = reflect.Append(slice, elem.Elem())
+	}
+
+	rv.Elem().Set(slice)
+	return nil
+}
+
+// Save a Single Document
+func (m *MemoryStore) SaveNewSingle(document any, col string) error {
+	return m.SaveNewMulti([]any{document}, col)
+}
+
+// Save Multiple Documents
+// documents without an _id are given one, as mongo would
+func (m *MemoryStore) SaveNewMulti(documents []any, col string) error {
+	docs := make([]bson.M, 0, len(documents))
+	for _, d := range documents {
+		doc, err := toBsonDoc(d)
+		if err != nil {
+			return err
+		}
+		if _, ok := doc["_id"]; !ok {
+			doc["_id"] = primitive.NewObjectID()
+		}
+		docs = append(docs, doc)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.collections[col] = append(m.collections[col], docs...)
+	return nil
+}
+
+// Replace a Single Document
+func (m *MemoryStore) ReplaceSingle(id primitive.ObjectID, document any, col string) error {
+	doc, err := toBsonDoc(document)
+	if err != nil {
+		return err
+	}
+	doc["_id"] = id
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, existing := range m.collection(col) {
+		if valuesEqual(existing["_id"], id) {
+			m.collections[col][i] = doc
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// Update Multiple Documents
+// - returns the number of modified documents
+func (m *MemoryStore) UpdateMulti(filter bson.D, update bson.D, col string) (int64, error) {
+	spec, err := toBsonSpecDoc(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	ops, err := toBsonSpecDoc(update)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	modified := int64(0)
+	for _, doc := range m.collection(col) {
+		if !matchDoc(doc, spec) {
+			continue
+		}
+		if err := applyUpdate(doc, ops); err != nil {
+			return modified, err
+		}
+		modified++
+	}
+
+	return modified, nil
+}
+
+// Upsert a Single Document
+// inserted documents start from the filter's plain field values, as mongo would
+func (m *MemoryStore) UpsertSingle(filter bson.D, update bson.D, col string) error {
+	spec, err := toBsonSpecDoc(filter)
+	if err != nil {
+		return err
+	}
+
+	ops, err := toBsonSpecDoc(update)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, doc := range m.collection(col) {
+		if matchDoc(doc, spec) {
+			return applyUpdate(doc, ops)
+		}
+	}
+
+	doc := bson.M{}
+	for _, f := range spec {
+		if _, operator := f.Value.(bson.D); operator || strings.HasPrefix(f.Key, "$") {
+			continue
+		}
+		setPath(doc, f.Key, cloneValue(f.Value))
+	}
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+
+	if err := applyUpdate(doc, ops); err != nil {
+		return err
+	}
+
+	m.collections[col] = append(m.collections[col], doc)
+	return nil
+}
+
+// updates the first document matching the filter and decodes it as it is after, the update and
+// the read happen under the same lock like mongo's findOneAndUpdate
+// - returns mongo.ErrNoDocuments if nothing matched
+func (m *MemoryStore) updateSingle(filter bson.D, update bson.D, result any, col string) error {
+	spec, err := toBsonSpecDoc(filter)
+	if err != nil {
+		return err
+	}
+
+	ops, err := toBsonSpecDoc(update)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, doc := range m.collection(col) {
+		if !matchDoc(doc, spec) {
+			continue
+		}
+		if err := applyUpdate(doc, ops); err != nil {
+			return err
+		}
+		return decodeDoc(doc, result)
+	}
+
+	return mongo.ErrNoDocuments
+}
+
+// Delete a single Document
+func (m *MemoryStore) DeleteSingle(id primitive.ObjectID, col string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, doc := range m.collection(col) {
+		if valuesEqual(doc["_id"], id) {
+			m.collections[col] = append(m.collections[col][:i], m.collections[col][i+1:]...)
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// Delete Multiple Documents
+// - returns the number of deleted documents
+func (m *MemoryStore) DeleteMulti(filter bson.D, col string) (int64, error) {
+	spec, err := toBsonSpecDoc(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := []bson.M{}
+	for _, doc := range m.collection(col) {
+		if !matchDoc(doc, spec) {
+			kept = append(kept, doc)
+		}
+	}
+
+	deleted := int64(len(m.collection(col)) - len(kept))
+	m.collections[col] = kept
+	return deleted, nil
+}
+
+// Cache Stats
+// - nothing is cached in memory so there's nothing to report
+func (m *MemoryStore) CacheStats() map[string]CacheStats {
+	return map[string]CacheStats{}
+}
+
+/*******************************************************************************************
+ * Article Operations
+ *******************************************************************************************/
+
+func (m *MemoryStore) FindArticleBySlug(slug string) (*Article, error) {
+	article := &Article{}
+
+	err := m.FindSingle(bson.D{{Key: "slug", Value: slug}}, article, "articles")
+	if err != nil {
+		return nil, err
+	}
+
+	return article, nil
+}
+
+func (m *MemoryStore) UpdateArticle(article *Article) error {
+	return m.ReplaceSingle(article.ID, article, "articles")
+}
+
+/*******************************************************************************************
+ * Board Operations
+ *******************************************************************************************/
+
+func (m *MemoryStore) FindAllBoards() ([]Board, error) {
+	boards := []Board{}
+
+	err := m.FindMulti(bson.D{}, &boards, "boards")
+	if err != nil {
+		return nil, err
+	}
+
+	return boards, nil
+}
+
+func (m *MemoryStore) FindBoardByShort(short string) (*Board, error) {
+	board := &Board{}
+
+	err := m.FindSingle(bson.D{{Key: "short", Value: short}}, board, "boards")
+	if err != nil {
+		return nil, err
+	}
+
+	return board, nil
+}
+
+func (m *MemoryStore) FindBoardByObjectID(id primitive.ObjectID) (*Board, error) {
+	board := &Board{}
+
+	err := m.FindSingle(bson.D{{Key: "_id", Value: id}}, board, "boards")
+	if err != nil {
+		return nil, err
+	}
+
+	return board, nil
+}
+
+func (m *MemoryStore) UpdateBoard(board *Board) error {
+	return m.ReplaceSingle(board.ID, board, "boards")
+}
+
+func (m *MemoryStore) NextPostNumber(board_id primitive.ObjectID) (uint64, error) {
+	update := bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "post_ref", Value: 1}}},
+		{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now().UTC()}}},
+	}
+
+	board := &Board{}
+	err := m.updateSingle(bson.D{{Key: "_id", Value: board_id}}, update, board, "boards")
+	if err != nil {
+		return 0, err
+	}
+
+	return board.PostRef, nil
+}
+
+/*******************************************************************************************
+ * Thread Operations
+ *******************************************************************************************/
+
+func (m *MemoryStore) FindThreadBySlug(slug string) (*Thread, error) {
+	thread := &Thread{}
+
+	err := m.FindSingle(bson.D{{Key: "slug", Value: slug}}, thread, "threads")
+	if err != nil {
+		return nil, err
+	}
+
+	return thread, nil
+}
+
+func (m *MemoryStore) UpdateThread(thread *Thread) error {
+	return m.ReplaceSingle(thread.ID, thread, "threads")
+}
+
+/*******************************************************************************************
+ * Account Operations
+ *******************************************************************************************/
+
+func (m *MemoryStore) FindAccountByUsernameOrEmail(username string, email string) (*Account, error) {
+	criteraTwo := email
+	if email == "" {
+		criteraTwo = username
+	}
+
+	account := &Account{}
+	filter := accountLookupFilter(username, criteraTwo)
+
+	err := m.FindSingle(filter, account, "accounts")
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+func (m *MemoryStore) FindAccountByID(id primitive.ObjectID) (*Account, error) {
+	account := &Account{}
+
+	err := m.FindSingle(bson.D{{Key: "_id", Value: id}}, account, "accounts")
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+func (m *MemoryStore) UpdateAccount(account *Account) error {
+	return m.ReplaceSingle(account.ID, account, "accounts")
+}
+
+func (m *MemoryStore) FindAccountHistory(account_id primitive.ObjectID) ([]*AccountHistory, error) {
+	history := []*AccountHistory{}
+
+	err := m.FindMulti(bson.D{{Key: "account_id", Value: account_id}}, &history, "account_history")
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].CreatedAt.After(*history[j].CreatedAt)
+	})
+
+	if len(history) > ACCOUNT_HISTORY_LIMIT {
+		history = history[:ACCOUNT_HISTORY_LIMIT]
+	}
+
+	return history, nil
+}
+
+func (m *MemoryStore) FindAccountFromSession(id string) (*Account, error) {
+	session, err := m.FindSession(id)
+	if err != nil {
+		return nil, err
+	}
+
+	account := &Account{}
+
+	err = m.FindSingle(bson.D{{Key: "_id", Value: session.AccountID}}, account, "accounts")
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+/*******************************************************************************************
+ * Board Role Operations
+ *******************************************************************************************/
+
+func (m *MemoryStore) FindAccountBoardRoles(account_id primitive.ObjectID) ([]*BoardRole, error) {
+	roles := []*BoardRole{}
+
+	err := m.FindMulti(bson.D{{Key: "account_id", Value: account_id}}, &roles, "board_roles")
+	if err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+/*******************************************************************************************
+ * Sanction Operations
+ *******************************************************************************************/
+
+func (m *MemoryStore) FindAccountSanctions(account_id primitive.ObjectID) ([]*Sanction, error) {
+	sanctions := []*Sanction{}
+
+	err := m.FindMulti(bson.D{{Key: "account_id", Value: account_id}}, &sanctions, "account_sanctions")
+	if err != nil {
+		return nil, err
+	}
+
+	return sanctions, nil
+}
+
+/*******************************************************************************************
+ * Report Operations
+ *******************************************************************************************/
+
+func (m *MemoryStore) FindAccountReports(account_id primitive.ObjectID) ([]*Report, error) {
+	reports := []*Report{}
+
+	err := m.FindMulti(bson.D{{Key: "reporter_id", Value: account_id}}, &reports, "reports")
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(reports, func(i, j int) bool {
+		return reports[i].CreatedAt.After(*reports[j].CreatedAt)
+	})
+
+	return reports, nil
+}
+
+/*******************************************************************************************
+ * Data Export Operations
+ *******************************************************************************************/
+
+func (m *MemoryStore) SaveDataExportArchive(export_id primitive.ObjectID, archive []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.archives[export_id] = append([]byte{}, archive...)
+	return nil
+}
+
+func (m *MemoryStore) WriteDataExportArchive(export_id primitive.ObjectID, w io.Writer) error {
+	m.mu.RLock()
+	archive, ok := m.archives[export_id]
+	m.mu.RUnlock()
+
+	if !ok {
+		return mongo.ErrNoDocuments
+	}
+
+	_, err := w.Write(archive)
+	return err
+}
+
+func (m *MemoryStore) DeleteDataExportArchive(export_id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.archives, export_id)
+	return nil
+}
+
+/*******************************************************************************************
+ * External Login Operations
+ *******************************************************************************************/
+
+func (m *MemoryStore) FindExternalLogin(provider string, subject string) (*ExternalLogin, error) {
+	login := &ExternalLogin{}
+
+	err := m.FindSingle(bson.D{{Key: "provider", Value: provider}, {Key: "subject", Value: subject}}, login, "external_logins")
+	if err != nil {
+		return nil, err
+	}
+
+	return login, nil
+}
+
+/*******************************************************************************************
+ * Session Operations
+ *******************************************************************************************/
+
+func (m *MemoryStore) FindSession(id string) (*Session, error) {
+	if id == "" {
+		return nil, fmt.Errorf("session id is empty")
+	}
+
+	session := &Session{}
+
+	err := m.FindSingle(bson.D{{Key: "session_id", Value: id}}, session, "sessions")
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func (m *MemoryStore) UpdateSession(session *Session) error {
+	return m.ReplaceSingle(session.ID, session, "sessions")
+}
+
+func (m *MemoryStore) DeleteSession(session *Session) error {
+	return m.DeleteSingle(session.ID, "sessions")
+}
+
+func (m *MemoryStore) FindAccountSessions(account_id primitive.ObjectID) ([]*Session, error) {
+	sessions := []*Session{}
+
+	err := m.FindMulti(accountSessionsFilter(account_id), &sessions, "sessions")
+	if err != nil {
+		return nil, err
+	}
+
+	sortSessionsByLastSeen(sessions)
+	return sessions, nil
+}
+
+func (m *MemoryStore) DeleteAccountSessions(account_id primitive.ObjectID, except ...primitive.ObjectID) error {
+	filter := bson.D{{Key: "account_id", Value: account_id}}
+	if len(except) > 0 {
+		filter = append(filter, bson.E{Key: "_id", Value: bson.D{{Key: "$nin", Value: except}}})
+	}
+
+	_, err := m.DeleteMulti(filter, "sessions")
+	return err
+}
+
+/*******************************************************************************************
+ * API Token Operations
+ *******************************************************************************************/
+
+func (m *MemoryStore) FindAPIToken(hash string) (*APIToken, error) {
+	token := &APIToken{}
+
+	err := m.FindSingle(bson.D{{Key: "token_hash", Value: hash}}, token, "api_tokens")
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+/*******************************************************************************************
+ * Login Attempt Operations
+ *******************************************************************************************/
+
+func (m *MemoryStore) FindLoginAttempts(key string) (*LoginAttempts, error) {
+	attempts := &LoginAttempts{}
+
+	err := m.FindSingle(bson.D{{Key: "key", Value: key}}, attempts, "login_attempts")
+	if err == mongo.ErrNoDocuments {
+		return NewLoginAttempts(key), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+// the record is read, updated and written back under the lock so concurrent failures all count
+func (m *MemoryStore) RecordLoginFailure(key string) (*LoginAttempts, error) {
+	now := time.Now().UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, doc := range m.collection("login_attempts") {
+		if !valuesEqual(doc["key"], key) {
+			continue
+		}
+
+		attempts := &LoginAttempts{}
+		if err := decodeDoc(doc, attempts); err != nil {
+			return nil, err
+		}
+
+		attempts.RecordFailure(now)
+
+		updated, err := toBsonDoc(attempts)
+		if err != nil {
+			return nil, err
+		}
+
+		m.collections["login_attempts"][i] = updated
+		return attempts, nil
+	}
+
+	attempts := NewLoginAttempts(key)
+	attempts.RecordFailure(now)
+
+	doc, err := toBsonDoc(attempts)
+	if err != nil {
+		return nil, err
+	}
+
+	m.collections["login_attempts"] = append(m.collections["login_attempts"], doc)
+	return attempts, nil
+}
+
+func (m *MemoryStore) ClearLoginAttempts(keys ...string) error {
+	_, err := m.DeleteMulti(bson.D{{Key: "key", Value: bson.D{{Key: "$in", Value: keys}}}}, "login_attempts")
+	return err
+}
+
+/*******************************************************************************************
+ * Settings Operations
+ *******************************************************************************************/
+
+func (m *MemoryStore) FindSettings() (*Settings, error) {
+	settings := &Settings{}
+
+	err := m.FindSingle(bson.D{}, settings, "settings")
+	if err == mongo.ErrNoDocuments {
+		return DefaultSettings(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+func (m *MemoryStore) UpdateSettings(settings *Settings) error {
+	if m.CountResults("settings", bson.D{{Key: "_id", Value: settings.ID}}) == 0 {
+		return m.SaveNewSingle(settings, "settings")
+	}
+	return m.ReplaceSingle(settings.ID, settings, "settings")
+}
+
+/*******************************************************************************************
+ * Asset Operations
+ *******************************************************************************************/
+
+// returns the AssetSource of the first query that matches, nil if none do
+func (m *MemoryStore) AssetHashCollisionResolver(queries ...primitive.D) (*AssetSource, error) {
+	for _, query := range queries {
+		found := &AssetSource{}
+		if err := m.FindSingle(query, found, "asset_sources"); err == nil {
+			return found, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (m *MemoryStore) UpdateAssetSource(source *AssetSource) error {
+	return m.ReplaceSingle(source.ID, source, "asset_sources")
+}
+
+func (m *MemoryStore) FindAssetByID(id primitive.ObjectID) (*Asset, error) {
+	asset := &Asset{}
+
+	err := m.FindSingle(bson.D{{Key: "_id", Value: id}}, asset, "assets")
+	if err != nil {
+		return nil, err
+	}
+
+	return asset, nil
+}
+
+func (m *MemoryStore) FindAssetSourceByHash(hash string) (*AssetSource, error) {
+	source := &AssetSource{}
+
+	err := m.FindSingle(bson.D{{Key: "details.source.hash_sha256", Value: hash}}, source, "asset_sources")
+	if err != nil {
+		return nil, err
+	}
+
+	return source, nil
+}
+
+func (m *MemoryStore) FindAssetsBySourceIDAccountID(source_id, account_id primitive.ObjectID) ([]*Asset, error) {
+	assets := []*Asset{}
+
+	err := m.FindMulti(bson.D{{Key: "source_id", Value: source_id}, {Key: "account_id", Value: account_id}}, &assets, "assets")
+	if err != nil {
+		return nil, err
+	}
+
+	return assets, nil
+}
+
+/*******************************************************************************************
+ * Identity Operations
+ *******************************************************************************************/
+
+// Resolves an identity from a particular account & thread, creating one if it doesn't exist
+func (m *MemoryStore) ResolveIdentity(account_id, thread_id primitive.ObjectID) (*Identity, error) {
+	identity := &Identity{}
+
+	err := m.FindSingle(FindFilterIdentityInThread(thread_id, account_id), identity, "identities")
+	if err == nil {
+		return identity, nil
+	}
+
+	identity = NewIdentity()
+	identity.Account = account_id
+	identity.Thread = thread_id
+
+	err = m.SaveNewSingle(identity, "identities")
+	if err != nil {
+		return nil, err
+	}
+
+	return identity, nil
+}
@@ -0,0 +1,364 @@
+package types
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// a one off change to existing documents, each is applied once and recorded in the migrations collection
+type migration struct {
+	Name  string
+	Apply func(ctx context.Context, db *mongo.Database) error
+}
+
+// in the order they're applied, new migrations go at the end
+var migrations = []migration{
+	{Name: "email-verified-at", Apply: migrateEmailVerifiedAt},
+	{Name: "username-key", Apply: migrateUsernameKey},
+	{Name: "data-export-archives", Apply: migrateDataExportArchives},
+	{Name: "login-attempts-key", Apply: migrateLoginAttemptsKey},
+	{Name: "post-revision-numbers", Apply: migratePostRevisionNumbers},
+	{Name: "ip-ban-ranges", Apply: migrateIPBanRanges},
+	{Name: "spam-classifier-counts", Apply: migrateSpamClassifierCounts},
+}
+
+// an index the store relies on, created on start after the migrations that make it possible
+type storeIndex struct {
+	Collection string
+	Model      mongo.IndexModel
+}
+
+var indexes = []storeIndex{
+	// purged accounts are removed, every account left has a username_key once username-key is applied
+	{Collection: "accounts", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "username_key", Value: 1}},
+		Options: options.Index().SetName("username_key_unique").SetUnique(true),
+	}},
+	// a provider's user signs in to one account
+	{Collection: "external_logins", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "provider", Value: 1}, {Key: "subject", Value: 1}},
+		Options: options.Index().SetName("provider_subject_unique").SetUnique(true),
+	}},
+	// sign ins that never came back from the provider are removed once they expire
+	{Collection: "oidc_states", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetName("expires_at_ttl").SetExpireAfterSeconds(0),
+	}},
+	// failures are counted with an upsert on the key, concurrent upserts would otherwise each insert one
+	{Collection: "login_attempts", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "key", Value: 1}},
+		Options: options.Index().SetName("key_unique").SetUnique(true),
+	}},
+	// edits made at the same time can't keep their versions under the same number
+	{Collection: "post_revisions", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "target_id", Value: 1}, {Key: "revision", Value: 1}},
+		Options: options.Index().SetName("target_id_revision_unique").SetUnique(true),
+	}},
+	// every write looks for a ban on the address, by it's hash and by the ranges covering it
+	{Collection: "ip_bans", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "ip_hash", Value: 1}},
+		Options: options.Index().SetName("ip_hash").SetSparse(true),
+	}},
+	{Collection: "ip_bans", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "range_start", Value: 1}, {Key: "range_end", Value: 1}},
+		Options: options.Index().SetName("range").SetSparse(true),
+	}},
+	{Collection: "ip_bans", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "lifted_at", Value: 1}, {Key: "expires_at", Value: 1}},
+		Options: options.Index().SetName("lifted_at_expires_at"),
+	}},
+	// training upserts each word's counts, concurrent upserts would otherwise each insert one
+	{Collection: "spam_tokens", Model: mongo.IndexModel{
+		Keys:    bson.D{{Key: "token", Value: 1}},
+		Options: options.Index().SetName("token_unique").SetUnique(true),
+	}},
+}
+
+// Migrate
+// brings documents saved by older versions up to date, run on start before serving requests
+// - returns an error if one occurs
+func (s *Store) Migrate() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	collection := s.DB.Collection("migrations")
+
+	for _, m := range migrations {
+		err := collection.FindOne(ctx, bson.D{{Key: "_id", Value: m.Name}}).Err()
+		if err == nil {
+			continue
+		}
+		if err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		if err := m.Apply(ctx, s.DB); err != nil {
+			return fmt.Errorf("migration %s: %w", m.Name, err)
+		}
+
+		_, err = collection.InsertOne(ctx, bson.D{{Key: "_id", Value: m.Name}, {Key: "applied_at", Value: time.Now().UTC()}})
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Applied migration", m.Name)
+	}
+
+	for _, idx := range indexes {
+		_, err := s.DB.Collection(idx.Collection).Indexes().CreateOne(ctx, idx.Model)
+		if err != nil {
+			return fmt.Errorf("index on %s: %w", idx.Collection, err)
+		}
+	}
+
+	return nil
+}
+
+// verification used to be an "unverified" account status, which sanctions and deletions overwrote
+// accounts that were past it are verified as of when they were created
+func migrateEmailVerifiedAt(ctx context.Context, db *mongo.Database) error {
+	unverified := "unverified"
+	accounts := db.Collection("accounts")
+	sanctions := db.Collection("account_sanctions")
+
+	// sanctioned and deleted accounts kept the unverified status to go back to
+	sanctioned, err := sanctions.Distinct(ctx, "account_id", bson.D{{Key: "prior_status", Value: unverified}})
+	if err != nil {
+		return err
+	}
+
+	filter := bson.D{
+		{Key: "email_verified_at", Value: bson.D{{Key: "$exists", Value: false}}},
+		{Key: "status", Value: bson.D{{Key: "$ne", Value: unverified}}},
+		{Key: "restore_status", Value: bson.D{{Key: "$ne", Value: unverified}}},
+		{Key: "_id", Value: bson.D{{Key: "$nin", Value: sanctioned}}},
+	}
+	verified := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{{Key: "email_verified_at", Value: bson.D{{Key: "$ifNull", Value: bson.A{"$created_at", time.Now().UTC()}}}}}}},
+	}
+
+	if _, err := accounts.UpdateMany(ctx, filter, verified); err != nil {
+		return err
+	}
+
+	for _, field := range []string{"status", "restore_status"} {
+		_, err := accounts.UpdateMany(ctx, bson.D{{Key: field, Value: unverified}}, bson.D{{Key: "$set", Value: bson.D{{Key: field, Value: AccountStatusActive}}}})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = sanctions.UpdateMany(ctx, bson.D{{Key: "prior_status", Value: unverified}}, bson.D{{Key: "$set", Value: bson.D{{Key: "prior_status", Value: AccountStatusActive}}}})
+	return err
+}
+
+// accounts created before username_key existed only have their username, the key is filled in so
+// usernames can be kept unique regardless of casing. usernames that only differ by casing can't both
+// have the key, they're reported so they can be renamed before the migration is run again
+func migrateUsernameKey(ctx context.Context, db *mongo.Database) error {
+	accounts := db.Collection("accounts")
+
+	cursor, err := accounts.Find(ctx, bson.D{{Key: "username_key", Value: bson.D{{Key: "$exists", Value: false}}}}, options.Find().SetProjection(bson.D{{Key: "username", Value: 1}}))
+	if err != nil {
+		return err
+	}
+
+	legacy := []*Account{}
+	if err := cursor.All(ctx, &legacy); err != nil {
+		return err
+	}
+
+	for _, account := range legacy {
+		key := NormalizeUsername(account.Username)
+
+		err := accounts.FindOne(ctx, bson.D{{Key: "username_key", Value: key}, {Key: "_id", Value: bson.D{{Key: "$ne", Value: account.ID}}}}).Err()
+		if err == nil {
+			return fmt.Errorf("username %q of account %s is taken by another account with different casing", account.Username, account.ID.Hex())
+		}
+		if err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		_, err = accounts.UpdateOne(ctx, bson.D{{Key: "_id", Value: account.ID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "username_key", Value: key}}}})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// export archives used to be kept in the export itself, those exports are removed and can be
+// requested again. they expire within a day anyway
+func migrateDataExportArchives(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("data_exports").DeleteMany(ctx, bson.D{{Key: "archive", Value: bson.D{{Key: "$exists", Value: true}}}})
+	return err
+}
+
+// failures used to be saved by replacing the whole record, concurrent failures could leave more than
+// one record for a key. they're only throttling counters so they're cleared rather than merged
+func migrateLoginAttemptsKey(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("login_attempts").DeleteMany(ctx, bson.D{})
+	return err
+}
+
+// revisions used to be numbered by counting the ones already kept, edits made at the same time could
+// share a number. the revisions of anything with a shared number are numbered again in the order
+// they were replaced
+func migratePostRevisionNumbers(ctx context.Context, db *mongo.Database) error {
+	revisions := db.Collection("post_revisions")
+
+	shared, err := revisions.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "target_id", Value: "$target_id"}, {Key: "revision", Value: "$revision"}}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+		{{Key: "$match", Value: bson.D{{Key: "count", Value: bson.D{{Key: "$gt", Value: 1}}}}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	groups := []struct {
+		ID struct {
+			TargetID primitive.ObjectID `bson:"target_id"`
+		} `bson:"_id"`
+	}{}
+	if err := shared.All(ctx, &groups); err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		cursor, err := revisions.Find(ctx, bson.D{{Key: "target_id", Value: group.ID.TargetID}}, options.Find().SetSort(bson.D{{Key: "replaced_at", Value: 1}, {Key: "_id", Value: 1}}))
+		if err != nil {
+			return err
+		}
+
+		kept := []*PostRevision{}
+		if err := cursor.All(ctx, &kept); err != nil {
+			return err
+		}
+
+		for i, revision := range kept {
+			_, err := revisions.UpdateOne(ctx, bson.D{{Key: "_id", Value: revision.ID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "revision", Value: i + 1}}}})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// range bans used to be matched by parsing every active ban's cidr, the bounds they're now found by
+// are filled in from it
+func migrateIPBanRanges(ctx context.Context, db *mongo.Database) error {
+	bans := db.Collection("ip_bans")
+
+	cursor, err := bans.Find(ctx, bson.D{
+		{Key: "cidr", Value: bson.D{{Key: "$exists", Value: true}}},
+		{Key: "range_start", Value: bson.D{{Key: "$exists", Value: false}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	legacy := []*IPBan{}
+	if err := cursor.All(ctx, &legacy); err != nil {
+		return err
+	}
+
+	for _, ban := range legacy {
+		_, ipnet, err := net.ParseCIDR(ban.CIDR)
+		if err != nil {
+			return fmt.Errorf("cidr %q of ip ban %s: %w", ban.CIDR, ban.ID.Hex(), err)
+		}
+
+		ban.SetRange(ipnet)
+
+		_, err = bans.UpdateOne(ctx, bson.D{{Key: "_id", Value: ban.ID}}, bson.D{{Key: "$set", Value: bson.D{
+			{Key: "range_start", Value: ban.RangeStart},
+			{Key: "range_end", Value: ban.RangeEnd},
+		}}})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// training used to insert a word's counts when updating found nothing and replace the corpus with
+// counts read beforehand, concurrent training could leave a word more than once and more than one
+// corpus. the counts of each are added together into one document, the corpus under SpamCorpusID
+func migrateSpamClassifierCounts(ctx context.Context, db *mongo.Database) error {
+	tokens := db.Collection("spam_tokens")
+
+	cursor, err := tokens.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$token"},
+			{Key: "spam", Value: bson.D{{Key: "$sum", Value: "$spam"}}},
+			{Key: "ham", Value: bson.D{{Key: "$sum", Value: "$ham"}}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+		{{Key: "$match", Value: bson.D{{Key: "count", Value: bson.D{{Key: "$gt", Value: 1}}}}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	duplicated := []struct {
+		Token string `bson:"_id"`
+		Spam  int    `bson:"spam"`
+		Ham   int    `bson:"ham"`
+	}{}
+	if err := cursor.All(ctx, &duplicated); err != nil {
+		return err
+	}
+
+	for _, v := range duplicated {
+		if _, err := tokens.DeleteMany(ctx, bson.D{{Key: "token", Value: v.Token}}); err != nil {
+			return err
+		}
+		if _, err := tokens.InsertOne(ctx, &SpamToken{ID: primitive.NewObjectID(), Token: v.Token, Spam: v.Spam, Ham: v.Ham}); err != nil {
+			return err
+		}
+	}
+
+	corpora := db.Collection("spam_corpus")
+
+	cursor, err = corpora.Find(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+
+	found := []*SpamCorpus{}
+	if err := cursor.All(ctx, &found); err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return nil
+	}
+
+	corpus := &SpamCorpus{ID: SpamCorpusID}
+	for _, v := range found {
+		corpus.Spam += v.Spam
+		corpus.Ham += v.Ham
+		if corpus.UpdatedAt == nil || (v.UpdatedAt != nil && v.UpdatedAt.After(*corpus.UpdatedAt)) {
+			corpus.UpdatedAt = v.UpdatedAt
+		}
+	}
+
+	if _, err := corpora.DeleteMany(ctx, bson.D{}); err != nil {
+		return err
+	}
+	_, err = corpora.InsertOne(ctx, corpus)
+	return err
+}
@@ -0,0 +1,159 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// the kind of resource a moderation action was taken on
+type ModTarget string
+
+const (
+	ModTargetThread   ModTarget = "thread"
+	ModTargetPost     ModTarget = "post"
+	ModTargetComment  ModTarget = "comment" // article comment
+	ModTargetIdentity ModTarget = "identity"
+	ModTargetAccount  ModTarget = "account"
+	ModTargetAsset    ModTarget = "asset"
+	ModTargetBoard    ModTarget = "board"
+	ModTargetSettings ModTarget = "settings"
+	ModTargetIPBan    ModTarget = "ip_ban"
+)
+
+func (t ModTarget) IsValid() bool {
+	switch t {
+	case ModTargetThread, ModTargetPost, ModTargetComment, ModTargetIdentity, ModTargetAccount, ModTargetAsset, ModTargetBoard, ModTargetSettings, ModTargetIPBan:
+		return true
+	}
+	return false
+}
+
+// what was done, named <resource>.<action> like permissions
+type ModActionKind string
+
+const (
+	ModActionSanctionIssue   ModActionKind = "account.sanction"
+	ModActionSanctionLift    ModActionKind = "account.sanction.lift"
+	ModActionBoardRoleGrant  ModActionKind = "board.role.grant"
+	ModActionBoardRoleRevoke ModActionKind = "board.role.revoke"
+	ModActionSettingsUpdate  ModActionKind = "settings.update"
+	ModActionThreadClose     ModActionKind = "thread.close"
+	ModActionThreadReopen    ModActionKind = "thread.reopen"
+	ModActionThreadModAdd    ModActionKind = "thread.mod.appoint"
+	ModActionThreadModRemove ModActionKind = "thread.mod.dismiss"
+	ModActionThreadDelete    ModActionKind = "thread.delete"
+	ModActionThreadRestore   ModActionKind = "thread.restore"
+	ModActionThreadEdit      ModActionKind = "thread.edit"
+	ModActionPostEdit        ModActionKind = "post.edit"
+	ModActionPostRemove      ModActionKind = "post.remove"
+	ModActionPostRestore     ModActionKind = "post.restore"
+	ModActionCommentDelete   ModActionKind = "comment.delete"
+	ModActionCommentRestore  ModActionKind = "comment.restore"
+	ModActionIdentityBan     ModActionKind = "identity.ban"
+	ModActionIdentityUnban   ModActionKind = "identity.unban"
+	ModActionReportClaim     ModActionKind = "report.claim"
+	ModActionReportUnclaim   ModActionKind = "report.unclaim"
+	ModActionReportResolve   ModActionKind = "report.resolve"
+	ModActionReportDismiss   ModActionKind = "report.dismiss"
+	ModActionSpamApprove     ModActionKind = "spam.approve"
+	ModActionSpamReject      ModActionKind = "spam.reject"
+	ModActionIPBan           ModActionKind = "ip.ban"
+	ModActionIPUnban         ModActionKind = "ip.unban"
+	ModActionShadowBan       ModActionKind = "shadow.ban"
+	ModActionShadowUnban     ModActionKind = "shadow.unban"
+)
+
+// fields never copied into a snapshot
+var modSnapshotRedacted = []string{"password_hash", "two_factor", "ip_hash"}
+
+// an entry in the moderation audit log, entries are only ever added, never changed or removed
+type ModAction struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"_id"`
+	Action     ModActionKind       `bson:"action" json:"action"`
+	ActorID    primitive.ObjectID  `bson:"actor_id" json:"actor_id"`
+	TargetType ModTarget           `bson:"target_type" json:"target_type"`
+	TargetID   primitive.ObjectID  `bson:"target_id" json:"target_id"`
+	BoardID    *primitive.ObjectID `bson:"board_id,omitempty" json:"board_id,omitempty"` // board the target belongs to, if any
+	Reason     string              `bson:"reason,omitempty" json:"reason,omitempty"`
+
+	// the target as it was before and after the action, either is empty when it didn't exist
+	Before bson.M `bson:"before,omitempty" json:"before,omitempty"`
+	After  bson.M `bson:"after,omitempty" json:"after,omitempty"`
+
+	CreatedAt *time.Time `bson:"created_at" json:"created_at"`
+}
+
+func NewModAction(actor_id primitive.ObjectID, action ModActionKind, target ModTarget, target_id primitive.ObjectID, reason string) *ModAction {
+	ts := time.Now().UTC()
+	return &ModAction{
+		ID:         primitive.NewObjectID(),
+		Action:     action,
+		ActorID:    actor_id,
+		TargetType: target,
+		TargetID:   target_id,
+		Reason:     reason,
+		CreatedAt:  &ts,
+	}
+}
+
+// sets the board the target belongs to
+func (a *ModAction) OnBoard(board_id primitive.ObjectID) *ModAction {
+	a.BoardID = &board_id
+	return a
+}
+
+// copies the target as it was before and after the action, pass nil for a side that didn't exist
+func (a *ModAction) Snapshot(before, after any) *ModAction {
+	a.Before = ModSnapshot(before)
+	a.After = ModSnapshot(after)
+	return a
+}
+
+// ClientFormatter implementation
+func (a *ModAction) CLFormat() bson.M {
+	return bson.M{
+		"_id":         a.ID,
+		"action":      a.Action,
+		"actor_id":    a.ActorID,
+		"target_type": a.TargetType,
+		"target_id":   a.TargetID,
+		"board_id":    a.BoardID,
+		"reason":      a.Reason,
+		"before":      a.Before,
+		"after":       a.After,
+		"created_at":  a.CreatedAt,
+	}
+}
+
+// a copy of the document as it's stored, without credentials
+// - returns nil if there's nothing to copy, including nil pointers
+func ModSnapshot(v any) bson.M {
+	if v == nil {
+		return nil
+	}
+
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil
+	}
+
+	snapshot := bson.M{}
+	if err := bson.Unmarshal(raw, &snapshot); err != nil {
+		return nil
+	}
+
+	for _, k := range modSnapshotRedacted {
+		delete(snapshot, k)
+	}
+
+	return snapshot
+}
+
+// Record Mod Action
+// - accepts the action to add to the audit log
+// - returns an error if it couldn't be saved
+func RecordModAction(repo Repository, action *ModAction) error {
+	return repo.SaveNewSingle(action, "mod_actions")
+}
@@ -0,0 +1,501 @@
+package types
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dd-web/opforu-server/internal/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// how long a user has to finish signing in with the provider
+	OIDC_STATE_MINUTES = 10
+	// the cookie binding a sign in to the browser that started it
+	OIDC_STATE_COOKIE_NAME = "oidc_state"
+	// how far the provider's clock may be off from ours when checking id tokens
+	OIDC_CLOCK_SKEW_SECONDS = 60
+	// how long signing in with a provider again stands in for the password of an account without one
+	OIDC_REAUTH_MINUTES = 10
+)
+
+/*******************************************************************************************
+ * Providers
+ *******************************************************************************************/
+
+// an external OpenID Connect provider accounts can sign in with
+// the endpoints and signing keys are discovered from the issuer the first time they're needed
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string // empty for public clients, PKCE alone protects the code then
+	RedirectURL  string // the front end page the provider sends the user back to
+	Scopes       []string
+
+	Client *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+}
+
+// the parts of the issuer's discovery document we use
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+func NewOIDCProvider(name, issuer, clientID, clientSecret, redirectURL string) *OIDCProvider {
+	return &OIDCProvider{
+		Name:         name,
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+		Client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// reads the providers from env vars, none are configured when OIDC_PROVIDERS is empty
+//
+//	OIDC_PROVIDERS=google,gitlab         the provider names, used in urls
+//	OIDC_<NAME>_ISSUER                   the issuer url, required
+//	OIDC_<NAME>_CLIENT_ID                required
+//	OIDC_<NAME>_CLIENT_SECRET            optional
+//	OIDC_<NAME>_REDIRECT_URL             defaults to APP_URL/login/oidc/<name>
+//	OIDC_<NAME>_SCOPES                   space separated, defaults to "openid email profile"
+func NewOIDCProvidersFromEnv() (map[string]*OIDCProvider, error) {
+	providers := map[string]*OIDCProvider{}
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		issuer := os.Getenv(prefix + "ISSUER")
+		clientID := os.Getenv(prefix + "CLIENT_ID")
+		if issuer == "" || clientID == "" {
+			return nil, fmt.Errorf("%sISSUER and %sCLIENT_ID must be set for the %s provider", prefix, prefix, name)
+		}
+
+		redirect := os.Getenv(prefix + "REDIRECT_URL")
+		if redirect == "" {
+			redirect = utils.AppURL() + "/login/oidc/" + name
+		}
+
+		provider := NewOIDCProvider(name, issuer, clientID, os.Getenv(prefix+"CLIENT_SECRET"), redirect)
+		if scopes := strings.Fields(os.Getenv(prefix + "SCOPES")); len(scopes) > 0 {
+			provider.Scopes = scopes
+		}
+
+		providers[name] = provider
+	}
+
+	return providers, nil
+}
+
+// fetches and decodes json from the provider
+func (p *OIDCProvider) getJSON(endpoint string, v any) error {
+	res, err := p.Client.Get(endpoint)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with status %d", endpoint, res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// the issuer's endpoints, fetched once
+func (p *OIDCProvider) discover() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	discovery := &oidcDiscovery{}
+	if err := p.getJSON(p.Issuer+"/.well-known/openid-configuration", discovery); err != nil {
+		return nil, err
+	}
+
+	// a document claiming to be another issuer could hand out that issuer's tokens as ours
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %s not %s", discovery.Issuer, p.Issuer)
+	}
+
+	p.discovery = discovery
+	return discovery, nil
+}
+
+// the issuer's signing keys, refetched when asked so rotated keys are picked up
+func (p *OIDCProvider) signingKeys(refresh bool) (map[string]*rsa.PublicKey, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keys != nil && !refresh {
+		return p.keys, nil
+	}
+
+	res, err := p.Client.Get(discovery.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := utils.ParseJWKS(body)
+	if err != nil {
+		return nil, err
+	}
+
+	p.keys = keys
+	return keys, nil
+}
+
+// the url to send the user to, the state, nonce and code challenge come back to us with the code
+func (p *OIDCProvider) AuthorizationURL(state, nonce, verifier string) (string, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", strings.Join(p.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", utils.PKCEChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return discovery.AuthorizationEndpoint + sep + query.Encode(), nil
+}
+
+// Exchange
+// trades an authorization code for the user's verified id token claims
+// - accepts the code, the PKCE verifier and nonce the sign in was started with
+// - returns an error if the exchange failed or the id token isn't valid for us
+func (p *OIDCProvider) Exchange(code, verifier, nonce string) (*OIDCClaims, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	// confidential clients authenticate with basic auth, public ones only name themselves
+	if p.ClientSecret == "" {
+		form.Set("client_id", p.ClientID)
+	}
+
+	req, err := http.NewRequest("POST", discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	res, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return nil, fmt.Errorf("token exchange failed with status %d: %s", res.StatusCode, tokens.Error)
+	}
+
+	return p.verifyIDToken(tokens.IDToken, nonce)
+}
+
+// checks the id token was signed by the issuer for us, for this sign in, and is still valid
+func (p *OIDCProvider) verifyIDToken(token, nonce string) (*OIDCClaims, error) {
+	keys, err := p.signingKeys(false)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &OIDCClaims{}
+	err = utils.VerifyJWT(token, keys, claims)
+	if err == utils.ErrUnknownSigningKey {
+		if keys, err = p.signingKeys(true); err == nil {
+			err = utils.VerifyJWT(token, keys, claims)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Unix()
+	skew := int64(OIDC_CLOCK_SKEW_SECONDS)
+
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != p.Issuer:
+		return nil, fmt.Errorf("id token is from issuer %s", claims.Issuer)
+	case !claims.Audience.Contains(p.ClientID):
+		return nil, fmt.Errorf("id token is not for this client")
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.ClientID:
+		return nil, fmt.Errorf("id token is authorized for another party")
+	case claims.Expires+skew < now:
+		return nil, fmt.Errorf("id token expired")
+	case claims.IssuedAt-skew > now:
+		return nil, fmt.Errorf("id token issued in the future")
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("id token nonce doesn't match")
+	case claims.Subject == "":
+		return nil, fmt.Errorf("id token has no subject")
+	}
+
+	return claims, nil
+}
+
+// the id token claims we use
+type OIDCClaims struct {
+	Issuer          string       `json:"iss"`
+	Subject         string       `json:"sub"`
+	Audience        oidcAudience `json:"aud"`
+	AuthorizedParty string       `json:"azp"`
+	Expires         int64        `json:"exp"`
+	IssuedAt        int64        `json:"iat"`
+	Nonce           string       `json:"nonce"`
+
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+}
+
+// the aud claim is either a single string or a list of them
+type oidcAudience []string
+
+func (a *oidcAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = oidcAudience{single}
+		return nil
+	}
+
+	var multi []string
+	if err := json.Unmarshal(data, &multi); err != nil {
+		return err
+	}
+	*a = multi
+	return nil
+}
+
+func (a oidcAudience) Contains(v string) bool {
+	for _, aud := range a {
+		if aud == v {
+			return true
+		}
+	}
+	return false
+}
+
+/*******************************************************************************************
+ * Sign in state
+ *******************************************************************************************/
+
+// a sign in that was sent to a provider and hasn't come back yet
+// the state itself is only held by the browser's cookie and the provider, we keep it's hash
+type OIDCLoginState struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	StateHash string             `bson:"state_hash" json:"-"`
+	Provider  string             `bson:"provider" json:"provider"`
+	Verifier  string             `bson:"verifier" json:"-"`
+	Nonce     string             `bson:"nonce" json:"-"`
+
+	// set when a signed in account is linking the provider rather than signing in with it
+	LinkAccountID *primitive.ObjectID `bson:"link_account_id,omitempty" json:"-"`
+	// set when a session is confirming it's user with the provider rather than signing in with it
+	ReauthSessionID *primitive.ObjectID `bson:"reauth_session_id,omitempty" json:"-"`
+
+	ExpiresAt *time.Time `bson:"expires_at" json:"expires_at"`
+	CreatedAt *time.Time `bson:"created_at" json:"created_at"`
+}
+
+// starts a sign in with the provider
+// - returns the state record to save and the plaintext state for the browser and provider
+func NewOIDCLoginState(provider string, link *Account) (*OIDCLoginState, string, error) {
+	state, err := utils.RandomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+	verifier, err := utils.RandomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+	nonce, err := utils.RandomHex(16)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ts := time.Now().UTC()
+	exp := ts.Add(time.Duration(OIDC_STATE_MINUTES) * time.Minute)
+
+	ls := &OIDCLoginState{
+		ID:        primitive.NewObjectID(),
+		StateHash: utils.HashToken(state),
+		Provider:  provider,
+		Verifier:  verifier,
+		Nonce:     nonce,
+		ExpiresAt: &exp,
+		CreatedAt: &ts,
+	}
+
+	if link != nil {
+		ls.LinkAccountID = &link.ID
+	}
+
+	return ls, state, nil
+}
+
+func (ls *OIDCLoginState) IsExpired() bool {
+	return ls.ExpiresAt.Before(time.Now().UTC())
+}
+
+// binds the sign in to the browser that started it, otherwise someone could finish their own
+// sign in in a victim's browser and have the victim use their account
+func SetOIDCStateCookie(w http.ResponseWriter, state string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDC_STATE_COOKIE_NAME,
+		Value:    state,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func ClearOIDCStateCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDC_STATE_COOKIE_NAME,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+/*******************************************************************************************
+ * External logins
+ *******************************************************************************************/
+
+// links an account to a provider's user, the subject is the provider's stable id for them
+type ExternalLogin struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	AccountID primitive.ObjectID `bson:"account_id" json:"account_id"`
+	Provider  string             `bson:"provider" json:"provider"`
+	Subject   string             `bson:"subject" json:"-"`
+	Email     string             `bson:"email,omitempty" json:"email,omitempty"` // what the provider said when linked, for display
+
+	LastUsedAt *time.Time `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	CreatedAt  *time.Time `bson:"created_at" json:"created_at"`
+}
+
+func NewExternalLogin(account *Account, provider string, claims *OIDCClaims) *ExternalLogin {
+	ts := time.Now().UTC()
+	return &ExternalLogin{
+		ID:         primitive.NewObjectID(),
+		AccountID:  account.ID,
+		Provider:   provider,
+		Subject:    claims.Subject,
+		Email:      NormalizeEmail(claims.Email),
+		LastUsedAt: &ts,
+		CreatedAt:  &ts,
+	}
+}
+
+// ClientFormatter implementation
+func (el *ExternalLogin) CLFormat() bson.M {
+	return bson.M{
+		"_id":          el.ID,
+		"provider":     el.Provider,
+		"email":        el.Email,
+		"last_used_at": el.LastUsedAt,
+		"created_at":   el.CreatedAt,
+	}
+}
+
+// a valid username for an account created on first sign in, from what the provider knows
+// about the user. it may be taken already, the caller makes it unique.
+func OIDCUsername(claims *OIDCClaims) string {
+	local, _, _ := strings.Cut(claims.Email, "@")
+
+	for _, candidate := range []string{claims.PreferredUsername, local, claims.Name} {
+		var sb strings.Builder
+		for _, r := range candidate {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+				sb.WriteRune(r)
+			case r == ' ' || r == '.':
+				sb.WriteRune('_')
+			}
+		}
+
+		username := sb.String()
+		// room is left for the suffix that makes it unique
+		if len(username) > USERNAME_MAX_LENGTH-5 {
+			username = username[:USERNAME_MAX_LENGTH-5]
+		}
+		if len(username) >= USERNAME_MIN_LENGTH {
+			return username
+		}
+	}
+
+	return "user"
+}
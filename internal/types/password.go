@@ -0,0 +1,51 @@
+package types
+
+import (
+	"time"
+
+	"github.com/dd-web/opforu-server/internal/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// how long a password reset link stays valid
+	PASSWORD_RESET_MINUTES = 60
+)
+
+// a single use password reset request. only the hash of the token is stored,
+// the token itself is only ever sent to the account's email address.
+type PasswordReset struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	AccountID primitive.ObjectID `bson:"account_id" json:"account_id"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+
+	Expires *time.Time `bson:"expires" json:"expires"`
+	UsedAt  *time.Time `bson:"used_at,omitempty" json:"used_at,omitempty"`
+
+	CreatedAt *time.Time `bson:"created_at" json:"created_at"`
+}
+
+// creates a new password reset for the account
+// - returns the reset to be saved and the plaintext token to be mailed
+func NewPasswordReset(account *Account) (*PasswordReset, string, error) {
+	token, err := utils.RandomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ts := time.Now().UTC()
+	exp := ts.Add(time.Duration(PASSWORD_RESET_MINUTES) * time.Minute)
+
+	return &PasswordReset{
+		ID:        primitive.NewObjectID(),
+		AccountID: account.ID,
+		TokenHash: utils.HashToken(token),
+		Expires:   &exp,
+		CreatedAt: &ts,
+	}, token, nil
+}
+
+// can the reset still be used
+func (pr *PasswordReset) IsUsable() bool {
+	return pr.UsedAt == nil && pr.Expires.After(time.Now().UTC())
+}
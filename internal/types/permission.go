@@ -0,0 +1,157 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// something an account can be allowed to do, handlers declare the ones they need when their
+// routes are registered instead of checking roles themselves
+type Permission string
+
+const (
+	PermThreadCreate     Permission = "thread.create"
+	PermPostCreate       Permission = "post.create"
+	PermCommentCreate    Permission = "comment.create"
+	PermCommentAnonymous Permission = "comment.anonymous" // hide the author of an article comment
+	PermAssetUpload      Permission = "asset.upload"
+	PermAccountRead      Permission = "account.read"
+	PermReportCreate     Permission = "report.create"
+	PermThreadModerate   Permission = "thread.moderate" // use thread mod powers in threads the account's identity mods
+
+	PermPostDeleteAny   Permission = "post.delete.any"
+	PermAccountSanction Permission = "account.sanction" // suspend and ban accounts
+	PermModLogRead      Permission = "modlog.read"      // read the moderation audit log
+	PermReportReview    Permission = "report.review"    // work through the report queue
+	PermContentDeleted  Permission = "content.deleted"  // see and restore deleted threads, posts and comments
+	PermPostEditAny     Permission = "post.edit.any"    // edit any thread or post, whenever
+	PermRevisionRead    Permission = "revision.read"    // browse the versions edits replaced
+	PermSpamReview      Permission = "spam.review"      // decide on held submissions and train the spam classifier, skips spam checks
+	PermIPBan           Permission = "ip.ban"           // ban and unban addresses, writes aren't stopped by ip bans
+	PermShadowBan       Permission = "shadow.ban"       // hide what accounts and identities write from everyone else
+
+	PermBoardManage    Permission = "board.manage"
+	PermRoleManage     Permission = "role.manage" // grant and revoke board roles
+	PermSettingsManage Permission = "settings.manage"
+)
+
+// what's needed to use a permission besides having it
+type permissionRules struct {
+	scope      APITokenScope // the scope an api token needs, empty when tokens can't use it at all
+	verified   bool          // the account's email must be verified
+	sanctioned bool          // suspended and banned accounts keep it
+}
+
+var permissionRulesMap = map[Permission]permissionRules{
+	PermThreadCreate:     {scope: APITokenScopePost, verified: true},
+	PermPostCreate:       {scope: APITokenScopePost, verified: true},
+	PermCommentCreate:    {scope: APITokenScopePost},
+	PermCommentAnonymous: {scope: APITokenScopeModerate},
+	PermAssetUpload:      {scope: APITokenScopePost},
+	PermAccountRead:      {scope: APITokenScopeRead, sanctioned: true},
+	PermReportCreate:     {scope: APITokenScopePost, verified: true},
+	PermThreadModerate:   {scope: APITokenScopePost},
+	PermPostDeleteAny:    {scope: APITokenScopeModerate},
+	PermAccountSanction:  {scope: APITokenScopeModerate},
+	PermModLogRead:       {scope: APITokenScopeModerate},
+	PermReportReview:     {scope: APITokenScopeModerate},
+	PermContentDeleted:   {scope: APITokenScopeModerate},
+	PermPostEditAny:      {scope: APITokenScopeModerate},
+	PermRevisionRead:     {scope: APITokenScopeModerate},
+	PermSpamReview:       {scope: APITokenScopeModerate},
+	PermIPBan:            {scope: APITokenScopeModerate},
+	PermShadowBan:        {scope: APITokenScopeModerate},
+	PermBoardManage:      {scope: APITokenScopeModerate},
+	PermRoleManage:       {},
+	PermSettingsManage:   {},
+}
+
+// the api token scope needed to use the permission, empty if only login sessions can use it
+func (p Permission) Scope() APITokenScope {
+	return permissionRulesMap[p].scope
+}
+
+// must the account have verified it's email to use the permission
+func (p Permission) RequiresVerified() bool {
+	return permissionRulesMap[p].verified
+}
+
+// can suspended and banned accounts still use the permission
+func (p Permission) AllowsSanctioned() bool {
+	return permissionRulesMap[p].sanctioned
+}
+
+var (
+	userPermissions = []Permission{PermThreadCreate, PermPostCreate, PermCommentCreate, PermAssetUpload, PermAccountRead, PermReportCreate, PermThreadModerate}
+	modPermissions  = append(append([]Permission{}, userPermissions...), PermCommentAnonymous, PermPostDeleteAny, PermAccountSanction, PermModLogRead, PermReportReview, PermContentDeleted, PermPostEditAny, PermRevisionRead, PermSpamReview, PermIPBan, PermShadowBan)
+
+	// the permissions each role has, roles not listed have none
+	ROLE_PERMISSIONS = map[AccountRole][]Permission{
+		AccountRoleUser:  userPermissions,
+		AccountRoleMod:   modPermissions,
+		AccountRoleAdmin: append(append([]Permission{}, modPermissions...), PermBoardManage, PermRoleManage, PermSettingsManage),
+	}
+)
+
+// does the role have the permission
+func RoleHasPermission(role AccountRole, perm Permission) bool {
+	for _, v := range ROLE_PERMISSIONS[role] {
+		if v == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// does the role have any permission an api token needs the scope for
+func RoleUsesScope(role AccountRole, scope APITokenScope) bool {
+	for _, v := range ROLE_PERMISSIONS[role] {
+		if v.Scope() == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// a role given to an account on a single board, on top of the role it has everywhere
+// e.g. a moderator of /g/ only
+type BoardRole struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	AccountID primitive.ObjectID `bson:"account_id" json:"account_id"`
+	BoardID   primitive.ObjectID `bson:"board_id" json:"board_id"`
+	Role      AccountRole        `bson:"role" json:"role"`
+
+	GrantedBy primitive.ObjectID `bson:"granted_by" json:"granted_by"`
+	CreatedAt *time.Time         `bson:"created_at" json:"created_at"`
+}
+
+// only staff roles are worth granting per board, everyone is a user everywhere already
+func IsGrantableBoardRole(role AccountRole) bool {
+	return role == AccountRoleMod || role == AccountRoleAdmin
+}
+
+func NewBoardRole(account_id, board_id primitive.ObjectID, role AccountRole, granted_by primitive.ObjectID) *BoardRole {
+	ts := time.Now().UTC()
+	return &BoardRole{
+		ID:        primitive.NewObjectID(),
+		AccountID: account_id,
+		BoardID:   board_id,
+		Role:      role,
+		GrantedBy: granted_by,
+		CreatedAt: &ts,
+	}
+}
+
+// ClientFormatter implementation
+func (br *BoardRole) CLFormat() bson.M {
+	return bson.M{
+		"_id":        br.ID,
+		"account_id": br.AccountID,
+		"board_id":   br.BoardID,
+		"role":       br.Role,
+		"granted_by": br.GrantedBy,
+		"created_at": br.CreatedAt,
+	}
+}
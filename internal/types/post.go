@@ -9,7 +9,7 @@ import (
 
 var (
 	// permissions
-	PUBLIC_POST_FIELDS = []string{"post_number", "body", "assets", "creator", "board", "thread", "created_at", "updated_at", "deleted_at"}
+	PUBLIC_POST_FIELDS = []string{"post_number", "body", "assets", "creator", "board", "thread", "created_at", "updated_at", "edited_at", "deleted_at", "deleted_by"}
 	ADMIN_POST_FIELDS  = []string{"_id", "account"}
 )
 
@@ -20,6 +20,8 @@ type Post struct {
 	Creator    primitive.ObjectID `bson:"creator" json:"creator"` // identity _id
 
 	Body   string               `bson:"body" json:"body"`
+	Raw    string               `bson:"raw,omitempty" json:"-"`     // as written, before it's rendered into Body
+	IPHash string               `bson:"ip_hash,omitempty" json:"-"` // who posted it, see utils.HashIP
 	Assets []primitive.ObjectID `bson:"assets" json:"assets"`
 
 	Board  primitive.ObjectID `bson:"board" json:"board"`
@@ -27,7 +29,9 @@ type Post struct {
 
 	CreatedAt *time.Time `bson:"created_at" json:"created_at"`
 	UpdatedAt *time.Time `bson:"updated_at" json:"updated_at"`
+	EditedAt  *time.Time `bson:"edited_at,omitempty" json:"edited_at,omitempty"`
 	DeletedAt *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy DeletedBy  `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
 }
 
 // Creates a new post with an ID and other default values.
@@ -0,0 +1,94 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// how long after posting an author can still edit until an admin sets their own in the site settings
+	DEFAULT_POST_EDIT_WINDOW_MINUTES = 15
+	// times an edit takes the next revision number again after another edit got to it first
+	REVISION_SAVE_ATTEMPTS = 3
+)
+
+// a version of a thread or post that an edit replaced, every version but the current one is kept
+type PostRevision struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	TargetType ModTarget          `bson:"target_type" json:"target_type"` // thread or post
+	TargetID   primitive.ObjectID `bson:"target_id" json:"target_id"`
+	Revision   int                `bson:"revision" json:"revision"` // 1 for the original
+
+	Title string `bson:"title,omitempty" json:"title,omitempty"` // threads only
+	Raw   string `bson:"raw" json:"raw"`                         // as written, empty for content posted before it was kept
+	Body  string `bson:"body" json:"body"`                       // as rendered
+
+	EditedBy   primitive.ObjectID `bson:"edited_by" json:"edited_by"` // the account whose edit replaced this version
+	CreatedAt  *time.Time         `bson:"created_at" json:"created_at"`
+	ReplacedAt *time.Time         `bson:"replaced_at" json:"replaced_at"`
+}
+
+// keeps a version that's about to be replaced, it's numbered when it's saved
+// - accepts when the version was written, the post's creation or last edit
+func NewPostRevision(target ModTarget, target_id primitive.ObjectID, title, raw, body string, written_at *time.Time, editor primitive.ObjectID) *PostRevision {
+	ts := time.Now().UTC()
+	return &PostRevision{
+		ID:         primitive.NewObjectID(),
+		TargetType: target,
+		TargetID:   target_id,
+		Title:      title,
+		Raw:        raw,
+		Body:       body,
+		EditedBy:   editor,
+		CreatedAt:  written_at,
+		ReplacedAt: &ts,
+	}
+}
+
+// ClientFormatter implementation
+func (r *PostRevision) CLFormat() bson.M {
+	return bson.M{
+		"revision":    r.Revision,
+		"title":       r.Title,
+		"raw":         r.Raw,
+		"body":        r.Body,
+		"created_at":  r.CreatedAt,
+		"replaced_at": r.ReplacedAt,
+	}
+}
+
+// the text versions are compared by, what was written when it was kept
+func (r *PostRevision) DiffText() string {
+	if r.Raw != "" {
+		return r.Raw
+	}
+	return r.Body
+}
+
+// can an author still edit something they posted at the time, staff can edit any time
+func WithinEditWindow(settings *Settings, created_at *time.Time) bool {
+	if created_at == nil {
+		return false
+	}
+	return time.Since(*created_at) < settings.EditWindow()
+}
+
+// the revisions kept for a thread or post, oldest first
+func FindPostRevisions(repo Repository, target_id primitive.ObjectID) ([]*PostRevision, error) {
+	revisions := []*PostRevision{}
+	err := repo.FindMulti(bson.D{{Key: "target_id", Value: target_id}}, &revisions, "post_revisions")
+	if err != nil {
+		return nil, err
+	}
+
+	// saved in order, but a revision number is what orders them
+	for i := 1; i < len(revisions); i++ {
+		for j := i; j > 0 && revisions[j].Revision < revisions[j-1].Revision; j-- {
+			revisions[j], revisions[j-1] = revisions[j-1], revisions[j]
+		}
+	}
+
+	return revisions, nil
+}
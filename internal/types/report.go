@@ -0,0 +1,150 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	REPORT_REASON_MAX_LENGTH = 500
+	REPORT_NOTE_MAX_LENGTH   = 500
+
+	// reports an account can file within the window before it has to wait
+	REPORT_LIMIT_PER_WINDOW = 10
+	REPORT_WINDOW_MINUTES   = 60
+)
+
+type ReportCategory string
+
+const (
+	ReportCategorySpam       ReportCategory = "spam"
+	ReportCategoryHarassment ReportCategory = "harassment"
+	ReportCategoryIllegal    ReportCategory = "illegal"
+	ReportCategoryOffTopic   ReportCategory = "off_topic"
+	ReportCategoryOther      ReportCategory = "other" // needs a reason
+)
+
+func (c ReportCategory) IsValid() bool {
+	switch c {
+	case ReportCategorySpam, ReportCategoryHarassment, ReportCategoryIllegal, ReportCategoryOffTopic, ReportCategoryOther:
+		return true
+	}
+	return false
+}
+
+type ReportStatus string
+
+const (
+	ReportStatusOpen      ReportStatus = "open"
+	ReportStatusResolved  ReportStatus = "resolved"  // action was taken on the content
+	ReportStatusDismissed ReportStatus = "dismissed" // the content was fine
+)
+
+// the content types users can report
+func IsReportableTarget(target ModTarget) bool {
+	switch target {
+	case ModTargetThread, ModTargetPost, ModTargetComment, ModTargetAsset:
+		return true
+	}
+	return false
+}
+
+// where the reported content lives, enough to look it up again through the public pipelines
+type ReportLocation struct {
+	ThreadID      *primitive.ObjectID `bson:"thread_id,omitempty" json:"-"`
+	Thread        string              `bson:"thread,omitempty" json:"thread,omitempty"`           // thread slug
+	PostNumber    int                 `bson:"post_number,omitempty" json:"post_number,omitempty"` // zero for the thread itself
+	Article       string              `bson:"article,omitempty" json:"article,omitempty"`         // article slug
+	CommentNumber int                 `bson:"comment_number,omitempty" json:"comment_number,omitempty"`
+	Asset         string              `bson:"asset,omitempty" json:"asset,omitempty"` // sha256 of the asset's source file
+}
+
+// a user's complaint about a piece of content. reports against the same content are worked
+// through together, claiming or closing one applies to every open report on that content.
+type Report struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"_id"`
+	ReporterID primitive.ObjectID  `bson:"reporter_id" json:"reporter_id"`
+	TargetType ModTarget           `bson:"target_type" json:"target_type"`
+	TargetID   primitive.ObjectID  `bson:"target_id" json:"target_id"`
+	BoardID    *primitive.ObjectID `bson:"board_id,omitempty" json:"board_id,omitempty"`
+	Location   ReportLocation      `bson:"location" json:"location"`
+
+	Category ReportCategory `bson:"category" json:"category"`
+	Reason   string         `bson:"reason,omitempty" json:"reason,omitempty"`
+	Status   ReportStatus   `bson:"status" json:"status"`
+
+	ClaimedBy *primitive.ObjectID `bson:"claimed_by,omitempty" json:"claimed_by,omitempty"`
+	ClaimedAt *time.Time          `bson:"claimed_at,omitempty" json:"claimed_at,omitempty"`
+
+	ClosedBy *primitive.ObjectID `bson:"closed_by,omitempty" json:"closed_by,omitempty"`
+	ClosedAt *time.Time          `bson:"closed_at,omitempty" json:"closed_at,omitempty"`
+	Note     string              `bson:"note,omitempty" json:"note,omitempty"` // left by staff when closing
+
+	CreatedAt *time.Time `bson:"created_at" json:"created_at"`
+}
+
+func NewReport(reporter_id primitive.ObjectID, target ModTarget, target_id primitive.ObjectID, location ReportLocation, category ReportCategory, reason string) *Report {
+	ts := time.Now().UTC()
+	return &Report{
+		ID:         primitive.NewObjectID(),
+		ReporterID: reporter_id,
+		TargetType: target,
+		TargetID:   target_id,
+		Location:   location,
+		Category:   category,
+		Reason:     reason,
+		Status:     ReportStatusOpen,
+		CreatedAt:  &ts,
+	}
+}
+
+// ClientFormatter implementation
+// reporters only ever see their own reports so staff details are left out
+func (r *Report) CLFormat() bson.M {
+	return bson.M{
+		"_id":         r.ID,
+		"target_type": r.TargetType,
+		"location":    r.Location,
+		"category":    r.Category,
+		"reason":      r.Reason,
+		"status":      r.Status,
+		"created_at":  r.CreatedAt,
+	}
+}
+
+// open reports against the content
+func OpenReportsFilter(target_id primitive.ObjectID) bson.D {
+	return bson.D{{Key: "target_id", Value: target_id}, {Key: "status", Value: ReportStatusOpen}}
+}
+
+// how long the reporter has to wait before filing another report, zero if they can now
+// - returns an error if the reporter's recent reports couldn't be looked up
+func ReportRetryAfter(repo Repository, reporter_id primitive.ObjectID) (time.Duration, error) {
+	window := time.Duration(REPORT_WINDOW_MINUTES) * time.Minute
+	since := time.Now().UTC().Add(-window)
+
+	recent := []*Report{}
+	err := repo.FindMulti(bson.D{
+		{Key: "reporter_id", Value: reporter_id},
+		{Key: "created_at", Value: bson.D{{Key: "$gt", Value: since}}},
+	}, &recent, "reports")
+	if err != nil {
+		return 0, err
+	}
+
+	if len(recent) < REPORT_LIMIT_PER_WINDOW {
+		return 0, nil
+	}
+
+	// another report is allowed once the oldest in the window falls out of it
+	oldest := *recent[0].CreatedAt
+	for _, v := range recent {
+		if v.CreatedAt.Before(oldest) {
+			oldest = *v.CreatedAt
+		}
+	}
+
+	return time.Until(oldest.Add(window)), nil
+}
@@ -0,0 +1,184 @@
+package types
+
+import (
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Repository is the storage layer every handler talks to.
+//
+// Store (MongoDB) is the production implementation, MemoryStore keeps everything in process
+// memory and is used to drive the handlers without a running database. Handlers should only
+// ever reach the database through these methods, never through a driver specific type.
+type Repository interface {
+	/*******************************************************************************************
+	 * Generic Operations
+	 *******************************************************************************************/
+
+	// runs an aggregation pipeline on a collection and returns the resulting documents
+	RunAggregation(col string, pipe any) ([]bson.M, error)
+	// counts documents in a collection matching the filter
+	CountResults(col string, filter bson.D) int64
+
+	// decodes the first document matching the filter into result
+	// returns mongo.ErrNoDocuments when nothing matched
+	FindSingle(filter bson.D, result any, col string) error
+	// decodes every document matching the filter into results (pointer to a slice)
+	FindMulti(filter bson.D, results any, col string) error
+
+	SaveNewSingle(document any, col string) error
+	SaveNewMulti(documents []any, col string) error
+
+	// replaces the document with the given _id
+	ReplaceSingle(id primitive.ObjectID, document any, col string) error
+	// applies update operators ($set, $unset, $inc, $push, $pull, $addToSet) to every matching document
+	UpdateMulti(filter bson.D, update bson.D, col string) (int64, error)
+	// applies update operators to the first matching document, inserting one made from the filter's
+	// fields when nothing matches. the filter should be on a unique index so only one is ever inserted
+	UpsertSingle(filter bson.D, update bson.D, col string) error
+
+	DeleteSingle(id primitive.ObjectID, col string) error
+	DeleteMulti(filter bson.D, col string) (int64, error)
+
+	// hit and miss counts of each cache table, keyed by collection
+	CacheStats() map[string]CacheStats
+
+	/*******************************************************************************************
+	 * Article Operations
+	 *******************************************************************************************/
+
+	FindArticleBySlug(slug string) (*Article, error)
+	UpdateArticle(article *Article) error
+
+	/*******************************************************************************************
+	 * Board Operations
+	 *******************************************************************************************/
+
+	FindAllBoards() ([]Board, error)
+	FindBoardByShort(short string) (*Board, error)
+	FindBoardByObjectID(id primitive.ObjectID) (*Board, error)
+	UpdateBoard(board *Board) error
+	// takes the next post number on the board, numbers are never handed out twice
+	NextPostNumber(board_id primitive.ObjectID) (uint64, error)
+
+	/*******************************************************************************************
+	 * Thread Operations
+	 *******************************************************************************************/
+
+	FindThreadBySlug(slug string) (*Thread, error)
+	UpdateThread(thread *Thread) error
+
+	/*******************************************************************************************
+	 * Account Operations
+	 *******************************************************************************************/
+
+	FindAccountByID(id primitive.ObjectID) (*Account, error)
+	FindAccountByUsernameOrEmail(username string, email string) (*Account, error)
+	FindAccountFromSession(id string) (*Account, error)
+	UpdateAccount(account *Account) error
+	// returns the account's most recent history entries, newest first
+	FindAccountHistory(account_id primitive.ObjectID) ([]*AccountHistory, error)
+
+	/*******************************************************************************************
+	 * Board Role Operations
+	 *******************************************************************************************/
+
+	// returns the roles the account was granted on single boards
+	FindAccountBoardRoles(account_id primitive.ObjectID) ([]*BoardRole, error)
+
+	/*******************************************************************************************
+	 * Sanction Operations
+	 *******************************************************************************************/
+
+	// returns every suspension and ban issued against the account
+	FindAccountSanctions(account_id primitive.ObjectID) ([]*Sanction, error)
+
+	/*******************************************************************************************
+	 * Report Operations
+	 *******************************************************************************************/
+
+	// returns the reports the account filed, newest first
+	FindAccountReports(account_id primitive.ObjectID) ([]*Report, error)
+
+	/*******************************************************************************************
+	 * Data Export Operations
+	 *******************************************************************************************/
+
+	// stores the zip file of an export apart from the export, archives can outgrow a document
+	SaveDataExportArchive(export_id primitive.ObjectID, archive []byte) error
+	// writes the zip file of an export to w
+	// returns mongo.ErrNoDocuments when the export has no archive
+	WriteDataExportArchive(export_id primitive.ObjectID, w io.Writer) error
+	// removes the zip file of an export, nothing happens if it has none
+	DeleteDataExportArchive(export_id primitive.ObjectID) error
+
+	/*******************************************************************************************
+	 * External Login Operations
+	 *******************************************************************************************/
+
+	// returns the account link for the provider's user
+	FindExternalLogin(provider string, subject string) (*ExternalLogin, error)
+
+	/*******************************************************************************************
+	 * Session Operations
+	 *******************************************************************************************/
+
+	FindSession(id string) (*Session, error)
+	UpdateSession(session *Session) error
+	// removes the session from storage and any caches holding it
+	DeleteSession(session *Session) error
+	// returns the account's sessions that haven't expired, most recently used first
+	FindAccountSessions(account_id primitive.ObjectID) ([]*Session, error)
+	// removes every session belonging to the account except the ones with the given _ids
+	DeleteAccountSessions(account_id primitive.ObjectID, except ...primitive.ObjectID) error
+
+	/*******************************************************************************************
+	 * API Token Operations
+	 *******************************************************************************************/
+
+	// finds an api token by the hash of the token
+	FindAPIToken(hash string) (*APIToken, error)
+
+	/*******************************************************************************************
+	 * Login Attempt Operations
+	 *******************************************************************************************/
+
+	// returns the failed attempts recorded against the key, a fresh record if there are none
+	FindLoginAttempts(key string) (*LoginAttempts, error)
+	// records a failed attempt against the key in a single update, returns the record as it is after
+	RecordLoginFailure(key string) (*LoginAttempts, error)
+	ClearLoginAttempts(keys ...string) error
+
+	/*******************************************************************************************
+	 * Settings Operations
+	 *******************************************************************************************/
+
+	// returns the site settings, DefaultSettings if none have been saved
+	FindSettings() (*Settings, error)
+	// inserts or replaces the site settings
+	UpdateSettings(settings *Settings) error
+
+	/*******************************************************************************************
+	 * Asset Operations
+	 *******************************************************************************************/
+
+	AssetHashCollisionResolver(queries ...primitive.D) (*AssetSource, error)
+	UpdateAssetSource(source *AssetSource) error
+	FindAssetByID(id primitive.ObjectID) (*Asset, error)
+	FindAssetSourceByHash(hash string) (*AssetSource, error)
+	FindAssetsBySourceIDAccountID(source_id, account_id primitive.ObjectID) ([]*Asset, error)
+
+	/*******************************************************************************************
+	 * Identity Operations
+	 *******************************************************************************************/
+
+	ResolveIdentity(account_id, thread_id primitive.ObjectID) (*Identity, error)
+}
+
+// compile time checks that both backends satisfy the Repository
+var (
+	_ Repository = (*Store)(nil)
+	_ Repository = (*MemoryStore)(nil)
+)
@@ -1,6 +1,10 @@
 package types
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type RequestUnmarshaller interface {
 	UnmarshalFromReqInto(*RequestCtx) error
@@ -10,6 +14,43 @@ type RequestUnmarshaller interface {
 /* Request UnMarshaller structs - used to unmarshal request bodies into objects
 /***********************************************************************************************/
 
+// account registration requests
+type RUMRegister struct {
+	Username        string `json:"username"`
+	Email           string `json:"email"`
+	Password        string `json:"password"`
+	ConfirmPassword string `json:"confirm_password"`
+}
+
+// trims whitespace and lowercases the email, the username keeps it's casing for display
+func (r *RUMRegister) Normalize() {
+	r.Username = strings.TrimSpace(r.Username)
+	r.Email = NormalizeEmail(r.Email)
+}
+
+// checks every field and returns all the problems found
+func (r *RUMRegister) Validate() FieldErrors {
+	fields := FieldErrors{}
+
+	if problem := ValidateUsername(r.Username); problem != "" {
+		fields.Add("username", problem)
+	}
+
+	if problem := ValidateEmail(r.Email); problem != "" {
+		fields.Add("email", problem)
+	}
+
+	if problem := ValidatePassword(r.Password, r.Username); problem != "" {
+		fields.Add("password", problem)
+	}
+
+	if r.ConfirmPassword != r.Password {
+		fields.Add("confirm_password", "does not match password")
+	}
+
+	return fields
+}
+
 // Session will be an ID in the cookie
 type RUMSession struct {
 	SessionID string `json:"session"`
@@ -0,0 +1,163 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	SANCTION_REASON_MAX_LENGTH = 500
+	// the longest a suspension can be, anything longer should be a ban
+	SANCTION_MAX_SUSPENSION_DAYS = 365
+)
+
+type SanctionKind string
+
+const (
+	SanctionKindSuspension SanctionKind = "suspension" // always timed
+	SanctionKindBan        SanctionKind = "ban"        // permanent unless given an expiry
+)
+
+func (k SanctionKind) IsValid() bool {
+	return k == SanctionKindSuspension || k == SanctionKindBan
+}
+
+// the account status the sanction puts the account in
+func (k SanctionKind) Status() AccountStatus {
+	if k == SanctionKindBan {
+		return AccountStatusBanned
+	}
+	return AccountStatusSuspended
+}
+
+// a suspension or ban issued by staff, a sanctioned account can still log in and read it's own data
+// but can't do anything else until the sanction expires or is lifted
+type Sanction struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	AccountID primitive.ObjectID `bson:"account_id" json:"account_id"`
+	Kind      SanctionKind       `bson:"kind" json:"kind"`
+	Reason    string             `bson:"reason" json:"reason"`
+	IssuedBy  primitive.ObjectID `bson:"issued_by" json:"issued_by"`
+
+	// the status the account goes back to once no sanction applies
+	PriorStatus AccountStatus `bson:"prior_status" json:"-"`
+
+	StartsAt  *time.Time          `bson:"starts_at" json:"starts_at"`
+	ExpiresAt *time.Time          `bson:"expires_at,omitempty" json:"expires_at,omitempty"` // nil never expires
+	LiftedAt  *time.Time          `bson:"lifted_at,omitempty" json:"lifted_at,omitempty"`
+	LiftedBy  *primitive.ObjectID `bson:"lifted_by,omitempty" json:"lifted_by,omitempty"`
+	CreatedAt *time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// creates a sanction against the account starting now
+// - accepts the sanction currently in force on the account, nil if there isn't one
+func NewSanction(account *Account, current *Sanction, kind SanctionKind, reason string, issued_by primitive.ObjectID, expires *time.Time) *Sanction {
+	ts := time.Now().UTC()
+
+	// stacked sanctions all go back to what the account was before the first one
+	prior := account.Status
+	if current != nil {
+		prior = current.PriorStatus
+	}
+
+	return &Sanction{
+		ID:          primitive.NewObjectID(),
+		AccountID:   account.ID,
+		Kind:        kind,
+		Reason:      reason,
+		IssuedBy:    issued_by,
+		PriorStatus: prior,
+		StartsAt:    &ts,
+		ExpiresAt:   expires,
+		CreatedAt:   &ts,
+	}
+}
+
+// does the sanction apply right now
+func (s *Sanction) IsActive() bool {
+	now := time.Now().UTC()
+
+	if s.LiftedAt != nil || s.StartsAt.After(now) {
+		return false
+	}
+
+	return s.ExpiresAt == nil || s.ExpiresAt.After(now)
+}
+
+// ends the sanction early
+func (s *Sanction) Lift(lifted_by primitive.ObjectID) {
+	ts := time.Now().UTC()
+	s.LiftedAt = &ts
+	s.LiftedBy = &lifted_by
+}
+
+// does the sanction outrank the other one, bans before suspensions then whichever lasts longer
+func (s *Sanction) outranks(other *Sanction) bool {
+	if s.Kind != other.Kind {
+		return s.Kind == SanctionKindBan
+	}
+	if s.ExpiresAt == nil || other.ExpiresAt == nil {
+		return s.ExpiresAt == nil && other.ExpiresAt != nil
+	}
+	return s.ExpiresAt.After(*other.ExpiresAt)
+}
+
+// ClientFormatter implementation
+func (s *Sanction) CLFormat() bson.M {
+	return bson.M{
+		"_id":        s.ID,
+		"kind":       s.Kind,
+		"reason":     s.Reason,
+		"starts_at":  s.StartsAt,
+		"expires_at": s.ExpiresAt,
+		"lifted_at":  s.LiftedAt,
+	}
+}
+
+// Resolve Account Sanction
+// finds the sanction in force on the account and keeps the account's status in step with it,
+// an account whose sanctions have all expired or been lifted gets it's old status back
+// - returns nil if no sanction applies
+func ResolveAccountSanction(repo Repository, account *Account) (*Sanction, error) {
+	sanctions, err := repo.FindAccountSanctions(account.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var active *Sanction
+	var latest *Sanction
+	for _, v := range sanctions {
+		if latest == nil || v.CreatedAt.After(*latest.CreatedAt) {
+			latest = v
+		}
+		if v.IsActive() && (active == nil || v.outranks(active)) {
+			active = v
+		}
+	}
+
+	status := account.Status
+	if active != nil {
+		status = active.Kind.Status()
+	} else if account.IsSanctioned() && latest != nil {
+		status = latest.PriorStatus
+	}
+
+	if status != account.Status && !account.IsDeleted() {
+		ts := time.Now().UTC()
+		account.Status = status
+		account.UpdatedAt = &ts
+
+		if err := repo.UpdateAccount(account); err != nil {
+			return nil, err
+		}
+	}
+
+	return active, nil
+}
+
+// is the account's status a suspension or ban, the sanction records decide whether it still applies
+func (a *Account) IsSanctioned() bool {
+	return a.Status == AccountStatusSuspended || a.Status == AccountStatusBanned
+}
@@ -1,8 +1,10 @@
 package types
 
 import (
+	"net/http"
 	"time"
 
+	"github.com/dd-web/opforu-server/internal/utils"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -27,6 +29,14 @@ var (
 	HOURS_IN_WEEK = HOURS_IN_DAY * DAYS_IN_WEEK
 	HOURS_IN_YEAR = HOURS_IN_DAY * DAYS_IN_YEAR
 
+	// how often a session's last seen time is written, so every request isn't a write
+	SESSION_LAST_SEEN_INTERVAL_MINUTES = 5
+
+	// the cookie the session id is sent in
+	SESSION_COOKIE_NAME = "session"
+	// the header state changing requests must send the session's csrf token in
+	CSRF_HEADER_NAME = "X-CSRF-Token"
+
 	// permissions
 	PUBLIC_SESSION_FIELDS   = []string{"created_at", "updated_at", "deleted_at"}
 	PERSONAL_SESSION_FIELDS = []string{"_id", "account_id", "session_id", "expires"}
@@ -41,6 +51,14 @@ type Session struct {
 
 	Expires *time.Time `bson:"expires" json:"expires"`
 
+	// the client the session was created from
+	IP         string     `bson:"ip,omitempty" json:"ip,omitempty"`
+	UserAgent  string     `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	LastSeenAt *time.Time `bson:"last_seen_at,omitempty" json:"last_seen_at,omitempty"`
+
+	// when the user last signed in with a provider again from the session, see OIDC_REAUTH_MINUTES
+	ReauthenticatedAt *time.Time `bson:"reauthenticated_at,omitempty" json:"-"`
+
 	CreatedAt *time.Time `bson:"created_at" json:"created_at"`
 	UpdatedAt *time.Time `bson:"updated_at" json:"updated_at"`
 	DeletedAt *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
@@ -53,36 +71,95 @@ func NewSession(account *Account) *Session {
 	exp := time.Now().Add(time.Duration(time.Hour * 24 * 7)).UTC() // 1 week vailidity
 
 	return &Session{
-		ID:        primitive.NewObjectID(),
-		SessionID: id,
-		AccountID: account.ID,
-		Account:   account,
-		Expires:   &exp,
-		CreatedAt: &ts,
-		UpdatedAt: &ts,
+		ID:         primitive.NewObjectID(),
+		SessionID:  id,
+		AccountID:  account.ID,
+		Account:    account,
+		Expires:    &exp,
+		LastSeenAt: &ts,
+		CreatedAt:  &ts,
+		UpdatedAt:  &ts,
 	}
 }
 
+// did the user sign in with a provider again from the session recently enough to skip the password
+func (s *Session) IsRecentlyReauthenticated() bool {
+	if s.ReauthenticatedAt == nil {
+		return false
+	}
+	return s.ReauthenticatedAt.Add(time.Duration(OIDC_REAUTH_MINUTES) * time.Minute).After(time.Now().UTC())
+}
+
 // is the session expired?
 func (s *Session) IsExpired() bool {
 	return s.Expires.Before(time.Now().UTC())
 }
 
-// will the session expire within the next hour?
+// will the session expire within the next day?
 func (s *Session) IsExpiringSoon() bool {
-	return s.Expires.Before(time.Now().Add(-time.Duration(time.Minute * 60 * 24))) // 1 day refresh
+	return s.Expires.Before(time.Now().Add(time.Duration(time.Minute * 60 * 24))) // 1 day refresh
 }
 
 // is the session expiring in the next 5 minutes?
 func (s *Session) IsExpiryImminent() bool {
-	return s.Expires.Before(time.Now().Add(-time.Duration(time.Minute * 5)))
+	return s.Expires.Before(time.Now().Add(time.Duration(time.Minute * 5)))
 }
 
 // implements the ClientFormatter interface
+// the session id only ever travels in the HttpOnly cookie, the client gets the csrf token instead
 func (s *Session) CLFormat() bson.M {
 	return bson.M{
-		"session_id": s.SessionID,
 		"account_id": s.AccountID,
 		"expires":    s.Expires,
+		"csrf_token": utils.CSRFToken(s.SessionID),
+	}
+}
+
+// sets the session cookie, it lives as long as the session does
+func SetSessionCookie(w http.ResponseWriter, s *Session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SESSION_COOKIE_NAME,
+		Value:    s.SessionID,
+		Path:     "/",
+		Expires:  *s.Expires,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// tells the client to drop the session cookie
+func ClearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SESSION_COOKIE_NAME,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// should the last seen time be refreshed
+func (s *Session) IsLastSeenStale() bool {
+	if s.LastSeenAt == nil {
+		return true
+	}
+	return time.Since(*s.LastSeenAt) > time.Duration(SESSION_LAST_SEEN_INTERVAL_MINUTES)*time.Minute
+}
+
+// formats the session for the account's list of active sessions
+// the session_id is the login secret and is never included, sessions are referred to by _id
+// - accepts whether this is the session making the request
+func (s *Session) ListFormat(current bool) bson.M {
+	return bson.M{
+		"_id":          s.ID,
+		"ip":           s.IP,
+		"user_agent":   s.UserAgent,
+		"current":      current,
+		"last_seen_at": s.LastSeenAt,
+		"created_at":   s.CreatedAt,
+		"expires":      s.Expires,
 	}
 }
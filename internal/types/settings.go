@@ -0,0 +1,60 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// site wide settings managed by admins, there is only ever one settings document
+type Settings struct {
+	ID primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+
+	// staff accounts without two-factor enabled are treated as regular users
+	RequireStaffTwoFactor bool `bson:"require_staff_two_factor" json:"require_staff_two_factor"`
+
+	// submissions scoring at least SpamHoldScore are held for review, SpamRejectScore and up are turned away
+	SpamHoldScore   float64 `bson:"spam_hold_score" json:"spam_hold_score"`
+	SpamRejectScore float64 `bson:"spam_reject_score" json:"spam_reject_score"`
+	// phrases that mark a submission as spam, matched case insensitively
+	SpamBannedPhrases []string `bson:"spam_banned_phrases" json:"spam_banned_phrases"`
+
+	// how long after posting an author can still edit
+	PostEditWindowMinutes int `bson:"post_edit_window_minutes" json:"post_edit_window_minutes"`
+
+	UpdatedAt *time.Time          `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+	UpdatedBy *primitive.ObjectID `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+}
+
+// the settings used until an admin saves some
+func DefaultSettings() *Settings {
+	return &Settings{
+		ID:                    primitive.NewObjectID(),
+		RequireStaffTwoFactor: false,
+		SpamHoldScore:         DEFAULT_SPAM_HOLD_SCORE,
+		SpamRejectScore:       DEFAULT_SPAM_REJECT_SCORE,
+		SpamBannedPhrases:     []string{},
+		PostEditWindowMinutes: DEFAULT_POST_EDIT_WINDOW_MINUTES,
+	}
+}
+
+// the hold and reject scores, the defaults for settings saved before they existed
+func (s *Settings) SpamThresholds() (float64, float64) {
+	hold, reject := s.SpamHoldScore, s.SpamRejectScore
+	if hold <= 0 {
+		hold = DEFAULT_SPAM_HOLD_SCORE
+	}
+	if reject <= 0 {
+		reject = DEFAULT_SPAM_REJECT_SCORE
+	}
+	return hold, reject
+}
+
+// how long authors can edit for, the default for settings saved before it existed
+func (s *Settings) EditWindow() time.Duration {
+	minutes := s.PostEditWindowMinutes
+	if minutes <= 0 {
+		minutes = DEFAULT_POST_EDIT_WINDOW_MINUTES
+	}
+	return time.Duration(minutes) * time.Minute
+}
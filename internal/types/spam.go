@@ -0,0 +1,285 @@
+package types
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// used until an admin sets their own in the site settings
+	DEFAULT_SPAM_HOLD_SCORE   = 1.0
+	DEFAULT_SPAM_REJECT_SCORE = 2.5
+
+	// accounts younger than this look more like throwaways the younger they are
+	SPAM_NEW_ACCOUNT_HOURS = 24
+
+	// runs of the same character shorter than this are left alone
+	SPAM_REPEAT_MIN_RUN = 5
+
+	spamLinkPattern = regexp.MustCompile(`(?i)\bhttps?://|\bwww\.`)
+)
+
+// what happens to a submission once it's been scored
+type SpamAction string
+
+const (
+	SpamActionAccept SpamAction = "accept"
+	SpamActionHold   SpamAction = "hold"   // saved but hidden until staff look at it
+	SpamActionReject SpamAction = "reject" // never saved
+)
+
+// content about to be saved, everything a scorer gets to look at
+type SpamSubmission struct {
+	Target  ModTarget // thread, post or comment
+	Title   string    // threads only
+	Content string    // as written, before it's rendered
+	Account *Account
+}
+
+// the title and content together
+func (s *SpamSubmission) Text() string {
+	if s.Title == "" {
+		return s.Content
+	}
+	return s.Title + "\n" + s.Content
+}
+
+// a single check a submission goes through
+type SpamScorer interface {
+	// the key the scorer's result is reported under
+	Name() string
+	// how much the submission looks like spam, from 0 (not at all) to 1
+	Score(repo Repository, settings *Settings, sub *SpamSubmission) (float64, error)
+}
+
+type weightedSpamScorer struct {
+	scorer SpamScorer
+	weight float64
+}
+
+// the scorers every submission goes through, their weighted scores are added up and compared
+// against the thresholds in the site settings
+type SpamPipeline struct {
+	scorers []weightedSpamScorer
+}
+
+func NewSpamPipeline() *SpamPipeline {
+	return &SpamPipeline{scorers: []weightedSpamScorer{}}
+}
+
+// the pipeline submissions go through unless it's been replaced
+func DefaultSpamPipeline() *SpamPipeline {
+	return NewSpamPipeline().
+		Use(LinkDensityScorer{}, 1).
+		Use(RepeatedCharScorer{}, 0.5).
+		Use(BannedPhraseScorer{}, 1.5).
+		Use(NewAccountScorer{}, 0.5).
+		Use(BayesScorer{}, 1.5)
+}
+
+// adds a scorer, the weight is the most it can add to a submission's score
+func (p *SpamPipeline) Use(scorer SpamScorer, weight float64) *SpamPipeline {
+	p.scorers = append(p.scorers, weightedSpamScorer{scorer: scorer, weight: weight})
+	return p
+}
+
+// the outcome of running a submission through the pipeline
+type SpamVerdict struct {
+	Score  float64            `bson:"score" json:"score"`
+	Scores map[string]float64 `bson:"scores" json:"scores"` // weighted, by scorer name
+	Action SpamAction         `bson:"action" json:"action"`
+}
+
+// scores the submission and decides what happens to it
+// - returns an error if a scorer couldn't reach what it needed from storage
+func (p *SpamPipeline) Check(repo Repository, sub *SpamSubmission) (*SpamVerdict, error) {
+	settings, err := repo.FindSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	verdict := &SpamVerdict{Scores: map[string]float64{}, Action: SpamActionAccept}
+
+	for _, v := range p.scorers {
+		score, err := v.scorer.Score(repo, settings, sub)
+		if err != nil {
+			return nil, err
+		}
+
+		weighted := math.Max(0, math.Min(1, score)) * v.weight
+		verdict.Scores[v.scorer.Name()] = weighted
+		verdict.Score += weighted
+	}
+
+	hold, reject := settings.SpamThresholds()
+	switch {
+	case verdict.Score >= reject:
+		verdict.Action = SpamActionReject
+	case verdict.Score >= hold:
+		verdict.Action = SpamActionHold
+	}
+
+	return verdict, nil
+}
+
+/***********************************************************************************************/
+/* Scorers
+/***********************************************************************************************/
+
+// lots of links in little text
+type LinkDensityScorer struct{}
+
+func (LinkDensityScorer) Name() string { return "link_density" }
+
+func (LinkDensityScorer) Score(repo Repository, settings *Settings, sub *SpamSubmission) (float64, error) {
+	text := sub.Text()
+
+	links := len(spamLinkPattern.FindAllStringIndex(text, -1))
+	words := len(strings.Fields(text))
+	if links == 0 || words == 0 {
+		return 0, nil
+	}
+
+	// a link in a sentence is fine, a handful of links and nothing else isn't
+	return float64(links) / float64(words) * float64(links), nil
+}
+
+// the same character over and over
+type RepeatedCharScorer struct{}
+
+func (RepeatedCharScorer) Name() string { return "repeated_chars" }
+
+func (RepeatedCharScorer) Score(repo Repository, settings *Settings, sub *SpamSubmission) (float64, error) {
+	longest, run := 0, 0
+	var last rune
+
+	for i, r := range sub.Text() {
+		if i > 0 && r == last && r != ' ' && r != '\n' {
+			run++
+		} else {
+			run = 1
+		}
+		last = r
+
+		if run > longest {
+			longest = run
+		}
+	}
+
+	if longest < SPAM_REPEAT_MIN_RUN {
+		return 0, nil
+	}
+	return float64(longest-SPAM_REPEAT_MIN_RUN+1) / 16, nil
+}
+
+// phrases admins have banned in the site settings
+type BannedPhraseScorer struct{}
+
+func (BannedPhraseScorer) Name() string { return "banned_phrases" }
+
+func (BannedPhraseScorer) Score(repo Repository, settings *Settings, sub *SpamSubmission) (float64, error) {
+	text := strings.ToLower(sub.Text())
+
+	for _, phrase := range settings.SpamBannedPhrases {
+		if phrase != "" && strings.Contains(text, strings.ToLower(phrase)) {
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// accounts made moments ago, most spam comes from freshly registered throwaways
+type NewAccountScorer struct{}
+
+func (NewAccountScorer) Name() string { return "new_account" }
+
+func (NewAccountScorer) Score(repo Repository, settings *Settings, sub *SpamSubmission) (float64, error) {
+	if sub.Account == nil || sub.Account.CreatedAt == nil {
+		return 0, nil
+	}
+
+	window := time.Duration(SPAM_NEW_ACCOUNT_HOURS) * time.Hour
+	age := time.Since(*sub.Account.CreatedAt)
+	if age >= window {
+		return 0, nil
+	}
+
+	score := 1 - float64(age)/float64(window)
+	if !sub.Account.IsVerified() {
+		return score, nil
+	}
+	// a verified email is a little reassurance
+	return score / 2, nil
+}
+
+// what the classifier learned from staff decisions
+type BayesScorer struct{}
+
+func (BayesScorer) Name() string { return "classifier" }
+
+func (BayesScorer) Score(repo Repository, settings *Settings, sub *SpamSubmission) (float64, error) {
+	probability, err := SpamProbability(repo, sub.Text())
+	if err != nil {
+		return 0, err
+	}
+
+	// anything the classifier thinks is more likely fine than not adds nothing
+	return (probability - 0.5) * 2, nil
+}
+
+/***********************************************************************************************/
+/* Holds
+/***********************************************************************************************/
+
+type SpamHoldStatus string
+
+const (
+	SpamHoldStatusPending  SpamHoldStatus = "pending"
+	SpamHoldStatusApproved SpamHoldStatus = "approved" // the content was put up
+	SpamHoldStatusRejected SpamHoldStatus = "rejected" // the content stays down
+)
+
+// content the spam pipeline held back, saved deleted by the spam filter until staff decide on it
+type SpamHold struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"_id"`
+	TargetType ModTarget           `bson:"target_type" json:"target_type"`
+	TargetID   primitive.ObjectID  `bson:"target_id" json:"target_id"`
+	BoardID    *primitive.ObjectID `bson:"board_id,omitempty" json:"board_id,omitempty"`
+	Location   ReportLocation      `bson:"location" json:"location"`
+	AccountID  primitive.ObjectID  `bson:"account_id" json:"account_id"`
+
+	Verdict SpamVerdict    `bson:"verdict" json:"verdict"`
+	Status  SpamHoldStatus `bson:"status" json:"status"`
+
+	ReviewedBy *primitive.ObjectID `bson:"reviewed_by,omitempty" json:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time          `bson:"reviewed_at,omitempty" json:"reviewed_at,omitempty"`
+
+	CreatedAt *time.Time `bson:"created_at" json:"created_at"`
+}
+
+func NewSpamHold(account_id primitive.ObjectID, target ModTarget, target_id primitive.ObjectID, location ReportLocation, verdict *SpamVerdict) *SpamHold {
+	ts := time.Now().UTC()
+	return &SpamHold{
+		ID:         primitive.NewObjectID(),
+		TargetType: target,
+		TargetID:   target_id,
+		Location:   location,
+		AccountID:  account_id,
+		Verdict:    *verdict,
+		Status:     SpamHoldStatusPending,
+		CreatedAt:  &ts,
+	}
+}
+
+// holds with the status, all of them when it's empty
+func SpamHoldFilter(status SpamHoldStatus) bson.D {
+	if status == "" {
+		return bson.D{}
+	}
+	return bson.D{{Key: "status", Value: status}}
+}
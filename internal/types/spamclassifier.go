@@ -0,0 +1,136 @@
+package types
+
+import (
+	"math"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	// the classifier stays quiet until it's seen at least this many of both spam and fine content
+	SPAM_CLASSIFIER_MIN_TRAINING = 5
+
+	// words outside these lengths say nothing about a submission
+	SPAM_TOKEN_MIN_LENGTH = 2
+	SPAM_TOKEN_MAX_LENGTH = 32
+)
+
+// the _id of the corpus document, fixed so training can upsert it without ever making a second one
+var SpamCorpusID = primitive.ObjectID{11: 1}
+
+// how often a word was seen in content trained as spam and as fine
+type SpamToken struct {
+	ID    primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	Token string             `bson:"token" json:"token"`
+	Spam  int                `bson:"spam" json:"spam"`
+	Ham   int                `bson:"ham" json:"ham"`
+}
+
+// how much content the classifier has been trained on, there is only ever one corpus document
+type SpamCorpus struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	Spam      int                `bson:"spam" json:"spam"`
+	Ham       int                `bson:"ham" json:"ham"`
+	UpdatedAt *time.Time         `bson:"updated_at" json:"updated_at"`
+}
+
+// the distinct words of a text, lower cased
+func SpamTokens(text string) []string {
+	seen := map[string]bool{}
+	tokens := []string{}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	for _, w := range words {
+		if len(w) < SPAM_TOKEN_MIN_LENGTH || len(w) > SPAM_TOKEN_MAX_LENGTH || seen[w] {
+			continue
+		}
+		seen[w] = true
+		tokens = append(tokens, w)
+	}
+
+	return tokens
+}
+
+// the corpus counts, empty if the classifier has never been trained
+func FindSpamCorpus(repo Repository) (*SpamCorpus, error) {
+	corpus := &SpamCorpus{}
+	err := repo.FindSingle(bson.D{{Key: "_id", Value: SpamCorpusID}}, corpus, "spam_corpus")
+	if err == mongo.ErrNoDocuments {
+		return &SpamCorpus{}, nil
+	}
+	return corpus, err
+}
+
+// teaches the classifier that the text is spam or fine
+// every count is incremented in place so training from concurrent decisions all adds up
+func TrainSpamClassifier(repo Repository, text string, spam bool) error {
+	field := "ham"
+	if spam {
+		field = "spam"
+	}
+	inc := bson.D{{Key: "$inc", Value: bson.D{{Key: field, Value: 1}}}}
+
+	for _, token := range SpamTokens(text) {
+		if err := repo.UpsertSingle(bson.D{{Key: "token", Value: token}}, inc, "spam_tokens"); err != nil {
+			return err
+		}
+	}
+
+	corpus := bson.D{
+		{Key: "$inc", Value: bson.D{{Key: field, Value: 1}}},
+		{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now().UTC()}}},
+	}
+	return repo.UpsertSingle(bson.D{{Key: "_id", Value: SpamCorpusID}}, corpus, "spam_corpus")
+}
+
+// naive Bayes estimate of the chance the text is spam, 0.5 until the classifier has been
+// trained enough to have an opinion
+func SpamProbability(repo Repository, text string) (float64, error) {
+	corpus, err := FindSpamCorpus(repo)
+	if err != nil {
+		return 0, err
+	}
+
+	if corpus.Spam < SPAM_CLASSIFIER_MIN_TRAINING || corpus.Ham < SPAM_CLASSIFIER_MIN_TRAINING {
+		return 0.5, nil
+	}
+
+	tokens := SpamTokens(text)
+	if len(tokens) == 0 {
+		return 0.5, nil
+	}
+
+	known := []*SpamToken{}
+	err = repo.FindMulti(bson.D{{Key: "token", Value: bson.D{{Key: "$in", Value: tokens}}}}, &known, "spam_tokens")
+	if err != nil {
+		return 0, err
+	}
+
+	counts := map[string]*SpamToken{}
+	for _, v := range known {
+		counts[v.Token] = v
+	}
+
+	// log odds so long texts don't underflow, add-one smoothing for words never seen in one class
+	spam := math.Log(float64(corpus.Spam) / float64(corpus.Spam+corpus.Ham))
+	ham := math.Log(float64(corpus.Ham) / float64(corpus.Spam+corpus.Ham))
+
+	for _, token := range tokens {
+		seen, ok := counts[token]
+		if !ok {
+			continue
+		}
+		spam += math.Log(float64(seen.Spam+1) / float64(corpus.Spam+2))
+		ham += math.Log(float64(seen.Ham+1) / float64(corpus.Ham+2))
+	}
+
+	return 1 / (1 + math.Exp(ham-spam)), nil
+}
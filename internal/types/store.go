@@ -1,39 +1,22 @@
 package types
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log"
+	"sort"
 	"time"
 
 	"github.com/dd-web/opforu-server/internal/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// High level server cache for frequently used data to avoid network calls
-//
-// Boards are cached on server start, sessions and accounts are cached as they're accessed.
-type ServerCache struct {
-	Boards    map[string]*Board               // short -> Board
-	Sessions  map[string]*Session             // session_id -> Session
-	Accounts  map[primitive.ObjectID]*Account // _id -> Account
-	StartedAt *time.Time
-	EndedAt   *time.Time
-}
-
-func NewServerCache() *ServerCache {
-	ts := time.Now().UTC()
-	return &ServerCache{
-		Boards:    map[string]*Board{},
-		Sessions:  map[string]*Session{},
-		Accounts:  map[primitive.ObjectID]*Account{},
-		StartedAt: &ts,
-	}
-}
-
 // Global data store initialized on server start
 //
 // Store is initialized on server start and is referenced in all handlers.
@@ -159,11 +142,142 @@ func (s *Store) DeleteSingle(id primitive.ObjectID, col string) error {
 		return err
 	}
 
+	s.Cache.Invalidate(col, id)
 	fmt.Printf("Successfully deleted document from %s column\n", col)
 	return nil
 }
 
+// Delete Multiple Documents
+// - accepts a bson.D filter matching the documents to be deleted
+// - accepts a string of the collection name
+// - returns the number of deleted documents
+// - returns an error if one occurs
+func (s *Store) DeleteMulti(filter bson.D, col string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := s.DB.Collection(col)
+	result, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	if result.DeletedCount > 0 {
+		s.Cache.InvalidateAll(col)
+	}
+
+	fmt.Printf("Successfully deleted %d documents from %s column\n", result.DeletedCount, col)
+	return result.DeletedCount, nil
+}
+
+// Find a Single Document
+// - accepts a bson.D filter
+// - accepts a pointer the document will be decoded into
+// - accepts a string of the collection name
+// - returns mongo.ErrNoDocuments if nothing matched
+func (s *Store) FindSingle(filter bson.D, result any, col string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := s.DB.Collection(col)
+	return collection.FindOne(ctx, filter).Decode(result)
+}
+
+// Find Multiple Documents
+// - accepts a bson.D filter
+// - accepts a pointer to a slice the documents will be decoded into
+// - accepts a string of the collection name
+// - returns an error if one occurs
+func (s *Store) FindMulti(filter bson.D, results any, col string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := s.DB.Collection(col)
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		cursor.Close(ctx)
+	}()
+
+	return cursor.All(ctx, results)
+}
+
+// Replace a Single Document
+// - accepts a primitive.ObjectID of the document to be replaced
+// - accepts the replacement document
+// - accepts a string of the collection name
+// - returns an error if one occurs
+func (s *Store) ReplaceSingle(id primitive.ObjectID, document any, col string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := s.DB.Collection(col)
+	_, err := collection.ReplaceOne(ctx, bson.D{{Key: "_id", Value: id}}, document)
+	if err != nil {
+		return err
+	}
+
+	s.Cache.Invalidate(col, id)
+	return nil
+}
+
+// Update Multiple Documents
+// - accepts a bson.D filter matching the documents to update
+// - accepts a bson.D of update operators
+// - accepts a string of the collection name
+// - returns the number of modified documents
+// - returns an error if one occurs
+func (s *Store) UpdateMulti(filter bson.D, update bson.D, col string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := s.DB.Collection(col)
+	result, err := collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+
+	if result.ModifiedCount > 0 {
+		s.Cache.InvalidateAll(col)
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// Upsert a Single Document
+// - accepts a bson.D filter matching the document, on a unique index
+// - accepts a bson.D of update operators
+// - accepts a string of the collection name
+// - returns an error if one occurs
+func (s *Store) UpsertSingle(filter bson.D, update bson.D, col string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := s.DB.Collection(col)
+	_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	// two upserts inserting at once, the one that lost goes again and updates the winner's
+	if mongo.IsDuplicateKeyError(err) {
+		_, err = collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	}
+	if err != nil {
+		return err
+	}
+
+	s.Cache.InvalidateAll(col)
+	return nil
+}
+
+// Cache Stats
+// - returns the hit and miss counts of each cache table
+func (s *Store) CacheStats() map[string]CacheStats {
+	return s.Cache.Stats()
+}
+
 // Hydrate Cache
+// loads every board into the cache so board lookups don't hit the database
 // - returns an error if one occurs
 func (s *Store) HydrateCache() error {
 	collection := s.DB.Collection("boards")
@@ -186,7 +300,7 @@ func (s *Store) HydrateCache() error {
 			fmt.Println("Error decoding board", err)
 			continue
 		}
-		s.Cache.Boards[board.Short] = &board
+		s.cacheBoard(&board)
 	}
 
 	return nil
@@ -237,11 +351,27 @@ func (s *Store) UpdateArticle(article *Article) error {
  * Board Operations
  *******************************************************************************************/
 
+// Find all boards
+// - returns a slice of every board
+func (s *Store) FindAllBoards() ([]Board, error) {
+	boards := []Board{}
+
+	err := s.FindMulti(bson.D{}, &boards, "boards")
+	if err != nil {
+		return nil, err
+	}
+
+	return boards, nil
+}
+
 // Find board by short
 // - accepts a string of the board short name
 // - returns a pointer to the board
 func (s *Store) FindBoardByShort(short string) (*Board, error) {
 	board := &Board{}
+	if s.Cache.Boards.Get("short:"+short, board) {
+		return board, nil
+	}
 
 	collection := s.DB.Collection("boards")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -252,6 +382,7 @@ func (s *Store) FindBoardByShort(short string) (*Board, error) {
 		return nil, err
 	}
 
+	s.cacheBoard(board)
 	return board, nil
 }
 
@@ -259,8 +390,10 @@ func (s *Store) FindBoardByShort(short string) (*Board, error) {
 // - accepts primitive.ObjectID of the board (_id)
 // - returns a pointer to the board
 func (s *Store) FindBoardByObjectID(id primitive.ObjectID) (*Board, error) {
-	// var board *Board
 	board := &Board{}
+	if s.Cache.Boards.Get("id:"+id.Hex(), board) {
+		return board, nil
+	}
 
 	collection := s.DB.Collection("boards")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -271,6 +404,7 @@ func (s *Store) FindBoardByObjectID(id primitive.ObjectID) (*Board, error) {
 		return nil, err
 	}
 
+	s.cacheBoard(board)
 	return board, nil
 }
 
@@ -290,9 +424,41 @@ func (s *Store) UpdateBoard(board *Board) error {
 		return err
 	}
 
+	s.Cache.Invalidate("boards", board.ID)
 	return nil
 }
 
+// Next Post Number
+// increments the board's post_ref in place so concurrent replies never share a number
+// - accepts primitive.ObjectID of the board (_id)
+// - returns the number taken
+// - returns an error if one occurs
+func (s *Store) NextPostNumber(board_id primitive.ObjectID) (uint64, error) {
+	collection := s.DB.Collection("boards")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "post_ref", Value: 1}}},
+		{Key: "$set", Value: bson.D{{Key: "updated_at", Value: time.Now().UTC()}}},
+	}
+
+	board := &Board{}
+	err := collection.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: board_id}}, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(board)
+	if err != nil {
+		return 0, err
+	}
+
+	s.Cache.Invalidate("boards", board.ID)
+	return board.PostRef, nil
+}
+
+// boards are looked up by both short name and _id so they're cached under both
+func (s *Store) cacheBoard(board *Board) {
+	s.Cache.Boards.Set("short:"+board.Short, board)
+	s.Cache.Boards.Set("id:"+board.ID.Hex(), board)
+}
+
 /*******************************************************************************************
  * Thread Operations
  *******************************************************************************************/
@@ -347,6 +513,7 @@ func (s *Store) UpdateThread(thread *Thread) error {
 //
 //	Always queries the database since we don't index by username or email in the cache and it's not a frequently used query.
 //	Email is optional, if it's empty it will use the username (first parameter) to search by both username and email.
+//	Usernames and emails are matched case insensitively through their normalized forms.
 func (s *Store) FindAccountByUsernameOrEmail(username string, email string) (*Account, error) {
 	collection := s.DB.Collection("accounts")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -358,7 +525,7 @@ func (s *Store) FindAccountByUsernameOrEmail(username string, email string) (*Ac
 	}
 
 	result := &Account{}
-	err := collection.FindOne(ctx, bson.D{{Key: "$or", Value: bson.A{bson.D{{Key: "username", Value: username}}, bson.D{{Key: "email", Value: criteraTwo}}}}}).Decode(&result)
+	err := collection.FindOne(ctx, accountLookupFilter(username, criteraTwo)).Decode(&result)
 	if err != nil {
 		return nil, err
 	}
@@ -366,6 +533,69 @@ func (s *Store) FindAccountByUsernameOrEmail(username string, email string) (*Ac
 	return result, nil
 }
 
+// matches an account by normalized username or email
+func accountLookupFilter(username string, email string) bson.D {
+	return bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "username_key", Value: NormalizeUsername(username)}},
+		bson.D{{Key: "email", Value: NormalizeEmail(email)}},
+	}}}
+}
+
+// Find Account By ID
+// - accepts primitive.ObjectID of the account (_id)
+// - returns a pointer to the account
+// - returns an error if one occurs
+func (s *Store) FindAccountByID(id primitive.ObjectID) (*Account, error) {
+	account := &Account{}
+	if s.Cache.Accounts.Get(id.Hex(), account) {
+		return account, nil
+	}
+
+	err := s.FindSingle(bson.D{{Key: "_id", Value: id}}, account, "accounts")
+	if err != nil {
+		return nil, err
+	}
+
+	s.Cache.Accounts.Set(id.Hex(), account)
+	return account, nil
+}
+
+// Update the provided account
+// uses the provided account's ID to determine which to update
+// - accepts a pointer to the account
+// - returns an error if one occurred, else nil
+func (s *Store) UpdateAccount(account *Account) error {
+	return s.ReplaceSingle(account.ID, account, "accounts")
+}
+
+// Find Account History
+// - accepts primitive.ObjectID of the account
+// - returns up to ACCOUNT_HISTORY_LIMIT entries, newest first
+// - returns an error if one occurred
+func (s *Store) FindAccountHistory(account_id primitive.ObjectID) ([]*AccountHistory, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(ACCOUNT_HISTORY_LIMIT))
+
+	collection := s.DB.Collection("account_history")
+	cursor, err := collection.Find(ctx, bson.D{{Key: "account_id", Value: account_id}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		cursor.Close(ctx)
+	}()
+
+	history := []*AccountHistory{}
+	if err = cursor.All(ctx, &history); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
 // Find Account By Session ID
 // - accepts a string of the session id
 // - returns a pointer to the associated account
@@ -375,32 +605,168 @@ func (s *Store) FindAccountFromSession(id string) (*Account, error) {
 		return nil, fmt.Errorf("session id is empty")
 	}
 
-	session := &Session{}
-	account := &Account{}
+	session, err := s.FindSession(id)
+	if err != nil {
+		return nil, err
+	}
 
-	{
-		collection := s.DB.Collection("sessions")
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+	return s.FindAccountByID(session.AccountID)
+}
 
-		err := collection.FindOne(ctx, bson.D{{Key: "session_id", Value: id}}).Decode(&session)
-		if err != nil {
-			return nil, err
-		}
+/*******************************************************************************************
+ * Board Role Operations
+ *******************************************************************************************/
+
+// Find Account Board Roles
+// - accepts primitive.ObjectID of the account
+// - returns the roles granted to the account on single boards
+// - returns an error if one occurred
+func (s *Store) FindAccountBoardRoles(account_id primitive.ObjectID) ([]*BoardRole, error) {
+	roles := []*BoardRole{}
+
+	err := s.FindMulti(bson.D{{Key: "account_id", Value: account_id}}, &roles, "board_roles")
+	if err != nil {
+		return nil, err
 	}
 
-	{
-		collection := s.DB.Collection("accounts")
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+	return roles, nil
+}
 
-		err := collection.FindOne(ctx, bson.D{{Key: "_id", Value: session.AccountID}}).Decode(&account)
-		if err != nil {
-			return nil, err
-		}
+/*******************************************************************************************
+ * Sanction Operations
+ *******************************************************************************************/
+
+// Find Account Sanctions
+// - accepts primitive.ObjectID of the account
+// - returns every suspension and ban issued against the account, including expired and lifted ones
+// - returns an error if one occurred
+func (s *Store) FindAccountSanctions(account_id primitive.ObjectID) ([]*Sanction, error) {
+	sanctions := []*Sanction{}
+
+	err := s.FindMulti(bson.D{{Key: "account_id", Value: account_id}}, &sanctions, "account_sanctions")
+	if err != nil {
+		return nil, err
 	}
 
-	return account, nil
+	return sanctions, nil
+}
+
+/*******************************************************************************************
+ * Report Operations
+ *******************************************************************************************/
+
+// Find Account Reports
+// - accepts primitive.ObjectID of the reporting account
+// - returns every report the account filed, newest first
+// - returns an error if one occurred
+func (s *Store) FindAccountReports(account_id primitive.ObjectID) ([]*Report, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+
+	collection := s.DB.Collection("reports")
+	cursor, err := collection.Find(ctx, bson.D{{Key: "reporter_id", Value: account_id}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		cursor.Close(ctx)
+	}()
+
+	reports := []*Report{}
+	if err = cursor.All(ctx, &reports); err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+/*******************************************************************************************
+ * Data Export Operations
+ *******************************************************************************************/
+
+// the gridfs bucket export archives are kept in, stored by the _id of their export
+func (s *Store) dataExportBucket() (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(s.DB, options.GridFSBucket().SetName("data_export_archives"))
+}
+
+// Save Data Export Archive
+// - accepts primitive.ObjectID of the export and it's zip file
+// - returns an error if one occurred
+func (s *Store) SaveDataExportArchive(export_id primitive.ObjectID, archive []byte) error {
+	bucket, err := s.dataExportBucket()
+	if err != nil {
+		return err
+	}
+
+	if err := bucket.SetWriteDeadline(time.Now().Add(time.Minute)); err != nil {
+		return err
+	}
+
+	return bucket.UploadFromStreamWithID(export_id, export_id.Hex()+".zip", bytes.NewReader(archive))
+}
+
+// Write Data Export Archive
+// - accepts primitive.ObjectID of the export and where to write it's zip file
+// - returns mongo.ErrNoDocuments if the export has no archive
+// - returns an error if one occurred
+func (s *Store) WriteDataExportArchive(export_id primitive.ObjectID, w io.Writer) error {
+	bucket, err := s.dataExportBucket()
+	if err != nil {
+		return err
+	}
+
+	if err := bucket.SetReadDeadline(time.Now().Add(time.Minute)); err != nil {
+		return err
+	}
+
+	_, err = bucket.DownloadToStream(export_id, w)
+	if err == gridfs.ErrFileNotFound {
+		return mongo.ErrNoDocuments
+	}
+
+	return err
+}
+
+// Delete Data Export Archive
+// - accepts primitive.ObjectID of the export
+// - returns an error if one occurred, an export without an archive isn't one
+func (s *Store) DeleteDataExportArchive(export_id primitive.ObjectID) error {
+	bucket, err := s.dataExportBucket()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = bucket.DeleteContext(ctx, export_id)
+	if err == gridfs.ErrFileNotFound {
+		return nil
+	}
+
+	return err
+}
+
+/*******************************************************************************************
+ * External Login Operations
+ *******************************************************************************************/
+
+// Find External Login
+// - accepts the provider name and the provider's subject id for the user
+// - returns the link to the account the user signs in to
+// - returns mongo.ErrNoDocuments if the user hasn't been linked
+func (s *Store) FindExternalLogin(provider string, subject string) (*ExternalLogin, error) {
+	login := &ExternalLogin{}
+
+	err := s.FindSingle(bson.D{{Key: "provider", Value: provider}, {Key: "subject", Value: subject}}, login, "external_logins")
+	if err != nil {
+		return nil, err
+	}
+
+	return login, nil
 }
 
 /*******************************************************************************************
@@ -416,7 +782,10 @@ func (s *Store) FindSession(id string) (*Session, error) {
 		return nil, fmt.Errorf("session id is empty")
 	}
 
-	var session *Session
+	session := &Session{}
+	if s.Cache.Sessions.Get(id, session) {
+		return session, nil
+	}
 
 	collection := s.DB.Collection("sessions")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -427,6 +796,20 @@ func (s *Store) FindSession(id string) (*Session, error) {
 		return nil, err
 	}
 
+	s.Cache.Sessions.Set(id, session)
+
+	// the session could have been deleted between being read and cached, in which case the delete
+	// dropped the cached copy before there was one. checking again once it's cached means either this
+	// sees it's gone or the delete comes after and drops the copy made here
+	n, err := collection.CountDocuments(ctx, bson.D{{Key: "session_id", Value: id}})
+	if err != nil || n == 0 {
+		s.Cache.Sessions.Delete(id)
+		if err == nil {
+			err = mongo.ErrNoDocuments
+		}
+		return nil, err
+	}
+
 	return session, nil
 }
 
@@ -444,9 +827,213 @@ func (s *Store) UpdateSession(session *Session) error {
 	if err != nil {
 		return err
 	}
+
+	s.Cache.Sessions.Set(session.SessionID, session)
+	return nil
+}
+
+// Delete the provided session
+// removes the session document as well as any cached copy of it
+// - accepts a pointer to the session
+// - returns an error if one occurred, else nil
+func (s *Store) DeleteSession(session *Session) error {
+	err := s.DeleteSingle(session.ID, "sessions")
+	if err != nil {
+		return err
+	}
+
+	s.Cache.Sessions.Delete(session.SessionID)
 	return nil
 }
 
+// Find the active sessions of an account
+// - accepts primitive.ObjectID of the account
+// - returns the unexpired sessions, most recently used first
+// - returns an error if one occurred
+func (s *Store) FindAccountSessions(account_id primitive.ObjectID) ([]*Session, error) {
+	sessions := []*Session{}
+
+	err := s.FindMulti(accountSessionsFilter(account_id), &sessions, "sessions")
+	if err != nil {
+		return nil, err
+	}
+
+	sortSessionsByLastSeen(sessions)
+	return sessions, nil
+}
+
+// Delete every session of an account
+// used to sign an account out everywhere, e.g. after a password reset
+// - accepts primitive.ObjectID of the account
+// - accepts the _ids of any sessions to keep, e.g. the one making the request
+// - returns an error if one occurred, else nil
+func (s *Store) DeleteAccountSessions(account_id primitive.ObjectID, except ...primitive.ObjectID) error {
+	filter := bson.D{{Key: "account_id", Value: account_id}}
+	if len(except) > 0 {
+		filter = append(filter, bson.E{Key: "_id", Value: bson.D{{Key: "$nin", Value: except}}})
+	}
+
+	// deleting many sessions drops the cached ones along with them
+	_, err := s.DeleteMulti(filter, "sessions")
+	return err
+}
+
+// unexpired sessions of an account
+func accountSessionsFilter(account_id primitive.ObjectID) bson.D {
+	return bson.D{
+		{Key: "account_id", Value: account_id},
+		{Key: "expires", Value: bson.D{{Key: "$gt", Value: time.Now().UTC()}}},
+	}
+}
+
+func sortSessionsByLastSeen(sessions []*Session) {
+	lastSeen := func(s *Session) time.Time {
+		if s.LastSeenAt != nil {
+			return *s.LastSeenAt
+		}
+		return *s.CreatedAt
+	}
+
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return lastSeen(sessions[i]).After(lastSeen(sessions[j]))
+	})
+}
+
+/*******************************************************************************************
+ * API Token Operations
+ *******************************************************************************************/
+
+// Find API Token
+// - accepts a string of the token's hash (utils.HashToken)
+// - returns a pointer to the token
+// - returns an error if one occurs
+func (s *Store) FindAPIToken(hash string) (*APIToken, error) {
+	token := &APIToken{}
+
+	err := s.FindSingle(bson.D{{Key: "token_hash", Value: hash}}, token, "api_tokens")
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+/*******************************************************************************************
+ * Login Attempt Operations
+ *******************************************************************************************/
+
+// Find Login Attempts
+// - accepts a string of the attempts key (see LoginAttemptsAccountKey & LoginAttemptsIPKey)
+// - returns the recorded attempts, or a new empty record if nothing has been recorded
+// - returns an error if one occurs
+func (s *Store) FindLoginAttempts(key string) (*LoginAttempts, error) {
+	attempts := &LoginAttempts{}
+
+	err := s.FindSingle(bson.D{{Key: "key", Value: key}}, attempts, "login_attempts")
+	if err == mongo.ErrNoDocuments {
+		return NewLoginAttempts(key), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+// Record Login Failure
+// counts the failure and locks the key in a single update so concurrent failures can't overwrite
+// each other, failures that have expired are started over in the same update
+// - accepts a string of the attempts key
+// - returns the attempts as they are after the failure is recorded
+// - returns an error if one occurs
+func (s *Store) RecordLoginFailure(key string) (*LoginAttempts, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	until := now.Add(time.Duration(LOGIN_LOCKOUT_MINUTES) * time.Minute)
+	windowStart := now.Add(-time.Duration(LOGIN_FAILURE_WINDOW_MINUTES) * time.Minute)
+
+	// mirrors LoginAttempts.expired
+	expired := bson.D{{Key: "$cond", Value: bson.A{
+		bson.D{{Key: "$ne", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$locked_until", nil}}}, nil}}},
+		bson.D{{Key: "$lte", Value: bson.A{"$locked_until", now}}},
+		bson.D{{Key: "$lt", Value: bson.A{bson.D{{Key: "$ifNull", Value: bson.A{"$last_failure_at", time.Unix(0, 0)}}}, windowStart}}},
+	}}}
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.D{
+			{Key: "failures", Value: bson.D{{Key: "$add", Value: bson.A{
+				bson.D{{Key: "$cond", Value: bson.A{expired, 0, bson.D{{Key: "$ifNull", Value: bson.A{"$failures", 0}}}}}}, 1,
+			}}}},
+			{Key: "locked_until", Value: bson.D{{Key: "$cond", Value: bson.A{expired, "$$REMOVE", "$locked_until"}}}},
+			{Key: "last_failure_at", Value: now},
+		}}},
+		{{Key: "$set", Value: bson.D{
+			{Key: "locked_until", Value: bson.D{{Key: "$cond", Value: bson.A{
+				bson.D{{Key: "$gte", Value: bson.A{"$failures", LOGIN_MAX_FAILURES}}}, until, "$locked_until",
+			}}}},
+		}}},
+	}
+
+	collection := s.DB.Collection("login_attempts")
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	attempts := &LoginAttempts{}
+	err := collection.FindOneAndUpdate(ctx, bson.D{{Key: "key", Value: key}}, update, opts).Decode(attempts)
+	// two first failures upserting at once, the one that lost goes again and updates the winner's
+	if mongo.IsDuplicateKeyError(err) {
+		err = collection.FindOneAndUpdate(ctx, bson.D{{Key: "key", Value: key}}, update, opts).Decode(attempts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+// Clear Login Attempts
+// - accepts any number of attempts keys to clear
+// - returns an error if one occurs
+func (s *Store) ClearLoginAttempts(keys ...string) error {
+	_, err := s.DeleteMulti(bson.D{{Key: "key", Value: bson.D{{Key: "$in", Value: keys}}}}, "login_attempts")
+	return err
+}
+
+/*******************************************************************************************
+ * Settings Operations
+ *******************************************************************************************/
+
+// Find Settings
+// - returns the site settings, or the defaults if none have been saved yet
+// - returns an error if one occurs
+func (s *Store) FindSettings() (*Settings, error) {
+	settings := &Settings{}
+
+	err := s.FindSingle(bson.D{}, settings, "settings")
+	if err == mongo.ErrNoDocuments {
+		return DefaultSettings(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// Update Settings
+// inserts the settings if they've never been saved, replaces them otherwise
+// - accepts a pointer to the settings
+// - returns an error if one occurs
+func (s *Store) UpdateSettings(settings *Settings) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := s.DB.Collection("settings")
+	_, err := collection.ReplaceOne(ctx, bson.D{{Key: "_id", Value: settings.ID}}, settings, options.Replace().SetUpsert(true))
+	return err
+}
+
 /*******************************************************************************************
  * Asset Operations
  *******************************************************************************************/
@@ -499,6 +1086,14 @@ func (s *Store) assetColliderQuery(query primitive.D) (*AssetSource, error) {
 	return result, nil
 }
 
+// Update the provided asset source
+// uses the provided source's ID to determine which to update
+// - accepts a pointer to the asset source
+// - returns an error if one occurred, else nil
+func (s *Store) UpdateAssetSource(source *AssetSource) error {
+	return s.ReplaceSingle(source.ID, source, "asset_sources")
+}
+
 // find asset (not source) by it's id
 func (s *Store) FindAssetByID(id primitive.ObjectID) (*Asset, error) {
 	collection := s.DB.Collection("assets")
@@ -12,7 +12,7 @@ import (
 
 var (
 	// permissions
-	PUBLIC_THREAD_FIELDS = []string{"title", "body", "slug", "board", "creator", "posts", "mods", "status", "tags", "created_at", "updated_at", "deleted_at"}
+	PUBLIC_THREAD_FIELDS = []string{"title", "body", "slug", "board", "creator", "posts", "mods", "status", "tags", "created_at", "updated_at", "edited_at", "deleted_at", "deleted_by"}
 	MOD_THREAD_FIELDS    = []string{"flags"}
 	ADMIN_THREAD_FIELDS  = []string{"_id", "account"}
 
@@ -26,9 +26,11 @@ type Thread struct {
 	ID     primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
 	Status ThreadStatus       `bson:"status" json:"status"`
 
-	Title string `bson:"title" json:"title"`
-	Body  string `bson:"body" json:"body"`
-	Slug  string `bson:"slug" json:"slug"`
+	Title  string `bson:"title" json:"title"`
+	Body   string `bson:"body" json:"body"`
+	Raw    string `bson:"raw,omitempty" json:"-"`     // as written, before it's rendered into Body
+	IPHash string `bson:"ip_hash,omitempty" json:"-"` // who posted it, see utils.HashIP
+	Slug   string `bson:"slug" json:"slug"`
 
 	Board primitive.ObjectID `bson:"board" json:"board"`
 
@@ -45,7 +47,11 @@ type Thread struct {
 
 	CreatedAt *time.Time `bson:"created_at" json:"created_at"`
 	UpdatedAt *time.Time `bson:"updated_at" json:"updated_at"`
+	EditedAt  *time.Time `bson:"edited_at,omitempty" json:"edited_at,omitempty"`
 	DeletedAt *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy DeletedBy  `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+
+	RestoreStatus ThreadStatus `bson:"restore_status,omitempty" json:"-"` // the status before the thread was deleted
 }
 
 func NewThread() *Thread {
@@ -133,6 +139,55 @@ func (t *Thread) HasFlag(flag ThreadFlag) bool {
 	return false
 }
 
+// closed, archived and deleted threads can be read but not written to
+func (t *Thread) IsReadOnly() bool {
+	return t.Status != ThreadStatusOpen
+}
+
+// the error a write to the thread is rejected with, nil if the thread accepts writes
+// - deleted threads are gone, closed and archived ones are forbidden
+func (t *Thread) WriteError() *APIError {
+	var err APIError
+	switch t.Status {
+	case ThreadStatusOpen:
+		return nil
+	case ThreadStatusDeleted:
+		err = ErrorGone("thread")
+	default:
+		err = ErrorForbidden("thread is " + string(t.Status))
+	}
+	return &err
+}
+
+// matches the thread only while it still accepts writes, updates made with it can't land on a thread
+// that was closed, archived or deleted since it was loaded
+func ThreadWritableFilter(id primitive.ObjectID) bson.D {
+	return bson.D{
+		{Key: "_id", Value: id},
+		{Key: "status", Value: ThreadStatusOpen},
+		{Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: false}}},
+	}
+}
+
+// threads listed on a board, deleted ones are left out unless asked for
+func ThreadListingFilter(board_id primitive.ObjectID, includeDeleted bool) bson.D {
+	filter := bson.D{{Key: "board", Value: board_id}}
+	if !includeDeleted {
+		filter = append(filter, bson.E{Key: "status", Value: bson.D{{Key: "$ne", Value: ThreadStatusDeleted}}})
+	}
+	return filter
+}
+
+// is the identity one of the thread's mods
+func (t *Thread) IsMod(identity_id primitive.ObjectID) bool {
+	for _, v := range t.Mods {
+		if v == identity_id {
+			return true
+		}
+	}
+	return false
+}
+
 func (t *Thread) Validate() error {
 	if len(t.Title) < 2 {
 		return fmt.Errorf("Thread title is too short")
@@ -0,0 +1,142 @@
+package types
+
+import (
+	"strings"
+	"time"
+
+	"github.com/dd-web/opforu-server/internal/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// shown as the issuer in authenticator apps
+	TOTP_ISSUER = "opforu"
+	// how many one time recovery codes are issued when two-factor is enabled
+	RECOVERY_CODE_COUNT = 10
+	// how long a password-verified login has to complete the second step
+	LOGIN_CHALLENGE_MINUTES = 5
+)
+
+// an account's TOTP two-factor authentication settings
+// the secret is kept pending (not Enabled) until the account proves it's authenticator works
+type TwoFactor struct {
+	Secret  string `bson:"secret" json:"-"`
+	Enabled bool   `bson:"enabled" json:"enabled"`
+
+	// hashes of the unused recovery codes
+	RecoveryCodes []string `bson:"recovery_codes,omitempty" json:"-"`
+	// the last time step a code was accepted for, a code can't be used twice
+	LastStep int64 `bson:"last_step,omitempty" json:"-"`
+
+	EnabledAt *time.Time `bson:"enabled_at,omitempty" json:"enabled_at,omitempty"`
+}
+
+// starts enrollment with a fresh secret, replacing any unconfirmed one
+func NewTwoFactor() (*TwoFactor, error) {
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	return &TwoFactor{Secret: secret}, nil
+}
+
+// the otpauth uri for the account's authenticator app
+func (tf *TwoFactor) URI(account *Account) string {
+	return utils.TOTPURI(TOTP_ISSUER, account.Username, tf.Secret)
+}
+
+// checks a TOTP code and marks it's time step as used
+func (tf *TwoFactor) VerifyCode(code string) bool {
+	step, ok := utils.VerifyTOTP(tf.Secret, code, time.Now().UTC())
+	if !ok || step <= tf.LastStep {
+		return false
+	}
+	tf.LastStep = step
+	return true
+}
+
+// checks a recovery code and removes it so it can't be used again
+func (tf *TwoFactor) UseRecoveryCode(code string) bool {
+	hashed := utils.HashToken(normalizeRecoveryCode(code))
+	for i, v := range tf.RecoveryCodes {
+		if v == hashed {
+			tf.RecoveryCodes = append(tf.RecoveryCodes[:i], tf.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// accepts either a TOTP code or a recovery code
+func (tf *TwoFactor) Verify(code string) bool {
+	return tf.VerifyCode(code) || tf.UseRecoveryCode(code)
+}
+
+// confirms enrollment and issues a new set of recovery codes
+// - returns the plaintext recovery codes, they are only ever shown once
+func (tf *TwoFactor) Enable() ([]string, error) {
+	codes := make([]string, 0, RECOVERY_CODE_COUNT)
+	hashes := make([]string, 0, RECOVERY_CODE_COUNT)
+
+	for i := 0; i < RECOVERY_CODE_COUNT; i++ {
+		raw, err := utils.RandomHex(5)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, utils.HashToken(raw))
+	}
+
+	ts := time.Now().UTC()
+	tf.Enabled = true
+	tf.EnabledAt = &ts
+	tf.RecoveryCodes = hashes
+
+	return codes, nil
+}
+
+// recovery codes are accepted with or without the dash and in any case
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// does the account need a second factor to sign in
+func (a *Account) HasTwoFactor() bool {
+	return a.TwoFactor != nil && a.TwoFactor.Enabled
+}
+
+// a login that passed the password check and is waiting on the second factor
+// only the hash of the challenge token is stored
+type LoginChallenge struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	AccountID primitive.ObjectID `bson:"account_id" json:"account_id"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+	Restore   bool               `bson:"restore,omitempty" json:"restore,omitempty"` // restore the deleted account once the code is verified
+
+	Expires   *time.Time `bson:"expires" json:"expires"`
+	CreatedAt *time.Time `bson:"created_at" json:"created_at"`
+}
+
+// creates a new login challenge for the account
+// - returns the challenge to be saved and the plaintext token to give the client
+func NewLoginChallenge(account *Account) (*LoginChallenge, string, error) {
+	token, err := utils.RandomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ts := time.Now().UTC()
+	exp := ts.Add(time.Duration(LOGIN_CHALLENGE_MINUTES) * time.Minute)
+
+	return &LoginChallenge{
+		ID:        primitive.NewObjectID(),
+		AccountID: account.ID,
+		TokenHash: utils.HashToken(token),
+		Expires:   &exp,
+		CreatedAt: &ts,
+	}, token, nil
+}
+
+func (lc *LoginChallenge) IsExpired() bool {
+	return !lc.Expires.After(time.Now().UTC())
+}
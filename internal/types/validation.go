@@ -0,0 +1,113 @@
+package types
+
+import (
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	USERNAME_MIN_LENGTH = 3
+	USERNAME_MAX_LENGTH = 24
+
+	// bcrypt ignores everything past 72 bytes so longer passwords would give a false sense of security
+	PASSWORD_MIN_LENGTH = 10
+	PASSWORD_MAX_LENGTH = 72
+
+	EMAIL_MAX_LENGTH = 254
+
+	usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+)
+
+// problems with a request body keyed by the json field they belong to
+type FieldErrors map[string]string
+
+// records a problem with a field, only the first problem for each field is kept
+func (fe FieldErrors) Add(field, problem string) {
+	if _, ok := fe[field]; !ok {
+		fe[field] = problem
+	}
+}
+
+func (fe FieldErrors) Empty() bool {
+	return len(fe) == 0
+}
+
+// the canonical form of a username, two usernames with the same key are the same account
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// the canonical form of an email address
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Validate Username
+// - returns a description of the problem or an empty string if it's valid
+func ValidateUsername(username string) string {
+	switch {
+	case username == "":
+		return "required"
+	case len(username) < USERNAME_MIN_LENGTH:
+		return "too short"
+	case len(username) > USERNAME_MAX_LENGTH:
+		return "too long"
+	case !usernamePattern.MatchString(username):
+		return "may only contain letters, numbers, underscores and dashes"
+	}
+	return ""
+}
+
+// Validate Email
+// - returns a description of the problem or an empty string if it's valid
+func ValidateEmail(email string) string {
+	if email == "" {
+		return "required"
+	}
+
+	if len(email) > EMAIL_MAX_LENGTH {
+		return "too long"
+	}
+
+	// ParseAddress also accepts display names ("Bob <bob@example.com>"), we only want the bare address
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || !strings.Contains(email[strings.LastIndex(email, "@"):], ".") {
+		return "not a valid email address"
+	}
+
+	return ""
+}
+
+// Validate Password
+// - accepts the username so the password can't just be the username
+// - returns a description of the problem or an empty string if it's valid
+func ValidatePassword(password, username string) string {
+	switch {
+	case password == "":
+		return "required"
+	case len(password) < PASSWORD_MIN_LENGTH:
+		return "too short"
+	case len(password) > PASSWORD_MAX_LENGTH:
+		return "too long"
+	case username != "" && strings.Contains(strings.ToLower(password), NormalizeUsername(username)):
+		return "must not contain the username"
+	case isSingleCharClass(password):
+		return "too weak, mix letters with numbers, symbols or spaces"
+	}
+	return ""
+}
+
+// passwords made only of letters or only of digits are too easy to guess at these lengths
+func isSingleCharClass(s string) bool {
+	var letters, others bool
+	for _, r := range s {
+		if unicode.IsLetter(r) {
+			letters = true
+		} else {
+			others = true
+		}
+	}
+	return !(letters && others)
+}
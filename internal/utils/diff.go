@@ -0,0 +1,102 @@
+package utils
+
+import "strings"
+
+// how a line changed between two versions of a text
+type DiffOp string
+
+const (
+	DiffOpEqual  DiffOp = "="
+	DiffOpInsert DiffOp = "+"
+	DiffOpDelete DiffOp = "-"
+)
+
+// the most cells the longest common subsequence table may have, the lines both versions start and
+// end with don't count. past it a diff would take more memory than it's worth.
+var DIFF_MAX_CELLS = 1_000_000
+
+type DiffLine struct {
+	Op   DiffOp `json:"op" bson:"op"`
+	Text string `json:"text" bson:"text"`
+}
+
+// line by line diff of two versions of a text through their longest common subsequence
+// - returns every line of both versions in order, lines only in before are deletions and lines
+// only in after are insertions
+// - returns false instead when the versions differ by too much to diff, see DIFF_MAX_CELLS
+func DiffLines(before, after string) ([]DiffLine, bool) {
+	a, b := splitLines(before), splitLines(after)
+
+	// lines both versions start and end with are left out of the table, which is all of it for most edits
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	head, tail := a[:prefix], a[len(a)-suffix:]
+	a, b = a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	if (len(a)+1)*(len(b)+1) > DIFF_MAX_CELLS {
+		return nil, false
+	}
+
+	diff := make([]DiffLine, 0, len(head)+len(a)+len(b)+len(tail))
+	for _, line := range head {
+		diff = append(diff, DiffLine{Op: DiffOpEqual, Text: line})
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff = append(diff, DiffLine{Op: DiffOpEqual, Text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, DiffLine{Op: DiffOpDelete, Text: a[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{Op: DiffOpInsert, Text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, DiffLine{Op: DiffOpDelete, Text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, DiffLine{Op: DiffOpInsert, Text: b[j]})
+	}
+	for _, line := range tail {
+		diff = append(diff, DiffLine{Op: DiffOpEqual, Text: line})
+	}
+
+	return diff, true
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+}
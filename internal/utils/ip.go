@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	trustedProxies     []*net.IPNet
+	trustedProxiesOnce sync.Once
+	trustedProxiesMu   sync.RWMutex
+)
+
+// parses an address or a CIDR range, single addresses become a range of one
+func ParseIPNet(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.Contains(s, "/") {
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range %q", s)
+		}
+		return ipnet, nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", s)
+	}
+
+	bits := 128
+	if v4 := ip.To4(); v4 != nil {
+		ip, bits = v4, 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// the proxies trusted to tell us who they're forwarding for, read from TRUSTED_PROXIES as a
+// comma separated list of addresses and CIDR ranges. none are trusted by default.
+func TrustedProxies() []*net.IPNet {
+	trustedProxiesOnce.Do(func() {
+		v := os.Getenv("TRUSTED_PROXIES")
+		if v == "" {
+			return
+		}
+
+		if err := SetTrustedProxies(strings.Split(v, ",")); err != nil {
+			log.Fatal("TRUSTED_PROXIES: ", err)
+		}
+	})
+
+	trustedProxiesMu.RLock()
+	defer trustedProxiesMu.RUnlock()
+	return trustedProxies
+}
+
+// replaces the trusted proxies, for when they aren't configured through the environment
+func SetTrustedProxies(list []string) error {
+	parsed := []*net.IPNet{}
+	for _, v := range list {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+
+		ipnet, err := ParseIPNet(v)
+		if err != nil {
+			return err
+		}
+		parsed = append(parsed, ipnet)
+	}
+
+	// the environment is never read once they've been set
+	trustedProxiesOnce.Do(func() {})
+
+	trustedProxiesMu.Lock()
+	defer trustedProxiesMu.Unlock()
+	trustedProxies = parsed
+	return nil
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, v := range TrustedProxies() {
+		if v.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// the address of the client behind any trusted proxies
+// X-Forwarded-For is read right to left, each proxy appends the address it got the request
+// from, so the first address that isn't a trusted proxy is the client. anything further left
+// was sent by the client and can't be believed.
+// - accepts the address of the connection's peer and every X-Forwarded-For header sent
+func ClientIP(peer string, forwarded []string) string {
+	ip := net.ParseIP(peer)
+	if ip == nil || !isTrustedProxy(ip) {
+		return peer
+	}
+
+	hops := []string{}
+	for _, header := range forwarded {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+
+		client = hop.String()
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+
+	return client
+}
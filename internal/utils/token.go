@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	tokenSecret     []byte
+	tokenSecretOnce sync.Once
+)
+
+// the secret used to sign tokens, read from TOKEN_SECRET
+// in development a random secret is generated when it's not set, which means tokens
+// won't survive a restart. production must always set it.
+func TokenSecret() []byte {
+	tokenSecretOnce.Do(func() {
+		if v := os.Getenv("TOKEN_SECRET"); v != "" {
+			tokenSecret = []byte(v)
+			return
+		}
+
+		if IsProdEnv() {
+			log.Fatal("TOKEN_SECRET must be set in production")
+		}
+
+		tokenSecret = make([]byte, 32)
+		if _, err := rand.Read(tokenSecret); err != nil {
+			log.Fatal(err)
+		}
+	})
+	return tokenSecret
+}
+
+// creates a signed, expiring token for the given purpose and subject
+// the purpose is part of the signature so a token for one flow can't be replayed in another
+func SignToken(purpose, subject string, expires time.Time) string {
+	payload := strings.Join([]string{purpose, subject, strconv.FormatInt(expires.Unix(), 10)}, "|")
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + signPayload(encoded)
+}
+
+// verifies a token created by SignToken and returns it's subject
+func VerifyToken(purpose, token string) (string, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", fmt.Errorf("malformed token")
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(signPayload(encoded))) {
+		return "", fmt.Errorf("invalid token signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("malformed token")
+	}
+
+	parts := strings.Split(string(payload), "|")
+	if len(parts) != 3 || parts[0] != purpose {
+		return "", fmt.Errorf("invalid token purpose")
+	}
+
+	exp, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("malformed token")
+	}
+
+	if time.Now().Unix() > exp {
+		return "", fmt.Errorf("token expired")
+	}
+
+	return parts[1], nil
+}
+
+func signPayload(encoded string) string {
+	mac := hmac.New(sha256.New, TokenSecret())
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// the csrf token for a session, derived from the session id so nothing extra needs storing
+// and the token dies with the session
+func CSRFToken(sessionID string) string {
+	return signPayload("csrf|" + sessionID)
+}
+
+// checks a csrf token sent by the client against the session it was sent with
+func VerifyCSRFToken(sessionID, token string) bool {
+	return token != "" && hmac.Equal([]byte(token), []byte(CSRFToken(sessionID)))
+}
+
+// a keyed hash of a client address, stored in place of the address so content can be tied
+// back to whoever posted it without anyone being able to read the address
+func HashIP(ip string) string {
+	return signPayload("ip|" + ip)
+}
+
+// generates a random hex string from n random bytes
+func RandomHex(n int) (string, error) {
+	bs := make([]byte, n)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bs), nil
+}
+
+// hashes a random token for storage, tokens are high entropy so a fast hash is fine here
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// the public url of the front end, used to build links sent to users
+func AppURL() string {
+	if v := os.Getenv("APP_URL"); v != "" {
+		return strings.TrimSuffix(v, "/")
+	}
+	return "http://localhost:5173"
+}
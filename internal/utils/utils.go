@@ -60,8 +60,18 @@ func HashPassword(plaintext string) (string, error) {
 	return string(hashed), nil
 }
 
+// bcrypt hash of a password nobody has, compared against when there's no real hash so a missing
+// account or password takes as long to reject as a wrong one
+const dummyPasswordHash = "$2a$10$YWeUNLOCSbiKGKD76HAJtOg1Ujg9w/JORzOhcuheWq4ZUNKQ8wQ0y"
+
 // compare a hashed password with plaintext
+// - an empty hash never matches, but still costs a full comparison
 func CompareHash(hashed, plaintext string) bool {
+	if hashed == "" {
+		bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(plaintext))
+		return false
+	}
+
 	err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(plaintext))
 	if err != nil {
 		fmt.Println(err)
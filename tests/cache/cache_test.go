@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dd-web/opforu-server/internal/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCacheTableHitsAndCopies(t *testing.T) {
+	table := types.NewCacheTable(time.Minute, 10)
+
+	session := types.NewSession(&types.Account{ID: primitive.NewObjectID()})
+	table.Set(session.SessionID, session)
+
+	got := &types.Session{}
+	if !table.Get(session.SessionID, got) {
+		t.Fatal("want a hit for a cached session")
+	}
+	if got.ID != session.ID || got.AccountID != session.AccountID {
+		t.Fatalf("want the cached session got %+v", got)
+	}
+
+	// changing what came back must not change the cached copy
+	got.AccountID = primitive.NewObjectID()
+	again := &types.Session{}
+	table.Get(session.SessionID, again)
+	if again.AccountID != session.AccountID {
+		t.Fatal("cached session changed through a returned copy")
+	}
+
+	if table.Get("missing", &types.Session{}) {
+		t.Fatal("want a miss for an uncached key")
+	}
+
+	stats := table.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Fatalf("want 2 hits, 1 miss and 1 entry got %+v", stats)
+	}
+}
+
+func TestCacheTableRefreshedSession(t *testing.T) {
+	table := types.NewCacheTable(time.Minute, 10)
+
+	session := types.NewSession(&types.Account{ID: primitive.NewObjectID()})
+	table.Set(session.SessionID, session)
+
+	// a refresh saves the session and caches it again, the old expiry must not be served after
+	extended := session.Expires.Add(7 * 24 * time.Hour).Truncate(time.Millisecond)
+	session.Expires = &extended
+	table.Set(session.SessionID, session)
+
+	got := &types.Session{}
+	if !table.Get(session.SessionID, got) {
+		t.Fatal("want a hit for the refreshed session")
+	}
+	if !got.Expires.Equal(extended) {
+		t.Fatalf("want the refreshed expiry %v got %v", extended, got.Expires)
+	}
+	if stats := table.Stats(); stats.Entries != 1 {
+		t.Fatalf("want the refreshed session to replace the cached one got %+v", stats)
+	}
+}
+
+func TestCacheTableExpiry(t *testing.T) {
+	table := types.NewCacheTable(10*time.Millisecond, 10)
+	table.Set("a", bson.M{"_id": primitive.NewObjectID()})
+
+	time.Sleep(20 * time.Millisecond)
+
+	if table.Get("a", &bson.M{}) {
+		t.Fatal("want an expired entry to miss")
+	}
+	if stats := table.Stats(); stats.Entries != 0 {
+		t.Fatalf("want the expired entry dropped got %+v", stats)
+	}
+}
+
+func TestCacheTableEviction(t *testing.T) {
+	table := types.NewCacheTable(time.Minute, 2)
+	table.Set("a", bson.M{"n": 1})
+	table.Set("b", bson.M{"n": 2})
+
+	// using "a" makes "b" the least recently used
+	table.Get("a", &bson.M{})
+	table.Set("c", bson.M{"n": 3})
+
+	if table.Get("b", &bson.M{}) {
+		t.Fatal("want the least recently used entry evicted")
+	}
+	if !table.Get("a", &bson.M{}) || !table.Get("c", &bson.M{}) {
+		t.Fatal("want the recently used entries kept")
+	}
+	if stats := table.Stats(); stats.Evictions != 1 || stats.Entries != 2 {
+		t.Fatalf("want 1 eviction and 2 entries got %+v", stats)
+	}
+}
+
+func TestServerCacheInvalidate(t *testing.T) {
+	cache := types.NewServerCache()
+
+	account := &types.Account{ID: primitive.NewObjectID()}
+	first := types.NewSession(account)
+	second := types.NewSession(account)
+	cache.Sessions.Set(first.SessionID, first)
+	cache.Sessions.Set(second.SessionID, second)
+
+	cache.Invalidate("sessions", first.ID)
+	if cache.Sessions.Get(first.SessionID, &types.Session{}) {
+		t.Fatal("want the invalidated session dropped")
+	}
+	if !cache.Sessions.Get(second.SessionID, &types.Session{}) {
+		t.Fatal("want the other session kept")
+	}
+
+	cache.InvalidateAll("sessions")
+	if cache.Sessions.Stats().Entries != 0 {
+		t.Fatal("want every session dropped")
+	}
+
+	// documents cached under more than one key lose all of them
+	board := types.NewBoard()
+	board.Short = "tech"
+	cache.Boards.Set("short:"+board.Short, board)
+	cache.Boards.Set("id:"+board.ID.Hex(), board)
+
+	cache.Invalidate("boards", board.ID)
+	if stats := cache.Boards.Stats(); stats.Entries != 0 {
+		t.Fatalf("want every key of the board dropped got %+v", stats)
+	}
+
+	// replacing a key moves it to the new document's _id
+	other := &types.Account{ID: primitive.NewObjectID()}
+	cache.Accounts.Set("shared", account)
+	cache.Accounts.Set("shared", other)
+
+	cache.Invalidate("accounts", account.ID)
+	if !cache.Accounts.Get("shared", &types.Account{}) {
+		t.Fatal("want the key kept after invalidating the document it no longer holds")
+	}
+
+	// collections that aren't cached are ignored
+	cache.Invalidate("threads", first.ID)
+	cache.InvalidateAll("threads")
+}
+
+func TestCacheTableConcurrentUse(t *testing.T) {
+	table := types.NewCacheTable(time.Minute, 50)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for n := 0; n < 200; n++ {
+				key := fmt.Sprintf("%d-%d", worker, n%80)
+				table.Set(key, bson.M{"n": n})
+				table.Get(key, &bson.M{})
+				if n%25 == 0 {
+					table.DeleteWhere(func(doc bson.Raw) bool { return true })
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if stats := table.Stats(); stats.Entries > 50 {
+		t.Fatalf("want at most 50 entries got %d", stats.Entries)
+	}
+}
@@ -0,0 +1,3103 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dd-web/opforu-server/internal/handlers"
+	"github.com/dd-web/opforu-server/internal/types"
+	"github.com/dd-web/opforu-server/internal/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type testServer struct {
+	*httptest.Server
+	handler *types.RoutingHandler
+	store   *types.MemoryStore
+	mailer  *testMailer
+	board   *types.Board
+}
+
+// captures mail instead of sending it
+type testMailer struct {
+	mu   sync.Mutex
+	sent []*types.Mail
+}
+
+func (tm *testMailer) Send(mail *types.Mail) error {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.sent = append(tm.sent, mail)
+	return nil
+}
+
+// the token from the link in the most recent mail sent to the address
+func (tm *testMailer) lastToken(t *testing.T, to string) string {
+	t.Helper()
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	for i := len(tm.sent) - 1; i >= 0; i-- {
+		if tm.sent[i].To != to {
+			continue
+		}
+		_, rest, found := strings.Cut(tm.sent[i].Body, "token=")
+		if !found {
+			continue
+		}
+		token, err := url.QueryUnescape(strings.Fields(rest)[0])
+		if err != nil {
+			t.Fatalf("unescaping token failed: %+v", err)
+		}
+		return token
+	}
+
+	t.Fatalf("no mail with a token was sent to %s", to)
+	return ""
+}
+
+// spins up the api routes against an in-memory store seeded with a single board
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	store := types.NewMemoryStore()
+
+	board := types.NewBoard()
+	board.Title = "Technology"
+	board.Short = "tech"
+	if err := store.SaveNewSingle(board, "boards"); err != nil {
+		t.Fatalf("seeding board failed: %+v", err)
+	}
+
+	mailer := &testMailer{}
+	handler := types.NewRoutingHandler(store)
+	handler.Mailer = mailer
+	handlers.RegisterRoutes(handler)
+
+	srv := httptest.NewServer(handler.Router)
+	t.Cleanup(srv.Close)
+
+	return &testServer{Server: srv, handler: handler, store: store, mailer: mailer, board: board}
+}
+
+// sends a request with an optional json body and session, decoding the json response
+// unsafe methods carry the session's csrf token like the front end does
+func (ts *testServer) send(t *testing.T, method, path string, body any, session string) (*http.Response, map[string]any) {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		bs, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("marshalling body failed: %+v", err)
+		}
+		reader = bytes.NewReader(bs)
+	}
+
+	req, err := http.NewRequest(method, ts.URL+path, reader)
+	if err != nil {
+		t.Fatalf("creating request failed: %+v", err)
+	}
+
+	if session != "" {
+		req.AddCookie(&http.Cookie{Name: types.SESSION_COOKIE_NAME, Value: session})
+		if !types.IsSafeMethod(method) {
+			req.Header.Set(types.CSRF_HEADER_NAME, utils.CSRFToken(session))
+		}
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %+v", method, path, err)
+	}
+	defer res.Body.Close()
+
+	decoded := map[string]any{}
+	_ = json.NewDecoder(res.Body).Decode(&decoded)
+
+	return res, decoded
+}
+
+func (ts *testServer) do(t *testing.T, method, path string, body any, session string) (int, map[string]any) {
+	t.Helper()
+	res, decoded := ts.send(t, method, path, body, session)
+	return res.StatusCode, decoded
+}
+
+// the session id set in the response's session cookie
+func sessionCookie(res *http.Response) *http.Cookie {
+	for _, c := range res.Cookies() {
+		if c.Name == types.SESSION_COOKIE_NAME {
+			return c
+		}
+	}
+	return nil
+}
+
+// logs in, returns the new session id
+func (ts *testServer) login(t *testing.T, username, password string) string {
+	t.Helper()
+
+	res, decoded := ts.send(t, "POST", "/api/account/login", map[string]string{"username": username, "password": password}, "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("login failed with status %d: %+v", res.StatusCode, decoded)
+	}
+
+	cookie := sessionCookie(res)
+	if cookie == nil || cookie.Value == "" {
+		t.Fatalf("login set no session cookie: %+v", decoded)
+	}
+
+	return cookie.Value
+}
+
+// registers a new account and returns it's session id, the account is left unverified
+func (ts *testServer) registerUnverified(t *testing.T, username string) string {
+	t.Helper()
+
+	res, decoded := ts.send(t, "POST", "/api/account/register", map[string]string{
+		"username":         username,
+		"email":            username + "@example.com",
+		"password":         "correct horse battery",
+		"confirm_password": "correct horse battery",
+	}, "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("register failed with status %d: %+v", res.StatusCode, decoded)
+	}
+
+	cookie := sessionCookie(res)
+	if cookie == nil || cookie.Value == "" {
+		t.Fatalf("register set no session cookie: %+v", decoded)
+	}
+
+	return cookie.Value
+}
+
+// registers and verifies a new account, returns it's session id
+func (ts *testServer) register(t *testing.T, username string) string {
+	t.Helper()
+
+	session := ts.registerUnverified(t, username)
+	token := ts.mailer.lastToken(t, username+"@example.com")
+
+	status, res := ts.do(t, "POST", "/api/account/verify", map[string]string{"token": token}, "")
+	if status != http.StatusOK {
+		t.Fatalf("verify failed with status %d: %+v", status, res)
+	}
+
+	return session
+}
+
+func TestBoardList(t *testing.T) {
+	ts := newTestServer(t)
+
+	status, res := ts.do(t, "GET", "/api/boards", nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("want status %d got %d", http.StatusOK, status)
+	}
+
+	boards, _ := res["boards"].([]any)
+	if len(boards) != 1 {
+		t.Fatalf("want 1 board got %+v", res["boards"])
+	}
+}
+
+func TestWriteRequiresAccount(t *testing.T) {
+	ts := newTestServer(t)
+
+	status, _ := ts.do(t, "POST", "/api/boards/tech", map[string]any{"title": "hello", "content": "world"}, "")
+	if status != http.StatusUnauthorized {
+		t.Fatalf("want status %d got %d", http.StatusUnauthorized, status)
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	ts := newTestServer(t)
+	session := ts.registerUnverified(t, "alice")
+
+	status, _ := ts.do(t, "POST", "/api/boards/tech", map[string]any{"title": "hello", "content": "world"}, session)
+	if status != http.StatusForbidden {
+		t.Fatalf("unverified: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	status, _ = ts.do(t, "POST", "/api/account/verify", map[string]string{"token": "bogus.token"}, "")
+	if status != http.StatusBadRequest {
+		t.Fatalf("bad token: want status %d got %d", http.StatusBadRequest, status)
+	}
+
+	token := ts.mailer.lastToken(t, "alice@example.com")
+	status, res := ts.do(t, "POST", "/api/account/verify", map[string]string{"token": token}, "")
+	if status != http.StatusOK {
+		t.Fatalf("verify: want status %d got %d", http.StatusOK, status)
+	}
+
+	account, _ := res["account"].(map[string]any)
+	if account["status"] != string(types.AccountStatusActive) || account["verified"] != true {
+		t.Fatalf("verify: want the account active and verified got %+v", account)
+	}
+
+	status, _ = ts.do(t, "POST", "/api/boards/tech", map[string]any{"title": "hello", "content": "world"}, session)
+	if status != http.StatusOK {
+		t.Fatalf("verified: want status %d got %d", http.StatusOK, status)
+	}
+}
+
+func TestRegisterValidation(t *testing.T) {
+	ts := newTestServer(t)
+
+	status, res := ts.do(t, "POST", "/api/account/register", map[string]string{
+		"username":         "b!",
+		"email":            "not an email",
+		"password":         "short",
+		"confirm_password": "different",
+	}, "")
+	if status != http.StatusBadRequest {
+		t.Fatalf("want status %d got %d", http.StatusBadRequest, status)
+	}
+
+	fields, _ := res["fields"].(map[string]any)
+	for _, field := range []string{"username", "email", "password", "confirm_password"} {
+		if _, ok := fields[field]; !ok {
+			t.Errorf("want a problem reported for %s got %+v", field, res)
+		}
+	}
+
+	ts.register(t, "bob")
+
+	// usernames are unique regardless of casing
+	status, _ = ts.do(t, "POST", "/api/account/register", map[string]string{
+		"username":         "Bob",
+		"email":            "other@example.com",
+		"password":         "correct horse battery",
+		"confirm_password": "correct horse battery",
+	}, "")
+	if status != http.StatusConflict {
+		t.Fatalf("case variant username: want status %d got %d", http.StatusConflict, status)
+	}
+
+	req, _ := http.NewRequest("POST", ts.URL+"/api/account/register", strings.NewReader("{not json"))
+	malformed, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	malformed.Body.Close()
+	if malformed.StatusCode != http.StatusBadRequest {
+		t.Fatalf("malformed body: want status %d got %d", http.StatusBadRequest, malformed.StatusCode)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	ts := newTestServer(t)
+	ts.register(t, "alice")
+
+	status, _ := ts.do(t, "POST", "/api/account/login", map[string]string{"username": "alice", "password": "wrong password"}, "")
+	if status != http.StatusUnauthorized {
+		t.Fatalf("bad password: want status %d got %d", http.StatusUnauthorized, status)
+	}
+
+	status, res := ts.do(t, "POST", "/api/account/login", map[string]string{"username": "alice", "password": "correct horse battery"}, "")
+	if status != http.StatusOK {
+		t.Fatalf("login: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+}
+
+func TestLoginThrottling(t *testing.T) {
+	ts := newTestServer(t)
+	ts.register(t, "alice")
+
+	login := func(password string) int {
+		status, _ := ts.do(t, "POST", "/api/account/login", map[string]string{"username": "alice", "password": password}, "")
+		return status
+	}
+
+	for i := 0; i < 2; i++ {
+		if status := login("wrong password"); status != http.StatusUnauthorized {
+			t.Fatalf("failure %d: want status %d got %d", i+1, http.StatusUnauthorized, status)
+		}
+	}
+
+	// a successful login resets the counters
+	if status := login("correct horse battery"); status != http.StatusOK {
+		t.Fatalf("correct password: want status %d got %d", http.StatusOK, status)
+	}
+
+	for i := 0; i <= types.LOGIN_FREE_FAILURES; i++ {
+		if status := login("wrong password"); status != http.StatusUnauthorized {
+			t.Fatalf("failure %d after reset: want status %d got %d", i+1, http.StatusUnauthorized, status)
+		}
+	}
+
+	// backing off now, even the right password has to wait
+	bs, _ := json.Marshal(map[string]string{"username": "alice", "password": "correct horse battery"})
+	res, err := http.Post(ts.URL+"/api/account/login", "application/json", bytes.NewReader(bs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("want status %d got %d", http.StatusTooManyRequests, res.StatusCode)
+	}
+	if res.Header.Get("Retry-After") == "" {
+		t.Fatalf("want a Retry-After header")
+	}
+}
+
+func TestLoginFailuresConcurrent(t *testing.T) {
+	ts := newTestServer(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < types.LOGIN_MAX_FAILURES; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ts.store.RecordLoginFailure("ip:192.0.2.1"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if count := ts.store.CountResults("login_attempts", bson.D{}); count != 1 {
+		t.Fatalf("want 1 attempts record got %d", count)
+	}
+
+	attempts, err := ts.store.FindLoginAttempts("ip:192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Failures != types.LOGIN_MAX_FAILURES || attempts.LockedUntil == nil {
+		t.Fatalf("want %d failures and a lockout got %d failures, locked until %v", types.LOGIN_MAX_FAILURES, attempts.Failures, attempts.LockedUntil)
+	}
+}
+
+func TestTwoFactor(t *testing.T) {
+	ts := newTestServer(t)
+	session := ts.register(t, "alice")
+
+	_, err := ts.store.UpdateMulti(bson.D{{Key: "username", Value: "alice"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "role", Value: types.AccountRoleAdmin}}}}, "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, res := ts.do(t, "POST", "/api/account/2fa/enroll", nil, session)
+	if status != http.StatusOK {
+		t.Fatalf("enroll: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	secret, _ := res["secret"].(string)
+	if uri, _ := res["otpauth_uri"].(string); !strings.HasPrefix(uri, "otpauth://totp/") {
+		t.Fatalf("want an otpauth uri got %q", uri)
+	}
+
+	code, err := utils.TOTPCode(secret, utils.TOTPStep(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, res = ts.do(t, "POST", "/api/account/2fa/confirm", map[string]string{"code": code}, session)
+	if status != http.StatusOK {
+		t.Fatalf("confirm: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	recovery, _ := res["recovery_codes"].([]any)
+	if len(recovery) != types.RECOVERY_CODE_COUNT {
+		t.Fatalf("want %d recovery codes got %+v", types.RECOVERY_CODE_COUNT, res["recovery_codes"])
+	}
+
+	// the password alone no longer signs in
+	status, res = ts.do(t, "POST", "/api/account/login", map[string]string{"username": "alice", "password": "correct horse battery"}, "")
+	if status != http.StatusOK || res["session"] != nil {
+		t.Fatalf("login: want a challenge and no session got %d: %+v", status, res)
+	}
+
+	challenge, _ := res["challenge"].(string)
+
+	status, _ = ts.do(t, "POST", "/api/account/login/2fa", map[string]string{"challenge": challenge, "code": "000000"}, "")
+	if status != http.StatusUnauthorized {
+		t.Fatalf("wrong code: want status %d got %d", http.StatusUnauthorized, status)
+	}
+
+	// the code used to confirm can't be replayed, but a recovery code works once
+	status, _ = ts.do(t, "POST", "/api/account/login/2fa", map[string]string{"challenge": challenge, "code": code}, "")
+	if status != http.StatusUnauthorized {
+		t.Fatalf("replayed code: want status %d got %d", http.StatusUnauthorized, status)
+	}
+
+	status, res = ts.do(t, "POST", "/api/account/login/2fa", map[string]string{"challenge": challenge, "code": recovery[0].(string)}, "")
+	if status != http.StatusOK || res["session"] == nil {
+		t.Fatalf("recovery code: want a session got %d: %+v", status, res)
+	}
+
+	// a signed in session guessing codes to turn two-factor off is throttled like logins
+	for i := 0; i <= types.LOGIN_FREE_FAILURES; i++ {
+		if status, _ := ts.do(t, "POST", "/api/account/2fa/disable", map[string]string{"code": "000000"}, session); status != http.StatusBadRequest {
+			t.Fatalf("disable with wrong code %d: want status %d got %d", i+1, http.StatusBadRequest, status)
+		}
+	}
+	if status, _ := ts.do(t, "POST", "/api/account/2fa/disable", map[string]string{"code": recovery[1].(string)}, session); status != http.StatusTooManyRequests {
+		t.Fatalf("disable while throttled: want status %d got %d", http.StatusTooManyRequests, status)
+	}
+
+	// staff without two-factor lose their role once it's required
+	status, res = ts.do(t, "POST", "/api/admin/settings", map[string]bool{"require_staff_two_factor": true}, session)
+	if status != http.StatusOK {
+		t.Fatalf("settings: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	modSession := ts.register(t, "bob")
+	_, err = ts.store.UpdateMulti(bson.D{{Key: "username", Value: "bob"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "role", Value: types.AccountRoleMod}}}}, "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, _ = ts.do(t, "GET", "/api/admin/settings", nil, modSession)
+	if status != http.StatusForbidden {
+		t.Fatalf("mod without two-factor: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	_, res = ts.do(t, "POST", "/api/account/2fa/enroll", nil, modSession)
+	if res["two_factor_setup_required"] != true {
+		t.Fatalf("want the mod told to set up two-factor got %+v", res)
+	}
+}
+
+func TestThreadLifecycle(t *testing.T) {
+	ts := newTestServer(t)
+	session := ts.register(t, "alice")
+
+	// new thread
+	status, res := ts.do(t, "POST", "/api/boards/tech", map[string]any{
+		"title":   "First thread",
+		"content": "hello world",
+	}, session)
+	if status != http.StatusOK {
+		t.Fatalf("new thread: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	slug, _ := res["thread_id"].(string)
+	if slug == "" {
+		t.Fatalf("new thread returned no slug: %+v", res)
+	}
+
+	// two replies, post numbers come from the board
+	for i := 1; i <= 2; i++ {
+		status, res = ts.do(t, "POST", "/api/threads/"+slug, map[string]any{"content": fmt.Sprintf("reply %d", i)}, session)
+		if status != http.StatusOK {
+			t.Fatalf("reply %d: want status %d got %d: %+v", i, http.StatusOK, status, res)
+		}
+		if num, _ := res["post_number"].(float64); int(num) != i {
+			t.Fatalf("reply %d: want post number %d got %+v", i, i, res["post_number"])
+		}
+	}
+
+	// board listing with thread previews
+	status, res = ts.do(t, "GET", "/api/boards/tech", nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("board: want status %d got %d", http.StatusOK, status)
+	}
+
+	records, _ := res["records"].([]any)
+	if len(records) != 1 {
+		t.Fatalf("board: want 1 thread got %+v", res["records"])
+	}
+
+	preview := records[0].(map[string]any)
+	if preview["post_count"] != float64(2) {
+		t.Fatalf("board: want post_count 2 got %+v", preview["post_count"])
+	}
+
+	creator, _ := preview["creator"].(map[string]any)
+	if creator["name"] == nil || creator["_id"] != nil {
+		t.Fatalf("board: creator identity not resolved correctly %+v", creator)
+	}
+
+	// the entire thread
+	status, res = ts.do(t, "GET", "/api/threads/"+slug, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("thread: want status %d got %d", http.StatusOK, status)
+	}
+
+	thread := res["thread"].(map[string]any)
+	posts, _ := thread["posts"].([]any)
+	if len(posts) != 2 {
+		t.Fatalf("thread: want 2 posts got %d", len(posts))
+	}
+
+	first := posts[0].(map[string]any)
+	if first["body"] != `<div class="content-body"><p>reply 1</p></div>` {
+		t.Fatalf("thread: unexpected first post body %+v", first["body"])
+	}
+
+	// internal single post lookup
+	status, res = ts.do(t, "GET", "/api/internal/post/"+slug+"/2", nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("internal post: want status %d got %d", http.StatusOK, status)
+	}
+
+	post := res["post"].(map[string]any)
+	if post["thread"] != slug || post["board"] != "tech" {
+		t.Fatalf("internal post: unexpected post %+v", post)
+	}
+
+	// the replies share a single identity within the thread
+	status, res = ts.do(t, "GET", "/api/account/posts", nil, session)
+	if status != http.StatusOK {
+		t.Fatalf("account posts: want status %d got %d", http.StatusOK, status)
+	}
+
+	identities, _ := res["result"].([]any)
+	if len(identities) != 1 {
+		t.Fatalf("account posts: want 1 identity got %d", len(identities))
+	}
+}
+
+func TestConcurrentReplies(t *testing.T) {
+	ts := newTestServer(t)
+	session := ts.register(t, "alice")
+
+	status, res := ts.do(t, "POST", "/api/boards/tech", map[string]any{"title": "Busy thread", "content": "hello world"}, session)
+	if status != http.StatusOK {
+		t.Fatalf("new thread: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	slug, _ := res["thread_id"].(string)
+
+	const replies = 8
+
+	var wg sync.WaitGroup
+	numbers := make(chan int, replies)
+	for i := 0; i < replies; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			status, res := ts.do(t, "POST", "/api/threads/"+slug, map[string]any{"content": fmt.Sprintf("reply %d", i)}, session)
+			if status != http.StatusOK {
+				t.Errorf("reply %d: want status %d got %d: %+v", i, http.StatusOK, status, res)
+				return
+			}
+			num, _ := res["post_number"].(float64)
+			numbers <- int(num)
+		}(i)
+	}
+	wg.Wait()
+	close(numbers)
+
+	seen := map[int]bool{}
+	for num := range numbers {
+		if seen[num] {
+			t.Fatalf("post number %d was given out twice", num)
+		}
+		seen[num] = true
+	}
+
+	thread, err := ts.store.FindThreadBySlug(slug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thread.Posts) != replies {
+		t.Fatalf("want %d posts on the thread got %d", replies, len(thread.Posts))
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	ts := newTestServer(t)
+	session := ts.register(t, "alice")
+
+	status, _ := ts.do(t, "POST", "/api/account/password/forgot", map[string]string{"email": "nobody@example.com"}, "")
+	if status != http.StatusOK {
+		t.Fatalf("unknown email: want status %d got %d", http.StatusOK, status)
+	}
+
+	status, _ = ts.do(t, "POST", "/api/account/password/forgot", map[string]string{"email": "alice@example.com"}, "")
+	if status != http.StatusOK {
+		t.Fatalf("forgot: want status %d got %d", http.StatusOK, status)
+	}
+
+	token := ts.mailer.lastToken(t, "alice@example.com")
+	reset := map[string]string{"token": token, "password": "a brand new password", "confirm_password": "a brand new password"}
+
+	// asking again within the window answers the same but sends nothing and keeps the first link
+	sent := len(ts.mailer.sent)
+	status, _ = ts.do(t, "POST", "/api/account/password/forgot", map[string]string{"email": "Alice@example.com"}, "")
+	if status != http.StatusOK || len(ts.mailer.sent) != sent {
+		t.Fatalf("forgot again: want status %d and no mail got %d with %d mails", http.StatusOK, status, len(ts.mailer.sent)-sent)
+	}
+
+	status, res := ts.do(t, "POST", "/api/account/password/reset", reset, "")
+	if status != http.StatusOK {
+		t.Fatalf("reset: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	// tokens are single use
+	status, _ = ts.do(t, "POST", "/api/account/password/reset", reset, "")
+	if status != http.StatusBadRequest {
+		t.Fatalf("reused token: want status %d got %d", http.StatusBadRequest, status)
+	}
+
+	// existing sessions are revoked
+	status, _ = ts.do(t, "GET", "/api/account/posts", nil, session)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("old session: want status %d got %d", http.StatusUnauthorized, status)
+	}
+
+	status, _ = ts.do(t, "POST", "/api/account/login", map[string]string{"username": "alice", "password": "correct horse battery"}, "")
+	if status != http.StatusUnauthorized {
+		t.Fatalf("old password: want status %d got %d", http.StatusUnauthorized, status)
+	}
+
+	status, _ = ts.do(t, "POST", "/api/account/login", map[string]string{"username": "alice", "password": "a brand new password"}, "")
+	if status != http.StatusOK {
+		t.Fatalf("new password: want status %d got %d", http.StatusOK, status)
+	}
+
+	// three requests were made above, one more uses up the free ones for the address but not its logins
+	for i := 3; i <= types.LOGIN_FREE_FAILURES; i++ {
+		ts.do(t, "POST", "/api/account/password/forgot", map[string]string{"email": fmt.Sprintf("nobody%d@example.com", i)}, "")
+	}
+	if status, _ := ts.do(t, "POST", "/api/account/password/forgot", map[string]string{"email": "someone@example.com"}, ""); status != http.StatusTooManyRequests {
+		t.Fatalf("forgot while throttled: want status %d got %d", http.StatusTooManyRequests, status)
+	}
+	if status, _ := ts.do(t, "POST", "/api/account/login", map[string]string{"username": "alice", "password": "a brand new password"}, ""); status != http.StatusOK {
+		t.Fatalf("login while reset is throttled: want status %d got %d", http.StatusOK, status)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	ts := newTestServer(t)
+	first := ts.register(t, "alice")
+
+	second := ts.login(t, "alice", "correct horse battery")
+	ts.login(t, "alice", "correct horse battery")
+
+	status, res := ts.do(t, "GET", "/api/account/sessions", nil, first)
+	if status != http.StatusOK {
+		t.Fatalf("list: want status %d got %d", http.StatusOK, status)
+	}
+
+	sessions, _ := res["sessions"].([]any)
+	if len(sessions) != 3 {
+		t.Fatalf("want 3 sessions got %+v", res["sessions"])
+	}
+
+	var otherID string
+	for _, v := range sessions {
+		session := v.(map[string]any)
+		if _, leaked := session["session_id"]; leaked {
+			t.Fatalf("session listing must not include the session secret: %+v", session)
+		}
+		if session["ip"] != "127.0.0.1" || session["user_agent"] == "" {
+			t.Fatalf("want the client recorded got %+v", session)
+		}
+		if session["current"] != true && otherID == "" {
+			otherID = session["_id"].(string)
+		}
+	}
+
+	status, _ = ts.do(t, "DELETE", "/api/account/sessions/"+otherID, nil, first)
+	if status != http.StatusOK {
+		t.Fatalf("revoke: want status %d got %d", http.StatusOK, status)
+	}
+
+	// other accounts can't revoke sessions that aren't theirs
+	intruder := ts.register(t, "mallory")
+	status, _ = ts.do(t, "DELETE", "/api/account/sessions/"+otherID, nil, intruder)
+	if status != http.StatusNotFound {
+		t.Fatalf("foreign session: want status %d got %d", http.StatusNotFound, status)
+	}
+
+	status, _ = ts.do(t, "DELETE", "/api/account/sessions", nil, first)
+	if status != http.StatusOK {
+		t.Fatalf("revoke others: want status %d got %d", http.StatusOK, status)
+	}
+
+	_, res = ts.do(t, "GET", "/api/account/sessions", nil, first)
+	if sessions, _ := res["sessions"].([]any); len(sessions) != 1 {
+		t.Fatalf("want only the current session left got %+v", res["sessions"])
+	}
+
+	status, _ = ts.do(t, "GET", "/api/account/sessions", nil, second)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("revoked session: want status %d got %d", http.StatusUnauthorized, status)
+	}
+}
+
+func TestSessionRefresh(t *testing.T) {
+	ts := newTestServer(t)
+	session := ts.register(t, "alice")
+
+	// sessions a day from running out are extended as they're used
+	soon := time.Now().UTC().Add(time.Hour)
+	_, err := ts.store.UpdateMulti(bson.D{{Key: "session_id", Value: session}}, bson.D{{Key: "$set", Value: bson.D{{Key: "expires", Value: soon}}}}, "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, body := ts.send(t, "GET", "/api/account/sessions", nil, session)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("sessions: want status %d got %d: %+v", http.StatusOK, res.StatusCode, body)
+	}
+
+	cookie := sessionCookie(res)
+	if cookie == nil || !cookie.Expires.After(soon.Add(24*time.Hour)) {
+		t.Fatalf("want the cookie extended with the session got %+v", cookie)
+	}
+
+	refreshed, err := ts.store.FindSession(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !refreshed.Expires.After(soon.Add(24 * time.Hour)) {
+		t.Fatalf("want the session extended got %v", refreshed.Expires)
+	}
+
+	// sessions with plenty of time left are left alone
+	res, _ = ts.send(t, "GET", "/api/account/sessions", nil, session)
+	if cookie := sessionCookie(res); cookie != nil {
+		t.Fatalf("want no refresh for a fresh session got %+v", cookie)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	ts := newTestServer(t)
+	session := ts.register(t, "alice")
+
+	mint := func(scopes ...string) (int, map[string]any) {
+		return ts.do(t, "POST", "/api/account/tokens", map[string]any{"name": "archiver", "scopes": scopes}, session)
+	}
+
+	// staff scopes can't be granted by regular accounts
+	if status, _ := mint("moderate"); status != http.StatusBadRequest {
+		t.Fatalf("moderate scope: want status %d got %d", http.StatusBadRequest, status)
+	}
+
+	status, res := mint("read")
+	if status != http.StatusOK {
+		t.Fatalf("mint: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	readToken, _ := res["token"].(string)
+
+	_, res = mint("read", "post")
+	postToken, _ := res["token"].(string)
+	postTokenID := res["api_token"].(map[string]any)["_id"].(string)
+
+	bearer := func(method, path string, body any, token string) int {
+		bs, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, ts.URL+path, bytes.NewReader(bs))
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if status := bearer("GET", "/api/account/posts", nil, readToken); status != http.StatusOK {
+		t.Fatalf("read scope: want status %d got %d", http.StatusOK, status)
+	}
+
+	newThread := map[string]any{"title": "archived", "content": "by a bot"}
+	if status := bearer("POST", "/api/boards/tech", newThread, readToken); status != http.StatusForbidden {
+		t.Fatalf("missing post scope: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	// bearer requests don't need a csrf token
+	if status := bearer("POST", "/api/boards/tech", newThread, postToken); status != http.StatusOK {
+		t.Fatalf("post scope: want status %d got %d", http.StatusOK, status)
+	}
+
+	// tokens can't manage the account
+	if status := bearer("POST", "/api/account/tokens", map[string]any{"name": "escalate", "scopes": []string{"post"}}, postToken); status != http.StatusForbidden {
+		t.Fatalf("token minting tokens: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	_, res = ts.do(t, "GET", "/api/account/tokens", nil, session)
+	if tokens, _ := res["tokens"].([]any); len(tokens) != 2 {
+		t.Fatalf("want 2 tokens listed got %+v", res["tokens"])
+	}
+
+	if status, _ := ts.do(t, "DELETE", "/api/account/tokens/"+postTokenID, nil, session); status != http.StatusOK {
+		t.Fatalf("revoke: want status %d got %d", http.StatusOK, status)
+	}
+
+	if status := bearer("POST", "/api/boards/tech", newThread, postToken); status != http.StatusUnauthorized {
+		t.Fatalf("revoked token: want status %d got %d", http.StatusUnauthorized, status)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	ts := newTestServer(t)
+	session := ts.register(t, "alice")
+
+	res, _ := ts.send(t, "POST", "/api/account/logout", nil, session)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("logout: want status %d got %d", http.StatusOK, res.StatusCode)
+	}
+
+	if cookie := sessionCookie(res); cookie == nil || cookie.MaxAge >= 0 {
+		t.Fatalf("logout: want the session cookie cleared got %+v", cookie)
+	}
+
+	status, _ := ts.do(t, "GET", "/api/account/posts", nil, session)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("after logout: want status %d got %d", http.StatusUnauthorized, status)
+	}
+}
+
+func TestSessionCookieAndCSRF(t *testing.T) {
+	ts := newTestServer(t)
+
+	res, decoded := ts.send(t, "POST", "/api/account/register", map[string]string{
+		"username":         "alice",
+		"email":            "alice@example.com",
+		"password":         "correct horse battery",
+		"confirm_password": "correct horse battery",
+	}, "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("register: want status %d got %d", http.StatusOK, res.StatusCode)
+	}
+
+	cookie := sessionCookie(res)
+	if cookie == nil || !cookie.HttpOnly || !cookie.Secure || cookie.SameSite == http.SameSiteDefaultMode {
+		t.Fatalf("want an HttpOnly, Secure, SameSite session cookie got %+v", cookie)
+	}
+
+	session, _ := decoded["session"].(map[string]any)
+	if _, leaked := session["session_id"]; leaked {
+		t.Fatalf("the session id must not be in the response body: %+v", session)
+	}
+
+	token, _ := session["csrf_token"].(string)
+	if token == "" {
+		t.Fatalf("want a csrf token in the response got %+v", session)
+	}
+
+	verify := ts.mailer.lastToken(t, "alice@example.com")
+	if status, _ := ts.do(t, "POST", "/api/account/verify", map[string]string{"token": verify}, ""); status != http.StatusOK {
+		t.Fatalf("verify: want status %d got %d", http.StatusOK, status)
+	}
+
+	post := func(csrf string) int {
+		bs, _ := json.Marshal(map[string]any{"title": "hello", "content": "world"})
+		req, _ := http.NewRequest("POST", ts.URL+"/api/boards/tech", bytes.NewReader(bs))
+		req.AddCookie(cookie)
+		if csrf != "" {
+			req.Header.Set(types.CSRF_HEADER_NAME, csrf)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if status := post(""); status != http.StatusForbidden {
+		t.Fatalf("missing csrf token: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	if status := post("forged"); status != http.StatusForbidden {
+		t.Fatalf("invalid csrf token: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	if status := post(token); status != http.StatusOK {
+		t.Fatalf("valid csrf token: want status %d got %d", http.StatusOK, status)
+	}
+}
+
+func TestAccountProfile(t *testing.T) {
+	ts := newTestServer(t)
+	session := ts.register(t, "alice")
+	other := ts.login(t, "alice", "correct horse battery")
+	ts.register(t, "bob")
+
+	status, res := ts.do(t, "GET", "/api/account", nil, session)
+	if account, _ := res["account"].(map[string]any); status != http.StatusOK || account["username"] != "alice" {
+		t.Fatalf("get: want the account got %d: %+v", status, res)
+	}
+
+	status, _ = ts.do(t, "POST", "/api/account/username", map[string]string{"username": "BOB"}, session)
+	if status != http.StatusConflict {
+		t.Fatalf("taken username: want status %d got %d", http.StatusConflict, status)
+	}
+
+	status, res = ts.do(t, "POST", "/api/account/username", map[string]string{"username": "Alicia"}, session)
+	if account, _ := res["account"].(map[string]any); status != http.StatusOK || account["username"] != "Alicia" {
+		t.Fatalf("username: want it changed got %d: %+v", status, res)
+	}
+
+	status, _ = ts.do(t, "POST", "/api/account/email", map[string]string{"email": "new@example.com", "current_password": "wrong password"}, session)
+	if status != http.StatusBadRequest {
+		t.Fatalf("email with wrong password: want status %d got %d", http.StatusBadRequest, status)
+	}
+
+	status, _ = ts.do(t, "POST", "/api/account/email", map[string]string{"email": "bob@example.com", "current_password": "correct horse battery"}, session)
+	if status != http.StatusConflict {
+		t.Fatalf("taken email: want status %d got %d", http.StatusConflict, status)
+	}
+
+	status, res = ts.do(t, "POST", "/api/account/email", map[string]string{"email": "New@Example.com", "current_password": "correct horse battery"}, session)
+	if account, _ := res["account"].(map[string]any); status != http.StatusOK || account["verified"] != false {
+		t.Fatalf("email: want the account unverified got %d: %+v", status, res)
+	}
+
+	// the new address gets a verification link, the old one a notice
+	notice := ts.mailer.sent[len(ts.mailer.sent)-1]
+	if notice.To != "alice@example.com" || !strings.Contains(notice.Body, "new@example.com") {
+		t.Fatalf("want a notice sent to the old address got %+v", notice)
+	}
+
+	token := ts.mailer.lastToken(t, "new@example.com")
+	if status, _ := ts.do(t, "POST", "/api/account/verify", map[string]string{"token": token}, ""); status != http.StatusOK {
+		t.Fatalf("reverify: want status %d got %d", http.StatusOK, status)
+	}
+
+	passwordRes, res := ts.send(t, "POST", "/api/account/password", map[string]string{
+		"current_password": "correct horse battery",
+		"password":         "a brand new secret",
+		"confirm_password": "a brand new secret",
+	}, session)
+	if passwordRes.StatusCode != http.StatusOK {
+		t.Fatalf("password: want status %d got %d: %+v", http.StatusOK, passwordRes.StatusCode, res)
+	}
+
+	rotated := sessionCookie(passwordRes)
+	if rotated == nil || rotated.Value == session {
+		t.Fatalf("password: want a new session cookie got %+v", rotated)
+	}
+
+	for _, old := range []string{session, other} {
+		if status, _ := ts.do(t, "GET", "/api/account", nil, old); status != http.StatusUnauthorized {
+			t.Fatalf("old session: want status %d got %d", http.StatusUnauthorized, status)
+		}
+	}
+
+	ts.login(t, "alicia", "a brand new secret")
+
+	status, res = ts.do(t, "GET", "/api/account/history", nil, rotated.Value)
+	if status != http.StatusOK {
+		t.Fatalf("history: want status %d got %d", http.StatusOK, status)
+	}
+
+	history, _ := res["history"].([]any)
+	if len(history) != 3 {
+		t.Fatalf("want 3 history entries got %+v", res["history"])
+	}
+
+	latest := history[0].(map[string]any)
+	if latest["change"] != string(types.AccountChangePassword) || latest["new_value"] != "" {
+		t.Fatalf("want the password change first without values got %+v", latest)
+	}
+}
+
+func TestAccountDeletion(t *testing.T) {
+	ts := newTestServer(t)
+	session := ts.register(t, "alice")
+
+	status, res := ts.do(t, "POST", "/api/boards/tech", map[string]any{"title": "hello", "content": "world"}, session)
+	if status != http.StatusOK {
+		t.Fatalf("new thread: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	account, err := ts.store.FindAccountByUsernameOrEmail("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	comment := types.NewArticleComment()
+	comment.AuthorID = account.ID
+	source := types.NewSourceAsset()
+	source.Uploaders = append(source.Uploaders, account.ID)
+	if err := ts.store.SaveNewSingle(comment, "article_comments"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.store.SaveNewSingle(source, "asset_sources"); err != nil {
+		t.Fatal(err)
+	}
+
+	status, _ = ts.do(t, "DELETE", "/api/account", map[string]string{"current_password": "wrong password"}, session)
+	if status != http.StatusBadRequest {
+		t.Fatalf("wrong password: want status %d got %d", http.StatusBadRequest, status)
+	}
+
+	status, res = ts.do(t, "DELETE", "/api/account", map[string]string{"current_password": "correct horse battery"}, session)
+	if status != http.StatusOK || res["purge_at"] == nil {
+		t.Fatalf("delete: want status %d and a purge time got %d: %+v", http.StatusOK, status, res)
+	}
+
+	if status, _ := ts.do(t, "GET", "/api/account", nil, session); status != http.StatusUnauthorized {
+		t.Fatalf("deleted session: want status %d got %d", http.StatusUnauthorized, status)
+	}
+
+	status, _ = ts.do(t, "POST", "/api/account/login", map[string]string{"username": "alice", "password": "correct horse battery"}, "")
+	if status != http.StatusForbidden {
+		t.Fatalf("login while deleted: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	// still within the grace period
+	status, _ = ts.do(t, "POST", "/api/account/restore", map[string]string{"username": "alice", "password": "correct horse battery"}, "")
+	if status != http.StatusOK {
+		t.Fatalf("restore: want status %d got %d", http.StatusOK, status)
+	}
+
+	session = ts.login(t, "alice", "correct horse battery")
+	if status, _ := ts.do(t, "DELETE", "/api/account", map[string]string{"current_password": "correct horse battery"}, session); status != http.StatusOK {
+		t.Fatalf("delete again: want status %d got %d", http.StatusOK, status)
+	}
+
+	if purged, err := types.SweepDeletedAccounts(ts.store); err != nil || purged != 0 {
+		t.Fatalf("sweep within grace period: want nothing purged got %d %+v", purged, err)
+	}
+
+	past := time.Now().UTC().AddDate(0, 0, -types.ACCOUNT_DELETION_GRACE_DAYS-1)
+	_, err = ts.store.UpdateMulti(bson.D{{Key: "_id", Value: account.ID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "deleted_at", Value: past}}}}, "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if purged, err := types.SweepDeletedAccounts(ts.store); err != nil || purged != 1 {
+		t.Fatalf("sweep: want 1 purged got %d %+v", purged, err)
+	}
+
+	if _, err := ts.store.FindAccountByID(account.ID); err == nil {
+		t.Fatalf("want the account hard deleted")
+	}
+
+	if n := ts.store.CountResults("identities", bson.D{{Key: "account", Value: account.ID}}); n != 0 {
+		t.Fatalf("want identities detached got %d linked", n)
+	}
+
+	if n := ts.store.CountResults("identities", bson.D{}); n != 1 {
+		t.Fatalf("want the identity kept got %d", n)
+	}
+
+	tombstone, err := types.ResolveTombstoneAccount(ts.store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := &types.ArticleComment{}
+	if err := ts.store.FindSingle(bson.D{{Key: "_id", Value: comment.ID}}, found, "article_comments"); err != nil || found.AuthorID != tombstone.ID {
+		t.Fatalf("want the comment handed to the tombstone got %+v %+v", found, err)
+	}
+
+	updated := &types.AssetSource{}
+	if err := ts.store.FindSingle(bson.D{{Key: "_id", Value: source.ID}}, updated, "asset_sources"); err != nil || len(updated.Uploaders) != 0 {
+		t.Fatalf("want the account dropped from uploaders got %+v %+v", updated, err)
+	}
+
+	// the username is free again
+	ts.register(t, "alice")
+}
+
+func TestDataExport(t *testing.T) {
+	ts := newTestServer(t)
+	session := ts.register(t, "alice")
+
+	status, res := ts.do(t, "POST", "/api/boards/tech", map[string]any{"title": "hello", "content": "world"}, session)
+	if status != http.StatusOK {
+		t.Fatalf("new thread: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	if status, _ := ts.do(t, "GET", "/api/account/export", nil, session); status != http.StatusNotFound {
+		t.Fatalf("before requesting: want status %d got %d", http.StatusNotFound, status)
+	}
+
+	status, res = ts.do(t, "POST", "/api/account/export", nil, session)
+	if status != http.StatusOK {
+		t.Fatalf("request: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	// built in the background
+	var link string
+	for deadline := time.Now().Add(5 * time.Second); link == "" && time.Now().Before(deadline); {
+		_, res = ts.do(t, "GET", "/api/account/export", nil, session)
+		link, _ = res["download_url"].(string)
+		if link == "" {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if link == "" {
+		t.Fatalf("export never became ready: %+v", res)
+	}
+
+	if status, _ := ts.do(t, "GET", "/api/account/export/download?token=bogus.token", nil, ""); status != http.StatusBadRequest {
+		t.Fatalf("bad token: want status %d got %d", http.StatusBadRequest, status)
+	}
+
+	download, err := http.Get(ts.URL + link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer download.Body.Close()
+
+	archive, err := io.ReadAll(download.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatalf("want a zip archive: %+v", err)
+	}
+
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rd, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bs, _ := io.ReadAll(rd)
+		rd.Close()
+		files[f.Name] = string(bs)
+	}
+
+	if !strings.Contains(files["account.json"], "alice@example.com") || strings.Contains(files["account.json"], "password") {
+		t.Fatalf("want the account without it's password got %s", files["account.json"])
+	}
+
+	if !strings.Contains(files["identities.json"], "hello") {
+		t.Fatalf("want the thread made through the identity got %s", files["identities.json"])
+	}
+
+	for _, name := range []string{"sessions.json", "api_tokens.json", "account_history.json", "article_comments.json", "assets.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("want %s in the archive", name)
+		}
+	}
+
+	// expired exports are swept away with their archive
+	past := time.Now().UTC().Add(-time.Minute)
+	if _, err := ts.store.UpdateMulti(bson.D{}, bson.D{{Key: "$set", Value: bson.D{{Key: "expires_at", Value: past}}}}, "data_exports"); err != nil {
+		t.Fatal(err)
+	}
+	exports := []*types.DataExport{}
+	if err := ts.store.FindMulti(bson.D{}, &exports, "data_exports"); err != nil || len(exports) != 1 {
+		t.Fatalf("want the export stored got %d: %+v", len(exports), err)
+	}
+
+	if removed, err := types.SweepDataExports(ts.store); err != nil || removed != 1 {
+		t.Fatalf("sweep: want 1 export removed got %d: %+v", removed, err)
+	}
+	if err := ts.store.WriteDataExportArchive(exports[0].ID, io.Discard); err != mongo.ErrNoDocuments {
+		t.Fatalf("want the archive removed got %+v", err)
+	}
+}
+
+func TestDataExportAbandoned(t *testing.T) {
+	ts := newTestServer(t)
+	session := ts.register(t, "alice")
+
+	// left pending by a process that stopped while building it
+	account, err := ts.store.FindAccountByUsernameOrEmail("alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	export := types.NewDataExport(account)
+	created := time.Now().UTC().Add(-time.Duration(types.DATA_EXPORT_BUILD_TIMEOUT_MINUTES+1) * time.Minute)
+	export.CreatedAt = &created
+	if err := ts.store.SaveNewSingle(export, "data_exports"); err != nil {
+		t.Fatal(err)
+	}
+
+	status, res := ts.do(t, "GET", "/api/account/export", nil, session)
+	if got, _ := res["export"].(map[string]any); status != http.StatusOK || got["status"] != string(types.DataExportStatusFailed) {
+		t.Fatalf("abandoned: want the export failed got %d: %+v", status, res)
+	}
+
+	if status, res := ts.do(t, "POST", "/api/account/export", nil, session); status != http.StatusOK {
+		t.Fatalf("request after abandoned: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	ts := newTestServer(t)
+	adminSession := ts.register(t, "alice")
+	session := ts.register(t, "bob")
+
+	_, err := ts.store.UpdateMulti(bson.D{{Key: "username", Value: "alice"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "role", Value: types.AccountRoleAdmin}}}}, "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := types.NewBoard()
+	other.Title = "Random"
+	other.Short = "b"
+	if err := ts.store.SaveNewSingle(other, "boards"); err != nil {
+		t.Fatal(err)
+	}
+
+	// regular accounts can't manage settings or roles
+	if status, _ := ts.do(t, "GET", "/api/admin/settings", nil, session); status != http.StatusForbidden {
+		t.Fatalf("settings: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	if status, _ := ts.do(t, "GET", "/api/admin/cache", nil, session); status != http.StatusForbidden {
+		t.Fatalf("cache stats: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	if status, res := ts.do(t, "GET", "/api/admin/cache", nil, adminSession); status != http.StatusOK || res["cache"] == nil {
+		t.Fatalf("cache stats as admin: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	grant := map[string]string{"username": "bob", "role": string(types.AccountRoleMod)}
+	if status, _ := ts.do(t, "POST", "/api/admin/boards/tech/roles", grant, session); status != http.StatusForbidden {
+		t.Fatalf("grant as user: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	if status, _ := ts.do(t, "POST", "/api/admin/boards/tech/roles", map[string]string{"username": "bob", "role": "user"}, adminSession); status != http.StatusBadRequest {
+		t.Fatalf("grant user role: want status %d got %d", http.StatusBadRequest, status)
+	}
+
+	status, res := ts.do(t, "POST", "/api/admin/boards/tech/roles", grant, adminSession)
+	if status != http.StatusOK {
+		t.Fatalf("grant: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	accountID := res["role"].(map[string]any)["account_id"].(string)
+
+	_, res = ts.do(t, "GET", "/api/admin/boards/tech/roles", nil, adminSession)
+	if roles, _ := res["roles"].([]any); len(roles) != 1 {
+		t.Fatalf("want 1 role on the board got %+v", res["roles"])
+	}
+
+	// the grant only applies on the board it was made for
+	can := func(perm types.Permission, board_id primitive.ObjectID) bool {
+		req := httptest.NewRequest("GET", "/api/account", nil)
+		req.AddCookie(&http.Cookie{Name: types.SESSION_COOKIE_NAME, Value: session})
+		rc := types.NewRequestCtx(httptest.NewRecorder(), req)
+		rc.UpdateStore(ts.store)
+		return rc.CanOnBoard(perm, board_id)
+	}
+
+	if !can(types.PermPostDeleteAny, ts.board.ID) {
+		t.Fatal("want the board mod to delete posts on it's board")
+	}
+	if can(types.PermPostDeleteAny, other.ID) {
+		t.Fatal("want the board mod unable to delete posts on another board")
+	}
+	if can(types.PermBoardManage, ts.board.ID) {
+		t.Fatal("want the board mod without the admin's permissions")
+	}
+
+	// board staff can mint tokens with the moderate scope
+	if status, _ := ts.do(t, "POST", "/api/account/tokens", map[string]any{"name": "modbot", "scopes": []string{"moderate"}}, session); status != http.StatusOK {
+		t.Fatalf("moderate token: want status %d got %d", http.StatusOK, status)
+	}
+
+	if status, _ := ts.do(t, "DELETE", "/api/admin/boards/tech/roles/"+accountID, nil, adminSession); status != http.StatusOK {
+		t.Fatalf("revoke: want status %d got %d", http.StatusOK, status)
+	}
+	if can(types.PermPostDeleteAny, ts.board.ID) {
+		t.Fatal("want the revoked role gone")
+	}
+}
+
+func TestSanctions(t *testing.T) {
+	ts := newTestServer(t)
+	modSession := ts.register(t, "alice")
+	session := ts.register(t, "bob")
+
+	_, err := ts.store.UpdateMulti(bson.D{{Key: "username", Value: "alice"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "role", Value: types.AccountRoleMod}}}}, "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newThread := func() (int, map[string]any) {
+		return ts.do(t, "POST", "/api/boards/tech", map[string]any{"title": "hello", "content": "world"}, session)
+	}
+	statusOf := func() string {
+		_, res := ts.do(t, "GET", "/api/account", nil, session)
+		return res["account"].(map[string]any)["status"].(string)
+	}
+
+	// users can't sanction and suspensions must be timed
+	if status, _ := ts.do(t, "POST", "/api/admin/accounts/alice/sanctions", map[string]any{"kind": "ban", "reason": "spite"}, session); status != http.StatusForbidden {
+		t.Fatalf("sanction as user: want status %d got %d", http.StatusForbidden, status)
+	}
+	if status, _ := ts.do(t, "POST", "/api/admin/accounts/bob/sanctions", map[string]any{"kind": "suspension", "reason": "spam"}, modSession); status != http.StatusBadRequest {
+		t.Fatalf("untimed suspension: want status %d got %d", http.StatusBadRequest, status)
+	}
+
+	status, res := ts.do(t, "POST", "/api/admin/accounts/bob/sanctions", map[string]any{"kind": "suspension", "reason": "spam", "duration_hours": 24}, modSession)
+	if status != http.StatusOK {
+		t.Fatalf("suspend: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	suspensionID := res["issued"].(map[string]any)["_id"].(string)
+
+	// the account can still read it's own data but not post, and is told why
+	status, res = ts.do(t, "GET", "/api/account", nil, session)
+	if status != http.StatusOK || res["sanction"] == nil {
+		t.Fatalf("account: want status %d with the sanction got %d: %+v", http.StatusOK, status, res)
+	}
+	if got := statusOf(); got != string(types.AccountStatusSuspended) {
+		t.Fatalf("want status suspended got %s", got)
+	}
+
+	status, res = newThread()
+	if status != http.StatusForbidden {
+		t.Fatalf("new thread while suspended: want status %d got %d", http.StatusForbidden, status)
+	}
+	sanction, _ := res["sanction"].(map[string]any)
+	if sanction["reason"] != "spam" || sanction["expires_at"] == nil {
+		t.Fatalf("want the reason and expiry got %+v", res)
+	}
+
+	// expired suspensions lift on their own
+	id, _ := primitive.ObjectIDFromHex(suspensionID)
+	past := time.Now().UTC().Add(-time.Minute)
+	_, err = ts.store.UpdateMulti(bson.D{{Key: "_id", Value: id}}, bson.D{{Key: "$set", Value: bson.D{{Key: "expires_at", Value: past}}}}, "account_sanctions")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := statusOf(); got != string(types.AccountStatusActive) {
+		t.Fatalf("after expiry: want status active got %s", got)
+	}
+	if status, res := newThread(); status != http.StatusOK {
+		t.Fatalf("new thread after expiry: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	// bans without a duration last until lifted
+	_, res = ts.do(t, "POST", "/api/admin/accounts/bob/sanctions", map[string]any{"kind": "ban", "reason": "ban evasion"}, modSession)
+	banID := res["issued"].(map[string]any)["_id"].(string)
+
+	status, res = newThread()
+	if status != http.StatusForbidden || res["error"] != "account is banned" {
+		t.Fatalf("new thread while banned: want status %d got %d: %+v", http.StatusForbidden, status, res)
+	}
+
+	if status, _ := ts.do(t, "DELETE", "/api/admin/accounts/bob/sanctions/"+banID, nil, modSession); status != http.StatusOK {
+		t.Fatalf("lift: want status %d got %d", http.StatusOK, status)
+	}
+	if got := statusOf(); got != string(types.AccountStatusActive) {
+		t.Fatalf("after lift: want status active got %s", got)
+	}
+
+	_, res = ts.do(t, "GET", "/api/admin/accounts/bob/sanctions", nil, modSession)
+	if sanctions, _ := res["sanctions"].([]any); len(sanctions) != 2 {
+		t.Fatalf("want both sanctions kept got %+v", res["sanctions"])
+	}
+}
+
+func TestSanctionsKeepVerification(t *testing.T) {
+	ts := newTestServer(t)
+	modSession := ts.register(t, "alice")
+	verifiedSession := ts.register(t, "bob")
+	unverifiedSession := ts.registerUnverified(t, "carol")
+
+	_, err := ts.store.UpdateMulti(bson.D{{Key: "username", Value: "alice"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "role", Value: types.AccountRoleMod}}}}, "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accountOf := func(session string) map[string]any {
+		_, res := ts.do(t, "GET", "/api/account", nil, session)
+		return res["account"].(map[string]any)
+	}
+	suspend := func(username string) string {
+		status, res := ts.do(t, "POST", "/api/admin/accounts/"+username+"/sanctions", map[string]any{"kind": "suspension", "reason": "spam", "duration_hours": 24}, modSession)
+		if status != http.StatusOK {
+			t.Fatalf("suspend %s: want status %d got %d: %+v", username, http.StatusOK, status, res)
+		}
+		return res["issued"].(map[string]any)["_id"].(string)
+	}
+	lift := func(username, id string) {
+		if status, _ := ts.do(t, "DELETE", "/api/admin/accounts/"+username+"/sanctions/"+id, nil, modSession); status != http.StatusOK {
+			t.Fatalf("lift %s: want status %d got %d", username, http.StatusOK, status)
+		}
+	}
+
+	// a suspended account that changes it's email comes back active but unverified
+	id := suspend("bob")
+	status, res := ts.do(t, "POST", "/api/account/email", map[string]string{"email": "bob2@example.com", "current_password": "correct horse battery"}, verifiedSession)
+	if status != http.StatusOK {
+		t.Fatalf("email while suspended: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	lift("bob", id)
+
+	if account := accountOf(verifiedSession); account["status"] != string(types.AccountStatusActive) || account["verified"] != false {
+		t.Fatalf("after lift: want bob active and unverified got %+v", account)
+	}
+	if status, _ := ts.do(t, "POST", "/api/boards/tech", map[string]any{"title": "hello", "content": "world"}, verifiedSession); status != http.StatusForbidden {
+		t.Fatalf("new thread unverified: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	// a suspended account that verifies stays verified once the suspension ends
+	id = suspend("carol")
+	token := ts.mailer.lastToken(t, "carol@example.com")
+	if status, res := ts.do(t, "POST", "/api/account/verify", map[string]string{"token": token}, ""); status != http.StatusOK {
+		t.Fatalf("verify while suspended: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	if account := accountOf(unverifiedSession); account["status"] != string(types.AccountStatusSuspended) || account["verified"] != true {
+		t.Fatalf("while suspended: want carol suspended and verified got %+v", account)
+	}
+	lift("carol", id)
+
+	if account := accountOf(unverifiedSession); account["status"] != string(types.AccountStatusActive) || account["verified"] != true {
+		t.Fatalf("after lift: want carol active and verified got %+v", account)
+	}
+	if status, res := ts.do(t, "POST", "/api/boards/tech", map[string]any{"title": "hello", "content": "world"}, unverifiedSession); status != http.StatusOK {
+		t.Fatalf("new thread verified: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+}
+
+func TestModActions(t *testing.T) {
+	ts := newTestServer(t)
+	adminSession := ts.register(t, "alice")
+	modSession := ts.register(t, "carol")
+	session := ts.register(t, "bob")
+
+	for username, role := range map[string]types.AccountRole{"alice": types.AccountRoleAdmin, "carol": types.AccountRoleMod} {
+		_, err := ts.store.UpdateMulti(bson.D{{Key: "username", Value: username}}, bson.D{{Key: "$set", Value: bson.D{{Key: "role", Value: role}}}}, "accounts")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status, res := ts.do(t, "POST", "/api/admin/accounts/bob/sanctions", map[string]any{"kind": "ban", "reason": "spam"}, modSession)
+	if status != http.StatusOK {
+		t.Fatalf("ban: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	banID := res["issued"].(map[string]any)["_id"].(string)
+
+	if status, res := ts.do(t, "DELETE", "/api/admin/accounts/bob/sanctions/"+banID, nil, modSession); status != http.StatusOK {
+		t.Fatalf("lift: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	if status, res := ts.do(t, "POST", "/api/admin/boards/tech/roles", map[string]string{"username": "bob", "role": string(types.AccountRoleMod)}, adminSession); status != http.StatusOK {
+		t.Fatalf("grant: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	// users can't read the log
+	if status, _ := ts.do(t, "GET", "/api/admin/mod-actions", nil, session); status != http.StatusForbidden {
+		t.Fatalf("log as user: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	records := func(query string) []any {
+		status, res := ts.do(t, "GET", "/api/admin/mod-actions"+query, nil, modSession)
+		if status != http.StatusOK {
+			t.Fatalf("log %s: want status %d got %d: %+v", query, http.StatusOK, status, res)
+		}
+		found, _ := res["records"].([]any)
+		return found
+	}
+
+	all := records("")
+	if len(all) != 3 {
+		t.Fatalf("want 3 actions got %d: %+v", len(all), all)
+	}
+
+	// newest first, with who did it and the state either side
+	latest := all[0].(map[string]any)
+	if latest["action"] != string(types.ModActionBoardRoleGrant) || latest["actor"].(map[string]any)["username"] != "alice" || latest["board"].(map[string]any)["short"] != "tech" {
+		t.Fatalf("want the grant by alice on tech first got %+v", latest)
+	}
+	if latest["before"] != nil || latest["after"].(map[string]any)["role"] != string(types.AccountRoleMod) {
+		t.Fatalf("want only an after snapshot of the grant got %+v", latest)
+	}
+
+	lift := all[1].(map[string]any)
+	if lift["action"] != string(types.ModActionSanctionLift) || lift["before"].(map[string]any)["lifted_at"] != nil || lift["after"].(map[string]any)["lifted_at"] == nil {
+		t.Fatalf("want the lift with before and after snapshots got %+v", lift)
+	}
+
+	issue := all[2].(map[string]any)
+	if issue["action"] != string(types.ModActionSanctionIssue) || issue["reason"] != "spam" {
+		t.Fatalf("want the ban with it's reason got %+v", issue)
+	}
+
+	if got := records("?actor=carol"); len(got) != 2 {
+		t.Fatalf("filter by actor: want 2 got %d", len(got))
+	}
+	if got := records("?action=account.sanction&target_type=account"); len(got) != 1 {
+		t.Fatalf("filter by action: want 1 got %d", len(got))
+	}
+	if got := records("?board=tech"); len(got) != 1 {
+		t.Fatalf("filter by board: want 1 got %d", len(got))
+	}
+
+	status, res = ts.do(t, "GET", "/api/admin/mod-actions?count=2&page=2", nil, modSession)
+	paginator, _ := res["paginator"].(map[string]any)
+	if status != http.StatusOK || len(res["records"].([]any)) != 1 || paginator["total_records"] != float64(3) || paginator["last_page"] != true {
+		t.Fatalf("second page: want 1 of 3 records on the last page got %+v", res)
+	}
+
+	if status, _ := ts.do(t, "GET", "/api/admin/mod-actions?target_type=nope", nil, modSession); status != http.StatusBadRequest {
+		t.Fatalf("bad target type: want status %d got %d", http.StatusBadRequest, status)
+	}
+}
+
+func TestReports(t *testing.T) {
+	ts := newTestServer(t)
+	modSession := ts.register(t, "carol")
+	otherModSession := ts.register(t, "dave")
+	session := ts.register(t, "bob")
+	reporterSession := ts.register(t, "erin")
+
+	_, err := ts.store.UpdateMulti(bson.D{{Key: "username", Value: bson.D{{Key: "$in", Value: bson.A{"carol", "dave"}}}}}, bson.D{{Key: "$set", Value: bson.D{{Key: "role", Value: types.AccountRoleMod}}}}, "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, res := ts.do(t, "POST", "/api/boards/tech", map[string]any{"title": "Reported", "content": "op"}, session)
+	if status != http.StatusOK {
+		t.Fatalf("new thread: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	slug := res["thread_id"].(string)
+
+	if status, res := ts.do(t, "POST", "/api/threads/"+slug, map[string]any{"content": "buy now"}, session); status != http.StatusOK {
+		t.Fatalf("reply: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	report := map[string]any{"target_type": "post", "thread": slug, "post_number": 1, "category": "spam"}
+
+	status, res = ts.do(t, "POST", "/api/reports", report, reporterSession)
+	if status != http.StatusOK {
+		t.Fatalf("report: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	if filed := res["report"].(map[string]any); filed["status"] != string(types.ReportStatusOpen) || filed["claimed_by"] != nil {
+		t.Fatalf("report: want an open report without staff details got %+v", filed)
+	}
+
+	// the same reporter can't pile on, others can
+	if status, _ := ts.do(t, "POST", "/api/reports", report, reporterSession); status != http.StatusConflict {
+		t.Fatalf("duplicate: want status %d got %d", http.StatusConflict, status)
+	}
+	if status, res := ts.do(t, "POST", "/api/reports", report, modSession); status != http.StatusOK {
+		t.Fatalf("second reporter: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	bad := []map[string]any{
+		{"target_type": "post", "thread": slug, "post_number": 9, "category": "spam"},
+		{"target_type": "account", "thread": slug, "category": "spam"},
+		{"target_type": "thread", "thread": slug, "category": "other"},
+	}
+	for i, body := range bad {
+		if status, _ := ts.do(t, "POST", "/api/reports", body, reporterSession); status != http.StatusNotFound && status != http.StatusBadRequest {
+			t.Fatalf("bad report %d: want status 404 or 400 got %d", i, status)
+		}
+	}
+
+	// users don't see the queue
+	if status, _ := ts.do(t, "GET", "/api/mod/reports", nil, session); status != http.StatusForbidden {
+		t.Fatalf("queue as user: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	status, res = ts.do(t, "GET", "/api/mod/reports?board=tech", nil, modSession)
+	if status != http.StatusOK {
+		t.Fatalf("queue: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	records, _ := res["records"].([]any)
+	if len(records) != 1 {
+		t.Fatalf("queue: want the post once got %+v", records)
+	}
+
+	group := records[0].(map[string]any)
+	content, _ := group["content"].(map[string]any)
+	if group["reports"] != float64(2) || group["board"].(map[string]any)["short"] != "tech" || content["body"] != `<div class="content-body"><p>buy now</p></div>` {
+		t.Fatalf("queue: want 2 reports against the live post got %+v", group)
+	}
+	target := group["_id"].(string)
+
+	status, res = ts.do(t, "GET", "/api/mod/reports/"+target, nil, modSession)
+	if status != http.StatusOK || len(res["reports"].([]any)) != 2 {
+		t.Fatalf("target: want both reports got %d: %+v", status, res)
+	}
+
+	if status, res := ts.do(t, "POST", "/api/mod/reports/"+target+"/claim", nil, modSession); status != http.StatusOK {
+		t.Fatalf("claim: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	if status, _ := ts.do(t, "POST", "/api/mod/reports/"+target+"/claim", nil, otherModSession); status != http.StatusConflict {
+		t.Fatalf("claim taken: want status %d got %d", http.StatusConflict, status)
+	}
+	if status, _ := ts.do(t, "POST", "/api/mod/reports/"+target+"/dismiss", nil, otherModSession); status != http.StatusConflict {
+		t.Fatalf("dismiss claimed: want status %d got %d", http.StatusConflict, status)
+	}
+
+	if status, res := ts.do(t, "POST", "/api/mod/reports/"+target+"/resolve", map[string]string{"note": "removed"}, modSession); status != http.StatusOK {
+		t.Fatalf("resolve: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	status, res = ts.do(t, "GET", "/api/mod/reports", nil, modSession)
+	if records, _ := res["records"].([]any); status != http.StatusOK || len(records) != 0 {
+		t.Fatalf("queue after resolve: want it empty got %d: %+v", status, res)
+	}
+
+	status, res = ts.do(t, "GET", "/api/reports", nil, reporterSession)
+	if own, _ := res["reports"].([]any); status != http.StatusOK || len(own) != 1 || own[0].(map[string]any)["status"] != string(types.ReportStatusResolved) {
+		t.Fatalf("own reports: want the resolved report got %d: %+v", status, res)
+	}
+
+	status, res = ts.do(t, "GET", "/api/admin/mod-actions?target_type=post", nil, modSession)
+	if records, _ := res["records"].([]any); status != http.StatusOK || len(records) != 2 || records[0].(map[string]any)["action"] != string(types.ModActionReportResolve) {
+		t.Fatalf("mod log: want the claim and resolve got %d: %+v", status, res)
+	}
+
+	// reporters are rate limited
+	limit := types.REPORT_LIMIT_PER_WINDOW
+	types.REPORT_LIMIT_PER_WINDOW = 1
+	defer func() { types.REPORT_LIMIT_PER_WINDOW = limit }()
+
+	res2, body := ts.send(t, "POST", "/api/reports", map[string]any{"target_type": "thread", "thread": slug, "category": "off_topic"}, reporterSession)
+	if res2.StatusCode != http.StatusTooManyRequests || res2.Header.Get("Retry-After") == "" {
+		t.Fatalf("rate limit: want status %d with Retry-After got %d: %+v", http.StatusTooManyRequests, res2.StatusCode, body)
+	}
+}
+
+func TestThreadModeration(t *testing.T) {
+	ts := newTestServer(t)
+	creatorSession := ts.register(t, "alice")
+	session := ts.register(t, "bob")
+	helperSession := ts.register(t, "carol")
+
+	status, res := ts.do(t, "POST", "/api/boards/tech", map[string]any{"title": "Moderated", "content": "op"}, creatorSession)
+	if status != http.StatusOK {
+		t.Fatalf("new thread: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	slug := res["thread_id"].(string)
+
+	for i, s := range []string{session, helperSession} {
+		if status, res := ts.do(t, "POST", "/api/threads/"+slug, map[string]any{"content": fmt.Sprintf("reply %d", i+1)}, s); status != http.StatusOK {
+			t.Fatalf("reply %d: want status %d got %d: %+v", i+1, http.StatusOK, status, res)
+		}
+	}
+
+	// thread mods only know the identities behind the posts
+	_, res = ts.do(t, "GET", "/api/threads/"+slug, nil, "")
+	posts := res["thread"].(map[string]any)["posts"].([]any)
+	bobIdentity := posts[0].(map[string]any)["creator"].(map[string]any)["name"].(string)
+	carolIdentity := posts[1].(map[string]any)["creator"].(map[string]any)["name"].(string)
+	aliceIdentity := res["thread"].(map[string]any)["creator"].(map[string]any)["name"].(string)
+
+	if status, _ := ts.do(t, "POST", "/api/threads/"+slug+"/bans", map[string]string{"identity": carolIdentity}, session); status != http.StatusForbidden {
+		t.Fatalf("ban as user: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	status, res = ts.do(t, "POST", "/api/threads/"+slug+"/bans", map[string]string{"identity": bobIdentity, "reason": "spam"}, creatorSession)
+	if status != http.StatusOK {
+		t.Fatalf("ban: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	if banned := res["identity"].(map[string]any); banned["status"] != string(types.IdentityStatusBanned) || banned["account"] != nil || banned["_id"] != nil {
+		t.Fatalf("ban: want the banned identity without it's account got %+v", banned)
+	}
+
+	if status, _ := ts.do(t, "POST", "/api/threads/"+slug, map[string]any{"content": "again"}, session); status != http.StatusForbidden {
+		t.Fatalf("reply while banned: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	if status, res := ts.do(t, "POST", "/api/threads/"+slug+"/mods", map[string]string{"identity": carolIdentity}, creatorSession); status != http.StatusOK {
+		t.Fatalf("appoint: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	// appointed mods share the creator's powers over the thread, not over the creator
+	if status, res := ts.do(t, "DELETE", "/api/threads/"+slug+"/posts/1", nil, helperSession); status != http.StatusOK {
+		t.Fatalf("remove post: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	if status, _ := ts.do(t, "POST", "/api/threads/"+slug+"/bans", map[string]string{"identity": aliceIdentity}, helperSession); status != http.StatusConflict {
+		t.Fatalf("ban creator: want status %d got %d", http.StatusConflict, status)
+	}
+	if status, _ := ts.do(t, "DELETE", "/api/threads/"+slug+"/mods/"+aliceIdentity, nil, helperSession); status != http.StatusForbidden {
+		t.Fatalf("dismiss as mod: want status %d got %d", http.StatusForbidden, status)
+	}
+	// the posts are theirs to look after, the thread itself is the creator's
+	if status, _ := ts.do(t, "DELETE", "/api/threads/"+slug, nil, helperSession); status != http.StatusForbidden {
+		t.Fatalf("delete thread as mod: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	_, res = ts.do(t, "GET", "/api/threads/"+slug, nil, "")
+	if posts := res["thread"].(map[string]any)["posts"].([]any); len(posts) != 2 || posts[0].(map[string]any)["body"] != "post deleted by moderator" {
+		t.Fatalf("removed post: want a tombstone in place of post 1 got %+v", posts)
+	}
+
+	status, res = ts.do(t, "POST", "/api/threads/"+slug+"/status", map[string]string{"status": string(types.ThreadStatusClosed)}, helperSession)
+	if status != http.StatusOK || res["status"] != string(types.ThreadStatusClosed) {
+		t.Fatalf("close: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	if status, res := ts.do(t, "DELETE", "/api/threads/"+slug+"/mods/"+carolIdentity, nil, creatorSession); status != http.StatusOK {
+		t.Fatalf("dismiss: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	if status, _ := ts.do(t, "POST", "/api/threads/"+slug+"/status", map[string]string{"status": string(types.ThreadStatusOpen)}, helperSession); status != http.StatusForbidden {
+		t.Fatalf("reopen after dismissal: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	if status, res := ts.do(t, "DELETE", "/api/threads/"+slug+"/bans/"+bobIdentity, nil, creatorSession); status != http.StatusOK {
+		t.Fatalf("unban: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	// every step is in the moderation log for site staff
+	if logged := ts.store.CountResults("mod_actions", bson.D{}); logged != 6 {
+		t.Fatalf("mod log: want 6 actions got %d", logged)
+	}
+}
+
+func TestThreadStatus(t *testing.T) {
+	ts := newTestServer(t)
+	session := ts.register(t, "alice")
+	modSession := ts.register(t, "carol")
+
+	_, err := ts.store.UpdateMulti(bson.D{{Key: "username", Value: "carol"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "role", Value: types.AccountRoleMod}}}}, "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, res := ts.do(t, "POST", "/api/boards/tech", map[string]any{"title": "Status", "content": "op"}, session)
+	if status != http.StatusOK {
+		t.Fatalf("new thread: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	slug := res["thread_id"].(string)
+
+	setStatus := func(status types.ThreadStatus) {
+		t.Helper()
+		if _, err := ts.store.UpdateMulti(bson.D{{Key: "slug", Value: slug}}, bson.D{{Key: "$set", Value: bson.D{{Key: "status", Value: status}}}}, "threads"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	listed := func(session string) []any {
+		t.Helper()
+		status, res := ts.do(t, "GET", "/api/boards/tech", nil, session)
+		if status != http.StatusOK {
+			t.Fatalf("board: want status %d got %d: %+v", http.StatusOK, status, res)
+		}
+		records, _ := res["records"].([]any)
+		paginator := res["paginator"].(map[string]any)
+		if total, _ := paginator["total_records"].(float64); int(total) != len(records) {
+			t.Fatalf("board: want the count to match the listing got %+v", paginator)
+		}
+		return records
+	}
+
+	if status, res := ts.do(t, "POST", "/api/threads/"+slug, map[string]any{"content": "open"}, session); status != http.StatusOK {
+		t.Fatalf("reply to open: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	// closed and archived threads can be read but not replied to
+	for _, s := range []types.ThreadStatus{types.ThreadStatusClosed, types.ThreadStatusArchived} {
+		setStatus(s)
+
+		if status, _ := ts.do(t, "POST", "/api/threads/"+slug, map[string]any{"content": "late"}, session); status != http.StatusForbidden {
+			t.Fatalf("reply to %s: want status %d got %d", s, http.StatusForbidden, status)
+		}
+
+		status, res := ts.do(t, "GET", "/api/threads/"+slug, nil, "")
+		if status != http.StatusOK || res["thread"].(map[string]any)["read_only"] != true {
+			t.Fatalf("read %s: want a read only thread got %d: %+v", s, status, res)
+		}
+
+		if records := listed(""); len(records) != 1 || records[0].(map[string]any)["read_only"] != true {
+			t.Fatalf("list %s: want the thread read only got %+v", s, records)
+		}
+	}
+
+	// deleted threads are gone for everyone but staff
+	setStatus(types.ThreadStatusDeleted)
+
+	if status, _ := ts.do(t, "POST", "/api/threads/"+slug, map[string]any{"content": "late"}, session); status != http.StatusGone {
+		t.Fatalf("reply to deleted: want status %d got %d", http.StatusGone, status)
+	}
+	if status, _ := ts.do(t, "GET", "/api/threads/"+slug, nil, session); status != http.StatusGone {
+		t.Fatalf("read deleted: want status %d got %d", http.StatusGone, status)
+	}
+	if status, _ := ts.do(t, "GET", "/api/internal/thread/tech/"+slug, nil, ""); status != http.StatusGone {
+		t.Fatalf("internal read deleted: want status %d got %d", http.StatusGone, status)
+	}
+	if records := listed(session); len(records) != 0 {
+		t.Fatalf("list deleted: want no threads got %+v", records)
+	}
+
+	if status, res := ts.do(t, "GET", "/api/threads/"+slug, nil, modSession); status != http.StatusOK {
+		t.Fatalf("staff read deleted: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	if records := listed(modSession); len(records) != 1 {
+		t.Fatalf("staff list deleted: want the thread got %+v", records)
+	}
+}
+
+func TestContentDeletion(t *testing.T) {
+	ts := newTestServer(t)
+	creatorSession := ts.register(t, "alice")
+	session := ts.register(t, "bob")
+	otherSession := ts.register(t, "carol")
+	modSession := ts.register(t, "dave")
+
+	_, err := ts.store.UpdateMulti(bson.D{{Key: "username", Value: "dave"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "role", Value: types.AccountRoleMod}}}}, "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, res := ts.do(t, "POST", "/api/boards/tech", map[string]any{"title": "Deletions", "content": "op"}, creatorSession)
+	if status != http.StatusOK {
+		t.Fatalf("new thread: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	slug := res["thread_id"].(string)
+
+	for i, s := range []string{session, otherSession} {
+		if status, res := ts.do(t, "POST", "/api/threads/"+slug, map[string]any{"content": fmt.Sprintf("reply %d", i+1)}, s); status != http.StatusOK {
+			t.Fatalf("reply %d: want status %d got %d: %+v", i+1, http.StatusOK, status, res)
+		}
+	}
+
+	posts := func(session string) []any {
+		t.Helper()
+		status, res := ts.do(t, "GET", "/api/threads/"+slug, nil, session)
+		if status != http.StatusOK {
+			t.Fatalf("thread: want status %d got %d: %+v", http.StatusOK, status, res)
+		}
+		return res["thread"].(map[string]any)["posts"].([]any)
+	}
+
+	if status, _ := ts.do(t, "DELETE", "/api/threads/"+slug+"/posts/1", nil, otherSession); status != http.StatusForbidden {
+		t.Fatalf("delete another's post: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	status, res = ts.do(t, "DELETE", "/api/threads/"+slug+"/posts/1", nil, session)
+	if status != http.StatusOK || res["deleted_by"] != string(types.DeletedByAuthor) {
+		t.Fatalf("delete own post: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	if status, _ := ts.do(t, "DELETE", "/api/threads/"+slug+"/posts/1", nil, session); status != http.StatusGone {
+		t.Fatalf("delete twice: want status %d got %d", http.StatusGone, status)
+	}
+
+	status, res = ts.do(t, "DELETE", "/api/threads/"+slug+"/posts/2", nil, modSession)
+	if status != http.StatusOK || res["deleted_by"] != string(types.DeletedByModerator) {
+		t.Fatalf("staff delete: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	// deleted posts keep their place and number as tombstones
+	public := posts("")
+	if len(public) != 2 {
+		t.Fatalf("tombstones: want 2 posts got %+v", public)
+	}
+	for i, by := range []string{"author", "moderator"} {
+		post := public[i].(map[string]any)
+		if post["post_number"] != float64(i+1) || post["body"] != "post deleted by "+by || len(post["assets"].([]any)) != 0 {
+			t.Fatalf("tombstone %d: want a post deleted by %s got %+v", i+1, by, post)
+		}
+	}
+
+	// staff see what was deleted
+	if post := posts(modSession)[1].(map[string]any); post["body"] != `<div class="content-body"><p>reply 2</p></div>` || post["deleted_by"] != "moderator" {
+		t.Fatalf("staff view: want the deleted body got %+v", post)
+	}
+
+	if status, _ := ts.do(t, "POST", "/api/threads/"+slug+"/posts/2/restore", nil, otherSession); status != http.StatusForbidden {
+		t.Fatalf("restore as user: want status %d got %d", http.StatusForbidden, status)
+	}
+	if status, res := ts.do(t, "POST", "/api/threads/"+slug+"/posts/2/restore", nil, modSession); status != http.StatusOK {
+		t.Fatalf("restore post: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	if post := posts("")[1].(map[string]any); post["body"] != `<div class="content-body"><p>reply 2</p></div>` || post["deleted_at"] != nil {
+		t.Fatalf("restored post: want the original body got %+v", post)
+	}
+
+	// threads
+	if status, _ := ts.do(t, "DELETE", "/api/threads/"+slug, nil, session); status != http.StatusForbidden {
+		t.Fatalf("delete another's thread: want status %d got %d", http.StatusForbidden, status)
+	}
+	if status, res := ts.do(t, "DELETE", "/api/threads/"+slug, nil, creatorSession); status != http.StatusOK || res["deleted_by"] != string(types.DeletedByAuthor) {
+		t.Fatalf("delete own thread: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	if status, _ := ts.do(t, "GET", "/api/threads/"+slug, nil, session); status != http.StatusGone {
+		t.Fatalf("read deleted thread: want status %d got %d", http.StatusGone, status)
+	}
+	if status, res := ts.do(t, "POST", "/api/threads/"+slug+"/restore", nil, modSession); status != http.StatusOK || res["status"] != string(types.ThreadStatusOpen) {
+		t.Fatalf("restore thread: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	posts("")
+
+	// article comments
+	article := types.NewArticle()
+	article.Title = "News"
+	article.Status = types.ArticleStatusPublished
+	if err := ts.store.SaveNewSingle(article, "articles"); err != nil {
+		t.Fatal(err)
+	}
+
+	if status, res := ts.do(t, "POST", "/api/articles/"+article.Slug, map[string]any{"content": "first"}, session); status != http.StatusOK {
+		t.Fatalf("comment: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	comment := func(session string) map[string]any {
+		t.Helper()
+		status, res := ts.do(t, "GET", "/api/articles/"+article.Slug, nil, session)
+		if status != http.StatusOK {
+			t.Fatalf("article: want status %d got %d: %+v", http.StatusOK, status, res)
+		}
+		return res["article"].(map[string]any)["comments"].([]any)[0].(map[string]any)
+	}
+
+	if status, _ := ts.do(t, "DELETE", "/api/articles/"+article.Slug+"/comments/1", nil, otherSession); status != http.StatusForbidden {
+		t.Fatalf("delete another's comment: want status %d got %d", http.StatusForbidden, status)
+	}
+	if status, res := ts.do(t, "DELETE", "/api/articles/"+article.Slug+"/comments/1", nil, modSession); status != http.StatusOK {
+		t.Fatalf("staff delete comment: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	if got := comment(""); got["body"] != "comment deleted by moderator" {
+		t.Fatalf("comment tombstone: want it deleted by moderator got %+v", got)
+	}
+	if got := comment(modSession); got["body"] != `<div class="content-body"><p>first</p></div>` {
+		t.Fatalf("staff comment view: want the deleted body got %+v", got)
+	}
+	if status, res := ts.do(t, "POST", "/api/articles/"+article.Slug+"/comments/1/restore", nil, modSession); status != http.StatusOK {
+		t.Fatalf("restore comment: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	// only what staff did is moderation, authors deleting their own content isn't logged
+	if logged := ts.store.CountResults("mod_actions", bson.D{}); logged != 5 {
+		t.Fatalf("mod log: want 5 actions got %d", logged)
+	}
+}
+
+func TestPostEditing(t *testing.T) {
+	ts := newTestServer(t)
+	creatorSession := ts.register(t, "alice")
+	session := ts.register(t, "bob")
+	otherSession := ts.register(t, "carol")
+	modSession := ts.register(t, "dave")
+
+	_, err := ts.store.UpdateMulti(bson.D{{Key: "username", Value: "dave"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "role", Value: types.AccountRoleMod}}}}, "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, res := ts.do(t, "POST", "/api/boards/tech", map[string]any{"title": "Edits", "content": "op"}, creatorSession)
+	if status != http.StatusOK {
+		t.Fatalf("new thread: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	slug := res["thread_id"].(string)
+
+	if status, res := ts.do(t, "POST", "/api/threads/"+slug, map[string]any{"content": "first line\nsecond line"}, session); status != http.StatusOK {
+		t.Fatalf("reply: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	if status, _ := ts.do(t, "PATCH", "/api/threads/"+slug+"/posts/1", map[string]any{"content": "hijacked"}, otherSession); status != http.StatusForbidden {
+		t.Fatalf("edit another's post: want status %d got %d", http.StatusForbidden, status)
+	}
+	if status, _ := ts.do(t, "PATCH", "/api/threads/"+slug+"/posts/1", map[string]any{"content": " "}, session); status != http.StatusBadRequest {
+		t.Fatalf("empty edit: want status %d got %d", http.StatusBadRequest, status)
+	}
+	if status, res := ts.do(t, "PATCH", "/api/threads/"+slug+"/posts/1", map[string]any{"content": "first line\nsecond line, edited"}, session); status != http.StatusOK || res["edited_at"] == nil {
+		t.Fatalf("edit own post: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	status, res = ts.do(t, "GET", "/api/threads/"+slug, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("thread: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	post := res["thread"].(map[string]any)["posts"].([]any)[0].(map[string]any)
+	if !strings.Contains(post["body"].(string), "second line, edited") || post["edited_at"] == nil || post["raw"] != nil {
+		t.Fatalf("edited post: want the new body and edited_at without raw got %+v", post)
+	}
+
+	if status, _ := ts.do(t, "GET", "/api/threads/"+slug+"/posts/1/revisions", nil, session); status != http.StatusForbidden {
+		t.Fatalf("revisions as user: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	// the author's window closes, staff can still edit
+	_, err = ts.store.UpdateMulti(bson.D{}, bson.D{{Key: "$set", Value: bson.D{{Key: "created_at", Value: time.Now().UTC().Add(-time.Hour)}}}}, "posts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status, _ := ts.do(t, "PATCH", "/api/threads/"+slug+"/posts/1", map[string]any{"content": "again"}, session); status != http.StatusForbidden {
+		t.Fatalf("edit after the window: want status %d got %d", http.StatusForbidden, status)
+	}
+	if status, res := ts.do(t, "PATCH", "/api/threads/"+slug+"/posts/1", map[string]any{"content": "removed by staff"}, modSession); status != http.StatusOK {
+		t.Fatalf("staff edit: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	status, res = ts.do(t, "GET", "/api/threads/"+slug+"/posts/1/revisions", nil, modSession)
+	if status != http.StatusOK {
+		t.Fatalf("revisions: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	if current := res["current"].(map[string]any); current["raw"] != "removed by staff" {
+		t.Fatalf("current version: want the staff edit got %+v", current)
+	}
+	revisions := res["revisions"].([]any)
+	if len(revisions) != 2 {
+		t.Fatalf("revisions: want 2 got %+v", revisions)
+	}
+
+	first := revisions[0].(map[string]any)
+	if first["revision"] != float64(1) || first["raw"] != "first line\nsecond line" || first["edited_by"] != "bob" {
+		t.Fatalf("first revision: want the original replaced by bob got %+v", first)
+	}
+	ops := []string{}
+	for _, line := range first["diff"].([]any) {
+		ops = append(ops, line.(map[string]any)["op"].(string))
+	}
+	if strings.Join(ops, "") != "=-+" {
+		t.Fatalf("first revision diff: want =-+ got %+v", first["diff"])
+	}
+	if second := revisions[1].(map[string]any); second["edited_by"] != "dave" || second["raw"] != "first line\nsecond line, edited" {
+		t.Fatalf("second revision: want bob's edit replaced by dave got %+v", second)
+	}
+
+	// versions too far apart to diff are marked instead
+	for _, prefix := range []string{"a", "b"} {
+		lines := make([]string, 0, 2000)
+		for i := 0; i < 2000; i++ {
+			lines = append(lines, fmt.Sprintf("%s%d", prefix, i))
+		}
+		if status, res := ts.do(t, "PATCH", "/api/threads/"+slug+"/posts/1", map[string]any{"content": strings.Join(lines, "\n")}, modSession); status != http.StatusOK {
+			t.Fatalf("large edit: want status %d got %d: %+v", http.StatusOK, status, res)
+		}
+	}
+
+	_, res = ts.do(t, "GET", "/api/threads/"+slug+"/posts/1/revisions", nil, modSession)
+	revisions = res["revisions"].([]any)
+	if first := revisions[0].(map[string]any); first["diff_too_large"] != false {
+		t.Fatalf("small diff: want it made got %+v", first)
+	}
+	if last := revisions[len(revisions)-1].(map[string]any); last["diff_too_large"] != true || last["diff"] != nil {
+		t.Fatalf("large diff: want it marked too large got %+v", last["diff_too_large"])
+	}
+
+	// threads keep their title with each version
+	if status, res := ts.do(t, "PATCH", "/api/threads/"+slug, map[string]any{"title": "Edited", "content": "op, edited"}, session); status != http.StatusForbidden {
+		t.Fatalf("edit another's thread: want status %d got %d: %+v", http.StatusForbidden, status, res)
+	}
+	if status, res := ts.do(t, "PATCH", "/api/threads/"+slug, map[string]any{"title": "Edited", "content": "op, edited"}, creatorSession); status != http.StatusOK {
+		t.Fatalf("edit own thread: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	status, res = ts.do(t, "GET", "/api/threads/"+slug+"/revisions", nil, modSession)
+	if status != http.StatusOK {
+		t.Fatalf("thread revisions: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	if current := res["current"].(map[string]any); current["title"] != "Edited" {
+		t.Fatalf("current thread: want the new title got %+v", current)
+	}
+	if revisions := res["revisions"].([]any); len(revisions) != 1 || revisions[0].(map[string]any)["title"] != "Edits" {
+		t.Fatalf("thread revisions: want the original title got %+v", revisions)
+	}
+
+	// only staff edits are moderation, dave made three
+	if logged := ts.store.CountResults("mod_actions", bson.D{{Key: "action", Value: string(types.ModActionPostEdit)}}); logged != 3 {
+		t.Fatalf("mod actions: want 3 post edits got %d", logged)
+	}
+}
+
+func TestEditWindowSetting(t *testing.T) {
+	ts := newTestServer(t)
+	adminSession := ts.register(t, "alice")
+	session := ts.register(t, "bob")
+
+	_, err := ts.store.UpdateMulti(bson.D{{Key: "username", Value: "alice"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "role", Value: types.AccountRoleAdmin}}}}, "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, res := ts.do(t, "POST", "/api/boards/tech", map[string]any{"title": "Edits", "content": "op"}, session)
+	if status != http.StatusOK {
+		t.Fatalf("new thread: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	slug := res["thread_id"].(string)
+
+	_, err = ts.store.UpdateMulti(bson.D{}, bson.D{{Key: "$set", Value: bson.D{{Key: "created_at", Value: time.Now().UTC().Add(-time.Hour)}}}}, "threads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status, _ := ts.do(t, "PATCH", "/api/threads/"+slug, map[string]any{"content": "edited"}, session); status != http.StatusForbidden {
+		t.Fatalf("edit after the default window: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	if status, _ := ts.do(t, "POST", "/api/admin/settings", map[string]any{"post_edit_window_minutes": 0}, adminSession); status != http.StatusBadRequest {
+		t.Fatalf("no window: want status %d got %d", http.StatusBadRequest, status)
+	}
+	if status, res := ts.do(t, "POST", "/api/admin/settings", map[string]any{"post_edit_window_minutes": 120}, adminSession); status != http.StatusOK {
+		t.Fatalf("settings: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	if status, res := ts.do(t, "PATCH", "/api/threads/"+slug, map[string]any{"content": "edited"}, session); status != http.StatusOK {
+		t.Fatalf("edit within the longer window: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+}
+
+func TestSpamFilter(t *testing.T) {
+	ts := newTestServer(t)
+	adminSession := ts.register(t, "alice")
+	session := ts.register(t, "bob")
+	otherSession := ts.register(t, "carol")
+	modSession := ts.register(t, "dave")
+
+	for username, role := range map[string]types.AccountRole{"alice": types.AccountRoleAdmin, "dave": types.AccountRoleMod} {
+		_, err := ts.store.UpdateMulti(bson.D{{Key: "username", Value: username}}, bson.D{{Key: "$set", Value: bson.D{{Key: "role", Value: role}}}}, "accounts")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// carol's account is old enough not to look like a throwaway, bob's was just made
+	_, err := ts.store.UpdateMulti(bson.D{{Key: "username", Value: "carol"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "created_at", Value: time.Now().UTC().Add(-30 * 24 * time.Hour)}}}}, "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status, res := ts.do(t, "POST", "/api/admin/settings", map[string]any{"spam_banned_phrases": []string{"Cheap Pills"}}, adminSession); status != http.StatusOK {
+		t.Fatalf("settings: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	status, res := ts.do(t, "POST", "/api/boards/tech", map[string]any{"title": "Spam", "content": "op"}, adminSession)
+	if status != http.StatusOK {
+		t.Fatalf("new thread: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	slug := res["thread_id"].(string)
+
+	posts := func() []any {
+		t.Helper()
+		status, res := ts.do(t, "GET", "/api/threads/"+slug, nil, "")
+		if status != http.StatusOK {
+			t.Fatalf("thread: want status %d got %d: %+v", http.StatusOK, status, res)
+		}
+		return res["thread"].(map[string]any)["posts"].([]any)
+	}
+
+	if status, res := ts.do(t, "POST", "/api/threads/"+slug, map[string]any{"content": "a plain reply"}, session); status != http.StatusOK || res["held"] != nil {
+		t.Fatalf("plain reply: want it accepted got %d: %+v", status, res)
+	}
+
+	status, res = ts.do(t, "POST", "/api/threads/"+slug, map[string]any{"content": "cheap pills here"}, session)
+	if status != http.StatusOK || res["held"] != true {
+		t.Fatalf("banned phrase: want it held got %d: %+v", status, res)
+	}
+	if post := posts()[1].(map[string]any); post["body"] != "post held for review" {
+		t.Fatalf("held post: want a placeholder got %+v", post)
+	}
+	if _, res := ts.do(t, "GET", "/api/boards/tech", nil, otherSession); res["records"].([]any)[0].(map[string]any)["post_count"] != float64(1) {
+		t.Fatalf("held post: want it left out of the post count got %+v", res["records"])
+	}
+	if status, _ := ts.do(t, "POST", "/api/threads/"+slug+"/posts/2/restore", nil, modSession); status != http.StatusConflict {
+		t.Fatalf("restore held post: want status %d got %d", http.StatusConflict, status)
+	}
+
+	if status, _ := ts.do(t, "POST", "/api/threads/"+slug, map[string]any{"content": "cheap pills https://a.example https://b.example"}, session); status != http.StatusUnprocessableEntity {
+		t.Fatalf("banned phrase with links: want status %d got %d", http.StatusUnprocessableEntity, status)
+	}
+	if got := len(posts()); got != 2 {
+		t.Fatalf("rejected post: want it never saved got %d posts", got)
+	}
+
+	// staff skip the checks
+	if status, res := ts.do(t, "POST", "/api/threads/"+slug, map[string]any{"content": "cheap pills https://a.example https://b.example"}, modSession); status != http.StatusOK || res["held"] != nil {
+		t.Fatalf("staff reply: want it accepted got %d: %+v", status, res)
+	}
+
+	if status, _ := ts.do(t, "GET", "/api/mod/spam", nil, session); status != http.StatusForbidden {
+		t.Fatalf("holds as user: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	holds := func() []any {
+		t.Helper()
+		status, res := ts.do(t, "GET", "/api/mod/spam", nil, modSession)
+		if status != http.StatusOK {
+			t.Fatalf("holds: want status %d got %d: %+v", http.StatusOK, status, res)
+		}
+		return res["holds"].([]any)
+	}
+
+	pending := holds()
+	if len(pending) != 1 {
+		t.Fatalf("holds: want 1 got %+v", pending)
+	}
+	hold := pending[0].(map[string]any)
+	if hold["target_type"] != string(types.ModTargetPost) || !strings.Contains(hold["content"].(map[string]any)["body"].(string), "cheap pills") {
+		t.Fatalf("hold: want the held post got %+v", hold)
+	}
+
+	if status, res := ts.do(t, "POST", "/api/mod/spam/"+hold["_id"].(string)+"/approve", nil, modSession); status != http.StatusOK || res["status"] != string(types.SpamHoldStatusApproved) {
+		t.Fatalf("approve: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	if status, _ := ts.do(t, "POST", "/api/mod/spam/"+hold["_id"].(string)+"/reject", nil, modSession); status != http.StatusConflict {
+		t.Fatalf("decide twice: want status %d got %d", http.StatusConflict, status)
+	}
+	if post := posts()[1].(map[string]any); !strings.Contains(post["body"].(string), "cheap pills") || post["deleted_at"] != nil {
+		t.Fatalf("approved post: want it up got %+v", post)
+	}
+
+	// held threads stay out of the listing, rejected content is left deleted by a moderator
+	status, res = ts.do(t, "POST", "/api/boards/tech", map[string]any{"title": "Cheap pills", "content": "order now"}, session)
+	if status != http.StatusOK || res["held"] != true {
+		t.Fatalf("held thread: want it held got %d: %+v", status, res)
+	}
+	heldSlug := res["thread_id"].(string)
+
+	if status, _ := ts.do(t, "GET", "/api/threads/"+heldSlug, nil, otherSession); status != http.StatusGone {
+		t.Fatalf("read held thread: want status %d got %d", http.StatusGone, status)
+	}
+
+	hold = holds()[0].(map[string]any)
+	if status, res := ts.do(t, "POST", "/api/mod/spam/"+hold["_id"].(string)+"/reject", nil, modSession); status != http.StatusOK || res["status"] != string(types.SpamHoldStatusRejected) {
+		t.Fatalf("reject: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	thread := &types.Thread{}
+	if err := ts.store.FindSingle(bson.D{{Key: "slug", Value: heldSlug}}, thread, "threads"); err != nil {
+		t.Fatal(err)
+	}
+	if !thread.IsDeleted() || thread.DeletedBy != types.DeletedByModerator {
+		t.Fatalf("rejected thread: want it deleted by a moderator got %+v", thread)
+	}
+
+	// the classifier has an opinion once it's been trained enough
+	for i := 0; i < types.SPAM_CLASSIFIER_MIN_TRAINING; i++ {
+		for content, spam := range map[string]bool{"casino bonus win money now": true, "thanks for the thoughtful reply": false} {
+			if status, res := ts.do(t, "POST", "/api/mod/spam/train", map[string]any{"content": content, "spam": spam}, modSession); status != http.StatusOK {
+				t.Fatalf("train: want status %d got %d: %+v", http.StatusOK, status, res)
+			}
+		}
+	}
+
+	if status, res := ts.do(t, "POST", "/api/threads/"+slug, map[string]any{"content": "thanks, a thoughtful reply"}, otherSession); status != http.StatusOK || res["held"] != nil {
+		t.Fatalf("classified fine: want it accepted got %d: %+v", status, res)
+	}
+	if status, res := ts.do(t, "POST", "/api/threads/"+slug, map[string]any{"content": "casino bonus, win money"}, otherSession); status != http.StatusOK || res["held"] != true {
+		t.Fatalf("classified spam: want it held got %d: %+v", status, res)
+	}
+
+	// comments
+	article := types.NewArticle()
+	article.Title = "News"
+	article.Status = types.ArticleStatusPublished
+	if err := ts.store.SaveNewSingle(article, "articles"); err != nil {
+		t.Fatal(err)
+	}
+
+	if status, res := ts.do(t, "POST", "/api/articles/"+article.Slug, map[string]any{"content": "cheap pills"}, session); status != http.StatusOK || res["held"] != true {
+		t.Fatalf("held comment: want it held got %d: %+v", status, res)
+	}
+
+	if logged := ts.store.CountResults("spam_holds", types.SpamHoldFilter(types.SpamHoldStatusPending)); logged != 2 {
+		t.Fatalf("pending holds: want 2 got %d", logged)
+	}
+	if logged := ts.store.CountResults("mod_actions", bson.D{{Key: "action", Value: bson.D{{Key: "$in", Value: bson.A{string(types.ModActionSpamApprove), string(types.ModActionSpamReject)}}}}}); logged != 2 {
+		t.Fatalf("mod actions: want 2 spam decisions got %d", logged)
+	}
+}
+
+func TestSpamFilterEdits(t *testing.T) {
+	ts := newTestServer(t)
+	adminSession := ts.register(t, "alice")
+	session := ts.register(t, "bob")
+	otherSession := ts.register(t, "carol")
+
+	_, err := ts.store.UpdateMulti(bson.D{{Key: "username", Value: "alice"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "role", Value: types.AccountRoleAdmin}}}}, "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status, res := ts.do(t, "POST", "/api/admin/settings", map[string]any{"spam_banned_phrases": []string{"Cheap Pills"}}, adminSession); status != http.StatusOK {
+		t.Fatalf("settings: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	status, res := ts.do(t, "POST", "/api/boards/tech", map[string]any{"title": "Plain", "content": "op"}, session)
+	if status != http.StatusOK || res["held"] != nil {
+		t.Fatalf("new thread: want it accepted got %d: %+v", status, res)
+	}
+	slug := res["thread_id"].(string)
+
+	if status, res := ts.do(t, "POST", "/api/threads/"+slug, map[string]any{"content": "a plain reply"}, session); status != http.StatusOK || res["held"] != nil {
+		t.Fatalf("plain reply: want it accepted got %d: %+v", status, res)
+	}
+
+	// edits are held and rejected the same as anything new
+	if status, _ := ts.do(t, "PATCH", "/api/threads/"+slug+"/posts/1", map[string]any{"content": "cheap pills https://a.example https://b.example"}, session); status != http.StatusUnprocessableEntity {
+		t.Fatalf("rejected edit: want status %d got %d", http.StatusUnprocessableEntity, status)
+	}
+	if revisions := ts.store.CountResults("post_revisions", bson.D{}); revisions != 0 {
+		t.Fatalf("rejected edit: want nothing kept got %d revisions", revisions)
+	}
+
+	if status, res := ts.do(t, "PATCH", "/api/threads/"+slug+"/posts/1", map[string]any{"content": "cheap pills here"}, session); status != http.StatusOK || res["held"] != true {
+		t.Fatalf("held edit: want it held got %d: %+v", status, res)
+	}
+	_, res = ts.do(t, "GET", "/api/threads/"+slug, nil, otherSession)
+	if post := res["thread"].(map[string]any)["posts"].([]any)[0].(map[string]any); post["body"] != "post held for review" {
+		t.Fatalf("held edit: want a placeholder got %+v", post)
+	}
+
+	if status, res := ts.do(t, "PATCH", "/api/threads/"+slug, map[string]any{"title": "Cheap pills", "content": "op"}, session); status != http.StatusOK || res["held"] != true {
+		t.Fatalf("held thread edit: want it held got %d: %+v", status, res)
+	}
+	if status, _ := ts.do(t, "GET", "/api/threads/"+slug, nil, otherSession); status != http.StatusGone {
+		t.Fatalf("read held thread: want status %d got %d", http.StatusGone, status)
+	}
+
+	if logged := ts.store.CountResults("spam_holds", types.SpamHoldFilter(types.SpamHoldStatusPending)); logged != 2 {
+		t.Fatalf("pending holds: want 2 got %d", logged)
+	}
+}
+
+func TestSpamClassifierConcurrentTraining(t *testing.T) {
+	ts := newTestServer(t)
+
+	const trained = 10
+
+	var wg sync.WaitGroup
+	for i := 0; i < trained; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := types.TrainSpamClassifier(ts.store, "casino bonus", true); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	tokens := []*types.SpamToken{}
+	if err := ts.store.FindMulti(bson.D{}, &tokens, "spam_tokens"); err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 {
+		t.Fatalf("want one document per word got %d", len(tokens))
+	}
+	for _, v := range tokens {
+		if v.Spam != trained || v.Ham != 0 {
+			t.Fatalf("want %q seen %d times as spam got %+v", v.Token, trained, v)
+		}
+	}
+
+	if count := ts.store.CountResults("spam_corpus", bson.D{}); count != 1 {
+		t.Fatalf("want one corpus got %d", count)
+	}
+	corpus, err := types.FindSpamCorpus(ts.store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if corpus.Spam != trained || corpus.UpdatedAt == nil {
+		t.Fatalf("want a corpus of %d spam got %+v", trained, corpus)
+	}
+}
+
+func TestIPBans(t *testing.T) {
+	ts := newTestServer(t)
+	session := ts.register(t, "bob")
+	otherSession := ts.register(t, "carol")
+	modSession := ts.register(t, "dave")
+
+	_, err := ts.store.UpdateMulti(bson.D{{Key: "username", Value: "dave"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "role", Value: types.AccountRoleMod}}}}, "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the test client connects from loopback, which stands in for the proxy
+	if err := utils.SetTrustedProxies([]string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { utils.SetTrustedProxies(nil) })
+
+	// like ts.do, sent through the proxy for the address
+	doFrom := func(ip, method, path string, body any, session string) (int, map[string]any) {
+		t.Helper()
+
+		var reader io.Reader
+		if body != nil {
+			bs, err := json.Marshal(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader = bytes.NewReader(bs)
+		}
+
+		req, err := http.NewRequest(method, ts.URL+path, reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Forwarded-For", "10.9.9.9, "+ip)
+		req.AddCookie(&http.Cookie{Name: types.SESSION_COOKIE_NAME, Value: session})
+		req.Header.Set(types.CSRF_HEADER_NAME, utils.CSRFToken(session))
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		decoded := map[string]any{}
+		json.NewDecoder(res.Body).Decode(&decoded)
+		return res.StatusCode, decoded
+	}
+
+	status, res := doFrom("203.0.113.5", "POST", "/api/boards/tech", map[string]any{"title": "Bans", "content": "op"}, otherSession)
+	if status != http.StatusOK {
+		t.Fatalf("new thread: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	slug := res["thread_id"].(string)
+
+	if status, res := doFrom("203.0.113.7", "POST", "/api/threads/"+slug, map[string]any{"content": "evading"}, session); status != http.StatusOK {
+		t.Fatalf("reply: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	status, res = ts.do(t, "GET", "/api/threads/"+slug, nil, "")
+	if status != http.StatusOK {
+		t.Fatalf("thread: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	if post := res["thread"].(map[string]any)["posts"].([]any)[0].(map[string]any); post["ip_hash"] != nil {
+		t.Fatalf("public post: want no address hash got %+v", post)
+	}
+
+	if status, _ := ts.do(t, "POST", "/api/admin/ip-bans", map[string]any{"thread": slug, "post_number": 1, "reason": "ban evasion"}, session); status != http.StatusForbidden {
+		t.Fatalf("ban as user: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	for _, body := range []map[string]any{
+		{"cidr": "0.0.0.0/0", "reason": "everyone"},
+		{"cidr": "not an address", "reason": "typo"},
+		{"cidr": "198.51.100.0/24"},
+	} {
+		if status, res := ts.do(t, "POST", "/api/admin/ip-bans", body, modSession); status != http.StatusBadRequest {
+			t.Fatalf("invalid ban %+v: want status %d got %d: %+v", body, http.StatusBadRequest, status, res)
+		}
+	}
+
+	// the address behind the post, without staff ever seeing it
+	status, res = ts.do(t, "POST", "/api/admin/ip-bans", map[string]any{"thread": slug, "post_number": 1, "reason": "ban evasion"}, modSession)
+	if status != http.StatusOK {
+		t.Fatalf("ban post: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	issued := res["issued"].(map[string]any)
+	if issued["cidr"] != "" || issued["ip_hash"] != nil {
+		t.Fatalf("post ban: want no address got %+v", issued)
+	}
+	postBan := issued["_id"].(string)
+
+	status, res = doFrom("203.0.113.7", "POST", "/api/threads/"+slug, map[string]any{"content": "again"}, otherSession)
+	if status != http.StatusForbidden || res["error"] != "address is banned" {
+		t.Fatalf("write from banned address: want status %d got %d: %+v", http.StatusForbidden, status, res)
+	}
+	if status, _ := doFrom("203.0.113.7", "GET", "/api/threads/"+slug, nil, otherSession); status != http.StatusOK {
+		t.Fatalf("read from banned address: want status %d got %d", http.StatusOK, status)
+	}
+	if status, _ := doFrom("203.0.113.8", "POST", "/api/threads/"+slug, map[string]any{"content": "neighbour"}, otherSession); status != http.StatusOK {
+		t.Fatalf("write from another address: want status %d got %d", http.StatusOK, status)
+	}
+
+	// forwarded addresses are only believed from trusted proxies
+	if err := utils.SetTrustedProxies(nil); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := doFrom("203.0.113.7", "POST", "/api/threads/"+slug, map[string]any{"content": "spoofed"}, otherSession); status != http.StatusOK {
+		t.Fatalf("untrusted forwarding: want status %d got %d", http.StatusOK, status)
+	}
+	if err := utils.SetTrustedProxies([]string{"127.0.0.0/8"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// ranges, which staff who manage bans aren't stopped by
+	status, res = ts.do(t, "POST", "/api/admin/ip-bans", map[string]any{"cidr": "198.51.100.9/24", "reason": "spam network", "duration_hours": 1}, modSession)
+	if status != http.StatusOK || res["issued"].(map[string]any)["cidr"] != "198.51.100.0/24" {
+		t.Fatalf("ban range: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	if status, _ := doFrom("198.51.100.42", "POST", "/api/threads/"+slug, map[string]any{"content": "from the range"}, otherSession); status != http.StatusForbidden {
+		t.Fatalf("write from banned range: want status %d got %d", http.StatusForbidden, status)
+	}
+	if status, _ := doFrom("198.51.100.42", "POST", "/api/threads/"+slug, map[string]any{"content": "staff"}, modSession); status != http.StatusOK {
+		t.Fatalf("staff write from banned range: want status %d got %d", http.StatusOK, status)
+	}
+	for ip, want := range map[string]int{"198.51.100.0": http.StatusForbidden, "198.51.100.255": http.StatusForbidden, "198.51.101.0": http.StatusOK, "2001:db8::1": http.StatusOK} {
+		if status, _ := doFrom(ip, "POST", "/api/threads/"+slug, map[string]any{"content": "edge of " + ip}, otherSession); status != want {
+			t.Fatalf("write from %s: want status %d got %d", ip, want, status)
+		}
+	}
+
+	_, err = ts.store.UpdateMulti(bson.D{{Key: "cidr", Value: "198.51.100.0/24"}}, bson.D{{Key: "$set", Value: bson.D{{Key: "expires_at", Value: time.Now().UTC().Add(-time.Minute)}}}}, "ip_bans")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := doFrom("198.51.100.42", "POST", "/api/threads/"+slug, map[string]any{"content": "expired"}, otherSession); status != http.StatusOK {
+		t.Fatalf("write after expiry: want status %d got %d", http.StatusOK, status)
+	}
+
+	status, res = ts.do(t, "GET", "/api/admin/ip-bans", nil, modSession)
+	if status != http.StatusOK || len(res["bans"].([]any)) != 1 {
+		t.Fatalf("bans in force: want 1 got %d: %+v", status, res)
+	}
+
+	if status, res := ts.do(t, "DELETE", "/api/admin/ip-bans/"+postBan, nil, modSession); status != http.StatusOK {
+		t.Fatalf("lift: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	if status, _ := doFrom("203.0.113.7", "POST", "/api/threads/"+slug, map[string]any{"content": "lifted"}, otherSession); status != http.StatusOK {
+		t.Fatalf("write after lift: want status %d got %d", http.StatusOK, status)
+	}
+
+	if logged := ts.store.CountResults("mod_actions", bson.D{{Key: "target_type", Value: string(types.ModTargetIPBan)}}); logged != 3 {
+		t.Fatalf("mod actions: want 3 got %d", logged)
+	}
+}
+
+func TestShadowBans(t *testing.T) {
+	ts := newTestServer(t)
+	creatorSession := ts.register(t, "alice")
+	session := ts.register(t, "bob")
+	otherSession := ts.register(t, "carol")
+	modSession := ts.register(t, "dave")
+	ts.register(t, "erin")
+
+	_, err := ts.store.UpdateMulti(bson.D{{Key: "username", Value: bson.D{{Key: "$in", Value: bson.A{"dave", "erin"}}}}}, bson.D{{Key: "$set", Value: bson.D{{Key: "role", Value: types.AccountRoleMod}}}}, "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, res := ts.do(t, "POST", "/api/boards/tech", map[string]any{"title": "Shadows", "content": "op"}, creatorSession)
+	if status != http.StatusOK {
+		t.Fatalf("new thread: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	slug := res["thread_id"].(string)
+
+	for i, s := range []string{session, otherSession} {
+		if status, res := ts.do(t, "POST", "/api/threads/"+slug, map[string]any{"content": fmt.Sprintf("reply %d", i+1)}, s); status != http.StatusOK {
+			t.Fatalf("reply %d: want status %d got %d: %+v", i+1, http.StatusOK, status, res)
+		}
+	}
+
+	posts := func(session string) []map[string]any {
+		t.Helper()
+		status, res := ts.do(t, "GET", "/api/threads/"+slug, nil, session)
+		if status != http.StatusOK {
+			t.Fatalf("thread: want status %d got %d: %+v", http.StatusOK, status, res)
+		}
+		found := []map[string]any{}
+		for _, v := range res["thread"].(map[string]any)["posts"].([]any) {
+			found = append(found, v.(map[string]any))
+		}
+		return found
+	}
+
+	if status, _ := ts.do(t, "PUT", "/api/admin/accounts/bob/shadow-ban", nil, otherSession); status != http.StatusForbidden {
+		t.Fatalf("shadow ban as user: want status %d got %d", http.StatusForbidden, status)
+	}
+	if status, _ := ts.do(t, "PUT", "/api/admin/accounts/dave/shadow-ban", nil, modSession); status != http.StatusForbidden {
+		t.Fatalf("shadow ban self: want status %d got %d", http.StatusForbidden, status)
+	}
+	if status, _ := ts.do(t, "PUT", "/api/admin/accounts/erin/shadow-ban", nil, modSession); status != http.StatusForbidden {
+		t.Fatalf("shadow ban staff as mod: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	status, res = ts.do(t, "PUT", "/api/admin/accounts/bob/shadow-ban", map[string]any{"reason": "spam"}, modSession)
+	if status != http.StatusOK || res["shadow_ban"].(map[string]any)["shadow_banned"] != true {
+		t.Fatalf("shadow ban: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	if status, _ := ts.do(t, "PUT", "/api/admin/accounts/bob/shadow-ban", nil, modSession); status != http.StatusConflict {
+		t.Fatalf("shadow ban twice: want status %d got %d", http.StatusConflict, status)
+	}
+
+	// bob carries on like nothing happened
+	if status, res := ts.do(t, "POST", "/api/threads/"+slug, map[string]any{"content": "reply 3"}, session); status != http.StatusOK {
+		t.Fatalf("reply while shadow banned: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	own := posts(session)
+	if len(own) != 3 {
+		t.Fatalf("author: want 3 posts got %+v", own)
+	}
+	for _, v := range own {
+		if _, ok := v["shadowed"]; ok {
+			t.Fatalf("author: want no shadow flag got %+v", v)
+		}
+	}
+	if _, res := ts.do(t, "GET", "/api/account", nil, session); res["account"].(map[string]any)["shadow_banned"] != nil {
+		t.Fatalf("account: want no shadow flag got %+v", res["account"])
+	}
+
+	for _, s := range []string{otherSession, ""} {
+		others := posts(s)
+		if len(others) != 1 || others[0]["post_number"] != float64(2) {
+			t.Fatalf("others: want only carol's post got %+v", others)
+		}
+	}
+
+	staff := posts(modSession)
+	if len(staff) != 3 || staff[0]["shadowed"] != true || staff[1]["shadowed"] != false || staff[2]["shadowed"] != true {
+		t.Fatalf("staff: want shadowed posts flagged got %+v", staff)
+	}
+
+	// the board's count of posts only counts what the viewer is shown
+	postCount := func(session string) any {
+		t.Helper()
+		_, res := ts.do(t, "GET", "/api/boards/tech", nil, session)
+		for _, v := range res["records"].([]any) {
+			if thread := v.(map[string]any); thread["slug"] == slug {
+				return thread["post_count"]
+			}
+		}
+		t.Fatalf("board: want the thread listed got %+v", res["records"])
+		return nil
+	}
+	for s, want := range map[string]float64{otherSession: 1, "": 1, session: 3, modSession: 3} {
+		if got := postCount(s); got != want {
+			t.Fatalf("post count: want %v got %v", want, got)
+		}
+	}
+
+	if status, _ := ts.do(t, "GET", "/api/internal/post/"+slug+"/1", nil, ""); status != http.StatusNotFound {
+		t.Fatalf("internal post: want status %d got %d", http.StatusNotFound, status)
+	}
+
+	// threads are left out of the board and can't be opened
+	status, res = ts.do(t, "POST", "/api/boards/tech", map[string]any{"title": "Cheap pills", "content": "buy"}, session)
+	if status != http.StatusOK {
+		t.Fatalf("thread while shadow banned: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	shadowSlug := res["thread_id"].(string)
+
+	board := func(session string) int {
+		t.Helper()
+		status, res := ts.do(t, "GET", "/api/boards/tech", nil, session)
+		if status != http.StatusOK {
+			t.Fatalf("board: want status %d got %d: %+v", http.StatusOK, status, res)
+		}
+		records, _ := res["records"].([]any)
+		if total, _ := res["paginator"].(map[string]any)["total_records"].(float64); int(total) != len(records) {
+			t.Fatalf("board: want total %d got %v", len(records), total)
+		}
+		return len(records)
+	}
+	if got := board(otherSession); got != 1 {
+		t.Fatalf("board for others: want 1 thread got %d", got)
+	}
+	if got := board(session); got != 2 {
+		t.Fatalf("board for author: want 2 threads got %d", got)
+	}
+	if got := board(modSession); got != 2 {
+		t.Fatalf("board for staff: want 2 threads got %d", got)
+	}
+
+	if status, _ := ts.do(t, "GET", "/api/threads/"+shadowSlug, nil, otherSession); status != http.StatusNotFound {
+		t.Fatalf("shadowed thread for others: want status %d got %d", http.StatusNotFound, status)
+	}
+	if status, res := ts.do(t, "GET", "/api/threads/"+shadowSlug, nil, modSession); status != http.StatusOK || res["thread"].(map[string]any)["shadowed"] != true {
+		t.Fatalf("shadowed thread for staff: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	// article comments
+	article := types.NewArticle()
+	article.Title = "News"
+	article.Status = types.ArticleStatusPublished
+	if err := ts.store.SaveNewSingle(article, "articles"); err != nil {
+		t.Fatal(err)
+	}
+
+	if status, res := ts.do(t, "POST", "/api/articles/"+article.Slug, map[string]any{"content": "first"}, session); status != http.StatusOK {
+		t.Fatalf("comment: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	comments := func(session string) []any {
+		t.Helper()
+		status, res := ts.do(t, "GET", "/api/articles/"+article.Slug, nil, session)
+		if status != http.StatusOK {
+			t.Fatalf("article: want status %d got %d: %+v", http.StatusOK, status, res)
+		}
+		return res["article"].(map[string]any)["comments"].([]any)
+	}
+	if got := comments(otherSession); len(got) != 0 {
+		t.Fatalf("comments for others: want none got %+v", got)
+	}
+	if got := comments(session); len(got) != 1 {
+		t.Fatalf("comments for author: want 1 got %+v", got)
+	}
+	if got := comments(modSession); len(got) != 1 || got[0].(map[string]any)["shadowed"] != true {
+		t.Fatalf("comments for staff: want 1 flagged got %+v", got)
+	}
+
+	if status, res := ts.do(t, "DELETE", "/api/admin/accounts/bob/shadow-ban", nil, modSession); status != http.StatusOK {
+		t.Fatalf("lift: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	if got := posts(otherSession); len(got) != 3 {
+		t.Fatalf("after lift: want 3 posts got %+v", got)
+	}
+
+	// identities only shadow what was written in their thread
+	carol := staff[1]["creator"].(map[string]any)["name"].(string)
+	if status, _ := ts.do(t, "PUT", "/api/threads/"+slug+"/shadow-bans/"+carol, nil, session); status != http.StatusForbidden {
+		t.Fatalf("shadow ban identity as user: want status %d got %d", http.StatusForbidden, status)
+	}
+	status, res = ts.do(t, "PUT", "/api/threads/"+slug+"/shadow-bans/"+carol, nil, modSession)
+	if status != http.StatusOK || res["identity"].(map[string]any)["shadow_banned"] != true {
+		t.Fatalf("shadow ban identity: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	if got := posts(session); len(got) != 2 {
+		t.Fatalf("identity shadowed for others: want 2 posts got %+v", got)
+	}
+	if got := posts(otherSession); len(got) != 3 {
+		t.Fatalf("identity shadowed for author: want 3 posts got %+v", got)
+	}
+	if status, res := ts.do(t, "DELETE", "/api/threads/"+slug+"/shadow-bans/"+carol, nil, modSession); status != http.StatusOK {
+		t.Fatalf("lift identity: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	if status, _ := ts.do(t, "DELETE", "/api/threads/"+slug+"/shadow-bans/"+carol, nil, modSession); status != http.StatusConflict {
+		t.Fatalf("lift identity twice: want status %d got %d", http.StatusConflict, status)
+	}
+
+	if logged := ts.store.CountResults("mod_actions", bson.D{{Key: "action", Value: bson.D{{Key: "$in", Value: bson.A{string(types.ModActionShadowBan), string(types.ModActionShadowUnban)}}}}}); logged != 4 {
+		t.Fatalf("mod actions: want 4 got %d", logged)
+	}
+
+	// identities left behind by purged accounts can still be shadow banned
+	_, err = ts.store.UpdateMulti(bson.D{{Key: "name", Value: carol}}, bson.D{{Key: "$unset", Value: bson.D{{Key: "account", Value: ""}}}}, "identities")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, res := ts.do(t, "PUT", "/api/threads/"+slug+"/shadow-bans/"+carol, nil, modSession); status != http.StatusOK {
+		t.Fatalf("shadow ban orphaned identity: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+}
+
+// a minimal OpenID Connect issuer, tests play the browser and the user approving the sign in
+type mockIssuer struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+
+	mu     sync.Mutex
+	grants map[string]mockGrant
+}
+
+// an approved authorization waiting to be exchanged
+type mockGrant struct {
+	challenge string
+	nonce     string
+	claims    map[string]any
+}
+
+func newMockIssuer(t *testing.T, clientID string) *mockIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mi := &mockIssuer{key: key, clientID: clientID, grants: map[string]mockGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 mi.URL,
+			"authorization_endpoint": mi.URL + "/authorize",
+			"token_endpoint":         mi.URL + "/token",
+			"jwks_uri":               mi.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", mi.handleToken)
+
+	mi.Server = httptest.NewServer(mux)
+	t.Cleanup(mi.Close)
+
+	return mi
+}
+
+// approves the authorization request as the user with the claims
+// - returns the code and state the provider would send back to the front end
+func (mi *mockIssuer) authorize(t *testing.T, authURL string, claims map[string]any) (string, string) {
+	t.Helper()
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+
+	if query.Get("client_id") != mi.clientID || query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization request %s", authURL)
+	}
+
+	code := fmt.Sprintf("code-%d", time.Now().UnixNano())
+
+	mi.mu.Lock()
+	mi.grants[code] = mockGrant{challenge: query.Get("code_challenge"), nonce: query.Get("nonce"), claims: claims}
+	mi.mu.Unlock()
+
+	return code, query.Get("state")
+}
+
+func (mi *mockIssuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+
+	mi.mu.Lock()
+	grant, ok := mi.grants[r.PostForm.Get("code")]
+	delete(mi.grants, r.PostForm.Get("code"))
+	mi.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	if id, _, _ := r.BasicAuth(); id != mi.clientID {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	claims := map[string]any{
+		"iss":   mi.URL,
+		"aud":   mi.clientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": grant.nonce,
+	}
+	for k, v := range grant.claims {
+		claims[k] = v
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signing := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signing))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, mi.key, crypto.SHA256, digest[:])
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "unused",
+		"token_type":   "Bearer",
+		"id_token":     signing + "." + base64.RawURLEncoding.EncodeToString(sig),
+	})
+}
+
+// signs in with the mock provider as the user with the claims, linking it when a session is given
+func (ts *testServer) oidcSignIn(t *testing.T, mi *mockIssuer, claims map[string]any, session string) (*http.Response, map[string]any) {
+	t.Helper()
+	return ts.oidcFlow(t, mi, claims, session, map[string]bool{"link": session != ""})
+}
+
+// goes through the provider from the session, confirming the user for the account management that needs it
+func (ts *testServer) oidcReauth(t *testing.T, mi *mockIssuer, claims map[string]any, session string) (*http.Response, map[string]any) {
+	t.Helper()
+	return ts.oidcFlow(t, mi, claims, session, map[string]bool{"reauth": true})
+}
+
+func (ts *testServer) oidcFlow(t *testing.T, mi *mockIssuer, claims map[string]any, session string, body map[string]bool) (*http.Response, map[string]any) {
+	t.Helper()
+
+	res, decoded := ts.send(t, "POST", "/api/account/oidc/mock/authorize", body, session)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("authorize: want status %d got %d: %+v", http.StatusOK, res.StatusCode, decoded)
+	}
+
+	var stateCookie *http.Cookie
+	for _, c := range res.Cookies() {
+		if c.Name == types.OIDC_STATE_COOKIE_NAME {
+			stateCookie = c
+		}
+	}
+	if stateCookie == nil {
+		t.Fatal("authorize set no state cookie")
+	}
+
+	code, state := mi.authorize(t, decoded["authorization_url"].(string), claims)
+
+	bs, _ := json.Marshal(map[string]string{"code": code, "state": state})
+	req, _ := http.NewRequest("POST", ts.URL+"/api/account/oidc/mock/callback", bytes.NewReader(bs))
+	req.AddCookie(stateCookie)
+	if session != "" {
+		req.AddCookie(&http.Cookie{Name: types.SESSION_COOKIE_NAME, Value: session})
+		req.Header.Set(types.CSRF_HEADER_NAME, utils.CSRFToken(session))
+	}
+
+	callback, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer callback.Body.Close()
+
+	decoded = map[string]any{}
+	_ = json.NewDecoder(callback.Body).Decode(&decoded)
+
+	return callback, decoded
+}
+
+func TestOIDCLogin(t *testing.T) {
+	ts := newTestServer(t)
+	mi := newMockIssuer(t, "opforu")
+	ts.handler.OIDCProviders["mock"] = types.NewOIDCProvider("mock", mi.URL, "opforu", "secret", "http://localhost:5173/login/oidc/mock")
+
+	_, res := ts.do(t, "GET", "/api/account/oidc/providers", nil, "")
+	if providers, _ := res["providers"].([]any); len(providers) != 1 || providers[0] != "mock" {
+		t.Fatalf("want the mock provider listed got %+v", res["providers"])
+	}
+
+	carol := map[string]any{"sub": "carol-1", "email": "carol@example.com", "email_verified": true, "preferred_username": "carol"}
+
+	// the first sign in creates the account
+	callback, res := ts.oidcSignIn(t, mi, carol, "")
+	if callback.StatusCode != http.StatusOK {
+		t.Fatalf("first sign in: want status %d got %d: %+v", http.StatusOK, callback.StatusCode, res)
+	}
+	if cookie := sessionCookie(callback); cookie == nil || cookie.Value == "" {
+		t.Fatal("first sign in set no session cookie")
+	}
+
+	account := res["account"].(map[string]any)
+	if account["username"] != "carol" || account["status"] != string(types.AccountStatusActive) {
+		t.Fatalf("want an active account named carol got %+v", account)
+	}
+
+	// signing in again uses the same account
+	callback, res = ts.oidcSignIn(t, mi, carol, "")
+	if callback.StatusCode != http.StatusOK || res["account"].(map[string]any)["username"] != "carol" {
+		t.Fatalf("second sign in: want carol's account got %d: %+v", callback.StatusCode, res)
+	}
+	if n := ts.store.CountResults("accounts", bson.D{{Key: "email", Value: "carol@example.com"}}); n != 1 {
+		t.Fatalf("want 1 account for carol got %d", n)
+	}
+	carolSession := sessionCookie(callback).Value
+
+	// the state must come from the browser that started the sign in
+	_, res = ts.send(t, "POST", "/api/account/oidc/mock/authorize", nil, "")
+	code, state := mi.authorize(t, res["authorization_url"].(string), carol)
+	if status, _ := ts.do(t, "POST", "/api/account/oidc/mock/callback", map[string]string{"code": code, "state": state}, ""); status != http.StatusBadRequest {
+		t.Fatalf("callback without state cookie: want status %d got %d", http.StatusBadRequest, status)
+	}
+
+	// an existing account is never taken over by email, it has to link the provider itself
+	daveSession := ts.register(t, "dave")
+	dave := map[string]any{"sub": "dave-1", "email": "dave@example.com", "email_verified": true}
+
+	if callback, _ := ts.oidcSignIn(t, mi, dave, ""); callback.StatusCode != http.StatusConflict {
+		t.Fatalf("sign in with a taken email: want status %d got %d", http.StatusConflict, callback.StatusCode)
+	}
+
+	callback, res = ts.oidcSignIn(t, mi, dave, daveSession)
+	if callback.StatusCode != http.StatusOK || res["external_login"] == nil {
+		t.Fatalf("link: want status %d got %d: %+v", http.StatusOK, callback.StatusCode, res)
+	}
+
+	if callback, _ := ts.oidcSignIn(t, mi, carol, daveSession); callback.StatusCode != http.StatusConflict {
+		t.Fatalf("linking another account's provider user: want status %d got %d", http.StatusConflict, callback.StatusCode)
+	}
+
+	callback, res = ts.oidcSignIn(t, mi, dave, "")
+	if callback.StatusCode != http.StatusOK || res["account"].(map[string]any)["username"] != "dave" {
+		t.Fatalf("sign in after linking: want dave's account got %d: %+v", callback.StatusCode, res)
+	}
+
+	// carol has no password so her only provider stays, dave can unlink his
+	if status, _ := ts.do(t, "DELETE", "/api/account/oidc/mock", nil, carolSession); status != http.StatusConflict {
+		t.Fatalf("unlink last sign in: want status %d got %d", http.StatusConflict, status)
+	}
+	if status, _ := ts.do(t, "DELETE", "/api/account/oidc/mock", nil, daveSession); status != http.StatusOK {
+		t.Fatalf("unlink: want status %d got %d", http.StatusOK, status)
+	}
+}
+
+func TestOIDCWithoutPassword(t *testing.T) {
+	ts := newTestServer(t)
+	mi := newMockIssuer(t, "opforu")
+	ts.handler.OIDCProviders["mock"] = types.NewOIDCProvider("mock", mi.URL, "opforu", "secret", "http://localhost:5173/login/oidc/mock")
+
+	carol := map[string]any{"sub": "carol-1", "email": "carol@example.com", "email_verified": true, "preferred_username": "carol"}
+	callback, res := ts.oidcSignIn(t, mi, carol, "")
+	if callback.StatusCode != http.StatusOK {
+		t.Fatalf("sign in: want status %d got %d: %+v", http.StatusOK, callback.StatusCode, res)
+	}
+	session := sessionCookie(callback).Value
+
+	// there's no password to confirm changes with, the provider has to confirm them
+	if status, _ := ts.do(t, "POST", "/api/account/email", map[string]string{"email": "carol2@example.com"}, session); status != http.StatusForbidden {
+		t.Fatalf("email without reauth: want status %d got %d", http.StatusForbidden, status)
+	}
+
+	// only the provider's user linked to the account confirms it
+	mallory := map[string]any{"sub": "mallory-1", "email": "mallory@example.com", "email_verified": true}
+	if callback, _ := ts.oidcReauth(t, mi, mallory, session); callback.StatusCode != http.StatusForbidden {
+		t.Fatalf("reauth as another user: want status %d got %d", http.StatusForbidden, callback.StatusCode)
+	}
+
+	callback, res = ts.oidcReauth(t, mi, carol, session)
+	if callback.StatusCode != http.StatusOK || res["reauthenticated_until"] == nil {
+		t.Fatalf("reauth: want status %d got %d: %+v", http.StatusOK, callback.StatusCode, res)
+	}
+
+	if status, res := ts.do(t, "POST", "/api/account/email", map[string]string{"email": "carol2@example.com"}, session); status != http.StatusOK {
+		t.Fatalf("email after reauth: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	status, res := ts.do(t, "POST", "/api/account/password", map[string]string{"password": "correct horse battery", "confirm_password": "correct horse battery"}, session)
+	if status != http.StatusOK {
+		t.Fatalf("first password after reauth: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	// deleted accounts are restored by signing in with the provider
+	session = ts.login(t, "carol", "correct horse battery")
+	if status, res := ts.do(t, "DELETE", "/api/account", map[string]string{"current_password": "correct horse battery"}, session); status != http.StatusOK {
+		t.Fatalf("delete: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	callback, res = ts.oidcSignIn(t, mi, carol, "")
+	if callback.StatusCode != http.StatusOK || res["restored"] != true {
+		t.Fatalf("sign in while deleted: want the account restored got %d: %+v", callback.StatusCode, res)
+	}
+	if account := res["account"].(map[string]any); account["status"] != string(types.AccountStatusActive) {
+		t.Fatalf("want the account active again got %+v", account)
+	}
+
+	// with two-factor on the account stays deleted until the second factor is verified
+	session = sessionCookie(callback).Value
+	_, res = ts.do(t, "POST", "/api/account/2fa/enroll", nil, session)
+	code, err := utils.TOTPCode(res["secret"].(string), utils.TOTPStep(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, res = ts.do(t, "POST", "/api/account/2fa/confirm", map[string]string{"code": code}, session)
+	if status != http.StatusOK {
+		t.Fatalf("confirm: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+	recovery, _ := res["recovery_codes"].([]any)
+
+	if status, res := ts.do(t, "DELETE", "/api/account", map[string]string{"current_password": "correct horse battery"}, session); status != http.StatusOK {
+		t.Fatalf("delete with two-factor: want status %d got %d: %+v", http.StatusOK, status, res)
+	}
+
+	callback, res = ts.oidcSignIn(t, mi, carol, "")
+	if callback.StatusCode != http.StatusOK || res["challenge"] == nil || res["restored"] != nil {
+		t.Fatalf("sign in while deleted with two-factor: want a challenge got %d: %+v", callback.StatusCode, res)
+	}
+	account, err := ts.store.FindAccountByUsernameOrEmail("carol", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !account.IsDeleted() {
+		t.Fatal("want the account still deleted before the second factor")
+	}
+
+	status, res = ts.do(t, "POST", "/api/account/login/2fa", map[string]string{"challenge": res["challenge"].(string), "code": recovery[0].(string)}, "")
+	if status != http.StatusOK || res["restored"] != true || res["session"] == nil {
+		t.Fatalf("second factor while deleted: want the account restored got %d: %+v", status, res)
+	}
+}